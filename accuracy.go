@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+horizonNext labels predictions of the next observation, as opposed to the
+forecast ladder's fixed horizons.
+*/
+const horizonNext = "next"
+
+/*
+AccuracyRecord is one prediction and, once its target time has passed, the
+price first observed at or after it.
+*/
+type AccuracyRecord struct {
+    Symbol         string     `json:"symbol"`
+    Horizon        string     `json:"horizon"`
+    CurrentPrice   float64    `json:"current_price"`
+    PredictedPrice float64    `json:"predicted_price"`
+    PredictedAt    time.Time  `json:"predicted_at"`
+    Target         time.Time  `json:"target"`
+    ActualPrice    float64    `json:"actual_price,omitempty"`
+    RealizedAt     *time.Time `json:"realized_at,omitempty"`
+}
+
+/*
+AccuracyReport summarizes one symbol's resolved predictions at one horizon.
+BaselineMAE is the error of predicting no change, and SkillPercent how much
+lower the model's MAE is than that; a model without skill scores 0 or below.
+*/
+type AccuracyReport struct {
+    Horizon                    string     `json:"horizon"`
+    Samples                    int        `json:"samples"`
+    Pending                    int        `json:"pending"`
+    MAE                        float64    `json:"mae"`
+    RMSE                       float64    `json:"rmse"`
+    DirectionalAccuracyPercent float64    `json:"directional_accuracy_percent"`
+    BaselineMAE                float64    `json:"baseline_mae"`
+    SkillPercent               *float64   `json:"skill_percent,omitempty"`
+    Since                      *time.Time `json:"since,omitempty"`
+}
+
+/*
+AccuracyTracker compares every prediction with the price observed at its
+target: the next tick for regular predictions, the first tick at or after
+the horizon for the forecast ladder. The last ACCURACY_WINDOW resolved
+predictions (default 1000) are kept per symbol and horizon. With
+ACCURACY_FILE set, pending and resolved predictions are saved there every
+ACCURACY_CHECKPOINT (default 1m) and survive restarts.
+*/
+type AccuracyTracker struct {
+    mu         sync.Mutex
+    pending    map[string][]AccuracyRecord
+    resolved   map[string]map[string][]AccuracyRecord
+    window     int
+    path       string
+    checkpoint time.Duration
+    dirty      bool
+}
+
+/*
+accuracyState is the file format of ACCURACY_FILE.
+*/
+type accuracyState struct {
+    Pending  []AccuracyRecord `json:"pending"`
+    Resolved []AccuracyRecord `json:"resolved"`
+}
+
+/*
+NewAccuracyTrackerFromEnv creates the tracker, loading ACCURACY_FILE if set.
+*/
+func NewAccuracyTrackerFromEnv() *AccuracyTracker {
+    at := &AccuracyTracker{
+        pending:    make(map[string][]AccuracyRecord),
+        resolved:   make(map[string]map[string][]AccuracyRecord),
+        window:     envInt("ACCURACY_WINDOW", 1000),
+        path:       os.Getenv("ACCURACY_FILE"),
+        checkpoint: envDuration("ACCURACY_CHECKPOINT", time.Minute),
+    }
+    if at.window < 1 {
+        log.Fatalf("ACCURACY_WINDOW must be at least 1, got %d", at.window)
+    }
+    if at.path == "" {
+        return at
+    }
+    raw, err := os.ReadFile(at.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            slog.Error("prediction accuracy unavailable", "err", err)
+        }
+        return at
+    }
+    if raw, err = openAtRest(raw); err != nil {
+        log.Fatalf("reading %s: %v", at.path, err)
+    }
+    var st accuracyState
+    if err := json.Unmarshal(raw, &st); err != nil {
+        slog.Warn("ignoring unreadable file", "path", at.path, "err", err)
+        return at
+    }
+    for _, rec := range st.Pending {
+        at.pending[rec.Symbol] = append(at.pending[rec.Symbol], rec)
+    }
+    for _, rec := range st.Resolved {
+        at.keep(rec)
+    }
+    return at
+}
+
+/*
+Track registers a prediction made at predictedAt from a price of current, to
+be judged by the first tick at or after target. Only the last window pending
+predictions of a symbol and horizon are kept.
+*/
+func (at *AccuracyTracker) Track(symbol, horizon string, current, predicted float64, predictedAt, target time.Time) {
+    at.mu.Lock()
+    defer at.mu.Unlock()
+    list := append(at.pending[symbol], AccuracyRecord{
+        Symbol:         symbol,
+        Horizon:        horizon,
+        CurrentPrice:   current,
+        PredictedPrice: predicted,
+        PredictedAt:    predictedAt,
+        Target:         target,
+    })
+    n := 0
+    for i := len(list) - 1; i >= 0; i-- {
+        if list[i].Horizon == horizon {
+            if n++; n > at.window {
+                list = append(list[:i], list[i+1:]...)
+            }
+        }
+    }
+    at.pending[symbol] = list
+    at.dirty = true
+}
+
+/*
+Resolve judges every pending prediction of sd.Symbol whose target sd has
+reached, and returns them.
+*/
+func (at *AccuracyTracker) Resolve(sd StockData) []AccuracyRecord {
+    at.mu.Lock()
+    defer at.mu.Unlock()
+    list := at.pending[sd.Symbol]
+    kept := list[:0]
+    var resolved []AccuracyRecord
+    for _, rec := range list {
+        if sd.Timestamp.Before(rec.Target) {
+            kept = append(kept, rec)
+            continue
+        }
+        realized := sd.Timestamp
+        rec.ActualPrice, rec.RealizedAt = sd.Price, &realized
+        at.keep(rec)
+        at.dirty = true
+        resolved = append(resolved, rec)
+    }
+    if len(kept) == 0 {
+        delete(at.pending, sd.Symbol)
+    } else {
+        at.pending[sd.Symbol] = kept
+    }
+    return resolved
+}
+
+/*
+keep adds a resolved record, evicting the oldest beyond the window. Callers
+must hold at.mu.
+*/
+func (at *AccuracyTracker) keep(rec AccuracyRecord) {
+    byHorizon := at.resolved[rec.Symbol]
+    if byHorizon == nil {
+        byHorizon = make(map[string][]AccuracyRecord)
+        at.resolved[rec.Symbol] = byHorizon
+    }
+    list := append(byHorizon[rec.Horizon], rec)
+    if len(list) > at.window {
+        list = list[len(list)-at.window:]
+    }
+    byHorizon[rec.Horizon] = list
+}
+
+/*
+Report returns symbol's accuracy at every horizon with a resolved or pending
+prediction, ordered by horizon.
+*/
+func (at *AccuracyTracker) Report(symbol string) []AccuracyReport {
+    at.mu.Lock()
+    defer at.mu.Unlock()
+    reports := make(map[string]*AccuracyReport)
+    get := func(h string) *AccuracyReport {
+        if reports[h] == nil {
+            reports[h] = &AccuracyReport{Horizon: h}
+        }
+        return reports[h]
+    }
+    for _, rec := range at.pending[symbol] {
+        get(rec.Horizon).Pending++
+    }
+    for h, list := range at.resolved[symbol] {
+        var t accuracyTotals
+        for _, rec := range list {
+            t.add(rec)
+        }
+        rep := t.report(h)
+        rep.Pending = get(h).Pending
+        since := list[0].PredictedAt
+        rep.Since = &since
+        reports[h] = &rep
+    }
+    out := make([]AccuracyReport, 0, len(reports))
+    for _, rep := range reports {
+        out = append(out, *rep)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Horizon < out[j].Horizon })
+    return out
+}
+
+/*
+accuracyTotals are the sums accuracy is derived from, over samples resolved
+predictions: absolute, squared and no-change baseline errors, and the number
+of correctly predicted directions.
+*/
+type accuracyTotals struct {
+    samples int
+    abs     float64
+    sq      float64
+    base    float64
+    hits    int
+}
+
+/*
+add adds a resolved prediction to the totals.
+*/
+func (t *accuracyTotals) add(rec AccuracyRecord) {
+    e := rec.PredictedPrice - rec.ActualPrice
+    t.samples++
+    t.abs += math.Abs(e)
+    t.sq += e * e
+    t.base += math.Abs(rec.ActualPrice - rec.CurrentPrice)
+    if sign(rec.PredictedPrice-rec.CurrentPrice) == sign(rec.ActualPrice-rec.CurrentPrice) {
+        t.hits++
+    }
+}
+
+/*
+report derives the accuracy statistics at horizon from the totals, which
+must cover at least one prediction.
+*/
+func (t accuracyTotals) report(horizon string) AccuracyReport {
+    n := float64(t.samples)
+    rep := AccuracyReport{Horizon: horizon, Samples: t.samples}
+    rep.MAE, rep.RMSE, rep.BaselineMAE = t.abs/n, math.Sqrt(t.sq/n), t.base/n
+    rep.DirectionalAccuracyPercent = 100 * float64(t.hits) / n
+    if rep.BaselineMAE > 0 {
+        skill := 100 * (1 - rep.MAE/rep.BaselineMAE)
+        rep.SkillPercent = &skill
+    }
+    return rep
+}
+
+/*
+sign returns -1, 0 or 1 by the sign of v.
+*/
+func sign(v float64) int {
+    switch {
+    case v > 0:
+        return 1
+    case v < 0:
+        return -1
+    }
+    return 0
+}
+
+/*
+Run saves changed state every checkpoint interval.
+*/
+func (at *AccuracyTracker) Run() {
+    if at.path == "" || at.checkpoint <= 0 {
+        return
+    }
+    for range time.Tick(at.checkpoint) {
+        at.Flush()
+    }
+}
+
+/*
+Flush saves the tracker to ACCURACY_FILE if anything changed since the last save.
+*/
+func (at *AccuracyTracker) Flush() {
+    at.mu.Lock()
+    defer at.mu.Unlock()
+    if !at.dirty || at.path == "" {
+        return
+    }
+    at.dirty = false
+    st := accuracyState{Pending: []AccuracyRecord{}, Resolved: []AccuracyRecord{}}
+    for _, list := range at.pending {
+        st.Pending = append(st.Pending, list...)
+    }
+    for _, byHorizon := range at.resolved {
+        for _, list := range byHorizon {
+            st.Resolved = append(st.Resolved, list...)
+        }
+    }
+    raw, err := json.MarshalIndent(st, "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = writeFileAtomic(at.path, raw)
+    }
+    if err != nil {
+        slog.Error("saving prediction accuracy failed", "err", err)
+    }
+}
+
+/*
+handleAccuracy exposes GET /api/accuracy/{symbol}, the symbol's MAE, RMSE and
+directional accuracy per horizon ("next" for regular predictions, and each
+forecast ladder horizon), against the no-change baseline, optionally for one
+?horizon only.
+*/
+func (fp *FinancialProcessor) handleAccuracy(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    horizon := r.URL.Query().Get("horizon")
+    out := []AccuracyReport{}
+    for _, rep := range fp.accuracy.Report(sym) {
+        if horizon == "" || rep.Horizon == horizon {
+            out = append(out, rep)
+        }
+    }
+    if len(out) == 0 {
+        http.Error(w, "no predictions tracked for "+sym, http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{"symbol": sym, "window": fp.accuracy.window, "horizons": out})
+}
+
+/*
+handleDailyAccuracy exposes GET /api/accuracy/{symbol}/daily, the symbol's
+accuracy per horizon and UTC day of resolution, oldest first. It reads the
+accuracy_daily table, which is kept up to date as predictions resolve, so
+unlike /api/accuracy it covers history beyond ACCURACY_WINDOW; it needs
+persistent storage. from and to (RFC 3339 or YYYY-MM-DD) bound the days, and
+?horizon picks one horizon.
+*/
+func (fp *FinancialProcessor) handleDailyAccuracy(w http.ResponseWriter, r *http.Request) {
+    if fp.store == nil {
+        http.Error(w, "daily accuracy needs STORAGE_DSN", http.StatusNotFound)
+        return
+    }
+    sym := mux.Vars(r)["symbol"]
+    qs := r.URL.Query()
+    from, err := parseExportTime(qs.Get("from"), false)
+    if err != nil {
+        http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    to, err := parseExportTime(qs.Get("to"), true)
+    if err != nil {
+        http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    if !from.IsZero() {
+        from = from.UTC().Truncate(24 * time.Hour)
+    }
+    days, err := fp.store.DailyAccuracy(sym, from, to)
+    if err != nil {
+        http.Error(w, "reading daily accuracy: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    horizon := qs.Get("horizon")
+    out := days[:0]
+    for _, d := range days {
+        if horizon == "" || d.Horizon == horizon {
+            out = append(out, d)
+        }
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{"symbol": sym, "days": out})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+registerAdminRoutes mounts net/http/pprof under /debug/pprof and the admin API
+under /api/admin, all behind the admin scope.
+*/
+func registerAdminRoutes(r *mux.Router, fp *FinancialProcessor) {
+    debug := r.PathPrefix("/debug/pprof").Subrouter()
+    debug.Use(fp.auth.RequireAdmin)
+    debug.HandleFunc("/cmdline", pprof.Cmdline)
+    debug.HandleFunc("/profile", pprof.Profile)
+    debug.HandleFunc("/symbol", pprof.Symbol)
+    debug.HandleFunc("/trace", pprof.Trace)
+    debug.PathPrefix("/").HandlerFunc(pprof.Index)
+
+    admin := r.PathPrefix("/api/admin").Subrouter()
+    admin.Use(fp.auth.RequireAdmin)
+    admin.HandleFunc("/profile", fp.handleProfileBundle).Methods("GET")
+    admin.HandleFunc("/capacity", fp.capacity.handleCapacity).Methods("GET")
+    admin.HandleFunc("/schedule", fp.handleSchedule).Methods("GET")
+    admin.HandleFunc("/symbols/{symbol}/restart", fp.handleRestartSymbol).Methods("POST")
+    admin.HandleFunc("/symbols/{symbol}/reactivate", fp.handleReactivateSymbol).Methods("POST")
+    admin.HandleFunc("/import", fp.handleImport).Methods("POST")
+    admin.HandleFunc("/flags", fp.handleFlags).Methods("GET")
+    admin.HandleFunc("/flags/{name}", fp.handleSetFlag).Methods("PUT", "DELETE")
+    admin.HandleFunc("/reload", fp.handleReload).Methods("POST")
+    admin.HandleFunc("/shards", fp.shards.handleShards).Methods("GET")
+}
+
+/*
+handleProfileBundle exposes GET /api/admin/profile?seconds=30, which records a
+CPU profile for the requested duration (1-120s, default 30) and returns it in a
+zip together with heap, allocs, goroutine, mutex and block snapshots.
+*/
+func (fp *FinancialProcessor) handleProfileBundle(w http.ResponseWriter, r *http.Request) {
+    seconds := 30
+    if v := r.URL.Query().Get("seconds"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > 120 {
+            http.Error(w, "seconds must be between 1 and 120", http.StatusBadRequest)
+            return
+        }
+        seconds = n
+    }
+
+    var cpu bytes.Buffer
+    if err := runtimepprof.StartCPUProfile(&cpu); err != nil {
+        http.Error(w, "CPU profile already in progress: "+err.Error(), http.StatusConflict)
+        return
+    }
+    select {
+    case <-time.After(time.Duration(seconds) * time.Second):
+    case <-r.Context().Done():
+    }
+    runtimepprof.StopCPUProfile()
+    if r.Context().Err() != nil {
+        return
+    }
+
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    write := func(name string, data []byte) error {
+        f, err := zw.Create(name)
+        if err != nil {
+            return err
+        }
+        _, err = f.Write(data)
+        return err
+    }
+    if err := write("cpu.pprof", cpu.Bytes()); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    for _, name := range []string{"heap", "allocs", "goroutine", "mutex", "block"} {
+        p := runtimepprof.Lookup(name)
+        if p == nil {
+            continue
+        }
+        var pb bytes.Buffer
+        if err := p.WriteTo(&pb, 0); err != nil {
+            continue
+        }
+        if err := write(name+".pprof", pb.Bytes()); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    }
+    if err := zw.Close(); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    name := fmt.Sprintf("profile-%s.zip", time.Now().UTC().Format("20060102T150405Z"))
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+    w.Write(buf.Bytes())
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+/*
+barResolutions are the bar widths maintained in the bars table as ticks are
+stored, by the interval names GET /api/candles uses for them. Bars are
+aligned to multiples of their width in UTC, like aggregateCandles.
+*/
+var barResolutions = []string{"5m", "1h", "1d"}
+
+/*
+DailyAccuracy is one symbol's prediction accuracy at one horizon over the
+predictions resolved on Day (UTC), as GET /api/accuracy/{symbol}/daily
+reports it.
+*/
+type DailyAccuracy struct {
+    Day                        time.Time `json:"day"`
+    Horizon                    string    `json:"horizon"`
+    Samples                    int       `json:"samples"`
+    MAE                        float64   `json:"mae"`
+    RMSE                       float64   `json:"rmse"`
+    DirectionalAccuracyPercent float64   `json:"directional_accuracy_percent"`
+    BaselineMAE                float64   `json:"baseline_mae"`
+    SkillPercent               *float64  `json:"skill_percent,omitempty"`
+}
+
+/*
+addToBars adds a newly stored tick to the bar containing it at every
+resolution, within the transaction that stored it. Its volume is the growth
+over the cumulative volume of the symbol's previous stored tick, as in
+aggregateCandles; a tick stored behind newer ones is not subtracted from its
+successor, so backfilled ticks may shift volume between bars.
+*/
+func (s *sqlStore) addToBars(tx *sql.Tx, sd StockData) error {
+    ts := sd.Timestamp.UnixNano()
+    var prev int64
+    err := tx.QueryRow(rebind(s.driver,
+        `SELECT volume FROM stock_data WHERE symbol = ? AND ts < ? ORDER BY ts DESC LIMIT 1`), sd.Symbol, ts).Scan(&prev)
+    traded := int64(0)
+    switch {
+    case errors.Is(err, sql.ErrNoRows):
+    case err != nil:
+        return err
+    case sd.Volume < prev:
+        traded = sd.Volume
+    default:
+        traded = sd.Volume - prev
+    }
+    for _, name := range barResolutions {
+        start := sd.Timestamp.UTC().Truncate(candleIntervals[name]).UnixNano()
+        _, err := tx.Exec(rebind(s.driver, `
+            INSERT INTO bars (symbol, resolution, ts, open, high, low, close, volume, ticks, open_ts, close_ts)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+            ON CONFLICT (symbol, resolution, ts) DO UPDATE SET
+                open = CASE WHEN excluded.open_ts < bars.open_ts THEN excluded.open ELSE bars.open END,
+                open_ts = CASE WHEN excluded.open_ts < bars.open_ts THEN excluded.open_ts ELSE bars.open_ts END,
+                high = CASE WHEN excluded.high > bars.high THEN excluded.high ELSE bars.high END,
+                low = CASE WHEN excluded.low < bars.low THEN excluded.low ELSE bars.low END,
+                close = CASE WHEN excluded.close_ts > bars.close_ts THEN excluded.close ELSE bars.close END,
+                close_ts = CASE WHEN excluded.close_ts > bars.close_ts THEN excluded.close_ts ELSE bars.close_ts END,
+                volume = bars.volume + excluded.volume,
+                ticks = bars.ticks + 1`),
+            sd.Symbol, name, start, sd.Price, sd.Price, sd.Price, sd.Price, traded, ts, ts)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *sqlStore) Bars(symbol, resolution string, since, until time.Time) ([]Candle, error) {
+    lo, hi := nanoBounds(since, until)
+    rows, err := s.db.Query(rebind(s.driver,
+        `SELECT ts, open, high, low, close, volume, ticks FROM bars
+         WHERE symbol = ? AND resolution = ? AND ts >= ? AND ts <= ? ORDER BY ts`), symbol, resolution, lo, hi)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := []Candle{}
+    for rows.Next() {
+        var c Candle
+        var ts int64
+        if err := rows.Scan(&ts, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume, &c.Ticks); err != nil {
+            return nil, err
+        }
+        c.Timestamp = time.Unix(0, ts).UTC()
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+func (s *sqlStore) SaveAccuracy(rec AccuracyRecord) error {
+    if rec.RealizedAt == nil {
+        return nil
+    }
+    var t accuracyTotals
+    t.add(rec)
+    hits := 0
+    if t.hits > 0 {
+        hits = 1
+    }
+    day := rec.RealizedAt.UTC().Truncate(24 * time.Hour).UnixNano()
+    _, err := s.db.Exec(rebind(s.driver, `
+        INSERT INTO accuracy_daily (symbol, horizon, day, samples, abs_error, squared_error, baseline_abs_error, direction_hits)
+        VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+        ON CONFLICT (symbol, horizon, day) DO UPDATE SET
+            samples = accuracy_daily.samples + 1,
+            abs_error = accuracy_daily.abs_error + excluded.abs_error,
+            squared_error = accuracy_daily.squared_error + excluded.squared_error,
+            baseline_abs_error = accuracy_daily.baseline_abs_error + excluded.baseline_abs_error,
+            direction_hits = accuracy_daily.direction_hits + excluded.direction_hits`),
+        rec.Symbol, rec.Horizon, day, t.abs, t.sq, t.base, hits)
+    return err
+}
+
+func (s *sqlStore) DailyAccuracy(symbol string, since, until time.Time) ([]DailyAccuracy, error) {
+    lo, hi := nanoBounds(since, until)
+    rows, err := s.db.Query(rebind(s.driver,
+        `SELECT day, horizon, samples, abs_error, squared_error, baseline_abs_error, direction_hits FROM accuracy_daily
+         WHERE symbol = ? AND day >= ? AND day <= ? ORDER BY day, horizon`), symbol, lo, hi)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := []DailyAccuracy{}
+    for rows.Next() {
+        var t accuracyTotals
+        var day int64
+        var horizon string
+        if err := rows.Scan(&day, &horizon, &t.samples, &t.abs, &t.sq, &t.base, &t.hits); err != nil {
+            return nil, err
+        }
+        rep := t.report(horizon)
+        out = append(out, DailyAccuracy{
+            Day:                        time.Unix(0, day).UTC(),
+            Horizon:                    horizon,
+            Samples:                    rep.Samples,
+            MAE:                        rep.MAE,
+            RMSE:                       rep.RMSE,
+            DirectionalAccuracyPercent: rep.DirectionalAccuracyPercent,
+            BaselineMAE:                rep.BaselineMAE,
+            SkillPercent:               rep.SkillPercent,
+        })
+    }
+    return out, rows.Err()
+}
+
+/*
+nanoBounds converts a since/until range, where zero is open, to inclusive
+nanosecond bounds.
+*/
+func nanoBounds(since, until time.Time) (lo, hi int64) {
+    lo, hi = 0, 1<<63-1
+    if !since.IsZero() {
+        lo = since.UnixNano()
+    }
+    if !until.IsZero() {
+        hi = until.UnixNano()
+    }
+    return lo, hi
+}
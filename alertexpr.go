@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+alertExprFields are the values an expression can read for a symbol: the
+price and cumulative volume of its latest tick and the predicted price and
+change of its latest prediction.
+*/
+var alertExprFields = map[string]bool{
+    "price":                    true,
+    "volume":                   true,
+    "predicted_price":          true,
+    "predicted_change_percent": true,
+}
+
+/*
+exprLookup returns field of symbol's latest tick or prediction, or false
+when there is none yet.
+*/
+type exprLookup func(symbol, field string) (float64, bool)
+
+/*
+exprNode is a node of a parsed arithmetic expression.
+*/
+type exprNode interface {
+    eval(lookup exprLookup) (float64, bool)
+}
+
+/*
+exprNum is a numeric literal.
+*/
+type exprNum float64
+
+func (n exprNum) eval(exprLookup) (float64, bool) { return float64(n), true }
+
+/*
+exprRef reads one field of one symbol, such as AAPL.price.
+*/
+type exprRef struct {
+    symbol string
+    field  string
+}
+
+func (r exprRef) eval(lookup exprLookup) (float64, bool) { return lookup(r.symbol, r.field) }
+
+/*
+exprNeg negates its operand.
+*/
+type exprNeg struct {
+    x exprNode
+}
+
+func (n exprNeg) eval(lookup exprLookup) (float64, bool) {
+    v, ok := n.x.eval(lookup)
+    return -v, ok
+}
+
+/*
+exprBinary applies one of + - * / to its operands. Division by zero leaves
+the expression undefined.
+*/
+type exprBinary struct {
+    op   byte
+    l, r exprNode
+}
+
+func (b exprBinary) eval(lookup exprLookup) (float64, bool) {
+    l, ok := b.l.eval(lookup)
+    if !ok {
+        return 0, false
+    }
+    r, ok := b.r.eval(lookup)
+    if !ok {
+        return 0, false
+    }
+    switch b.op {
+    case '+':
+        return l + r, true
+    case '-':
+        return l - r, true
+    case '*':
+        return l * r, true
+    }
+    if r == 0 {
+        return 0, false
+    }
+    return l / r, true
+}
+
+/*
+alertExpr is a parsed alert condition: two arithmetic expressions over
+symbol fields and numbers compared with one of > >= < <=, such as
+"AAPL.predicted_change_percent - SPY.predicted_change_percent > 2".
+*/
+type alertExpr struct {
+    left    exprNode
+    op      string
+    right   exprNode
+    symbols []string
+}
+
+/*
+holds evaluates the condition, returning the left-hand value. ok is false
+while some symbol it reads has no value yet.
+*/
+func (e *alertExpr) holds(lookup exprLookup) (holds bool, left, right float64, ok bool) {
+    if left, ok = e.left.eval(lookup); !ok {
+        return false, 0, 0, false
+    }
+    if right, ok = e.right.eval(lookup); !ok {
+        return false, 0, 0, false
+    }
+    switch e.op {
+    case ">":
+        holds = left > right
+    case ">=":
+        holds = left >= right
+    case "<":
+        holds = left < right
+    default:
+        holds = left <= right
+    }
+    return holds, left, right, true
+}
+
+/*
+reads reports whether the condition reads any field of symbol.
+*/
+func (e *alertExpr) reads(symbol string) bool {
+    for _, s := range e.symbols {
+        if s == symbol {
+            return true
+        }
+    }
+    return false
+}
+
+/*
+exprParser is a recursive descent parser over an expression's source.
+*/
+type exprParser struct {
+    src  string
+    pos  int
+    refs map[string]bool
+}
+
+/*
+parseAlertExpr parses a condition. References are SYMBOL.field, where the
+symbol may itself contain dots, dashes, carets and equals signs (BRK.B.price,
+BTC-USD.price, ^GSPC.price, EURUSD=X.price) and is matched case-insensitively.
+*/
+func parseAlertExpr(src string) (*alertExpr, error) {
+    p := &exprParser{src: src, refs: make(map[string]bool)}
+    left, err := p.sum()
+    if err != nil {
+        return nil, err
+    }
+    p.space()
+    var op string
+    for _, c := range []string{">=", "<=", ">", "<"} {
+        if strings.HasPrefix(p.src[p.pos:], c) {
+            op = c
+            break
+        }
+    }
+    if op == "" {
+        return nil, p.errorf("expected one of > >= < <=")
+    }
+    p.pos += len(op)
+    right, err := p.sum()
+    if err != nil {
+        return nil, err
+    }
+    if p.space(); p.pos < len(p.src) {
+        return nil, p.errorf("unexpected %q", p.src[p.pos:])
+    }
+    if len(p.refs) == 0 {
+        return nil, fmt.Errorf("the expression reads no symbol")
+    }
+    e := &alertExpr{left: left, op: op, right: right}
+    for s := range p.refs {
+        e.symbols = append(e.symbols, s)
+    }
+    sort.Strings(e.symbols)
+    return e, nil
+}
+
+/*
+errorf reports a syntax error at the current position.
+*/
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+    return fmt.Errorf("expression: at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+/*
+space skips whitespace.
+*/
+func (p *exprParser) space() {
+    for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+        p.pos++
+    }
+}
+
+/*
+peek returns the next non-space byte, or 0 at the end.
+*/
+func (p *exprParser) peek() byte {
+    p.space()
+    if p.pos >= len(p.src) {
+        return 0
+    }
+    return p.src[p.pos]
+}
+
+/*
+sum parses terms joined by + and -.
+*/
+func (p *exprParser) sum() (exprNode, error) {
+    x, err := p.product()
+    for err == nil {
+        op := p.peek()
+        if op != '+' && op != '-' {
+            break
+        }
+        p.pos++
+        var y exprNode
+        if y, err = p.product(); err == nil {
+            x = exprBinary{op, x, y}
+        }
+    }
+    return x, err
+}
+
+/*
+product parses factors joined by * and /.
+*/
+func (p *exprParser) product() (exprNode, error) {
+    x, err := p.factor()
+    for err == nil {
+        op := p.peek()
+        if op != '*' && op != '/' {
+            break
+        }
+        p.pos++
+        var y exprNode
+        if y, err = p.factor(); err == nil {
+            x = exprBinary{op, x, y}
+        }
+    }
+    return x, err
+}
+
+/*
+factor parses a number, a reference, a negation or a parenthesised sum.
+*/
+func (p *exprParser) factor() (exprNode, error) {
+    switch c := p.peek(); {
+    case c == 0:
+        return nil, p.errorf("unexpected end")
+    case c == '-':
+        p.pos++
+        x, err := p.factor()
+        return exprNeg{x}, err
+    case c == '(':
+        p.pos++
+        x, err := p.sum()
+        if err != nil {
+            return nil, err
+        }
+        if p.peek() != ')' {
+            return nil, p.errorf("expected )")
+        }
+        p.pos++
+        return x, nil
+    case c >= '0' && c <= '9' || c == '.':
+        // Symbols such as 7203.T start with a digit too.
+        start := p.pos
+        if x, err := p.ref(); err == nil {
+            return x, nil
+        }
+        p.pos = start
+        for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+            p.pos++
+        }
+        text := p.src[start:p.pos]
+        v, err := strconv.ParseFloat(text, 64)
+        if err != nil {
+            p.pos = start
+            return nil, p.errorf("invalid number %q", text)
+        }
+        return exprNum(v), nil
+    }
+    return p.ref()
+}
+
+/*
+ref parses SYMBOL.field: the shortest run of symbol characters that ends in
+a dot, a known field and something other than a letter, digit or underscore.
+A hyphen only joins the run once it holds a letter, as in BTC-USD, so
+2-AAPL.price is a subtraction rather than a symbol.
+*/
+func (p *exprParser) ref() (exprNode, error) {
+    start := p.pos
+    end := start
+    letter := false
+    for end < len(p.src) && isExprSymbolByte(p.src[end]) {
+        c := p.src[end]
+        if c == '-' && !letter {
+            break
+        }
+        letter = letter || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+        end++
+    }
+    run := p.src[start:end]
+    for i := 1; i < len(run); i++ {
+        if run[i] != '.' {
+            continue
+        }
+        j := i + 1
+        for j < len(run) && isExprWordByte(run[j]) {
+            j++
+        }
+        if field := run[i+1 : j]; alertExprFields[field] {
+            symbol := strings.ToUpper(run[:i])
+            p.refs[symbol] = true
+            p.pos = start + j
+            return exprRef{symbol, field}, nil
+        }
+    }
+    if run == "" {
+        return nil, p.errorf("unexpected %q", p.src[start:start+1])
+    }
+    return nil, p.errorf("expected SYMBOL.field with a field among price, volume, predicted_price and predicted_change_percent, got %q", run)
+}
+
+/*
+isExprSymbolByte reports whether c can appear in a reference.
+*/
+func isExprSymbolByte(c byte) bool {
+    return isExprWordByte(c) || strings.IndexByte(".^=-", c) >= 0
+}
+
+/*
+isExprWordByte reports whether c is a letter, digit or underscore.
+*/
+func isExprWordByte(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAlertExpr(t *testing.T) {
+    values := map[string]float64{
+        "AAPL.price":                    200,
+        "MSFT.price":                    400,
+        "BTC-USD.price":                 50000,
+        "BRK.B.price":                   450,
+        "7203.T.price":                  3000,
+        "^GSPC.price":                   5000,
+        "EURUSD=X.price":                1.1,
+        "SPY.predicted_change_percent":  1.5,
+        "AAPL.predicted_change_percent": 4,
+    }
+    lookup := func(symbol, field string) (float64, bool) {
+        v, ok := values[symbol+"."+field]
+        return v, ok
+    }
+    tests := []struct {
+        src     string
+        left    float64
+        symbols []string
+    }{
+        {"2-AAPL.price > 0", -198, []string{"AAPL"}},
+        {"2 - AAPL.price > 0", -198, []string{"AAPL"}},
+        {"10-BTC-USD.price < 0", -49990, []string{"BTC-USD"}},
+        {"AAPL.price-2 > 0", 198, []string{"AAPL"}},
+        {"AAPL.price-MSFT.price < 0", -200, []string{"AAPL", "MSFT"}},
+        {"btc-usd.price > 0", 50000, []string{"BTC-USD"}},
+        {"-AAPL.price < 0", -200, []string{"AAPL"}},
+        {"BRK.B.price / 2 > 0", 225, []string{"BRK.B"}},
+        {"7203.T.price*2 > 0", 6000, []string{"7203.T"}},
+        {"^GSPC.price + EURUSD=X.price > 0", 5001.1, []string{"EURUSD=X", "^GSPC"}},
+        {"(AAPL.predicted_change_percent - SPY.predicted_change_percent) * 2 >= 5", 5, []string{"AAPL", "SPY"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.src, func(t *testing.T) {
+            e, err := parseAlertExpr(tt.src)
+            if err != nil {
+                t.Fatalf("parseAlertExpr: %v", err)
+            }
+            if !reflect.DeepEqual(e.symbols, tt.symbols) {
+                t.Errorf("symbols = %v, want %v", e.symbols, tt.symbols)
+            }
+            if _, left, _, ok := e.holds(lookup); !ok || left != tt.left {
+                t.Errorf("left = %v (ok %v), want %v", left, ok, tt.left)
+            }
+        })
+    }
+}
+
+func TestParseAlertExprErrors(t *testing.T) {
+    for _, src := range []string{
+        "",
+        "AAPL.price",
+        "2 > 1",
+        "AAPL.close > 1",
+        "AAPL.price > (1",
+        "2-AAPL > 0",
+        "AAPL.price > 1 extra",
+    } {
+        if _, err := parseAlertExpr(src); err == nil {
+            t.Errorf("parseAlertExpr(%q) succeeded, want an error", src)
+        }
+    }
+}
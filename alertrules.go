@@ -0,0 +1,570 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Alert rule kinds.
+*/
+const (
+    rulePrice           = "price"
+    rulePredictedChange = "predicted_change"
+    ruleVolumeSpike     = "volume_spike"
+    ruleExpression      = "expression"
+)
+
+/*
+AlertRule is a user-registered alert on one symbol, firing at most once per
+CooldownSeconds. A "price" rule fires when the price crosses Above upwards or
+Below downwards. A "predicted_change" rule fires when a prediction's change
+reaches ChangePercent: at or above it when positive, at or below it when
+negative. A "volume_spike" rule fires when the volume traded since the
+previous tick is at least VolumeMultiple times its average over the
+VolumeWindow ticks before (default 20). An "expression" rule fires when
+Expression, a comparison of arithmetic over several symbols' values such as
+"AAPL.predicted_change_percent - SPY.predicted_change_percent > 2", holds;
+it is evaluated whenever a tick or prediction of any symbol it reads
+arrives, and Symbol is the first of them. The latter three fire when their
+condition starts to hold and re-arm once it stops, tracked in Active.
+LastPrice, Active and LastFiredAt are its trigger state; they are
+checkpointed so a restart neither re-fires an alert that was already reported
+nor forgets a cooldown in progress.
+*/
+type AlertRule struct {
+    ID              int64      `json:"id"`
+    Kind            string     `json:"kind"`
+    Symbol          string     `json:"symbol"`
+    Above           *float64   `json:"above,omitempty"`
+    Below           *float64   `json:"below,omitempty"`
+    ChangePercent   *float64   `json:"change_percent,omitempty"`
+    VolumeMultiple  *float64   `json:"volume_multiple,omitempty"`
+    VolumeWindow    int        `json:"volume_window,omitempty"`
+    Expression      string     `json:"expression,omitempty"`
+    CooldownSeconds int        `json:"cooldown_seconds"`
+    CreatedAt       time.Time  `json:"created_at"`
+    LastPrice       *float64   `json:"last_price,omitempty"`
+    Active          bool       `json:"active,omitempty"`
+    LastFiredAt     *time.Time `json:"last_fired_at,omitempty"`
+    expr            *alertExpr
+}
+
+/*
+validate normalises the rule's kind and checks it has the fields its kind needs.
+*/
+func (r *AlertRule) validate() error {
+    r.Symbol = strings.ToUpper(strings.TrimSpace(r.Symbol))
+    if r.Kind == "" {
+        r.Kind = rulePrice
+    }
+    if r.Kind == ruleExpression {
+        expr, err := parseAlertExpr(r.Expression)
+        if err != nil {
+            return err
+        }
+        r.expr, r.Symbol = expr, expr.symbols[0]
+    }
+    switch {
+    case r.Symbol == "":
+        return fmt.Errorf("symbol is required")
+    case r.CooldownSeconds < 0:
+        return fmt.Errorf("cooldown_seconds must not be negative")
+    }
+    switch r.Kind {
+    case rulePrice:
+        if r.Above == nil && r.Below == nil {
+            return fmt.Errorf("a price rule needs above or below")
+        }
+    case rulePredictedChange:
+        if r.ChangePercent == nil || *r.ChangePercent == 0 {
+            return fmt.Errorf("a predicted_change rule needs a non-zero change_percent")
+        }
+    case ruleVolumeSpike:
+        if r.VolumeMultiple == nil || *r.VolumeMultiple <= 1 {
+            return fmt.Errorf("a volume_spike rule needs a volume_multiple above 1")
+        }
+        if r.VolumeWindow < 0 {
+            return fmt.Errorf("volume_window must not be negative")
+        }
+        if r.VolumeWindow == 0 {
+            r.VolumeWindow = 20
+        }
+    case ruleExpression:
+    default:
+        return fmt.Errorf("unknown kind %q (price, predicted_change, volume_spike or expression)", r.Kind)
+    }
+    return nil
+}
+
+/*
+coolingDown reports whether the rule fired less than its cooldown before now.
+*/
+func (r *AlertRule) coolingDown(now time.Time) bool {
+    return r.LastFiredAt != nil && now.Sub(*r.LastFiredAt) < time.Duration(r.CooldownSeconds)*time.Second
+}
+
+/*
+edge records whether the rule's condition holds and reports whether it
+should fire at now: the condition has just started to hold and the rule is
+not cooling down.
+*/
+func (r *AlertRule) edge(holds bool, now time.Time) bool {
+    was := r.Active
+    r.Active = holds
+    if !holds || was || r.coolingDown(now) {
+        return false
+    }
+    firedAt := now
+    r.LastFiredAt = &firedAt
+    return true
+}
+
+/*
+crossed reports which level, if any, the move from prev to price crossed.
+*/
+func (r AlertRule) crossed(prev, price float64) (string, float64, bool) {
+    if r.Above != nil && prev < *r.Above && price >= *r.Above {
+        return "above", *r.Above, true
+    }
+    if r.Below != nil && prev > *r.Below && price <= *r.Below {
+        return "below", *r.Below, true
+    }
+    return "", 0, false
+}
+
+/*
+volumeSpike returns the volume traded in the last tick of history and the
+average traded per tick over the window ticks before it. Volumes are
+cumulative for the session, so a drop means a new session began and the
+tick's own volume is what traded since. ok is false without enough history.
+*/
+func volumeSpike(history []StockData, window int) (last, avg float64, ok bool) {
+    if len(history) < window+2 {
+        return 0, 0, false
+    }
+    history = history[len(history)-window-2:]
+    traded := make([]float64, len(history)-1)
+    for i := 1; i < len(history); i++ {
+        d := history[i].Volume - history[i-1].Volume
+        if d < 0 {
+            d = history[i].Volume
+        }
+        traded[i-1] = float64(d)
+    }
+    var sum float64
+    for _, v := range traded[:window] {
+        sum += v
+    }
+    return traded[window], sum / float64(window), true
+}
+
+/*
+AlertRuleBook holds alert rules and their trigger state. Rules are saved to
+ALERT_RULES_FILE (or PRICE_ALERTS_FILE, its former name) when set:
+immediately when a rule is added, removed or fires, and otherwise every
+ALERT_RULE_CHECKPOINT (default 30s) while trigger state has changed.
+*/
+type AlertRuleBook struct {
+    mu         sync.Mutex
+    rules      map[int64]*AlertRule
+    nextID     int64
+    dirty      bool
+    path       string
+    checkpoint time.Duration
+}
+
+/*
+AlertFiring is one rule firing, to be delivered through the alert dispatcher.
+Value is the price, predicted change or traded volume that triggered it.
+*/
+type AlertFiring struct {
+    Rule    AlertRule
+    Value   float64
+    Message string
+}
+
+/*
+NewAlertRuleBookFromEnv loads rules and their state from ALERT_RULES_FILE.
+*/
+func NewAlertRuleBookFromEnv() *AlertRuleBook {
+    path := os.Getenv("ALERT_RULES_FILE")
+    if path == "" {
+        path = os.Getenv("PRICE_ALERTS_FILE")
+    }
+    ab := &AlertRuleBook{
+        rules:      make(map[int64]*AlertRule),
+        nextID:     1,
+        path:       path,
+        checkpoint: envDuration("ALERT_RULE_CHECKPOINT", 30*time.Second),
+    }
+    if ab.path == "" {
+        return ab
+    }
+    raw, err := os.ReadFile(ab.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            slog.Error("alert rules unavailable", "err", err)
+        }
+        return ab
+    }
+    if raw, err = openAtRest(raw); err != nil {
+        log.Fatalf("reading %s: %v", ab.path, err)
+    }
+    var list []*AlertRule
+    if err := json.Unmarshal(raw, &list); err != nil {
+        slog.Warn("ignoring unreadable file", "path", ab.path, "err", err)
+        return ab
+    }
+    for _, r := range list {
+        if r.Kind == "" {
+            r.Kind = rulePrice
+        }
+        if r.Kind == ruleExpression {
+            if r.expr, err = parseAlertExpr(r.Expression); err != nil {
+                slog.Warn("skipping alert rule with an invalid expression", "id", r.ID, "err", err)
+                continue
+            }
+        }
+        ab.rules[r.ID] = r
+        if r.ID >= ab.nextID {
+            ab.nextID = r.ID + 1
+        }
+    }
+    return ab
+}
+
+/*
+save writes every rule with its state to the configured file atomically.
+Callers must hold ab.mu.
+*/
+func (ab *AlertRuleBook) save() {
+    ab.dirty = false
+    if ab.path == "" {
+        return
+    }
+    raw, err := json.MarshalIndent(ab.list(), "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = writeFileAtomic(ab.path, raw)
+    }
+    if err != nil {
+        slog.Error("saving alert rules failed", "err", err)
+    }
+}
+
+/*
+list returns copies of all rules ordered by ID. Callers must hold ab.mu.
+*/
+func (ab *AlertRuleBook) list() []AlertRule {
+    out := make([]AlertRule, 0, len(ab.rules))
+    for _, r := range ab.rules {
+        out = append(out, *r)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out
+}
+
+/*
+Run checkpoints changed trigger state every checkpoint interval.
+*/
+func (ab *AlertRuleBook) Run() {
+    if ab.path == "" || ab.checkpoint <= 0 {
+        return
+    }
+    for range time.Tick(ab.checkpoint) {
+        ab.Flush()
+    }
+}
+
+/*
+Flush saves trigger state that changed since the last checkpoint.
+*/
+func (ab *AlertRuleBook) Flush() {
+    ab.mu.Lock()
+    defer ab.mu.Unlock()
+    if ab.dirty {
+        ab.save()
+    }
+}
+
+/*
+Evaluate updates the price and volume rules for sd.Symbol with its new tick,
+history being the symbol's recent ticks ending with sd, and returns the rules
+that fire at now. A price rule's first observed price only arms it.
+*/
+func (ab *AlertRuleBook) Evaluate(sd StockData, history []StockData, now time.Time) []AlertFiring {
+    ab.mu.Lock()
+    defer ab.mu.Unlock()
+    var fired []AlertFiring
+    for _, r := range ab.rules {
+        if r.Symbol != sd.Symbol {
+            continue
+        }
+        switch r.Kind {
+        case rulePrice:
+            prev := r.LastPrice
+            price := sd.Price
+            r.LastPrice = &price
+            ab.dirty = true
+            if prev == nil {
+                continue
+            }
+            dir, level, ok := r.crossed(*prev, price)
+            if !ok || r.coolingDown(now) {
+                continue
+            }
+            firedAt := now
+            r.LastFiredAt = &firedAt
+            msg := fmt.Sprintf("%s crossed %s %.2f at %.2f (rule %d)", sd.Symbol, dir, level, price, r.ID)
+            fired = append(fired, AlertFiring{Rule: *r, Value: price, Message: msg})
+        case ruleVolumeSpike:
+            last, avg, ok := volumeSpike(history, r.VolumeWindow)
+            if !ok {
+                continue
+            }
+            was := r.Active
+            if r.edge(last >= *r.VolumeMultiple*avg && last > 0, now) {
+                msg := fmt.Sprintf("%s traded %.0f shares in one tick, %.1fx its %d-tick average of %.0f (rule %d)",
+                    sd.Symbol, last, last/math.Max(avg, 1), r.VolumeWindow, avg, r.ID)
+                fired = append(fired, AlertFiring{Rule: *r, Value: last, Message: msg})
+            }
+            ab.dirty = ab.dirty || r.Active != was
+        }
+    }
+    if len(fired) > 0 {
+        ab.save()
+    }
+    return fired
+}
+
+/*
+EvaluatePrediction updates the predicted change rules for p.Symbol and
+returns the rules that fire at now.
+*/
+func (ab *AlertRuleBook) EvaluatePrediction(p Prediction, now time.Time) []AlertFiring {
+    ab.mu.Lock()
+    defer ab.mu.Unlock()
+    var fired []AlertFiring
+    for _, r := range ab.rules {
+        if r.Symbol != p.Symbol || r.Kind != rulePredictedChange {
+            continue
+        }
+        x := *r.ChangePercent
+        holds := x > 0 && p.PredictedChangePerc >= x || x < 0 && p.PredictedChangePerc <= x
+        was := r.Active
+        if r.edge(holds, now) {
+            msg := fmt.Sprintf("%s predicted to move %.2f%% to %.2f, past %.2f%% (rule %d)",
+                p.Symbol, p.PredictedChangePerc, p.PredictedPrice, x, r.ID)
+            fired = append(fired, AlertFiring{Rule: *r, Value: p.PredictedChangePerc, Message: msg})
+        }
+        ab.dirty = ab.dirty || r.Active != was
+    }
+    if len(fired) > 0 {
+        ab.save()
+    }
+    return fired
+}
+
+/*
+EvaluateExpressions updates the expression rules reading symbol, which has
+just had a tick or prediction, looking values up through lookup, and
+returns the rules that fire at now.
+*/
+func (ab *AlertRuleBook) EvaluateExpressions(symbol string, lookup exprLookup, now time.Time) []AlertFiring {
+    ab.mu.Lock()
+    defer ab.mu.Unlock()
+    var fired []AlertFiring
+    for _, r := range ab.rules {
+        if r.Kind != ruleExpression || !r.expr.reads(symbol) {
+            continue
+        }
+        holds, left, right, ok := r.expr.holds(lookup)
+        if !ok {
+            continue
+        }
+        was := r.Active
+        if r.edge(holds, now) {
+            msg := fmt.Sprintf("%s now holds: %.2f %s %.2f (rule %d)", r.Expression, left, r.expr.op, right, r.ID)
+            fired = append(fired, AlertFiring{Rule: *r, Value: left, Message: msg})
+        }
+        ab.dirty = ab.dirty || r.Active != was
+    }
+    if len(fired) > 0 {
+        ab.save()
+    }
+    return fired
+}
+
+/*
+handleCreateAlertRule exposes POST /api/alerts. The body needs symbol and
+kind (default "price") with the fields that kind uses: above and/or below,
+change_percent, or volume_multiple and optionally volume_window; an
+expression rule needs expression instead of symbol. cooldown_seconds
+defaults to 0.
+*/
+func (ab *AlertRuleBook) handleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+    var rule AlertRule
+    if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+        http.Error(w, "invalid rule: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    ab.create(w, rule)
+}
+
+/*
+handleCreatePriceAlert exposes POST /api/alerts/price, which only creates
+price rules.
+*/
+func (ab *AlertRuleBook) handleCreatePriceAlert(w http.ResponseWriter, r *http.Request) {
+    var rule AlertRule
+    if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+        http.Error(w, "invalid rule: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    rule.Kind = rulePrice
+    ab.create(w, rule)
+}
+
+/*
+create validates and stores a new rule and writes it back with its ID.
+*/
+func (ab *AlertRuleBook) create(w http.ResponseWriter, rule AlertRule) {
+    if err := rule.validate(); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    rule.CreatedAt = time.Now()
+    rule.LastPrice, rule.LastFiredAt, rule.Active = nil, nil, false
+    ab.mu.Lock()
+    rule.ID = ab.nextID
+    ab.nextID++
+    ab.rules[rule.ID] = &rule
+    ab.save()
+    ab.mu.Unlock()
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(rule)
+}
+
+/*
+handleListAlertRules exposes GET /api/alerts with each rule's trigger state,
+optionally only those of ?kind=.
+*/
+func (ab *AlertRuleBook) handleListAlertRules(w http.ResponseWriter, r *http.Request) {
+    kind := r.URL.Query().Get("kind")
+    ab.mu.Lock()
+    out := []AlertRule{}
+    for _, rule := range ab.list() {
+        if kind == "" || rule.Kind == kind {
+            out = append(out, rule)
+        }
+    }
+    ab.mu.Unlock()
+    json.NewEncoder(w).Encode(out)
+}
+
+/*
+handleListPriceAlerts exposes GET /api/alerts/price, the price rules only.
+*/
+func (ab *AlertRuleBook) handleListPriceAlerts(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    q.Set("kind", rulePrice)
+    r.URL.RawQuery = q.Encode()
+    ab.handleListAlertRules(w, r)
+}
+
+/*
+handleDeleteAlertRule exposes DELETE /api/alerts/{id} (and its former path
+/api/alerts/price/{id}).
+*/
+func (ab *AlertRuleBook) handleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "invalid id", http.StatusBadRequest)
+        return
+    }
+    ab.mu.Lock()
+    _, ok := ab.rules[id]
+    delete(ab.rules, id)
+    if ok {
+        ab.save()
+    }
+    ab.mu.Unlock()
+    if !ok {
+        http.Error(w, "no rule", http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+checkAlertRules evaluates price, volume and expression rules against a new
+tick and delivers any that fire.
+*/
+func (fp *FinancialProcessor) checkAlertRules(sd StockData) {
+    history := fp.dataStore.Window(sd.Symbol, 0)
+    now := fp.clock.Now()
+    fired := fp.alertRules.Evaluate(sd, history, now)
+    fired = append(fired, fp.alertRules.EvaluateExpressions(sd.Symbol, fp.alertValue, now)...)
+    for _, f := range fired {
+        fp.fireAlert(alertRuleName(f.Rule.Kind), sd.Symbol, f.Value, f.Message)
+    }
+}
+
+/*
+checkPredictionAlerts evaluates predicted change and expression rules
+against a new prediction and delivers any that fire.
+*/
+func (fp *FinancialProcessor) checkPredictionAlerts(p Prediction) {
+    now := fp.clock.Now()
+    fired := fp.alertRules.EvaluatePrediction(p, now)
+    fired = append(fired, fp.alertRules.EvaluateExpressions(p.Symbol, fp.alertValue, now)...)
+    for _, f := range fired {
+        fp.fireAlert(alertRuleName(f.Rule.Kind), p.Symbol, f.Value, f.Message)
+    }
+}
+
+/*
+alertValue looks up a field of symbol's latest tick or prediction for
+expression rules.
+*/
+func (fp *FinancialProcessor) alertValue(symbol, field string) (float64, bool) {
+    switch field {
+    case "price", "volume":
+        sd, ok := fp.dataStore.Latest(symbol)
+        if field == "volume" {
+            return float64(sd.Volume), ok
+        }
+        return sd.Price, ok
+    }
+    p, ok := fp.predictions.Latest(symbol)
+    if field == "predicted_price" {
+        return p.PredictedPrice, ok
+    }
+    return p.PredictedChangePerc, ok
+}
+
+/*
+alertRuleName is the rule name recorded in the alert history for kind. Price
+rules keep their original name, price_level.
+*/
+func alertRuleName(kind string) string {
+    if kind == rulePrice {
+        return "price_level"
+    }
+    return kind
+}
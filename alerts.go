@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Alert delivery states recorded in the history.
+*/
+const (
+    deliveryDelivered  = "delivered"
+    deliveryFailed     = "failed"
+    deliveryNoChannel  = "no_channel"
+    deliveryQueued     = "queued"
+    deliverySuppressed = "suppressed"
+    deliveryPending    = "pending"
+    deliveryDropped    = "dropped"
+    deliverySkipped    = "skipped"
+)
+
+/*
+AlertRecord is one fired alert and the outcome of delivering it.
+*/
+type AlertRecord struct {
+    ID             int64     `json:"id"`
+    Rule           string    `json:"rule"`
+    Symbol         string    `json:"symbol"`
+    TriggerValue   float64   `json:"trigger_value"`
+    Message        string    `json:"message"`
+    FiredAt        time.Time `json:"fired_at"`
+    Recipient      string    `json:"recipient,omitempty"`
+    Channel        string    `json:"channel,omitempty"`
+    DeliveryStatus string    `json:"delivery_status"`
+    DeliveryError  string    `json:"delivery_error,omitempty"`
+}
+
+/*
+AlertHistory keeps fired alerts in memory, bounded by ALERT_HISTORY_MAX
+(default 10000), and appends each one to ALERT_HISTORY_FILE when set so the
+history survives restarts. Lines are encrypted when a storage key is configured.
+*/
+type AlertHistory struct {
+    mu      sync.RWMutex
+    records []AlertRecord
+    nextID  int64
+    max     int
+    path    string
+}
+
+/*
+NewAlertHistoryFromEnv creates the history and reloads any persisted records.
+*/
+func NewAlertHistoryFromEnv() *AlertHistory {
+    ah := &AlertHistory{
+        max:    envInt("ALERT_HISTORY_MAX", 10000),
+        path:   os.Getenv("ALERT_HISTORY_FILE"),
+        nextID: 1,
+    }
+    if ah.path == "" {
+        return ah
+    }
+    f, err := os.Open(ah.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            slog.Error("alert history unavailable", "err", err)
+        }
+        return ah
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 1024*1024)
+    for sc.Scan() {
+        var rec AlertRecord
+        line, err := openSealedLine(sc.Bytes())
+        if err != nil {
+            slog.Warn("skipping unreadable alert history line", "err", err)
+            continue
+        }
+        if err := json.Unmarshal(line, &rec); err != nil {
+            continue
+        }
+        ah.add(rec)
+        if rec.ID >= ah.nextID {
+            ah.nextID = rec.ID + 1
+        }
+    }
+    return ah
+}
+
+/*
+add appends rec to the in-memory window. Callers must hold ah.mu or own ah exclusively.
+*/
+func (ah *AlertHistory) add(rec AlertRecord) {
+    ah.records = append(ah.records, rec)
+    if ah.max > 0 && len(ah.records) > ah.max {
+        ah.records = ah.records[len(ah.records)-ah.max:]
+    }
+}
+
+/*
+Record assigns rec an ID, stores it, and persists it.
+*/
+func (ah *AlertHistory) Record(rec AlertRecord) AlertRecord {
+    ah.mu.Lock()
+    rec.ID = ah.nextID
+    ah.nextID++
+    ah.add(rec)
+    ah.mu.Unlock()
+
+    if ah.path != "" {
+        if err := appendSealedJSONLine(ah.path, rec); err != nil {
+            slog.Error("alert history write error", "err", err)
+        }
+    }
+    return rec
+}
+
+/*
+AlertQuery filters the alert history. Zero values match everything.
+*/
+type AlertQuery struct {
+    Symbol string
+    Rule   string
+    Status string
+    Since  time.Time
+    Until  time.Time
+    Limit  int
+}
+
+/*
+Query returns matching records, newest first.
+*/
+func (ah *AlertHistory) Query(q AlertQuery) []AlertRecord {
+    ah.mu.RLock()
+    defer ah.mu.RUnlock()
+    out := []AlertRecord{}
+    for i := len(ah.records) - 1; i >= 0; i-- {
+        rec := ah.records[i]
+        if q.Symbol != "" && rec.Symbol != q.Symbol ||
+            q.Rule != "" && rec.Rule != q.Rule ||
+            q.Status != "" && rec.DeliveryStatus != q.Status ||
+            !q.Since.IsZero() && rec.FiredAt.Before(q.Since) ||
+            !q.Until.IsZero() && rec.FiredAt.After(q.Until) {
+            continue
+        }
+        out = append(out, rec)
+        if q.Limit > 0 && len(out) >= q.Limit {
+            break
+        }
+    }
+    return out
+}
+
+/*
+AlertDispatcher delivers fired alerts to each recipient's channels (a JSON
+webhook, Slack and Discord webhooks, a Telegram chat and email) through the
+Notifier for each, and records every delivery with its status in the
+history. Alerts for a recipient in quiet hours are recorded as queued and
+sent later as one summary. Operator alerts about the service itself go only
+to the operator's channels, set by OPERATOR_WEBHOOK_URL,
+OPERATOR_SLACK_WEBHOOK_URL, OPERATOR_DISCORD_WEBHOOK_URL,
+OPERATOR_TELEGRAM_CHAT_ID and OPERATOR_EMAIL_TO, which have no quiet hours.
+
+Deliveries never block the caller, which is usually collecting a tick: they
+go into a queue of ALERT_QUEUE_SIZE (default 1000) drained by a goroutine
+that records each outcome once it is known. When the queue is full the
+delivery is recorded as dropped and counted instead.
+*/
+type AlertDispatcher struct {
+    history    *AlertHistory
+    recipients *RecipientBook
+    operator   Recipient
+    notifiers  map[string]Notifier
+    queue      chan alertDelivery
+    dropped    atomic.Int64
+}
+
+/*
+alertDelivery is one queued send of rec on ch.
+*/
+type alertDelivery struct {
+    rec     AlertRecord
+    ch      alertChannel
+    payload interface{}
+}
+
+/*
+smtpSender sends alert emails through the relay at ALERT_SMTP_ADDR
+(host:port) from ALERT_EMAIL_FROM, authenticating with ALERT_SMTP_USER and
+ALERT_SMTP_PASSWORD when a user is set. Connecting and each whole exchange
+are bounded by ALERT_SMTP_TIMEOUT (default 10s).
+*/
+type smtpSender struct {
+    addr    string
+    host    string
+    from    string
+    auth    smtp.Auth
+    timeout time.Duration
+}
+
+/*
+newSMTPSenderFromEnv returns nil when no relay is configured.
+*/
+func newSMTPSenderFromEnv() *smtpSender {
+    addr := os.Getenv("ALERT_SMTP_ADDR")
+    if addr == "" {
+        return nil
+    }
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        log.Fatalf("ALERT_SMTP_ADDR: %v", err)
+    }
+    s := &smtpSender{
+        addr:    addr,
+        host:    host,
+        from:    os.Getenv("ALERT_EMAIL_FROM"),
+        timeout: envDuration("ALERT_SMTP_TIMEOUT", 10*time.Second),
+    }
+    if s.from == "" {
+        s.from = "alerts@" + host
+    }
+    if user := os.Getenv("ALERT_SMTP_USER"); user != "" {
+        s.auth = smtp.PlainAuth("", user, os.Getenv("ALERT_SMTP_PASSWORD"), host)
+    }
+    return s
+}
+
+/*
+send mails a plain text message to one address, as smtp.SendMail would but
+within the timeout, upgrading to TLS when the relay offers it.
+*/
+func (s *smtpSender) send(to, subject, body string) error {
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", s.from, to, subject, time.Now().Format(time.RFC1123Z))
+    msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+    msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+    conn, err := (&net.Dialer{Timeout: s.timeout}).Dial("tcp", s.addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+        return err
+    }
+    c, err := smtp.NewClient(conn, s.host)
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+            return err
+        }
+    }
+    if s.auth != nil {
+        if err := c.Auth(s.auth); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(s.from); err != nil {
+        return err
+    }
+    if err := c.Rcpt(to); err != nil {
+        return err
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(msg.Bytes()); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+/*
+NewAlertDispatcherFromEnv creates a dispatcher writing into history and
+starts draining its delivery queue.
+*/
+func NewAlertDispatcherFromEnv(history *AlertHistory) *AlertDispatcher {
+    size := envInt("ALERT_QUEUE_SIZE", 1000)
+    if size < 1 {
+        log.Fatalf("ALERT_QUEUE_SIZE must be at least 1, got %d", size)
+    }
+    ad := &AlertDispatcher{
+        history:    history,
+        recipients: NewRecipientBookFromEnv(),
+        operator: Recipient{
+            User:       "operator",
+            WebhookURL:     os.Getenv("OPERATOR_WEBHOOK_URL"),
+            SlackURL:       os.Getenv("OPERATOR_SLACK_WEBHOOK_URL"),
+            DiscordURL:     os.Getenv("OPERATOR_DISCORD_WEBHOOK_URL"),
+            TelegramChatID: os.Getenv("OPERATOR_TELEGRAM_CHAT_ID"),
+            Email:          os.Getenv("OPERATOR_EMAIL_TO"),
+        },
+        notifiers:  newNotifiersFromEnv(egressClient(egressWebhook, 10*time.Second)),
+        queue:      make(chan alertDelivery, size),
+    }
+    go ad.drain()
+    return ad
+}
+
+/*
+newSilentAlertDispatcher returns a dispatcher for replays: it records alerts
+in an in-memory history only and delivers nothing, so no recipients are
+configured and every delivery it is asked for is recorded as skipped.
+*/
+func newSilentAlertDispatcher() *AlertDispatcher {
+    return &AlertDispatcher{
+        history:    &AlertHistory{max: envInt("ALERT_HISTORY_MAX", 10000), nextID: 1},
+        recipients: &RecipientBook{recipients: make(map[string]Recipient), queued: make(map[string][]AlertRecord)},
+    }
+}
+
+/*
+Fire queues an alert for every channel of every recipient, or holds it for
+those in quiet hours, and records one outcome per delivery (one per quiet
+recipient). It returns the last record, whose status is pending when it is
+still to be delivered.
+*/
+func (ad *AlertDispatcher) Fire(rule, symbol string, value float64, message string) AlertRecord {
+    rec := AlertRecord{
+        Rule:         rule,
+        Symbol:       symbol,
+        TriggerValue: value,
+        Message:      message,
+        FiredAt:      time.Now(),
+    }
+    recipients := ad.recipients.Recipients()
+    if len(recipients) == 0 {
+        rec.DeliveryStatus = deliveryNoChannel
+        return ad.history.Record(rec)
+    }
+
+    for _, rc := range recipients {
+        rec.Recipient = rc.User
+        rec.DeliveryError = ""
+        if rc.quiet(rec.FiredAt) {
+            rec.Channel = ""
+            rec.DeliveryStatus = deliveryQueued
+            rec = ad.history.Record(rec)
+            ad.recipients.Queue(rc.User, rec)
+            continue
+        }
+        for _, ch := range rc.channels() {
+            rec.Channel = ch.name
+            rec.DeliveryError = ""
+            rec = ad.enqueue(rec, ch, nil)
+        }
+    }
+    return rec
+}
+
+/*
+Suppressed records an alert that is not delivered because leader, in the
+same correlation cluster as symbol, has just raised it.
+*/
+func (ad *AlertDispatcher) Suppressed(rule, symbol string, value float64, message, leader string) AlertRecord {
+    return ad.history.Record(AlertRecord{
+        Rule:           rule,
+        Symbol:         symbol,
+        TriggerValue:   value,
+        Message:        fmt.Sprintf("%s (suppressed: %s in the same cluster already alerted)", message, leader),
+        FiredAt:        time.Now(),
+        DeliveryStatus: deliverySuppressed,
+    })
+}
+
+/*
+FireOperator delivers an operator alert on each of the operator's channels
+and records one outcome per channel under the "operator" recipient.
+*/
+func (ad *AlertDispatcher) FireOperator(rule string, value float64, message string) AlertRecord {
+    rec := AlertRecord{
+        Rule:         rule,
+        TriggerValue: value,
+        Message:      message,
+        FiredAt:      time.Now(),
+    }
+    return ad.SendTo(ad.operator, rec, nil)
+}
+
+/*
+SendTo queues rec for each of rc's channels, ignoring quiet hours, and
+records one outcome per channel under rc's user. Webhooks receive payload,
+or rec itself when payload is nil. It returns the last record.
+*/
+func (ad *AlertDispatcher) SendTo(rc Recipient, rec AlertRecord, payload interface{}) AlertRecord {
+    rec.Recipient = rc.User
+    channels := rc.channels()
+    if len(channels) == 0 {
+        rec.DeliveryStatus = deliveryNoChannel
+        return ad.history.Record(rec)
+    }
+    for _, ch := range channels {
+        rec.Channel = ch.name
+        rec.DeliveryError = ""
+        rec = ad.enqueue(rec, ch, payload)
+    }
+    return rec
+}
+
+/*
+enqueue queues the delivery of rec on ch and returns rec as pending, or
+records it as dropped when the queue is full. A nil payload sends rec. A
+dispatcher without a queue records it as skipped.
+*/
+func (ad *AlertDispatcher) enqueue(rec AlertRecord, ch alertChannel, payload interface{}) AlertRecord {
+    if ad.queue == nil {
+        rec.DeliveryStatus = deliverySkipped
+        return ad.history.Record(rec)
+    }
+    rec.DeliveryStatus = deliveryPending
+    select {
+    case ad.queue <- alertDelivery{rec: rec, ch: ch, payload: payload}:
+        return rec
+    default:
+    }
+    ad.dropped.Add(1)
+    slog.Warn("alert delivery queue full; dropping delivery", "rule", rec.Rule, "symbol", rec.Symbol, "recipient", rec.Recipient, "channel", ch.name)
+    rec.DeliveryStatus = deliveryDropped
+    rec.DeliveryError = "delivery queue full"
+    return ad.history.Record(rec)
+}
+
+/*
+drain delivers queued alerts one at a time and records their outcomes.
+*/
+func (ad *AlertDispatcher) drain() {
+    for d := range ad.queue {
+        payload := d.payload
+        if payload == nil {
+            payload = d.rec
+        }
+        ad.deliver(&d.rec, d.ch, payload)
+        ad.history.Record(d.rec)
+    }
+}
+
+/*
+deliver sends an alert on ch and sets rec's delivery status from the
+outcome. Webhooks receive payload as JSON; the other channels receive rec's
+message.
+*/
+func (ad *AlertDispatcher) deliver(rec *AlertRecord, ch alertChannel, payload interface{}) {
+    subject := "Alert: " + rec.Rule
+    if rec.Symbol != "" {
+        subject += " " + rec.Symbol
+    }
+    err := ad.notifiers[ch.name].Notify(ch.target, Notification{Subject: subject, Text: rec.Message, Payload: payload})
+    if err != nil {
+        rec.DeliveryStatus = deliveryFailed
+        rec.DeliveryError = err.Error()
+        slog.Warn("alert delivery failed", "rule", rec.Rule, "symbol", rec.Symbol, "recipient", rec.Recipient, "channel", ch.name, "err", err)
+    } else {
+        rec.DeliveryStatus = deliveryDelivered
+    }
+}
+
+/*
+RunSummaries checks every minute for recipients whose quiet hours have ended
+and sends each one a single summary of the alerts queued meanwhile.
+*/
+func (ad *AlertDispatcher) RunSummaries() {
+    for range time.Tick(time.Minute) {
+        ad.flushQueued(time.Now())
+    }
+}
+
+/*
+flushQueued sends the summaries due at now and records them under the
+"quiet_hours_summary" rule.
+*/
+func (ad *AlertDispatcher) flushQueued(now time.Time) {
+    due := ad.recipients.Due(now)
+    if len(due) == 0 {
+        return
+    }
+    channels := make(map[string][]alertChannel)
+    for _, rc := range ad.recipients.Recipients() {
+        channels[rc.User] = rc.channels()
+    }
+    for user, held := range due {
+        symbols := make(map[string]bool)
+        for _, h := range held {
+            symbols[h.Symbol] = true
+        }
+        rec := AlertRecord{
+            Rule:         "quiet_hours_summary",
+            TriggerValue: float64(len(held)),
+            Message:      fmt.Sprintf("%d alerts for %d symbols held during quiet hours", len(held), len(symbols)),
+            FiredAt:      now,
+            Recipient:    user,
+        }
+        for _, ch := range channels[user] {
+            rec.Channel = ch.name
+            rec.DeliveryError = ""
+            ad.enqueue(rec, ch, map[string]interface{}{
+                "rule":      rec.Rule,
+                "recipient": user,
+                "message":   rec.Message,
+                "fired_at":  now,
+                "alerts":    held,
+            })
+        }
+    }
+}
+
+/*
+handleAlertHistory exposes GET /api/alerts/history with optional symbol, rule,
+status, since and until (RFC 3339) and limit query filters.
+*/
+func (ad *AlertDispatcher) handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+    qs := r.URL.Query()
+    q := AlertQuery{Symbol: qs.Get("symbol"), Rule: qs.Get("rule"), Status: qs.Get("status")}
+    var err error
+    if v := qs.Get("since"); v != "" {
+        if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+            http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    if v := qs.Get("until"); v != "" {
+        if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+            http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    if v := qs.Get("limit"); v != "" {
+        if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+            http.Error(w, "invalid limit", http.StatusBadRequest)
+            return
+        }
+    }
+    streamJSONArray(w, ad.history.Query(q))
+}
+
+/*
+writeMetrics appends the delivery queue's depth and the deliveries dropped
+because it was full in Prometheus text format.
+*/
+func (ad *AlertDispatcher) writeMetrics(sb *strings.Builder) {
+    sb.WriteString("# HELP alert_queue_depth Alert deliveries waiting to be sent.\n")
+    sb.WriteString("# TYPE alert_queue_depth gauge\n")
+    fmt.Fprintf(sb, "alert_queue_depth %d\n", len(ad.queue))
+    sb.WriteString("# HELP alert_deliveries_dropped_total Alert deliveries dropped because the queue was full.\n")
+    sb.WriteString("# TYPE alert_deliveries_dropped_total counter\n")
+    fmt.Fprintf(sb, "alert_deliveries_dropped_total %d\n", ad.dropped.Load())
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Annotation is a user note attached to a symbol at a point in time, such as a
+price point or a prediction ("Fed announcement").
+*/
+type Annotation struct {
+    ID        int64     `json:"id"`
+    Symbol    string    `json:"symbol"`
+    Timestamp time.Time `json:"timestamp"`
+    Target    string    `json:"target"`
+    Text      string    `json:"text"`
+    CreatedAt time.Time `json:"created_at"`
+    Deleted   bool      `json:"deleted,omitempty"`
+}
+
+/*
+AnnotationStore keeps annotations in memory and appends every change to
+ANNOTATIONS_FILE when set; deletions are written as tombstones.
+*/
+type AnnotationStore struct {
+    mu     sync.RWMutex
+    notes  map[int64]Annotation
+    nextID int64
+    path   string
+}
+
+/*
+NewAnnotationStoreFromEnv creates the store and replays ANNOTATIONS_FILE.
+*/
+func NewAnnotationStoreFromEnv() *AnnotationStore {
+    as := &AnnotationStore{notes: make(map[int64]Annotation), nextID: 1, path: os.Getenv("ANNOTATIONS_FILE")}
+    if as.path == "" {
+        return as
+    }
+    f, err := os.Open(as.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            slog.Error("annotations unavailable", "err", err)
+        }
+        return as
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 1024*1024)
+    for sc.Scan() {
+        line, err := openSealedLine(sc.Bytes())
+        if err != nil {
+            slog.Warn("skipping unreadable annotation line", "err", err)
+            continue
+        }
+        var a Annotation
+        if err := json.Unmarshal(line, &a); err != nil {
+            continue
+        }
+        if a.Deleted {
+            delete(as.notes, a.ID)
+        } else {
+            as.notes[a.ID] = a
+        }
+        if a.ID >= as.nextID {
+            as.nextID = a.ID + 1
+        }
+    }
+    return as
+}
+
+/*
+persist appends a to the annotations file.
+*/
+func (as *AnnotationStore) persist(a Annotation) {
+    if as.path == "" {
+        return
+    }
+    if err := appendSealedJSONLine(as.path, a); err != nil {
+        slog.Error("annotation write error", "err", err)
+    }
+}
+
+/*
+Add stores a new annotation and returns it with its ID.
+*/
+func (as *AnnotationStore) Add(a Annotation) Annotation {
+    as.mu.Lock()
+    a.ID = as.nextID
+    as.nextID++
+    as.notes[a.ID] = a
+    as.mu.Unlock()
+    as.persist(a)
+    return a
+}
+
+/*
+Delete removes an annotation, reporting whether it existed.
+*/
+func (as *AnnotationStore) Delete(id int64) bool {
+    as.mu.Lock()
+    a, ok := as.notes[id]
+    delete(as.notes, id)
+    as.mu.Unlock()
+    if ok {
+        a.Deleted = true
+        as.persist(a)
+    }
+    return ok
+}
+
+/*
+List returns symbol's annotations (all symbols when empty) whose timestamp
+falls within [since, until], oldest first. Zero bounds are open.
+*/
+func (as *AnnotationStore) List(symbol string, since, until time.Time) []Annotation {
+    as.mu.RLock()
+    out := []Annotation{}
+    for _, a := range as.notes {
+        if symbol != "" && a.Symbol != symbol ||
+            !since.IsZero() && a.Timestamp.Before(since) ||
+            !until.IsZero() && a.Timestamp.After(until) {
+            continue
+        }
+        out = append(out, a)
+    }
+    as.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool {
+        if !out[i].Timestamp.Equal(out[j].Timestamp) {
+            return out[i].Timestamp.Before(out[j].Timestamp)
+        }
+        return out[i].ID < out[j].ID
+    })
+    return out
+}
+
+/*
+attachAnnotations sets Annotations on the tick nearest in time to each
+annotation that falls within the span of data, which must be oldest first.
+*/
+func (as *AnnotationStore) attachAnnotations(symbol string, data []StockData) {
+    if len(data) == 0 {
+        return
+    }
+    for _, a := range as.List(symbol, data[0].Timestamp, data[len(data)-1].Timestamp) {
+        i := sort.Search(len(data), func(i int) bool { return !data[i].Timestamp.Before(a.Timestamp) })
+        if i > 0 && (i == len(data) ||
+            math.Abs(float64(a.Timestamp.Sub(data[i-1].Timestamp))) < math.Abs(float64(data[i].Timestamp.Sub(a.Timestamp)))) {
+            i--
+        }
+        data[i].Annotations = append(data[i].Annotations, a)
+    }
+}
+
+/*
+handleCreateAnnotation exposes POST /api/annotations. The body needs symbol
+and text; timestamp defaults to now and target (price or prediction) to price.
+*/
+func (as *AnnotationStore) handleCreateAnnotation(w http.ResponseWriter, r *http.Request) {
+    var a Annotation
+    if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+        http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    a.Text = strings.TrimSpace(a.Text)
+    if a.Symbol == "" || a.Text == "" {
+        http.Error(w, "symbol and text are required", http.StatusBadRequest)
+        return
+    }
+    switch a.Target {
+    case "":
+        a.Target = "price"
+    case "price", "prediction":
+    default:
+        http.Error(w, "target must be price or prediction", http.StatusBadRequest)
+        return
+    }
+    a.CreatedAt = time.Now()
+    if a.Timestamp.IsZero() {
+        a.Timestamp = a.CreatedAt
+    }
+    a.Deleted = false
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(as.Add(a))
+}
+
+/*
+handleListAnnotations exposes GET /api/annotations with optional symbol,
+since and until (RFC 3339) filters.
+*/
+func (as *AnnotationStore) handleListAnnotations(w http.ResponseWriter, r *http.Request) {
+    qs := r.URL.Query()
+    var since, until time.Time
+    var err error
+    if v := qs.Get("since"); v != "" {
+        if since, err = time.Parse(time.RFC3339, v); err != nil {
+            http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    if v := qs.Get("until"); v != "" {
+        if until, err = time.Parse(time.RFC3339, v); err != nil {
+            http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    json.NewEncoder(w).Encode(as.List(qs.Get("symbol"), since, until))
+}
+
+/*
+handleDeleteAnnotation exposes DELETE /api/annotations/{id}.
+*/
+func (as *AnnotationStore) handleDeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        http.Error(w, "invalid id", http.StatusBadRequest)
+        return
+    }
+    if !as.Delete(id) {
+        http.Error(w, "no annotation", http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+PayloadArchive persists the exact request body sent to the ML service for each
+prediction as a gzip-compressed file, so any forecast can be replayed later
+against a new model version. Retention is bounded by file count and age,
+enforced against an index of the archived files built when the archive is
+opened and kept up to date as payloads are saved, so saving does not scan
+the directory.
+*/
+type PayloadArchive struct {
+    dir      string
+    maxFiles int
+    maxAge   time.Duration
+    mu       sync.Mutex
+    files    []archivedPayload
+}
+
+/*
+archivedPayload is one file in the archive and when it was written; the
+index holds them oldest first.
+*/
+type archivedPayload struct {
+    path string
+    at   time.Time
+}
+
+/*
+NewPayloadArchiveFromEnv returns an archive rooted at PREDICTION_ARCHIVE_DIR,
+(under a NAMESPACE subdirectory when one is set), or nil when archiving is
+disabled. PREDICTION_ARCHIVE_MAX_FILES (default 10000)
+and PREDICTION_ARCHIVE_MAX_AGE (default 168h) bound retention; zero disables a limit.
+*/
+func NewPayloadArchiveFromEnv() *PayloadArchive {
+    dir := namespacedDir(os.Getenv("PREDICTION_ARCHIVE_DIR"))
+    if dir == "" {
+        return nil
+    }
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        slog.Error("payload archive disabled", "err", err)
+        return nil
+    }
+    pa := &PayloadArchive{
+        dir:      dir,
+        maxFiles: envInt("PREDICTION_ARCHIVE_MAX_FILES", 10000),
+        maxAge:   envDuration("PREDICTION_ARCHIVE_MAX_AGE", 7*24*time.Hour),
+    }
+    pa.index()
+    pa.prune(time.Now())
+    return pa
+}
+
+/*
+index lists the payloads already archived, by modification time.
+*/
+func (pa *PayloadArchive) index() {
+    entries, err := os.ReadDir(pa.dir)
+    if err != nil {
+        slog.Error("payload archive unreadable", "err", err)
+        return
+    }
+    for _, e := range entries {
+        if e.IsDir() || !strings.HasSuffix(e.Name(), ".json.gz") {
+            continue
+        }
+        if info, err := e.Info(); err == nil {
+            pa.files = append(pa.files, archivedPayload{filepath.Join(pa.dir, e.Name()), info.ModTime()})
+        }
+    }
+    sort.Slice(pa.files, func(i, j int) bool { return pa.files[i].at.Before(pa.files[j].at) })
+}
+
+/*
+Save writes body to <dir>/<symbol>-<unixnano>.json.gz (encrypted after
+compression when a storage key is configured), applies the retention limits,
+and returns the path of the new file.
+*/
+func (pa *PayloadArchive) Save(symbol string, ts time.Time, body []byte) (string, error) {
+    name := fmt.Sprintf("%s-%d.json.gz", sanitizeFileComponent(symbol), ts.UnixNano())
+    path := filepath.Join(pa.dir, name)
+
+    var buf bytes.Buffer
+    zw := gzip.NewWriter(&buf)
+    zw.Name = strings.TrimSuffix(name, ".gz")
+    zw.ModTime = ts
+    if _, err := zw.Write(body); err != nil {
+        return "", err
+    }
+    if err := zw.Close(); err != nil {
+        return "", err
+    }
+
+    data, err := sealAtRest(buf.Bytes())
+    if err != nil {
+        return "", err
+    }
+
+    pa.mu.Lock()
+    defer pa.mu.Unlock()
+    if err := os.WriteFile(path, data, 0o644); err != nil {
+        return "", err
+    }
+    i := sort.Search(len(pa.files), func(i int) bool { return pa.files[i].at.After(ts) })
+    pa.files = append(pa.files, archivedPayload{})
+    copy(pa.files[i+1:], pa.files[i:])
+    pa.files[i] = archivedPayload{path, ts}
+    pa.prune(ts)
+    return path, nil
+}
+
+/*
+prune removes archived payloads older than maxAge and, if still above maxFiles,
+the oldest remaining ones. Callers must hold pa.mu or own pa exclusively.
+*/
+func (pa *PayloadArchive) prune(now time.Time) {
+    drop := 0
+    for pa.maxAge > 0 && drop < len(pa.files) && now.Sub(pa.files[drop].at) > pa.maxAge {
+        drop++
+    }
+    if pa.maxFiles > 0 && len(pa.files)-drop > pa.maxFiles {
+        drop = len(pa.files) - pa.maxFiles
+    }
+    if drop == 0 {
+        return
+    }
+    for _, f := range pa.files[:drop] {
+        if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+            slog.Warn("removing archived payload failed", "path", f.path, "err", err)
+        }
+    }
+    pa.files = append(pa.files[:0], pa.files[drop:]...)
+}
+
+/*
+readArchivedPayload returns the decrypted, decompressed request body stored at path.
+*/
+func readArchivedPayload(path string) ([]byte, error) {
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    if raw, err = openAtRest(raw); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    zr, err := gzip.NewReader(bytes.NewReader(raw))
+    if err != nil {
+        return nil, err
+    }
+    defer zr.Close()
+    return io.ReadAll(zr)
+}
+
+/*
+sanitizeFileComponent replaces characters that are unsafe in file names,
+such as the "^" and "=" used by index and currency tickers.
+*/
+func sanitizeFileComponent(s string) string {
+    return strings.Map(func(r rune) rune {
+        switch {
+        case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+            return r
+        }
+        return '_'
+    }, s)
+}
+
+/*
+runReproduce implements the `reproduce` subcommand, which resends an archived
+prediction payload to the ML service routed for its symbol and prints the
+response, so a past forecast can be compared against the current model version.
+*/
+func runReproduce(args []string) error {
+    fs := flag.NewFlagSet("reproduce", flag.ContinueOnError)
+    file := fs.String("file", "", "archived payload (.json.gz) to resend")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if *file == "" {
+        return errors.New("-file is required")
+    }
+
+    body, err := readArchivedPayload(*file)
+    if err != nil {
+        return err
+    }
+    var payload struct {
+        Symbol string `json:"symbol"`
+    }
+    json.Unmarshal(body, &payload)
+    _, url := NewMLRouterFromEnv().Resolve(payload.Symbol, "/predict")
+
+    ml, err := NewMLClientFromEnv()
+    if err != nil {
+        return err
+    }
+    resp, err := ml.Post(url, body)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    out, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("ML service returned %s: %s", resp.Status, bytes.TrimSpace(out))
+    }
+    fmt.Println(string(bytes.TrimSpace(out)))
+    return nil
+}
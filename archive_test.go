@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+/*
+archivedNames returns the names of the payload files in dir, sorted.
+*/
+func archivedNames(t *testing.T, dir string) []string {
+    t.Helper()
+    paths, err := filepath.Glob(filepath.Join(dir, "*.json.gz"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, p := range paths {
+        names = append(names, filepath.Base(p))
+    }
+    sort.Strings(names)
+    return names
+}
+
+func TestPayloadArchiveRetention(t *testing.T) {
+    start := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name     string
+        maxFiles string
+        maxAge   string
+        offsets  []time.Duration
+        want     []time.Duration
+    }{
+        {"under both limits", "5", "1h", []time.Duration{0, time.Minute}, []time.Duration{0, time.Minute}},
+        {"count limit keeps newest", "2", "0", []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute}, []time.Duration{2 * time.Minute, 3 * time.Minute}},
+        {"age limit drops old", "0", "10m", []time.Duration{0, 5 * time.Minute, 20 * time.Minute}, []time.Duration{20 * time.Minute}},
+        {"out of order saves", "2", "0", []time.Duration{2 * time.Minute, 0, time.Minute}, []time.Duration{time.Minute, 2 * time.Minute}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            t.Setenv("PREDICTION_ARCHIVE_DIR", dir)
+            t.Setenv("PREDICTION_ARCHIVE_MAX_FILES", tt.maxFiles)
+            t.Setenv("PREDICTION_ARCHIVE_MAX_AGE", tt.maxAge)
+            pa := NewPayloadArchiveFromEnv()
+            for _, off := range tt.offsets {
+                if _, err := pa.Save("AAPL", start.Add(off), []byte(`{}`)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            var want []string
+            for _, off := range tt.want {
+                want = append(want, fmt.Sprintf("AAPL-%d.json.gz", start.Add(off).UnixNano()))
+            }
+            sort.Strings(want)
+            if got := archivedNames(t, dir); !reflect.DeepEqual(got, want) {
+                t.Errorf("archived %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+func TestPayloadArchiveIndexesExistingFiles(t *testing.T) {
+    dir := t.TempDir()
+    t.Setenv("PREDICTION_ARCHIVE_DIR", dir)
+    t.Setenv("PREDICTION_ARCHIVE_MAX_AGE", "0")
+    old := time.Now().Add(-time.Hour)
+    for _, name := range []string{"MSFT-1.json.gz", "MSFT-2.json.gz"} {
+        p := filepath.Join(dir, name)
+        if err := os.WriteFile(p, nil, 0o644); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Chtimes(p, old, old); err != nil {
+            t.Fatal(err)
+        }
+        old = old.Add(time.Minute)
+    }
+    t.Setenv("PREDICTION_ARCHIVE_MAX_FILES", "2")
+    pa := NewPayloadArchiveFromEnv()
+    now := time.Now()
+    if _, err := pa.Save("MSFT", now, []byte(`{}`)); err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"MSFT-2.json.gz", fmt.Sprintf("MSFT-%d.json.gz", now.UnixNano())}
+    sort.Strings(want)
+    if got := archivedNames(t, dir); !reflect.DeepEqual(got, want) {
+        t.Errorf("archived %v, want %v", got, want)
+    }
+}
@@ -0,0 +1,87 @@
+package main
+
+import "time"
+
+/*
+Asset classes recorded on StockData and Instrument. Equities (including
+funds), ETFs and indexes trade in the exchange session, FX pairs around the
+clock on weekdays and crypto pairs around the clock. Watchlist indexes are
+recorded as indexes too.
+*/
+const (
+    assetEquity = "equity"
+    assetETF    = "etf"
+    assetCrypto = "crypto"
+    assetFX     = "fx"
+    assetIndex  = "index"
+)
+
+/*
+assetClasses is the set of known asset classes.
+*/
+var assetClasses = map[string]bool{assetEquity: true, assetETF: true, assetCrypto: true, assetFX: true, assetIndex: true}
+
+/*
+assetClassFromYahoo maps Yahoo's instrumentType or quoteType to an asset
+class, falling back to the symbol's notation when the type is missing.
+*/
+func assetClassFromYahoo(instrumentType, symbol string) string {
+    switch instrumentType {
+    case "CRYPTOCURRENCY":
+        return assetCrypto
+    case "ETF":
+        return assetETF
+    case "CURRENCY":
+        return assetFX
+    case "INDEX":
+        return assetIndex
+    case "":
+        return assetClassOf(symbol)
+    }
+    return assetEquity
+}
+
+/*
+assetClassOf infers symbol's asset class from its notation (see
+ParseInstrument).
+*/
+func assetClassOf(symbol string) string {
+    return ParseInstrument(symbol).Class
+}
+
+/*
+tradesAt reports whether symbol's market is open at t: always for crypto,
+during its calendar's sessions otherwise.
+*/
+func (fp *FinancialProcessor) tradesAt(symbol string, t time.Time) bool {
+    mc := fp.calendars.For(symbol)
+    return mc == nil || mc.IsOpen(t)
+}
+
+/*
+marketInterval applies market hours to base, symbol's configured collection
+interval. Outside its calendar's sessions a symbol is collected as the
+market_closed setting says: every market closed interval, not until the next
+open, or as usual; while the service is idle it waits at least IDLE_INTERVAL.
+Crypto pairs trade around the clock and keep base.
+*/
+func (fp *FinancialProcessor) marketInterval(symbol string, base time.Duration) time.Duration {
+    mc := fp.calendars.For(symbol)
+    if mc == nil {
+        return base
+    }
+    interval := base
+    if now := fp.clock.Now(); !mc.IsOpen(now) {
+        cfg := fp.currentConfig()
+        switch cfg.MarketClosed {
+        case "pause":
+            return mc.NextOpen(now).Sub(now)
+        case "slow":
+            interval = cfg.ClosedInterval
+        }
+    }
+    if fp.idle.Idle() && fp.idle.interval > interval {
+        interval = fp.idle.interval
+    }
+    return interval
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+API key scopes. Admin implies read.
+*/
+const (
+    scopeRead  = "read"
+    scopeAdmin = "admin"
+)
+
+/*
+KeyAuth enforces scoped API keys. Keys come from API_KEYS as comma-separated
+key:scope pairs, e.g. "k1:read,k2:admin"; ADMIN_TOKEN is accepted as an admin
+key. Clients send a key as "Authorization: Bearer <key>" or "X-API-Key: <key>".
+*/
+type KeyAuth struct {
+    keys     map[string]string
+    enforced bool
+    mu       sync.Mutex
+    counts   map[[2]string]uint64
+}
+
+/*
+NewKeyAuthFromEnv parses API_KEYS and ADMIN_TOKEN. Reads from the public API
+stay open unless API_KEYS is set.
+*/
+func NewKeyAuthFromEnv() (*KeyAuth, error) {
+    ka := &KeyAuth{keys: make(map[string]string), counts: make(map[[2]string]uint64)}
+    for _, entry := range strings.Split(os.Getenv("API_KEYS"), ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        key, scope, ok := strings.Cut(entry, ":")
+        if !ok || key == "" || scope != scopeRead && scope != scopeAdmin {
+            return nil, fmt.Errorf("API_KEYS: entries must be key:read or key:admin")
+        }
+        ka.keys[key] = scope
+        ka.enforced = true
+    }
+    if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+        ka.keys[token] = scopeAdmin
+    }
+    return ka, nil
+}
+
+/*
+keyOf returns the configured key the request carries and its scope, or ""
+twice when it carries no valid key. Every configured key is compared in
+constant time.
+*/
+func (ka *KeyAuth) keyOf(r *http.Request) (string, string) {
+    got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok {
+        got = r.Header.Get("X-API-Key")
+    }
+    if got == "" {
+        return "", ""
+    }
+    match, scope := "", ""
+    for key, s := range ka.keys {
+        if subtle.ConstantTimeCompare([]byte(got), []byte(key)) == 1 {
+            match, scope = key, s
+        }
+    }
+    return match, scope
+}
+
+/*
+scopeOf returns the scope granted by the request's key, or "" when it carries
+no valid key.
+*/
+func (ka *KeyAuth) scopeOf(r *http.Request) string {
+    _, scope := ka.keyOf(r)
+    return scope
+}
+
+/*
+keyID identifies the request's key without revealing it: the first 16 hex
+digits of its SHA-256. It returns "" when the request carries no valid key.
+*/
+func (ka *KeyAuth) keyID(r *http.Request) string {
+    key, _ := ka.keyOf(r)
+    if key == "" {
+        return ""
+    }
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:8])
+}
+
+/*
+hasAdminKeys reports whether any key grants the admin scope.
+*/
+func (ka *KeyAuth) hasAdminKeys() bool {
+    for _, s := range ka.keys {
+        if s == scopeAdmin {
+            return true
+        }
+    }
+    return false
+}
+
+/*
+count records one authorization decision for the per-scope metrics.
+*/
+func (ka *KeyAuth) count(scope, result string) {
+    ka.mu.Lock()
+    ka.counts[[2]string{scope, result}]++
+    ka.mu.Unlock()
+}
+
+/*
+authorize checks that the request holds scope, writing 401 or 403 otherwise.
+*/
+func (ka *KeyAuth) authorize(w http.ResponseWriter, r *http.Request, scope string) bool {
+    got := ka.scopeOf(r)
+    switch {
+    case got == "":
+        ka.count(scope, "unauthenticated")
+        w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+        return false
+    case scope == scopeAdmin && got != scopeAdmin:
+        ka.count(scope, "forbidden")
+        http.Error(w, "admin scope required", http.StatusForbidden)
+        return false
+    }
+    ka.count(scope, "allowed")
+    return true
+}
+
+/*
+Middleware protects the public API: GET and HEAD requests need the read
+scope, anything that changes state needs admin. Routes under /api/digest
+only touch the caller's own subscription and need the read scope for every
+method, as does POST /api/backtest, which changes nothing. Reads are only
+checked once API_KEYS is set, changes whenever any admin key is configured,
+so ADMIN_TOKEN alone already protects symbols, alerts and positions. Admin
+routes are checked by RequireAdmin instead.
+*/
+func (ka *KeyAuth) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasPrefix(r.URL.Path, "/api/admin") || strings.HasPrefix(r.URL.Path, "/debug/") {
+            next.ServeHTTP(w, r)
+            return
+        }
+        scope := scopeRead
+        if r.Method != http.MethodGet && r.Method != http.MethodHead && !strings.HasPrefix(r.URL.Path, "/api/digest") && r.URL.Path != "/api/backtest" {
+            scope = scopeAdmin
+        }
+        if !ka.enforced && (scope == scopeRead || !ka.hasAdminKeys()) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if ka.authorize(w, r, scope) {
+            next.ServeHTTP(w, r)
+        }
+    })
+}
+
+/*
+RequireAdmin wraps admin-only handlers. When no admin key is configured the
+admin routes are disabled entirely.
+*/
+func (ka *KeyAuth) RequireAdmin(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !ka.hasAdminKeys() {
+            http.Error(w, "admin API disabled: set ADMIN_TOKEN or an admin key in API_KEYS", http.StatusForbidden)
+            return
+        }
+        if ka.authorize(w, r, scopeAdmin) {
+            next.ServeHTTP(w, r)
+        }
+    })
+}
+
+/*
+writeMetrics appends auth_requests_total by required scope and result.
+*/
+func (ka *KeyAuth) writeMetrics(sb *strings.Builder) {
+    ka.mu.Lock()
+    defer ka.mu.Unlock()
+    keys := make([][2]string, 0, len(ka.counts))
+    for k := range ka.counts {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i][0] != keys[j][0] {
+            return keys[i][0] < keys[j][0]
+        }
+        return keys[i][1] < keys[j][1]
+    })
+    sb.WriteString("# HELP auth_requests_total Authorization decisions by required scope and result.\n")
+    sb.WriteString("# TYPE auth_requests_total counter\n")
+    for _, k := range keys {
+        fmt.Fprintf(sb, "auth_requests_total{scope=%q,result=%q} %d\n", k[0], k[1], ka.counts[k])
+    }
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyAuthMiddleware(t *testing.T) {
+    tests := []struct {
+        name    string
+        apiKeys string
+        admin   string
+        method  string
+        path    string
+        key     string
+        want    int
+    }{
+        {"open without keys", "", "", http.MethodPost, "/api/symbols", "", http.StatusOK},
+        {"admin token only: reads stay open", "", "tok", http.MethodGet, "/api/symbols", "", http.StatusOK},
+        {"admin token only: add symbol without token", "", "tok", http.MethodPost, "/api/symbols", "", http.StatusUnauthorized},
+        {"admin token only: alert rule with wrong token", "", "tok", http.MethodPost, "/api/alerts", "nope", http.StatusUnauthorized},
+        {"admin token only: position with token", "", "tok", http.MethodPut, "/api/positions/AAPL", "tok", http.StatusOK},
+        {"admin token only: delete symbol with token", "", "tok", http.MethodDelete, "/api/symbols/AAPL", "tok", http.StatusOK},
+        {"admin token only: backtest stays open", "", "tok", http.MethodPost, "/api/backtest", "", http.StatusOK},
+        {"api keys: read without key", "r:read", "", http.MethodGet, "/api/data", "", http.StatusUnauthorized},
+        {"api keys: read with read key", "r:read", "", http.MethodGet, "/api/data", "r", http.StatusOK},
+        {"api keys: change with read key", "r:read", "", http.MethodPost, "/api/alerts", "r", http.StatusForbidden},
+        {"api keys: change with admin token", "r:read", "tok", http.MethodPost, "/api/alerts", "tok", http.StatusOK},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            t.Setenv("API_KEYS", tt.apiKeys)
+            t.Setenv("ADMIN_TOKEN", tt.admin)
+            ka, err := NewKeyAuthFromEnv()
+            if err != nil {
+                t.Fatal(err)
+            }
+            h := ka.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+            req := httptest.NewRequest(tt.method, tt.path, nil)
+            if tt.key != "" {
+                req.Header.Set("Authorization", "Bearer "+tt.key)
+            }
+            rec := httptest.NewRecorder()
+            h.ServeHTTP(rec, req)
+            if rec.Code != tt.want {
+                t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+            }
+        })
+    }
+}
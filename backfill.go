@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+backfill seeds each symbol's history with the last BACKFILL_DAYS days of
+BACKFILL_INTERVAL candles (default 5m) from the chart API, so predictions can
+start straight away instead of after five live scrapes. Only candles newer
+than a symbol's stored history are added, which after a restart fills the
+gap since the last run. Symbols with a SYMBOL_SOURCES override are skipped.
+It must run before collection starts so history stays in time order.
+*/
+func (fp *FinancialProcessor) backfill(symbols []string, days int) {
+    interval := os.Getenv("BACKFILL_INTERVAL")
+    if interval == "" {
+        interval = "5m"
+    }
+    since := fp.clock.Now().AddDate(0, 0, -days)
+
+    var wg sync.WaitGroup
+    for _, sym := range symbols {
+        if _, custom := fp.sources[sym]; custom || fp.delisting.Inactive(sym) {
+            continue
+        }
+        wg.Add(1)
+        go func(sym string) {
+            defer wg.Done()
+            fp.ramp.Wait()
+            start := time.Now()
+            candles, err := FetchCandles(sym, since, interval)
+            fp.latency.Observe(depYahoo, time.Since(start), err)
+            if err != nil {
+                slog.Warn("backfill failed", "symbol", sym, "source", depYahoo, "err", err)
+                return
+            }
+            var latest time.Time
+            if sd, ok := fp.dataStore.Latest(sym); ok {
+                latest = sd.Timestamp
+            }
+            added := 0
+            for _, sd := range candles {
+                if sd.Timestamp.After(latest) {
+                    fp.dataStore.Append(sym, sd)
+                    added++
+                }
+            }
+            slog.Info("backfilled candles", "symbol", sym, "candles", added, "interval", interval)
+            if fp.dataStore.Len(sym) >= 5 && added > 0 {
+                fp.pending.Add(1)
+                fp.sched.Background(func() {
+                    defer fp.pending.Done()
+                    fp.getPrediction(sym)
+                })
+            }
+        }(sym)
+    }
+    wg.Wait()
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+BacktestRequest is the body of POST /api/backtest. Strategy is "ml", which
+asks the ML service for a prediction at every step from the history up to
+it, or one of the local indicator strategies:
+
+  - sma_crossover: long while the Fast SMA (default 10) is above the Slow
+    SMA (default 30).
+  - macd: long while MACD is above its signal line.
+  - rsi: goes long when RSI (Period, default 14) falls below Lower (default
+    30) and flat again once it rises above Upper (default 70).
+  - bollinger: goes long when the price closes below the lower band and flat
+    once it is back above the middle.
+
+With AllowShort the crossover, MACD and ML strategies go short instead of
+flat. ThresholdPercent is the predicted move the ml strategy needs to take
+a position (default 0.5). Every, when set, only lets the strategy change
+its position once per that long (e.g. "5m"), which also bounds the ML calls.
+FeeBps is charged on every change of position.
+*/
+type BacktestRequest struct {
+    Symbol           string          `json:"symbol"`
+    From             time.Time       `json:"from"`
+    To               time.Time       `json:"to"`
+    Strategy         string          `json:"strategy"`
+    Params           IndicatorParams `json:"params"`
+    Lower            float64         `json:"lower,omitempty"`
+    Upper            float64         `json:"upper,omitempty"`
+    ThresholdPercent float64         `json:"threshold_percent,omitempty"`
+    AllowShort       bool            `json:"allow_short"`
+    Every            string          `json:"every,omitempty"`
+    FeeBps           float64         `json:"fee_bps"`
+}
+
+/*
+BacktestTrade is one position held from Entry to Exit.
+*/
+type BacktestTrade struct {
+    Side          string    `json:"side"`
+    Entry         time.Time `json:"entry"`
+    EntryPrice    float64   `json:"entry_price"`
+    Exit          time.Time `json:"exit"`
+    ExitPrice     float64   `json:"exit_price"`
+    ReturnPercent float64   `json:"return_percent"`
+}
+
+/*
+BacktestResult reports a backtest: the compounded return of the strategy
+after fees next to buying and holding, the largest peak-to-trough fall of
+its equity, and the share of closed trades that made money. A position
+still open at the end is closed at the last price.
+*/
+type BacktestResult struct {
+    Symbol               string          `json:"symbol"`
+    Strategy             string          `json:"strategy"`
+    From                 time.Time       `json:"from"`
+    To                   time.Time       `json:"to"`
+    Ticks                int             `json:"ticks"`
+    ReturnPercent        float64         `json:"return_percent"`
+    BuyHoldReturnPercent float64         `json:"buy_hold_return_percent"`
+    MaxDrawdownPercent   float64         `json:"max_drawdown_percent"`
+    HitRatePercent       float64         `json:"hit_rate_percent"`
+    ExposurePercent      float64         `json:"exposure_percent"`
+    MLCalls              int             `json:"ml_calls,omitempty"`
+    Trades               []BacktestTrade `json:"trades"`
+}
+
+/*
+errBacktestML and errBacktestStorage mark backtest failures that are not
+the request's fault.
+*/
+var (
+    errBacktestML      = errors.New("ML service")
+    errBacktestStorage = errors.New("reading history")
+)
+
+/*
+backtestStrategy returns the position to hold after tick i, from -1 (short)
+to 1 (long), given the position held so far. It may only look at ticks up
+to i.
+*/
+type backtestStrategy func(i int, held int) (int, error)
+
+/*
+backtest replays symbol's stored history between req.From and req.To
+through the requested strategy. Ticks come from tickRange, so history in
+cold storage is included.
+*/
+func (fp *FinancialProcessor) backtest(req BacktestRequest, canceled func() bool) (*BacktestResult, error) {
+    var every time.Duration
+    if req.Every != "" {
+        d, err := time.ParseDuration(req.Every)
+        if err != nil || d <= 0 {
+            return nil, fmt.Errorf("every must be a positive duration such as 5m")
+        }
+        every = d
+    }
+    if req.FeeBps < 0 {
+        return nil, fmt.Errorf("fee_bps must not be negative")
+    }
+    data, err := fp.tickRange(req.Symbol, req.From, req.To, 0)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", errBacktestStorage, err)
+    }
+    if len(data) < 2 {
+        return nil, fmt.Errorf("%s has %d stored ticks in the range; at least 2 are needed", req.Symbol, len(data))
+    }
+    res := &BacktestResult{
+        Symbol:   req.Symbol,
+        Strategy: req.Strategy,
+        From:     data[0].Timestamp,
+        To:       data[len(data)-1].Timestamp,
+        Ticks:    len(data),
+        Trades:   []BacktestTrade{},
+    }
+    strategy, err := fp.strategyFor(req, data, res)
+    if err != nil {
+        return nil, err
+    }
+
+    equity, peak := 1.0, 1.0
+    held, exposed := 0, 0
+    var open *BacktestTrade
+    var decided time.Time
+    fee := req.FeeBps / 1e4
+    closeTrade := func(i int) {
+        open.Exit, open.ExitPrice = data[i].Timestamp, data[i].Price
+        r := open.ExitPrice/open.EntryPrice - 1
+        if open.Side == "short" {
+            r = -r
+        }
+        open.ReturnPercent = round2(r * 100)
+        res.Trades = append(res.Trades, *open)
+        open = nil
+    }
+    for i := 0; i < len(data)-1; i++ {
+        if canceled() {
+            return nil, fmt.Errorf("backtest canceled")
+        }
+        if every == 0 || decided.IsZero() || data[i].Timestamp.Sub(decided) >= every {
+            next, err := strategy(i, held)
+            if err != nil {
+                return nil, err
+            }
+            decided = data[i].Timestamp
+            if next != held {
+                equity *= 1 - fee*math.Abs(float64(next-held))
+                if open != nil {
+                    closeTrade(i)
+                }
+                if next != 0 {
+                    side := "long"
+                    if next < 0 {
+                        side = "short"
+                    }
+                    open = &BacktestTrade{Side: side, Entry: data[i].Timestamp, EntryPrice: data[i].Price}
+                }
+                held = next
+            }
+        }
+        if held != 0 && data[i].Price > 0 {
+            exposed++
+            equity *= 1 + float64(held)*(data[i+1].Price/data[i].Price-1)
+        }
+        peak = math.Max(peak, equity)
+        res.MaxDrawdownPercent = math.Max(res.MaxDrawdownPercent, (peak-equity)/peak*100)
+    }
+    if open != nil {
+        equity *= 1 - fee
+        closeTrade(len(data) - 1)
+    }
+    wins := 0
+    for _, t := range res.Trades {
+        if t.ReturnPercent > 0 {
+            wins++
+        }
+    }
+    if len(res.Trades) > 0 {
+        res.HitRatePercent = round2(float64(wins) / float64(len(res.Trades)) * 100)
+    }
+    res.ReturnPercent = round2((equity - 1) * 100)
+    if first := data[0].Price; first > 0 {
+        res.BuyHoldReturnPercent = round2((data[len(data)-1].Price/first - 1) * 100)
+    }
+    res.MaxDrawdownPercent = round2(res.MaxDrawdownPercent)
+    res.ExposurePercent = round2(float64(exposed) / float64(len(data)-1) * 100)
+    return res, nil
+}
+
+/*
+round2 rounds v to two decimals for reporting.
+*/
+func round2(v float64) float64 {
+    return math.Round(v*100) / 100
+}
+
+/*
+strategyFor builds the strategy named by req over data.
+*/
+func (fp *FinancialProcessor) strategyFor(req BacktestRequest, data []StockData, res *BacktestResult) (backtestStrategy, error) {
+    prices := closingPrices(data)
+    short := -1
+    if !req.AllowShort {
+        short = 0
+    }
+    side := func(up bool) int {
+        if up {
+            return 1
+        }
+        return short
+    }
+    p := req.Params
+    switch req.Strategy {
+    case "ml":
+        return fp.mlBacktestStrategy(req, data, res, short)
+    case "sma_crossover":
+        if p.Fast == 0 {
+            p.Fast = 10
+        }
+        if p.Slow == 0 {
+            p.Slow = 30
+        }
+        if p.Fast < 1 || p.Slow <= p.Fast {
+            return nil, fmt.Errorf("sma_crossover needs 0 < fast < slow")
+        }
+        fast, _ := computeIndicator("sma", prices, IndicatorParams{Period: p.Fast})
+        slow, _ := computeIndicator("sma", prices, IndicatorParams{Period: p.Slow})
+        return func(i, held int) (int, error) {
+            f, s := fast["sma"][i], slow["sma"][i]
+            if math.IsNaN(s) {
+                return 0, nil
+            }
+            return side(f > s), nil
+        }, nil
+    case "macd":
+        def := defaultIndicatorParams("macd")
+        if p.Fast == 0 && p.Slow == 0 && p.Signal == 0 {
+            p = def
+        }
+        series, err := computeIndicator("macd", prices, IndicatorParams{Fast: p.Fast, Slow: p.Slow, Signal: p.Signal})
+        if err != nil {
+            return nil, err
+        }
+        return func(i, held int) (int, error) {
+            // The signal line is only meaningful once the slow EMA has warmed up.
+            if i < p.Slow+p.Signal-2 || math.IsNaN(series["macd_signal"][i]) {
+                return 0, nil
+            }
+            return side(series["macd"][i] > series["macd_signal"][i]), nil
+        }, nil
+    case "rsi":
+        if p.Period == 0 {
+            p.Period = defaultIndicatorParams("rsi").Period
+        }
+        lower, upper := req.Lower, req.Upper
+        if lower == 0 {
+            lower = 30
+        }
+        if upper == 0 {
+            upper = 70
+        }
+        if lower >= upper {
+            return nil, fmt.Errorf("rsi needs lower below upper")
+        }
+        series, err := computeIndicator("rsi", prices, IndicatorParams{Period: p.Period})
+        if err != nil {
+            return nil, err
+        }
+        return func(i, held int) (int, error) {
+            switch v := series["rsi"][i]; {
+            case math.IsNaN(v):
+                return held, nil
+            case v < lower:
+                return 1, nil
+            case v > upper:
+                return 0, nil
+            }
+            return held, nil
+        }, nil
+    case "bollinger":
+        def := defaultIndicatorParams("bollinger")
+        if p.Period == 0 {
+            p.Period = def.Period
+        }
+        if p.StdDev == 0 {
+            p.StdDev = def.StdDev
+        }
+        series, err := computeIndicator("bollinger", prices, IndicatorParams{Period: p.Period, StdDev: p.StdDev})
+        if err != nil {
+            return nil, err
+        }
+        return func(i, held int) (int, error) {
+            switch {
+            case math.IsNaN(series["bollinger_lower"][i]):
+                return held, nil
+            case prices[i] < series["bollinger_lower"][i]:
+                return 1, nil
+            case prices[i] > series["bollinger_middle"][i]:
+                return 0, nil
+            }
+            return held, nil
+        }, nil
+    }
+    return nil, fmt.Errorf("unknown strategy %q (ml, sma_crossover, macd, rsi or bollinger)", req.Strategy)
+}
+
+/*
+mlBacktestStrategy asks the symbol's ML service for a prediction from the
+last MAX_HISTORY ticks up to each step, as live collection would, taking a
+position in the predicted direction when the move reaches the threshold.
+Steps with fewer than 5 ticks of history stay flat. Calls are counted in
+res and capped by BACKTEST_MAX_ML_CALLS (default 2000).
+*/
+func (fp *FinancialProcessor) mlBacktestStrategy(req BacktestRequest, data []StockData, res *BacktestResult, short int) (backtestStrategy, error) {
+    threshold := req.ThresholdPercent
+    if threshold == 0 {
+        threshold = 0.5
+    }
+    if threshold < 0 {
+        return nil, fmt.Errorf("threshold_percent must not be negative")
+    }
+    maxCalls := envInt("BACKTEST_MAX_ML_CALLS", 2000)
+    window := fp.currentConfig().MaxHistory
+    _, url := fp.mlRoutes.Resolve(req.Symbol, "/predict")
+    inst := fp.instruments.Get(req.Symbol)
+    return func(i, held int) (int, error) {
+        if i < 4 {
+            return 0, nil
+        }
+        if res.MLCalls >= maxCalls {
+            return 0, fmt.Errorf("the backtest needs more than %d ML calls (BACKTEST_MAX_ML_CALLS); set every or narrow the range", maxCalls)
+        }
+        res.MLCalls++
+        hist := data[max(0, i+1-window) : i+1]
+        body, _ := json.Marshal(predictionPayload(nil, fp.interp, inst, hist, fp.indicators))
+        resp, err := fp.ml.Post(url, body)
+        if err != nil {
+            return 0, fmt.Errorf("%w: %v", errBacktestML, err)
+        }
+        defer resp.Body.Close()
+        var result mlPrediction
+        if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+            return 0, fmt.Errorf("%w: invalid response: %v", errBacktestML, err)
+        }
+        switch chg := result.PredictedChangePerc; {
+        case result.Error != "":
+            // Like live collection, no prediction means no new view.
+            return held, nil
+        case chg >= threshold:
+            return 1, nil
+        case chg <= -threshold:
+            return short, nil
+        }
+        return 0, nil
+    }, nil
+}
+
+/*
+handleBacktest exposes POST /api/backtest, which runs the BacktestRequest in
+its body against stored history and returns the BacktestResult. Unknown
+strategies, bad parameters and ranges with too little history are 400s; a
+failing ML service is a 502 and failing storage a 500.
+*/
+func (fp *FinancialProcessor) handleBacktest(w http.ResponseWriter, r *http.Request) {
+    var req BacktestRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+    if !symbolPattern.MatchString(req.Symbol) {
+        http.Error(w, "invalid symbol", http.StatusBadRequest)
+        return
+    }
+    if !req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To) {
+        http.Error(w, "from must be before to", http.StatusBadRequest)
+        return
+    }
+    res, err := fp.backtest(req, func() bool { return r.Context().Err() != nil })
+    if err != nil {
+        status := http.StatusBadRequest
+        switch {
+        case errors.Is(err, errBacktestML):
+            status = http.StatusBadGateway
+        case errors.Is(err, errBacktestStorage):
+            status = http.StatusInternalServerError
+        }
+        http.Error(w, err.Error(), status)
+        return
+    }
+    json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+BlendedForecast is the ML prediction blended with recent momentum. Momentum
+is the recency-weighted mean of the last tick-to-tick returns, projected one
+step ahead from the current price; ModelWeight is the share given to the model.
+*/
+type BlendedForecast struct {
+    Price           float64 `json:"price"`
+    ChangePercent   float64 `json:"change_percent"`
+    MomentumPercent float64 `json:"momentum_percent"`
+    ModelWeight     float64 `json:"model_weight"`
+}
+
+/*
+PredictionBlender blends each prediction with momentum over the last
+BLEND_WINDOW returns (default 10, 0 disables). BLEND_SCHEME weighs them
+linearly by recency ("linear", the default) or with exponential decay
+("exponential", newest weighted 1 and each older return BLEND_DECAY times the
+next, default 0.7). BLEND_MODEL_WEIGHT (default 0.7) is the model's share,
+unless BLEND_WEIGHTING=skill derives it from each component's recent accuracy.
+*/
+type PredictionBlender struct {
+    window      int
+    scheme      string
+    decay       float64
+    modelWeight float64
+    skill       *SkillWeights
+}
+
+/*
+NewPredictionBlenderFromEnv returns the configured blender, or nil when
+blending is disabled.
+*/
+func NewPredictionBlenderFromEnv() *PredictionBlender {
+    pb := &PredictionBlender{
+        window:      envInt("BLEND_WINDOW", 10),
+        scheme:      os.Getenv("BLEND_SCHEME"),
+        decay:       envFloat("BLEND_DECAY", 0.7),
+        modelWeight: envFloat("BLEND_MODEL_WEIGHT", 0.7),
+    }
+    if pb.window <= 0 {
+        return nil
+    }
+    switch pb.scheme {
+    case "":
+        pb.scheme = "linear"
+    case "linear", "exponential":
+    default:
+        log.Fatalf("BLEND_SCHEME must be linear or exponential, got %q", pb.scheme)
+    }
+    if pb.modelWeight < 0 || pb.modelWeight > 1 || pb.decay <= 0 || pb.decay > 1 {
+        log.Fatal("BLEND_MODEL_WEIGHT must be within [0, 1] and BLEND_DECAY within (0, 1]")
+    }
+    switch w := os.Getenv("BLEND_WEIGHTING"); w {
+    case "", "fixed":
+    case "skill":
+        pb.skill = NewSkillWeightsFromEnv()
+    default:
+        log.Fatalf("BLEND_WEIGHTING must be fixed or skill, got %q", w)
+    }
+    return pb
+}
+
+/*
+Blend combines p with the momentum of data, the history p was made from. It
+returns nil when data holds fewer than two prices.
+*/
+func (pb *PredictionBlender) Blend(p Prediction, data []StockData) *BlendedForecast {
+    start := len(data) - pb.window - 1
+    if start < 0 {
+        start = 0
+    }
+    recent := data[start:]
+    var sum, total float64
+    for i := 1; i < len(recent); i++ {
+        if recent[i-1].Price == 0 {
+            continue
+        }
+        ret := recent[i].Price/recent[i-1].Price - 1
+        w := float64(i)
+        if pb.scheme == "exponential" {
+            w = math.Pow(pb.decay, float64(len(recent)-1-i))
+        }
+        sum += w * ret
+        total += w
+    }
+    if total == 0 || p.CurrentPrice == 0 {
+        return nil
+    }
+    momentum := sum / total
+    projected := p.CurrentPrice * (1 + momentum)
+    weight := pb.modelWeight
+    if pb.skill != nil {
+        weight = pb.skill.Weight(p.Symbol, weight)
+        pb.skill.Track(p.Symbol, data[len(data)-1].Timestamp, p.PredictedPrice, projected)
+    }
+    price := weight*p.PredictedPrice + (1-weight)*projected
+    return &BlendedForecast{
+        Price:           price,
+        ChangePercent:   (price - p.CurrentPrice) / p.CurrentPrice * 100,
+        MomentumPercent: momentum * 100,
+        ModelWeight:     weight,
+    }
+}
+
+/*
+Resolve scores the blend's pending forecast for sd.Symbol against sd when
+weights are skill-based.
+*/
+func (pb *PredictionBlender) Resolve(sd StockData) {
+    if pb.skill != nil {
+        pb.skill.Resolve(sd)
+    }
+}
+
+/*
+SkillWeights weighs the blend's components by their recent realized accuracy.
+Every resolved forecast updates each component's exponentially decayed mean
+absolute percentage error for its symbol (each older error weighted
+SKILL_DECAY times the next, default 0.95). Every SKILL_RECOMPUTE (default 24h)
+of tick time the model's weight is reset to its share of the inverse errors,
+so the blend leans toward whichever component is currently working. A symbol
+keeps the fixed weight until it has SKILL_MIN_SAMPLES (default 20) resolved
+forecasts.
+*/
+type SkillWeights struct {
+    mu         sync.Mutex
+    decay      float64
+    every      time.Duration
+    minSamples int
+    pending    map[string]skillForecast
+    errs       map[string]*skillErrors
+    weights    map[string]float64
+    computedAt time.Time
+}
+
+/*
+skillForecast is one unresolved pair of component forecasts, made from data
+up to after.
+*/
+type skillForecast struct {
+    after    time.Time
+    model    float64
+    momentum float64
+}
+
+/*
+skillErrors holds a symbol's decayed errors per component.
+*/
+type skillErrors struct {
+    model    float64
+    momentum float64
+    samples  int
+}
+
+/*
+NewSkillWeightsFromEnv creates skill weights configured from the environment.
+*/
+func NewSkillWeightsFromEnv() *SkillWeights {
+    sw := &SkillWeights{
+        decay:      envFloat("SKILL_DECAY", 0.95),
+        every:      envDuration("SKILL_RECOMPUTE", 24*time.Hour),
+        minSamples: envInt("SKILL_MIN_SAMPLES", 20),
+        pending:    make(map[string]skillForecast),
+        errs:       make(map[string]*skillErrors),
+        weights:    make(map[string]float64),
+    }
+    if sw.decay <= 0 || sw.decay >= 1 {
+        log.Fatal("SKILL_DECAY must be within (0, 1)")
+    }
+    return sw
+}
+
+/*
+Weight returns the model's current weight for symbol, or fallback before one
+has been computed.
+*/
+func (sw *SkillWeights) Weight(symbol string, fallback float64) float64 {
+    sw.mu.Lock()
+    defer sw.mu.Unlock()
+    if w, ok := sw.weights[symbol]; ok {
+        return w
+    }
+    return fallback
+}
+
+/*
+Track registers the component forecasts made from data up to after, replacing
+any unresolved pair for symbol.
+*/
+func (sw *SkillWeights) Track(symbol string, after time.Time, model, momentum float64) {
+    sw.mu.Lock()
+    sw.pending[symbol] = skillForecast{after: after, model: model, momentum: momentum}
+    sw.mu.Unlock()
+}
+
+/*
+Resolve scores symbol's pending forecasts against sd when it is newer than
+their input, and recomputes all weights once SKILL_RECOMPUTE has passed.
+*/
+func (sw *SkillWeights) Resolve(sd StockData) {
+    sw.mu.Lock()
+    defer sw.mu.Unlock()
+    f, ok := sw.pending[sd.Symbol]
+    if !ok || !sd.Timestamp.After(f.after) || sd.Price == 0 {
+        return
+    }
+    delete(sw.pending, sd.Symbol)
+    modelErr := math.Abs(f.model-sd.Price) / sd.Price
+    momentumErr := math.Abs(f.momentum-sd.Price) / sd.Price
+    e, ok := sw.errs[sd.Symbol]
+    if !ok {
+        e = &skillErrors{model: modelErr, momentum: momentumErr}
+        sw.errs[sd.Symbol] = e
+    } else {
+        e.model = sw.decay*e.model + (1-sw.decay)*modelErr
+        e.momentum = sw.decay*e.momentum + (1-sw.decay)*momentumErr
+    }
+    e.samples++
+
+    if sw.computedAt.IsZero() {
+        sw.computedAt = sd.Timestamp
+    }
+    if sd.Timestamp.Sub(sw.computedAt) < sw.every {
+        return
+    }
+    sw.computedAt = sd.Timestamp
+    for sym, e := range sw.errs {
+        if e.samples < sw.minSamples {
+            continue
+        }
+        // A tiny floor keeps a perfect component from dividing by zero.
+        inverseModel, inverseMomentum := 1/(e.model+1e-12), 1/(e.momentum+1e-12)
+        sw.weights[sym] = inverseModel / (inverseModel + inverseMomentum)
+    }
+    slog.Info("blend skill weights recomputed", "symbols", len(sw.weights))
+}
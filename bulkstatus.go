@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+EntryStatus describes how fresh one symbol's entry in a multi-symbol response
+is, so a single failing symbol is reported in place instead of failing the
+whole response. Status is "ok", "stale" (no tick for three collection
+intervals while its market is open), "no_data", "error" (the last fetch
+failed) or "inactive" (delisted). Retriable says whether asking again later
+may help, and RetryAfterSeconds suggests when.
+*/
+type EntryStatus struct {
+    Status            string `json:"status"`
+    Error             string `json:"error,omitempty"`
+    Retriable         bool   `json:"retriable"`
+    RetryAfterSeconds int    `json:"retry_after_seconds,omitempty"`
+}
+
+/*
+fetchFailures remembers the last failed fetch per symbol until the next
+successful one.
+*/
+type fetchFailures struct {
+    mu   sync.Mutex
+    errs map[string]string
+}
+
+/*
+note records the outcome of one fetch for symbol.
+*/
+func (ff *fetchFailures) note(symbol string, err error) {
+    ff.mu.Lock()
+    defer ff.mu.Unlock()
+    if err == nil {
+        delete(ff.errs, symbol)
+        return
+    }
+    if ff.errs == nil {
+        ff.errs = make(map[string]string)
+    }
+    ff.errs[symbol] = err.Error()
+}
+
+/*
+last returns the error of the last failed fetch for symbol, if it has not
+succeeded since.
+*/
+func (ff *fetchFailures) last(symbol string) (string, bool) {
+    ff.mu.Lock()
+    defer ff.mu.Unlock()
+    msg, ok := ff.errs[symbol]
+    return msg, ok
+}
+
+/*
+entryStatus works out the status of symbol's entry given its latest tick,
+when it has one.
+*/
+func (fp *FinancialProcessor) entryStatus(symbol string, latest *time.Time) EntryStatus {
+    if fp.delisting.Inactive(symbol) {
+        return EntryStatus{Status: "inactive", Error: "symbol looks delisted; collection stopped"}
+    }
+    interval := fp.collectionInterval(symbol)
+    retry := int(interval.Seconds())
+    if msg, failed := fp.failures.last(symbol); failed {
+        if at, held := fp.quarantine.Holds(symbol, fp.clock.Now()); held {
+            retry = int(math.Ceil(at.Sub(fp.clock.Now()).Seconds()))
+        }
+        return EntryStatus{Status: "error", Error: msg, Retriable: true, RetryAfterSeconds: retry}
+    }
+    if latest == nil {
+        return EntryStatus{Status: "no_data", Error: "no ticks collected yet", Retriable: true, RetryAfterSeconds: retry}
+    }
+    now := fp.clock.Now()
+    if age := now.Sub(*latest); age > 3*interval && fp.tradesAt(symbol, now) {
+        msg := fmt.Sprintf("latest tick is %s old", age.Round(time.Second))
+        return EntryStatus{Status: "stale", Error: msg, Retriable: true, RetryAfterSeconds: retry}
+    }
+    return EntryStatus{Status: "ok"}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+candleIntervals are the candle widths GET /api/candles accepts.
+*/
+var candleIntervals = map[string]time.Duration{
+    "1m": time.Minute,
+    "5m": 5 * time.Minute,
+    "1h": time.Hour,
+    "1d": 24 * time.Hour,
+}
+
+/*
+Candle is one OHLCV bar starting at Timestamp. Volume is the volume traded
+within the bar, taken from the growth of the cumulative daily volume that
+quotes carry, and Ticks counts the records aggregated into it.
+*/
+type Candle struct {
+    Timestamp time.Time `json:"timestamp"`
+    Open      float64   `json:"open"`
+    High      float64   `json:"high"`
+    Low       float64   `json:"low"`
+    Close     float64   `json:"close"`
+    Volume    int64     `json:"volume"`
+    Ticks     int       `json:"ticks"`
+}
+
+/*
+aggregateCandles buckets data, which must be oldest first, into candles of
+width interval aligned to multiples of interval in UTC; intervals without a
+tick produce no candle. Aggregated bars kept by a retention policy
+contribute their own open, high and low, raw ticks only their price. A drop
+in cumulative volume marks a new session, whose volume counts from zero.
+*/
+func aggregateCandles(data []StockData, interval time.Duration) []Candle {
+    out := []Candle{}
+    var prevVolume int64
+    for i, d := range data {
+        open, high, low := d.Price, d.Price, d.Price
+        if d.Resolution != "" && d.Open > 0 {
+            open, high, low = d.Open, d.High, d.Low
+        }
+        traded := int64(0)
+        if i > 0 {
+            traded = d.Volume - prevVolume
+            if traded < 0 {
+                traded = d.Volume
+            }
+        }
+        prevVolume = d.Volume
+
+        start := d.Timestamp.UTC().Truncate(interval)
+        if n := len(out); n == 0 || !out[n-1].Timestamp.Equal(start) {
+            out = append(out, Candle{Timestamp: start, Open: open, High: high, Low: low})
+        }
+        c := &out[len(out)-1]
+        if high > c.High {
+            c.High = high
+        }
+        if low < c.Low {
+            c.Low = low
+        }
+        c.Close = d.Price
+        c.Volume += traded
+        c.Ticks++
+    }
+    return out
+}
+
+/*
+handleCandles exposes GET /api/candles/{symbol}?interval=1m|5m|1h|1d, the
+symbol's history aggregated into OHLCV candles, oldest first, in the shape
+charting libraries expect. interval defaults to 1m; from and to (RFC 3339 or
+YYYY-MM-DD, both optional) bound the range, which is read through persistent
+and cold storage like the export. With persistent storage, 5m, 1h and 1d
+candles come from the precomputed bars table instead of the ticks, whole
+candles starting between from and to, unless the range reaches into cold
+storage.
+*/
+func (fp *FinancialProcessor) handleCandles(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    qs := r.URL.Query()
+    name := qs.Get("interval")
+    if name == "" {
+        name = "1m"
+    }
+    interval, ok := candleIntervals[name]
+    if !ok {
+        http.Error(w, "interval must be 1m, 5m, 1h or 1d", http.StatusBadRequest)
+        return
+    }
+    from, err := parseExportTime(qs.Get("from"), false)
+    if err != nil {
+        http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    to, err := parseExportTime(qs.Get("to"), true)
+    if err != nil {
+        http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    if candles, ok, err := fp.storedCandles(sym, name, from, to); ok {
+        if err != nil {
+            http.Error(w, "reading stored bars: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        if len(candles) == 0 {
+            http.Error(w, "no data", http.StatusNotFound)
+            return
+        }
+        streamJSONArray(w, candles)
+        return
+    }
+    data, err := fp.tickRange(sym, from, to, 0)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if len(data) == 0 {
+        http.Error(w, "no data", http.StatusNotFound)
+        return
+    }
+    sort.SliceStable(data, func(i, j int) bool { return data[i].Timestamp.Before(data[j].Timestamp) })
+    streamJSONArray(w, aggregateCandles(data, interval))
+}
+
+/*
+storedCandles reads symbol's candles at interval name from the bars table.
+It reports false when name is not materialized, no Store is configured, or
+the range may reach into cold storage, whose ticks have no bars.
+*/
+func (fp *FinancialProcessor) storedCandles(symbol, name string, from, to time.Time) ([]Candle, bool, error) {
+    materialized := false
+    for _, res := range barResolutions {
+        materialized = materialized || res == name
+    }
+    if !materialized || fp.store == nil {
+        return nil, false, nil
+    }
+    if fp.cold != nil {
+        if first, ok := fp.hotStart(symbol); !ok || from.IsZero() || from.Before(first) {
+            return nil, false, nil
+        }
+    }
+    if !from.IsZero() {
+        from = from.UTC().Truncate(candleIntervals[name])
+    }
+    candles, err := fp.store.Bars(symbol, name, from, to)
+    return candles, true, err
+}
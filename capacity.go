@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+/*
+CapacityReport is the result of one self-benchmark run.
+
+IngestTicksPerSec is the parallel append rate into a scratch store shaped like
+the live one, and ParallelEfficiency that rate relative to perfect scaling of
+the single-goroutine rate (1 means no lock contention). LockWaitMicros is the
+mean time to acquire the processor's shared lock while live traffic runs.
+*/
+type CapacityReport struct {
+    RanAt                 time.Time `json:"ran_at"`
+    DurationMs            float64   `json:"duration_ms"`
+    CPUs                  int       `json:"cpus"`
+    TrackedSymbols        int       `json:"tracked_symbols"`
+    IngestTicksPerSec     float64   `json:"ingest_ticks_per_sec"`
+    SingleTicksPerSec     float64   `json:"single_goroutine_ticks_per_sec"`
+    ParallelEfficiency    float64   `json:"parallel_efficiency"`
+    LockWaitMicros        float64   `json:"lock_wait_micros"`
+    BytesPerSymbol        float64   `json:"bytes_per_symbol"`
+    HeapAllocBytes        uint64    `json:"heap_alloc_bytes"`
+    MemoryBudgetBytes     int64     `json:"memory_budget_bytes"`
+    MaxSymbolsByMemory    int       `json:"max_symbols_by_memory"`
+    MaxSymbolsByIngest    int       `json:"max_symbols_by_ingest"`
+    MaxRecommendedSymbols int       `json:"max_recommended_symbols"`
+    LimitingFactor        string    `json:"limiting_factor"`
+}
+
+/*
+CapacityBenchmark runs the self-benchmark periodically and keeps the latest report.
+*/
+type CapacityBenchmark struct {
+    fp       *FinancialProcessor
+    interval time.Duration
+    mu       sync.Mutex
+    running  sync.Mutex
+    last     *CapacityReport
+}
+
+/*
+NewCapacityBenchmarkFromEnv creates the benchmark. CAPACITY_BENCHMARK_INTERVAL
+(default 6h) sets how often it reruns; 0 runs it only on demand.
+*/
+func NewCapacityBenchmarkFromEnv(fp *FinancialProcessor) *CapacityBenchmark {
+    return &CapacityBenchmark{fp: fp, interval: envDuration("CAPACITY_BENCHMARK_INTERVAL", 6*time.Hour)}
+}
+
+/*
+Run benchmarks once shortly after startup and then every interval.
+*/
+func (cb *CapacityBenchmark) Run() {
+    if cb.interval <= 0 {
+        return
+    }
+    time.Sleep(2 * time.Minute)
+    for {
+        cb.Measure()
+        time.Sleep(cb.interval)
+    }
+}
+
+/*
+Latest returns the most recent report, or nil before the first run.
+*/
+func (cb *CapacityBenchmark) Latest() *CapacityReport {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+    return cb.last
+}
+
+/*
+memoryBudget returns GOMEMLIMIT when one is set, otherwise
+CAPACITY_MEMORY_BUDGET_MB (default 512).
+*/
+func memoryBudget() int64 {
+    if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+        return limit
+    }
+    return int64(envInt("CAPACITY_MEMORY_BUDGET_MB", 512)) << 20
+}
+
+/*
+Measure runs the benchmark and stores the report. Concurrent calls wait for
+the run in progress rather than starting another.
+*/
+func (cb *CapacityBenchmark) Measure() CapacityReport {
+    cb.running.Lock()
+    defer cb.running.Unlock()
+
+    const symbols, appends = 200, 50000
+    start := time.Now()
+    rep := CapacityReport{RanAt: start, CPUs: runtime.GOMAXPROCS(0), MemoryBudgetBytes: memoryBudget()}
+    cb.fp.mutex.RLock()
+    rep.TrackedSymbols = len(cb.fp.symbols)
+    cb.fp.mutex.RUnlock()
+
+    sample := StockData{Price: 100, Volume: 1000, Timestamp: start}
+    names := make([]string, symbols)
+    for i := range names {
+        names[i] = fmt.Sprintf("BENCH%03d", i)
+    }
+
+    // Single-goroutine baseline.
+    single := NewMemorySeries[StockData](100)
+    t0 := time.Now()
+    for i := 0; i < appends; i++ {
+        single.Append(names[i%symbols], sample)
+    }
+    rep.SingleTicksPerSec = float64(appends) / time.Since(t0).Seconds()
+
+    // Parallel ingest across all CPUs, measuring heap growth for a full store.
+    runtime.GC()
+    var before, after runtime.MemStats
+    runtime.ReadMemStats(&before)
+    store := NewMemorySeries[StockData](100)
+    var wg sync.WaitGroup
+    t0 = time.Now()
+    for w := 0; w < rep.CPUs; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for i := w; i < appends; i += rep.CPUs {
+                store.Append(names[i%symbols], sample)
+            }
+        }(w)
+    }
+    wg.Wait()
+    elapsed := time.Since(t0)
+    rep.IngestTicksPerSec = float64(appends) / elapsed.Seconds()
+    if rep.SingleTicksPerSec > 0 {
+        rep.ParallelEfficiency = rep.IngestTicksPerSec / (rep.SingleTicksPerSec * float64(rep.CPUs))
+    }
+    runtime.ReadMemStats(&after)
+    if after.HeapAlloc > before.HeapAlloc {
+        rep.BytesPerSymbol = float64(after.HeapAlloc-before.HeapAlloc) / symbols
+    }
+    runtime.KeepAlive(store)
+    rep.HeapAllocBytes = after.HeapAlloc
+
+    // Contention on the live processor lock.
+    var wait time.Duration
+    for i := 0; i < 1000; i++ {
+        t := time.Now()
+        cb.fp.mutex.Lock()
+        wait += time.Since(t)
+        cb.fp.mutex.Unlock()
+    }
+    rep.LockWaitMicros = float64(wait.Microseconds()) / 1000
+
+    // Each symbol stores one tick per 30s collection; keep ingest at 1% of
+    // measured throughput and memory within half the budget for headroom.
+    rep.MaxSymbolsByIngest = int(rep.IngestTicksPerSec * 30 * 0.01)
+    rep.MaxSymbolsByMemory = math.MaxInt32
+    if rep.BytesPerSymbol > 0 {
+        free := float64(rep.MemoryBudgetBytes)/2 - float64(rep.HeapAllocBytes)
+        rep.MaxSymbolsByMemory = int(math.Max(0, free) / rep.BytesPerSymbol)
+    }
+    rep.MaxRecommendedSymbols, rep.LimitingFactor = rep.MaxSymbolsByIngest, "ingest"
+    if rep.MaxSymbolsByMemory < rep.MaxRecommendedSymbols {
+        rep.MaxRecommendedSymbols, rep.LimitingFactor = rep.MaxSymbolsByMemory, "memory"
+    }
+    rep.DurationMs = durationMs(time.Since(start))
+
+    cb.mu.Lock()
+    cb.last = &rep
+    cb.mu.Unlock()
+    return rep
+}
+
+/*
+handleCapacity exposes GET /api/admin/capacity, returning the latest capacity
+report, or running a fresh benchmark first with ?run=true or when none exists.
+*/
+func (cb *CapacityBenchmark) handleCapacity(w http.ResponseWriter, r *http.Request) {
+    rep := cb.Latest()
+    if rep == nil || r.URL.Query().Get("run") == "true" {
+        fresh := cb.Measure()
+        rep = &fresh
+    }
+    json.NewEncoder(w).Encode(rep)
+}
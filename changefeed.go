@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+/*
+ConfigChange describes a runtime configuration change published to the
+change feed. Kind is "symbol_added", "symbol_removed", "symbol_reactivated",
+"symbol_settings" or "config_reloaded"; Settings carries the new settings
+for symbol_settings and Changed the reloaded settings for config_reloaded.
+*/
+type ConfigChange struct {
+    Kind     string          `json:"kind"`
+    Settings *SymbolSettings `json:"settings,omitempty"`
+    Changed  []string        `json:"changed,omitempty"`
+}
+
+/*
+ChangeFeedPage is one page of the change feed. NextCursor is the cursor to
+pass to fetch the following page.
+*/
+type ChangeFeedPage struct {
+    Events     []Event `json:"events"`
+    NextCursor int64   `json:"next_cursor"`
+    Oldest     int64   `json:"oldest"`
+}
+
+/*
+publishConfig publishes a configuration change for symbol.
+*/
+func (fp *FinancialProcessor) publishConfig(symbol string, change ConfigChange) {
+    fp.events.Publish(Event{Type: "config", Symbol: symbol, Data: change})
+}
+
+/*
+fireAlert fires an alert through the dispatcher, or only records it when
+symbol's correlation cluster has just raised it, and publishes the resulting
+record as an "alert" event.
+*/
+func (fp *FinancialProcessor) fireAlert(rule, symbol string, value float64, message string) {
+    var rec AlertRecord
+    if leader, ok := fp.clusters.Suppress(rule, symbol, value, fp.clock.Now()); ok {
+        rec = fp.alerts.Suppressed(rule, symbol, value, message, leader)
+    } else {
+        rec = fp.alerts.Fire(rule, symbol, value, message)
+    }
+    fp.events.Publish(Event{Type: "alert", Symbol: symbol, Data: rec})
+}
+
+/*
+handleChanges exposes GET /api/changes?cursor=N&limit=500, the ordered feed
+of ticks, predictions, alerts, configuration changes and history imports
+with a sequence number above cursor (or from the oldest retained event
+without one). A cursor whose successors have already been discarded, for example after a
+restart, yields 410 Gone so consumers know to resynchronise.
+*/
+func (fp *FinancialProcessor) handleChanges(w http.ResponseWriter, r *http.Request) {
+    qs := r.URL.Query()
+    cursor := int64(-1)
+    if v := qs.Get("cursor"); v != "" {
+        n, err := strconv.ParseInt(v, 10, 64)
+        if err != nil || n < 0 {
+            http.Error(w, "invalid cursor", http.StatusBadRequest)
+            return
+        }
+        cursor = n
+    }
+    limit := 500
+    if v := qs.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > 10000 {
+            http.Error(w, "limit must be between 1 and 10000", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+
+    events, oldest, ok := fp.events.Since(cursor, limit)
+    if !ok {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusGone)
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "error":  "events after this cursor are no longer retained",
+            "oldest": oldest,
+        })
+        return
+    }
+    page := ChangeFeedPage{Events: events, NextCursor: cursor, Oldest: oldest}
+    if page.Events == nil {
+        page.Events = []Event{}
+    }
+    if n := len(events); n > 0 {
+        page.NextCursor = events[n-1].Seq
+    } else if cursor < 0 {
+        page.NextCursor = oldest - 1
+    }
+    json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*
+yahooChartAPI is Yahoo's v8 chart endpoint, which returns one symbol's
+session bars together with its quote metadata.
+*/
+const yahooChartAPI = "https://query1.finance.yahoo.com/v8/finance/chart/"
+
+/*
+chartAPIResponse mirrors the parts of the v8 chart response we consume. The
+indicator arrays hold one entry per bar and may contain nulls.
+*/
+type chartAPIResponse struct {
+    Chart struct {
+        Result []struct {
+            Meta struct {
+                Symbol               string  `json:"symbol"`
+                InstrumentType       string  `json:"instrumentType"`
+                RegularMarketPrice   float64 `json:"regularMarketPrice"`
+                RegularMarketVolume  int64   `json:"regularMarketVolume"`
+                RegularMarketTime    int64   `json:"regularMarketTime"`
+                RegularMarketDayHigh float64 `json:"regularMarketDayHigh"`
+                RegularMarketDayLow  float64 `json:"regularMarketDayLow"`
+                PreviousClose        float64 `json:"previousClose"`
+                ChartPreviousClose   float64 `json:"chartPreviousClose"`
+            } `json:"meta"`
+            Timestamp  []int64 `json:"timestamp"`
+            Indicators struct {
+                Quote []struct {
+                    Open   []*float64 `json:"open"`
+                    High   []*float64 `json:"high"`
+                    Low    []*float64 `json:"low"`
+                    Close  []*float64 `json:"close"`
+                    Volume []*int64   `json:"volume"`
+                } `json:"quote"`
+            } `json:"indicators"`
+        } `json:"result"`
+        Error *struct {
+            Code        string `json:"code"`
+            Description string `json:"description"`
+        } `json:"error"`
+    } `json:"chart"`
+}
+
+/*
+fetchChartResponse requests symbol's chart with the given query parameters.
+An unknown symbol yields a *QuoteMissingError.
+*/
+func fetchChartResponse(symbol string, query url.Values) (*chartAPIResponse, error) {
+    u := yahooChartAPI + url.PathEscape(symbol) + "?" + query.Encode()
+    req, err := http.NewRequest("GET", u, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", "Mozilla/5.0")
+
+    resp, err := quoteClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotFound {
+        return nil, &QuoteMissingError{Symbol: symbol, Status: resp.StatusCode}
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("chart API returned %s", resp.Status)
+    }
+
+    var cr chartAPIResponse
+    if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+        return nil, err
+    }
+    if e := cr.Chart.Error; e != nil {
+        return nil, fmt.Errorf("chart API error %s: %s", e.Code, e.Description)
+    }
+    if len(cr.Chart.Result) == 0 {
+        return nil, fmt.Errorf("chart API returned no result for %s", symbol)
+    }
+    return &cr, nil
+}
+
+/*
+FetchChart retrieves the current session for symbol from the chart API:
+last price and volume plus the day's open, high and low and the previous
+close. An unknown symbol yields a *QuoteMissingError.
+*/
+func FetchChart(symbol string) (*StockData, error) {
+    cr, err := fetchChartResponse(symbol, url.Values{"range": {"1d"}, "interval": {"1d"}})
+    if err != nil {
+        return nil, err
+    }
+    if cr.Chart.Result[0].Meta.RegularMarketPrice == 0 {
+        return nil, fmt.Errorf("chart API returned no price for %s", symbol)
+    }
+
+    res := cr.Chart.Result[0]
+    m := res.Meta
+    sd := &StockData{
+        Symbol:        symbol,
+        Price:         m.RegularMarketPrice,
+        Volume:        m.RegularMarketVolume,
+        AssetClass:    assetClassFromYahoo(m.InstrumentType, symbol),
+        High:          m.RegularMarketDayHigh,
+        Low:           m.RegularMarketDayLow,
+        PreviousClose: m.PreviousClose,
+    }
+    if m.RegularMarketTime > 0 {
+        sd.Timestamp = time.Unix(m.RegularMarketTime, 0)
+    }
+    if sd.PreviousClose == 0 {
+        sd.PreviousClose = m.ChartPreviousClose
+    }
+    if len(res.Indicators.Quote) > 0 {
+        q := res.Indicators.Quote[0]
+        if n := len(q.Open); n > 0 && q.Open[n-1] != nil {
+            sd.Open = *q.Open[n-1]
+        }
+        if n := len(q.High); sd.High == 0 && n > 0 && q.High[n-1] != nil {
+            sd.High = *q.High[n-1]
+        }
+        if n := len(q.Low); sd.Low == 0 && n > 0 && q.Low[n-1] != nil {
+            sd.Low = *q.Low[n-1]
+        }
+        if n := len(q.Volume); sd.Volume == 0 && n > 0 && q.Volume[n-1] != nil {
+            sd.Volume = *q.Volume[n-1]
+        }
+    }
+    return sd, nil
+}
+
+/*
+FetchCandles retrieves symbol's bars of the given interval (such as "5m" or
+"1d") from since until now, oldest first, one StockData per bar priced at its
+close. Bars Yahoo reports without a close are skipped.
+*/
+func FetchCandles(symbol string, since time.Time, interval string) ([]StockData, error) {
+    cr, err := fetchChartResponse(symbol, url.Values{
+        "period1":  {strconv.FormatInt(since.Unix(), 10)},
+        "period2":  {strconv.FormatInt(time.Now().Unix(), 10)},
+        "interval": {interval},
+    })
+    if err != nil {
+        return nil, err
+    }
+    return chartCandles(symbol, cr), nil
+}
+
+/*
+chartCandles converts a chart response into one StockData per bar, skipping
+bars without a close.
+*/
+func chartCandles(symbol string, cr *chartAPIResponse) []StockData {
+    res := cr.Chart.Result[0]
+    if len(res.Indicators.Quote) == 0 {
+        return nil
+    }
+    q := res.Indicators.Quote[0]
+    class := assetClassFromYahoo(res.Meta.InstrumentType, symbol)
+    at := func(vs []*float64, i int) float64 {
+        if i < len(vs) && vs[i] != nil {
+            return *vs[i]
+        }
+        return 0
+    }
+    var out []StockData
+    for i, ts := range res.Timestamp {
+        price := at(q.Close, i)
+        if price == 0 {
+            continue
+        }
+        sd := StockData{
+            Symbol:     symbol,
+            Price:      price,
+            Timestamp:  time.Unix(ts, 0),
+            AssetClass: class,
+            Open:       at(q.Open, i),
+            High:       at(q.High, i),
+            Low:        at(q.Low, i),
+        }
+        if i < len(q.Volume) && q.Volume[i] != nil {
+            sd.Volume = *q.Volume[i]
+        }
+        out = append(out, sd)
+    }
+    return out
+}
+
+/*
+chartAPISource fetches the symbol through the Yahoo chart API.
+*/
+func chartAPISource(string) (FetchFunc, error) {
+    return FetchChart, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Clock is the source of time for collection loops, tick bookkeeping and
+retention, so replay and tests can drive virtual time instead of sleeping.
+Latency measurements of real calls keep using the wall clock.
+*/
+type Clock interface {
+    Now() time.Time
+    After(d time.Duration) <-chan time.Time
+    Sleep(d time.Duration)
+}
+
+/*
+systemClock is the wall clock.
+*/
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+/*
+VirtualClock is a Clock that only moves when told to. Channels from After
+fire, in deadline order, when Advance or Set reaches their deadline.
+*/
+type VirtualClock struct {
+    mu      sync.Mutex
+    now     time.Time
+    waiters []virtualWaiter
+}
+
+/*
+virtualWaiter is a pending After on a VirtualClock.
+*/
+type virtualWaiter struct {
+    at time.Time
+    ch chan time.Time
+}
+
+/*
+NewVirtualClock returns a virtual clock reading start.
+*/
+func NewVirtualClock(start time.Time) *VirtualClock {
+    return &VirtualClock{now: start}
+}
+
+/*
+Now returns the current virtual time.
+*/
+func (vc *VirtualClock) Now() time.Time {
+    vc.mu.Lock()
+    defer vc.mu.Unlock()
+    return vc.now
+}
+
+/*
+After returns a channel that receives the virtual time once the clock has
+advanced by d.
+*/
+func (vc *VirtualClock) After(d time.Duration) <-chan time.Time {
+    vc.mu.Lock()
+    defer vc.mu.Unlock()
+    ch := make(chan time.Time, 1)
+    if d <= 0 {
+        ch <- vc.now
+        return ch
+    }
+    vc.waiters = append(vc.waiters, virtualWaiter{at: vc.now.Add(d), ch: ch})
+    return ch
+}
+
+/*
+Sleep blocks until another goroutine advances the clock by d.
+*/
+func (vc *VirtualClock) Sleep(d time.Duration) {
+    <-vc.After(d)
+}
+
+/*
+Advance moves the clock forward by d.
+*/
+func (vc *VirtualClock) Advance(d time.Duration) {
+    vc.Set(vc.Now().Add(d))
+}
+
+/*
+Set moves the clock to t and fires every waiter due by then. The clock never
+moves backwards; an earlier t is ignored.
+*/
+func (vc *VirtualClock) Set(t time.Time) {
+    vc.mu.Lock()
+    defer vc.mu.Unlock()
+    if t.Before(vc.now) {
+        return
+    }
+    vc.now = t
+    pending := vc.waiters[:0]
+    var due []virtualWaiter
+    for _, w := range vc.waiters {
+        if w.at.After(t) {
+            pending = append(pending, w)
+        } else {
+            due = append(due, w)
+        }
+    }
+    vc.waiters = pending
+    for len(due) > 0 {
+        first := 0
+        for i := range due {
+            if due[i].at.Before(due[first].at) {
+                first = i
+            }
+        }
+        due[first].ch <- t
+        due = append(due[:first], due[first+1:]...)
+    }
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualClockAdvance(t *testing.T) {
+    start := time.Date(2025, 1, 10, 9, 30, 0, 0, time.UTC)
+    vc := NewVirtualClock(start)
+    late, early := vc.After(2*time.Minute), vc.After(time.Minute)
+    select {
+    case <-early:
+        t.Fatal("After fired before the clock moved")
+    default:
+    }
+
+    vc.Advance(time.Minute)
+    if got := vc.Now(); !got.Equal(start.Add(time.Minute)) {
+        t.Errorf("Now = %v, want %v", got, start.Add(time.Minute))
+    }
+    select {
+    case at := <-early:
+        if !at.Equal(start.Add(time.Minute)) {
+            t.Errorf("After(1m) fired with %v, want %v", at, start.Add(time.Minute))
+        }
+    default:
+        t.Error("After(1m) did not fire once the clock reached its deadline")
+    }
+    select {
+    case <-late:
+        t.Error("After(2m) fired a minute early")
+    default:
+    }
+
+    vc.Set(start)
+    if got := vc.Now(); !got.Equal(start.Add(time.Minute)) {
+        t.Errorf("Set moved the clock backwards to %v", got)
+    }
+
+    done := make(chan struct{})
+    go func() {
+        vc.Sleep(30 * time.Second)
+        close(done)
+    }()
+    // Wait for Sleep to register, alongside the pending After(2m).
+    for waiting := 0; waiting < 2; {
+        time.Sleep(time.Millisecond)
+        vc.mu.Lock()
+        waiting = len(vc.waiters)
+        vc.mu.Unlock()
+    }
+    vc.Advance(time.Hour)
+    <-done
+    select {
+    case <-late:
+    default:
+        t.Error("After(2m) did not fire once the clock passed its deadline")
+    }
+}
+
+func TestProcessorUsesVirtualClock(t *testing.T) {
+    fp := newTestProcessor(t, map[string]string{"SYMBOLS": "TEST", "NEWS_BOOST_INTERVAL": "5s"})
+    start := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+    vc := NewVirtualClock(start)
+    fp.clock = vc
+    fp.sources["TEST"] = &SymbolSource{Provider: "test", Fetch: func(symbol string) (*StockData, error) {
+        return &StockData{Symbol: symbol, Price: 10, Volume: 100}, nil
+    }}
+
+    sd, err := fp.fetch("TEST")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !sd.Timestamp.Equal(start) {
+        t.Errorf("fetched tick stamped %v, want the virtual time %v", sd.Timestamp, start)
+    }
+
+    normal := fp.collectionInterval("TEST")
+    fp.boost("TEST", start.Add(time.Minute))
+    if got := fp.collectionInterval("TEST"); got != 5*time.Second {
+        t.Errorf("interval while boosted = %v, want 5s", got)
+    }
+    vc.Advance(2 * time.Minute)
+    if got := fp.collectionInterval("TEST"); got != normal {
+        t.Errorf("interval once the boost ended in virtual time = %v, want %v", got, normal)
+    }
+
+    sd, err = fp.fetch("TEST")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := start.Add(2 * time.Minute); !sd.Timestamp.Equal(want) {
+        t.Errorf("tick after Advance stamped %v, want %v", sd.Timestamp, want)
+    }
+}
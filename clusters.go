@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+SymbolCluster is a group of tracked symbols whose returns move together.
+AverageCorrelation is the mean pairwise correlation of its members' returns.
+*/
+type SymbolCluster struct {
+    ID                 int      `json:"id"`
+    Symbols            []string `json:"symbols"`
+    AverageCorrelation float64  `json:"average_correlation"`
+}
+
+/*
+ClusterBook groups the tracked symbols by how their returns correlate,
+recomputed every CLUSTER_INTERVAL (default 15m, 0 disables clustering).
+Returns are the log changes between consecutive CLUSTER_RESOLUTION bars
+(default 5m) of each symbol's in-memory history, and two symbols are only
+compared over at least CLUSTER_MIN_RETURNS (default 20) bars they both
+have. Clusters are merged by average linkage for as long as the mean
+correlation between two clusters' members is at least
+CLUSTER_MIN_CORRELATION (default 0.7), so a cluster never holds symbols that
+merely chain together through a common neighbour.
+
+With CLUSTER_ALERT_WINDOW set (default 0, off), an alert that a member of a
+cluster already raised in the same direction within that window is
+recorded as suppressed instead of being delivered again, so a whole sector
+moving at once makes one notification rather than one per symbol.
+*/
+type ClusterBook struct {
+    fp         *FinancialProcessor
+    every      time.Duration
+    resolution time.Duration
+    minCorr    float64
+    minReturns int
+    window     time.Duration
+    mu         sync.RWMutex
+    clusters   []SymbolCluster
+    member     map[string]int
+    computedAt time.Time
+    fired      map[string]clusterFiring
+    suppressed atomic.Int64
+}
+
+/*
+clusterFiring is the last delivered alert of one rule and direction within
+a cluster.
+*/
+type clusterFiring struct {
+    symbol string
+    at     time.Time
+}
+
+/*
+NewClusterBookFromEnv creates the cluster book for fp.
+*/
+func NewClusterBookFromEnv(fp *FinancialProcessor) *ClusterBook {
+    cb := &ClusterBook{
+        fp:         fp,
+        every:      envDuration("CLUSTER_INTERVAL", 15*time.Minute),
+        resolution: envDuration("CLUSTER_RESOLUTION", 5*time.Minute),
+        minCorr:    envFloat("CLUSTER_MIN_CORRELATION", 0.7),
+        minReturns: envInt("CLUSTER_MIN_RETURNS", 20),
+        window:     envDuration("CLUSTER_ALERT_WINDOW", 0),
+        member:     make(map[string]int),
+        fired:      make(map[string]clusterFiring),
+    }
+    if cb.resolution <= 0 {
+        log.Fatalf("CLUSTER_RESOLUTION must be positive, got %s", cb.resolution)
+    }
+    if cb.minCorr <= 0 || cb.minCorr > 1 {
+        log.Fatalf("CLUSTER_MIN_CORRELATION must be in (0, 1], got %g", cb.minCorr)
+    }
+    if cb.minReturns < 2 {
+        log.Fatalf("CLUSTER_MIN_RETURNS must be at least 2, got %d", cb.minReturns)
+    }
+    return cb
+}
+
+/*
+Run recomputes the clusters now and then every interval.
+*/
+func (cb *ClusterBook) Run() {
+    if cb.every <= 0 {
+        return
+    }
+    for {
+        cb.Recompute()
+        time.Sleep(cb.every)
+    }
+}
+
+/*
+Recompute clusters the tracked symbols from their current history.
+*/
+func (cb *ClusterBook) Recompute() {
+    symbols := cb.fp.trackedSymbols()
+    sort.Strings(symbols)
+    returns := make([]map[int64]float64, len(symbols))
+    for i, sym := range symbols {
+        returns[i] = barReturns(cb.fp.dataStore.Window(sym, 0), cb.resolution)
+    }
+    n := len(symbols)
+    corr := make([][]float64, n)
+    for i := range corr {
+        corr[i] = make([]float64, n)
+    }
+    for i := 0; i < n; i++ {
+        for j := i + 1; j < n; j++ {
+            c, ok := returnCorrelation(returns[i], returns[j], cb.minReturns)
+            if !ok {
+                // Too little shared history is no evidence of co-movement.
+                c = 0
+            }
+            corr[i][j], corr[j][i] = c, c
+        }
+    }
+    groups := averageLinkage(corr, cb.minCorr)
+
+    var clusters []SymbolCluster
+    for _, g := range groups {
+        if len(g) < 2 {
+            continue
+        }
+        sc := SymbolCluster{}
+        var sum float64
+        for a, i := range g {
+            sc.Symbols = append(sc.Symbols, symbols[i])
+            for _, j := range g[a+1:] {
+                sum += corr[i][j]
+            }
+        }
+        sc.AverageCorrelation = math.Round(sum/float64(len(g)*(len(g)-1)/2)*1e4) / 1e4
+        clusters = append(clusters, sc)
+    }
+    sort.Slice(clusters, func(i, j int) bool {
+        if len(clusters[i].Symbols) != len(clusters[j].Symbols) {
+            return len(clusters[i].Symbols) > len(clusters[j].Symbols)
+        }
+        return clusters[i].Symbols[0] < clusters[j].Symbols[0]
+    })
+    member := make(map[string]int)
+    for i := range clusters {
+        clusters[i].ID = i + 1
+        for _, sym := range clusters[i].Symbols {
+            member[sym] = clusters[i].ID
+        }
+    }
+    cb.mu.Lock()
+    cb.clusters, cb.member, cb.computedAt = clusters, member, time.Now()
+    cb.mu.Unlock()
+}
+
+/*
+barReturns returns the log return into each bar of width res from the bar
+before it, keyed by the bar's start in nanoseconds, skipping bars whose
+predecessor is missing.
+*/
+func barReturns(data []StockData, res time.Duration) map[int64]float64 {
+    out := make(map[int64]float64)
+    candles := aggregateCandles(data, res)
+    for i := 1; i < len(candles); i++ {
+        prev, cur := candles[i-1], candles[i]
+        if cur.Timestamp.Sub(prev.Timestamp) != res || prev.Close <= 0 || cur.Close <= 0 {
+            continue
+        }
+        out[cur.Timestamp.UnixNano()] = math.Log(cur.Close / prev.Close)
+    }
+    return out
+}
+
+/*
+returnCorrelation is the Pearson correlation of a and b over the bars both
+have, or false when they share fewer than shared bars or either is constant.
+*/
+func returnCorrelation(a, b map[int64]float64, shared int) (float64, bool) {
+    if len(b) < len(a) {
+        a, b = b, a
+    }
+    var n, sx, sy, sxx, syy, sxy float64
+    for ts, x := range a {
+        y, ok := b[ts]
+        if !ok {
+            continue
+        }
+        n++
+        sx += x
+        sy += y
+        sxx += x * x
+        syy += y * y
+        sxy += x * y
+    }
+    if n < float64(shared) {
+        return 0, false
+    }
+    vx, vy := sxx-sx*sx/n, syy-sy*sy/n
+    if vx <= 0 || vy <= 0 {
+        return 0, false
+    }
+    return (sxy - sx*sy/n) / math.Sqrt(vx*vy), true
+}
+
+/*
+averageLinkage clusters the items of the correlation matrix corr, merging
+the two clusters with the highest mean pairwise correlation while it is at
+least threshold, and returns the clusters as lists of item indexes in
+ascending order.
+*/
+func averageLinkage(corr [][]float64, threshold float64) [][]int {
+    n := len(corr)
+    groups := make([][]int, n)
+    link := make([][]float64, n)
+    for i := range groups {
+        groups[i] = []int{i}
+        link[i] = append([]float64(nil), corr[i]...)
+    }
+    alive := make([]bool, n)
+    for i := range alive {
+        alive[i] = true
+    }
+    for {
+        best, bi, bj := threshold, -1, -1
+        for i := 0; i < n; i++ {
+            if !alive[i] {
+                continue
+            }
+            for j := i + 1; j < n; j++ {
+                if !alive[j] {
+                    continue
+                }
+                if avg := link[i][j] / float64(len(groups[i])*len(groups[j])); avg >= best {
+                    best, bi, bj = avg, i, j
+                }
+            }
+        }
+        if bi < 0 {
+            break
+        }
+        groups[bi] = append(groups[bi], groups[bj]...)
+        sort.Ints(groups[bi])
+        alive[bj] = false
+        for k := 0; k < n; k++ {
+            link[bi][k] += link[bj][k]
+            link[k][bi] = link[bi][k]
+        }
+    }
+    var out [][]int
+    for i, g := range groups {
+        if alive[i] {
+            out = append(out, g)
+        }
+    }
+    return out
+}
+
+/*
+Suppress reports whether an alert of rule for symbol, in the direction of
+value's sign, repeats one already delivered for another member of symbol's
+cluster within the alert window, naming that member. Otherwise the alert is
+remembered as the cluster's latest for its rule and direction.
+*/
+func (cb *ClusterBook) Suppress(rule, symbol string, value float64, now time.Time) (string, bool) {
+    if cb.window <= 0 || symbol == "" {
+        return "", false
+    }
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+    id, ok := cb.member[symbol]
+    if !ok {
+        return "", false
+    }
+    key := fmt.Sprintf("%d/%s/%v", id, rule, value < 0)
+    if last, ok := cb.fired[key]; ok && last.symbol != symbol && now.Sub(last.at) < cb.window {
+        cb.suppressed.Add(1)
+        return last.symbol, true
+    }
+    cb.fired[key] = clusterFiring{symbol, now}
+    return "", false
+}
+
+/*
+handleClusters exposes GET /api/clusters, the current correlation clusters
+of two or more symbols, when they were computed and with which settings.
+Tracked symbols in no cluster are listed as unclustered.
+*/
+func (cb *ClusterBook) handleClusters(w http.ResponseWriter, r *http.Request) {
+    cb.mu.RLock()
+    clusters, member, at := cb.clusters, cb.member, cb.computedAt
+    cb.mu.RUnlock()
+    unclustered := []string{}
+    for _, sym := range cb.fp.trackedSymbols() {
+        if _, ok := member[sym]; !ok {
+            unclustered = append(unclustered, sym)
+        }
+    }
+    sort.Strings(unclustered)
+    resp := map[string]interface{}{
+        "clusters":        append([]SymbolCluster{}, clusters...),
+        "unclustered":     unclustered,
+        "resolution":      cb.resolution.String(),
+        "min_correlation": cb.minCorr,
+    }
+    if !at.IsZero() {
+        resp["computed_at"] = at
+    }
+    json.NewEncoder(w).Encode(resp)
+}
+
+/*
+writeMetrics appends the cluster count and suppressed alerts in Prometheus
+text format.
+*/
+func (cb *ClusterBook) writeMetrics(sb *strings.Builder) {
+    cb.mu.RLock()
+    n := len(cb.clusters)
+    cb.mu.RUnlock()
+    sb.WriteString("# HELP symbol_clusters Correlation clusters of two or more symbols.\n")
+    sb.WriteString("# TYPE symbol_clusters gauge\n")
+    fmt.Fprintf(sb, "symbol_clusters %d\n", n)
+    sb.WriteString("# HELP cluster_alerts_suppressed_total Alerts not delivered because their cluster had just raised the same one.\n")
+    sb.WriteString("# TYPE cluster_alerts_suppressed_total counter\n")
+    fmt.Fprintf(sb, "cluster_alerts_suppressed_total %d\n", cb.suppressed.Load())
+}
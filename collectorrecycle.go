@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+yahooCookieURL is where the Yahoo session cookies a collector holds are kept.
+*/
+const yahooCookieURL = "https://finance.yahoo.com/"
+
+/*
+CollectorRecycler replaces each symbol's Colly collector once it has been
+in use for COLLECTOR_RECYCLE_INTERVAL (default 24h, 0 disables), since
+collectors accumulate internal state that slowly degrades scraping over
+weeks of uptime. The swap happens between two fetches of the symbol and the
+fresh collector takes over the old one's Yahoo cookies, so the next fetch
+neither waits nor starts a new session; a fetch still running on the old
+collector finishes with it.
+*/
+type CollectorRecycler struct {
+    every    time.Duration
+    recycled atomic.Int64
+}
+
+/*
+NewCollectorRecyclerFromEnv creates the recycler.
+*/
+func NewCollectorRecyclerFromEnv() *CollectorRecycler {
+    return &CollectorRecycler{every: envDuration("COLLECTOR_RECYCLE_INTERVAL", 24*time.Hour)}
+}
+
+/*
+due reports whether dc has been in use long enough to be replaced at now.
+*/
+func (cr *CollectorRecycler) due(dc *DataCollector, now time.Time) bool {
+    return cr.every > 0 && now.Sub(dc.created) >= cr.every
+}
+
+/*
+successor returns a fresh collector carrying over dc's Yahoo cookies and
+event dates.
+*/
+func (dc *DataCollector) successor() *DataCollector {
+    next := NewDataCollector()
+    dc.eventsMu.Lock()
+    next.events = dc.events
+    dc.eventsMu.Unlock()
+    if cookies := dc.collector.Cookies(yahooCookieURL); len(cookies) > 0 {
+        if err := next.collector.SetCookies(yahooCookieURL, cookies); err != nil {
+            slog.Warn("carrying over collector cookies failed", "err", err)
+        }
+    }
+    return next
+}
+
+/*
+collectorFor returns symbol's collector for its next fetch, recycling it
+first when it is due.
+*/
+func (fp *FinancialProcessor) collectorFor(symbol string) *DataCollector {
+    now := time.Now()
+    fp.mutex.RLock()
+    dc := fp.collectors[symbol]
+    fp.mutex.RUnlock()
+    if dc == nil || !fp.recycler.due(dc, now) {
+        return dc
+    }
+    fp.mutex.Lock()
+    defer fp.mutex.Unlock()
+    switch cur := fp.collectors[symbol]; {
+    case cur == nil:
+        return dc
+    case cur != dc:
+        return cur
+    }
+    next := dc.successor()
+    fp.collectors[symbol] = next
+    fp.recycler.recycled.Add(1)
+    slog.Info("collector recycled", "symbol", symbol, "age", now.Sub(dc.created).Round(time.Second))
+    return next
+}
+
+/*
+writeMetrics appends the number of recycled collectors in Prometheus text format.
+*/
+func (cr *CollectorRecycler) writeMetrics(sb *strings.Builder) {
+    sb.WriteString("# HELP collector_recycles_total Collectors replaced after COLLECTOR_RECYCLE_INTERVAL.\n")
+    sb.WriteString("# TYPE collector_recycles_total counter\n")
+    fmt.Fprintf(sb, "collector_recycles_total %d\n", cr.recycled.Load())
+}
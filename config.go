@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Config holds the core collection settings: how often each symbol is fetched,
+how many ticks are kept per symbol, the predicted move (in percent) below
+which a prediction is recorded but not acted on, and the symbols to track.
+MarketClosed says what collection does outside a symbol's trading sessions:
+"slow" to ClosedInterval, "pause" until the next open, or "off" to carry on
+as usual. Calendars adds or replaces trading calendars, Watchlists defines
+the synthetic indexes computed from tracked symbols, and RetentionTiers,
+when set, keeps older history as aggregated bars (see parseRetentionTiers).
+Features turns feature flags on or off (see FeatureFlags).
+*/
+type Config struct {
+    Interval            time.Duration   `yaml:"interval"`
+    MaxHistory          int             `yaml:"max_history"`
+    PredictionThreshold float64         `yaml:"prediction_threshold"`
+    Symbols             []string        `yaml:"symbols"`
+    MarketClosed        string          `yaml:"market_closed"`
+    ClosedInterval      time.Duration   `yaml:"market_closed_interval"`
+    Calendars           []CalendarSpec  `yaml:"calendars"`
+    Watchlists          []WatchlistSpec `yaml:"watchlists"`
+    RetentionTiers      string          `yaml:"retention_tiers"`
+    Features            map[string]bool `yaml:"features"`
+}
+
+/*
+DefaultConfig returns the settings used when nothing is configured.
+*/
+func DefaultConfig() Config {
+    return Config{
+        Interval:       30 * time.Second,
+        MaxHistory:     100,
+        Symbols:        []string{"AAPL", "MSFT", "GOOGL", "AMZN", "META"},
+        MarketClosed:   "slow",
+        ClosedInterval: 15 * time.Minute,
+    }
+}
+
+/*
+LoadConfig starts from DefaultConfig, applies the YAML file named by
+CONFIG_FILE when set, then COLLECTION_INTERVAL, MAX_HISTORY,
+PREDICTION_THRESHOLD, SYMBOLS (comma-separated), MARKET_CLOSED_MODE,
+MARKET_CLOSED_INTERVAL and RETENTION_TIERS from the environment.
+*/
+func LoadConfig() (Config, error) {
+    cfg := DefaultConfig()
+    if path := os.Getenv("CONFIG_FILE"); path != "" {
+        raw, err := os.ReadFile(path)
+        if err != nil {
+            return cfg, fmt.Errorf("reading config: %w", err)
+        }
+        if err := yaml.Unmarshal(raw, &cfg); err != nil {
+            return cfg, fmt.Errorf("parsing %s: %w", path, err)
+        }
+    }
+    cfg.Interval = envDuration("COLLECTION_INTERVAL", cfg.Interval)
+    cfg.MaxHistory = envInt("MAX_HISTORY", cfg.MaxHistory)
+    cfg.PredictionThreshold = envFloat("PREDICTION_THRESHOLD", cfg.PredictionThreshold)
+    if v := os.Getenv("MARKET_CLOSED_MODE"); v != "" {
+        cfg.MarketClosed = v
+    }
+    cfg.ClosedInterval = envDuration("MARKET_CLOSED_INTERVAL", cfg.ClosedInterval)
+    if v := os.Getenv("RETENTION_TIERS"); v != "" {
+        cfg.RetentionTiers = v
+    }
+    symbols := cfg.Symbols
+    if v := os.Getenv("SYMBOLS"); v != "" {
+        symbols = strings.Split(v, ",")
+    }
+    cfg.Symbols = nil
+    for _, s := range symbols {
+        if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+            cfg.Symbols = append(cfg.Symbols, s)
+        }
+    }
+    return cfg, cfg.validate()
+}
+
+/*
+validate rejects settings the collection loops cannot run with.
+*/
+func (c Config) validate() error {
+    switch {
+    case c.Interval < time.Second:
+        return fmt.Errorf("collection interval must be at least 1s, got %s", c.Interval)
+    case c.MaxHistory < 2:
+        return fmt.Errorf("max history must be at least 2, got %d", c.MaxHistory)
+    case c.PredictionThreshold < 0:
+        return fmt.Errorf("prediction threshold must not be negative, got %g", c.PredictionThreshold)
+    case c.MarketClosed != "slow" && c.MarketClosed != "pause" && c.MarketClosed != "off":
+        return fmt.Errorf("market_closed must be slow, pause or off, got %q", c.MarketClosed)
+    case c.MarketClosed == "slow" && c.ClosedInterval < c.Interval:
+        return fmt.Errorf("market closed interval must be at least the collection interval, got %s", c.ClosedInterval)
+    }
+    if _, err := NewCalendarSet(c.Calendars); err != nil {
+        return err
+    }
+    if _, err := NewWatchlistIndexes(c.Watchlists); err != nil {
+        return err
+    }
+    if c.RetentionTiers != "" {
+        if _, err := parseRetentionTiers(c.RetentionTiers); err != nil {
+            return err
+        }
+    }
+    if err := checkFeatures(c.Features); err != nil {
+        return err
+    }
+    for _, s := range c.Symbols {
+        if !symbolPattern.MatchString(s) {
+            return fmt.Errorf("invalid symbol %q", s)
+        }
+    }
+    return nil
+}
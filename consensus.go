@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Consensus summarizes the last N individual forecasts for a symbol. The median
+predicted change is the headline figure; the mean and spread show how much
+the underlying forecasts disagree.
+*/
+type Consensus struct {
+    Symbol                    string    `json:"symbol"`
+    Count                     int       `json:"count"`
+    CurrentPrice              float64   `json:"current_price"`
+    MedianPredictedChangePerc float64   `json:"median_predicted_change_percent"`
+    MeanPredictedChangePerc   float64   `json:"mean_predicted_change_percent"`
+    MinPredictedChangePerc    float64   `json:"min_predicted_change_percent"`
+    MaxPredictedChangePerc    float64   `json:"max_predicted_change_percent"`
+    ConsensusPrice            float64   `json:"consensus_price"`
+    From                      time.Time `json:"from"`
+    To                        time.Time `json:"to"`
+}
+
+/*
+computeConsensus aggregates preds, which must be non-empty and oldest first.
+The consensus price applies the median change to the latest current price.
+*/
+func computeConsensus(symbol string, preds []Prediction) Consensus {
+    changes := make([]float64, len(preds))
+    sum := 0.0
+    for i, p := range preds {
+        changes[i] = p.PredictedChangePerc
+        sum += p.PredictedChangePerc
+    }
+    sort.Float64s(changes)
+    median := changes[len(changes)/2]
+    if len(changes)%2 == 0 {
+        median = (changes[len(changes)/2-1] + changes[len(changes)/2]) / 2
+    }
+    last := preds[len(preds)-1]
+    return Consensus{
+        Symbol:                    symbol,
+        Count:                     len(preds),
+        CurrentPrice:              last.CurrentPrice,
+        MedianPredictedChangePerc: median,
+        MeanPredictedChangePerc:   sum / float64(len(preds)),
+        MinPredictedChangePerc:    changes[0],
+        MaxPredictedChangePerc:    changes[len(changes)-1],
+        ConsensusPrice:            last.CurrentPrice * (1 + median/100),
+        From:                      preds[0].Timestamp,
+        To:                        last.Timestamp,
+    }
+}
+
+/*
+handleGetConsensus exposes GET /api/consensus/{symbol}?n=10, aggregating the
+last n forecasts (default CONSENSUS_WINDOW, 10).
+*/
+func (fp *FinancialProcessor) handleGetConsensus(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    n := envInt("CONSENSUS_WINDOW", 10)
+    if v := r.URL.Query().Get("n"); v != "" {
+        var err error
+        if n, err = strconv.Atoi(v); err != nil || n < 1 {
+            http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+            return
+        }
+    }
+    preds := fp.predictions.Window(sym, n)
+    if len(preds) == 0 {
+        http.Error(w, "no predictions", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(computeConsensus(sym, preds))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+/*
+yahooSessionURL hands out the session cookie Yahoo's JSON APIs expect, and
+yahooCrumbURL the crumb that goes with it.
+*/
+const (
+    yahooSessionURL = "https://fc.yahoo.com/"
+    yahooCrumbURL   = "https://query1.finance.yahoo.com/v1/test/getcrumb"
+)
+
+/*
+errYahooUnauthorized is returned when Yahoo answers 401 even to a request
+made with a freshly fetched cookie and crumb.
+*/
+var errYahooUnauthorized = errors.New("yahoo refused the session cookie and crumb")
+
+/*
+YahooSession holds the cookie and crumb Yahoo's quote and quoteSummary
+endpoints require; without them they answer 401. The cookie lives in the
+jar of the session's client, shared by every request made through the
+session. Both are fetched on first use and again whenever a request is
+refused, which is then retried once.
+*/
+type YahooSession struct {
+    base   *http.Client
+    mu     sync.Mutex
+    client *http.Client
+    crumb  string
+}
+
+/*
+NewYahooSession creates a session making its requests like base, with a
+cookie jar of its own.
+*/
+func NewYahooSession(base *http.Client) *YahooSession {
+    return &YahooSession{base: base}
+}
+
+/*
+yahooSession is the session shared by every call to Yahoo's JSON APIs that
+needs a crumb.
+*/
+var yahooSession = NewYahooSession(quoteClient)
+
+/*
+Get requests rawURL with the session's crumb added to its query. A 401
+renews the cookie and crumb and retries once; a second 401 yields
+errYahooUnauthorized.
+*/
+func (ys *YahooSession) Get(rawURL string) (*http.Response, error) {
+    for retried := false; ; retried = true {
+        u, client, crumb, err := ys.withCrumb(rawURL)
+        if err != nil {
+            return nil, err
+        }
+        req, err := http.NewRequest("GET", u, nil)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("User-Agent", "Mozilla/5.0")
+        resp, err := client.Do(req)
+        if err != nil {
+            return nil, err
+        }
+        if resp.StatusCode != http.StatusUnauthorized {
+            return resp, nil
+        }
+        resp.Body.Close()
+        ys.forget(crumb)
+        if retried {
+            return nil, errYahooUnauthorized
+        }
+    }
+}
+
+/*
+withCrumb returns rawURL with the session's crumb set in its query, the
+client holding the cookie it was issued for, and the crumb.
+*/
+func (ys *YahooSession) withCrumb(rawURL string) (string, *http.Client, string, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return "", nil, "", err
+    }
+    client, crumb, err := ys.session()
+    if err != nil {
+        return "", nil, "", err
+    }
+    q := u.Query()
+    q.Set("crumb", crumb)
+    u.RawQuery = q.Encode()
+    return u.String(), client, crumb, nil
+}
+
+/*
+session returns the session's client and crumb. Without a crumb it starts
+over with an empty cookie jar, visits yahooSessionURL, whose response sets
+the cookie whatever its status, then asks yahooCrumbURL for the crumb issued
+to that cookie. Concurrent callers wait for a single handshake.
+*/
+func (ys *YahooSession) session() (*http.Client, string, error) {
+    ys.mu.Lock()
+    defer ys.mu.Unlock()
+    if ys.crumb != "" {
+        return ys.client, ys.crumb, nil
+    }
+    jar, err := cookiejar.New(nil)
+    if err != nil {
+        return nil, "", err
+    }
+    client := *ys.base
+    client.Jar = jar
+    for _, u := range []string{yahooSessionURL, yahooCrumbURL} {
+        req, err := http.NewRequest("GET", u, nil)
+        if err != nil {
+            return nil, "", err
+        }
+        req.Header.Set("User-Agent", "Mozilla/5.0")
+        resp, err := client.Do(req)
+        if err != nil {
+            return nil, "", fmt.Errorf("yahoo crumb handshake: %w", err)
+        }
+        body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        resp.Body.Close()
+        if u == yahooSessionURL {
+            continue
+        }
+        if err != nil {
+            return nil, "", fmt.Errorf("yahoo crumb handshake: %w", err)
+        }
+        crumb := strings.TrimSpace(string(body))
+        if resp.StatusCode != http.StatusOK || crumb == "" || strings.ContainsAny(crumb, "<{ ") {
+            return nil, "", fmt.Errorf("yahoo crumb handshake: getcrumb returned %s", resp.Status)
+        }
+        ys.client, ys.crumb = &client, crumb
+    }
+    return ys.client, ys.crumb, nil
+}
+
+/*
+forget drops crumb once Yahoo has refused it, unless another request has
+already replaced it, so the next request starts a new session.
+*/
+func (ys *YahooSession) forget(crumb string) {
+    ys.mu.Lock()
+    defer ys.mu.Unlock()
+    if ys.crumb == crumb {
+        ys.crumb = ""
+    }
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+//go:embed dashboard/index.html
+var dashboardFiles embed.FS
+
+/*
+dashboardTemplate is the embedded dashboard page. The initial quotes payload
+is rendered into it so the first paint needs no further round trip.
+*/
+var dashboardTemplate = template.Must(template.ParseFS(dashboardFiles, "dashboard/index.html"))
+
+/*
+DashboardQuote is one symbol's row on the dashboard: its latest tick and
+latest prediction, either of which may be missing, and the row's status.
+*/
+type DashboardQuote struct {
+    Symbol     string      `json:"symbol"`
+    Price      *float64    `json:"price"`
+    Volume     *int64      `json:"volume"`
+    Timestamp  *time.Time  `json:"timestamp"`
+    Prediction *Prediction `json:"prediction,omitempty"`
+    Status     EntryStatus `json:"status"`
+}
+
+/*
+DashboardPayload is the data behind the dashboard.
+*/
+type DashboardPayload struct {
+    GeneratedAt time.Time        `json:"generated_at"`
+    Quotes      []DashboardQuote `json:"quotes"`
+}
+
+/*
+dashboardPayload collects the latest tick and prediction for every tracked symbol.
+*/
+func (fp *FinancialProcessor) dashboardPayload() DashboardPayload {
+    symbols := fp.trackedSymbols()
+    out := DashboardPayload{GeneratedAt: fp.clock.Now(), Quotes: make([]DashboardQuote, 0, len(symbols))}
+    for _, sym := range symbols {
+        q := DashboardQuote{Symbol: sym}
+        if sd, ok := fp.dataStore.Latest(sym); ok {
+            q.Price, q.Volume, q.Timestamp = &sd.Price, &sd.Volume, &sd.Timestamp
+        }
+        if p, ok := fp.predictions.Latest(sym); ok {
+            q.Prediction = &p
+        }
+        q.Status = fp.entryStatus(sym, q.Timestamp)
+        out.Quotes = append(out.Quotes, q)
+    }
+    return out
+}
+
+/*
+handleDashboard serves the embedded dashboard at /, primed with the current
+quotes payload.
+*/
+func (fp *FinancialProcessor) handleDashboard(w http.ResponseWriter, r *http.Request) {
+    var buf bytes.Buffer
+    if err := dashboardTemplate.Execute(&buf, fp.dashboardPayload()); err != nil {
+        requestLogger(r).Error("dashboard render error", "err", err)
+        http.Error(w, "dashboard unavailable", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Write(buf.Bytes())
+}
+
+/*
+handleDashboardQuotes exposes GET /api/dashboard/quotes, the payload the
+dashboard polls to refresh itself, served from a snapshot.
+*/
+func (fp *FinancialProcessor) handleDashboardQuotes(w http.ResponseWriter, r *http.Request) {
+    fp.snapshots.Serve(w, "dashboard", func() (interface{}, bool) { return fp.dashboardPayload(), true })
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+RawFetch is the outcome of a debug fetch: the fields parsed from the
+provider, or why parsing failed, and on request the document it returned.
+*/
+type RawFetch struct {
+    Symbol         string     `json:"symbol"`
+    Provider       string     `json:"provider"`
+    FetchedAt      time.Time  `json:"fetched_at"`
+    ElapsedSeconds float64    `json:"elapsed_seconds"`
+    Parsed         *StockData `json:"parsed,omitempty"`
+    Error          string     `json:"error,omitempty"`
+    Raw            *RawBody   `json:"raw,omitempty"`
+}
+
+/*
+RawBody is a provider response as received, sanitized of session values and
+cut to a maximum length.
+*/
+type RawBody struct {
+    URL         string `json:"url"`
+    Status      int    `json:"status"`
+    ContentType string `json:"content_type,omitempty"`
+    Bytes       int    `json:"bytes"`
+    Truncated   bool   `json:"truncated"`
+    Body        string `json:"body"`
+    Error       string `json:"error,omitempty"`
+}
+
+/*
+rawSourceURL returns the document symbol's provider reads and the client to
+read it with; page selects the Yahoo quote page instead of the chart API
+for symbols on the default source.
+*/
+func (fp *FinancialProcessor) rawSourceURL(symbol string, page bool) (string, *http.Client) {
+    src, ok := fp.sources[symbol]
+    switch {
+    case !ok && page:
+        return "https://finance.yahoo.com/quote/" + url.PathEscape(symbol), quoteClient
+    case !ok || src.Provider == "chart":
+        return yahooChartAPI + url.PathEscape(symbol) + "?range=1d&interval=1d", quoteClient
+    case src.Provider == "quote-api":
+        if u, client, _, err := yahooSession.withCrumb(yahooQuoteAPI + "?symbols=" + url.QueryEscape(symbol)); err == nil {
+            return u, client
+        }
+    case src.Provider == "json" || src.Provider == "html":
+        if tmpl, _, _, err := splitSourceURL(src.Arg, "", ""); err == nil {
+            return sourceURL(tmpl, symbol), egressClient(egressSource, 15*time.Second)
+        }
+    }
+    return "", nil
+}
+
+/*
+fetchRawBody requests u and keeps at most limit bytes of the response.
+*/
+func fetchRawBody(client *http.Client, u string, limit int) *RawBody {
+    rb := &RawBody{URL: u}
+    req, err := http.NewRequest("GET", u, nil)
+    if err != nil {
+        rb.Error = err.Error()
+        return rb
+    }
+    req.Header.Set("User-Agent", "Mozilla/5.0")
+    resp, err := client.Do(req)
+    if err != nil {
+        rb.Error = err.Error()
+        return rb
+    }
+    defer resp.Body.Close()
+    rb.Status, rb.ContentType = resp.StatusCode, resp.Header.Get("Content-Type")
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        rb.Error = err.Error()
+    }
+    rb.Bytes = len(body)
+    if len(body) > limit {
+        body = body[:limit]
+        for len(body) > 0 && !utf8.Valid(body) {
+            body = body[:len(body)-1]
+        }
+        rb.Truncated = true
+    }
+    rb.Body = string(sanitizeFixtureHTML(body))
+    return rb
+}
+
+/*
+handleDebugRaw exposes GET /api/debug/raw/{symbol}, which fetches a collected
+symbol through its configured source right now and returns what was parsed,
+or the parse error, without storing anything. With ?raw=true the provider's
+document is fetched again and included, sanitized like genfixtures output
+and cut to max_bytes (default 65536); ?raw=page picks the Yahoo quote page
+that is scraped when the chart API fails, for symbols on the default source.
+*/
+func (fp *FinancialProcessor) handleDebugRaw(w http.ResponseWriter, r *http.Request) {
+    sym := strings.ToUpper(mux.Vars(r)["symbol"])
+    qs := r.URL.Query()
+    fp.mutex.RLock()
+    _, collected := fp.collectors[sym]
+    fp.mutex.RUnlock()
+    if _, ok := fp.sources[sym]; !ok && !collected {
+        http.Error(w, sym+" is not collected by this process", http.StatusNotFound)
+        return
+    }
+    limit := 65536
+    if v := qs.Get("max_bytes"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            http.Error(w, "max_bytes must be a positive integer", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+    raw := qs.Get("raw")
+    if raw != "" && raw != "true" && raw != "false" && raw != "page" {
+        http.Error(w, "raw must be true, false or page", http.StatusBadRequest)
+        return
+    }
+
+    out := RawFetch{Symbol: sym, Provider: "yahoo", FetchedAt: time.Now().UTC()}
+    if src, ok := fp.sources[sym]; ok {
+        out.Provider = src.Provider
+    }
+    start := time.Now()
+    sd, err := fp.fetch(sym)
+    out.ElapsedSeconds = time.Since(start).Seconds()
+    if err != nil {
+        out.Error = err.Error()
+    } else {
+        out.Parsed = sd
+    }
+    if raw == "true" || raw == "page" {
+        if u, client := fp.rawSourceURL(sym, raw == "page"); client != nil {
+            out.Raw = fetchRawBody(client, u, limit)
+        }
+    }
+    json.NewEncoder(w).Encode(out)
+}
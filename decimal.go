@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Decimal is an exact decimal number for money amounts. It is held in
+canonical form (no exponent, no leading or trailing zeros), so sub-cent
+crypto prices and very large amounts keep exactly the digits they were given
+instead of picking up binary rounding or exponent notation. The zero value is 0.
+*/
+type Decimal struct {
+    s string
+}
+
+/*
+ParseDecimal parses an optionally signed decimal such as "12.50", "-0.00001234"
+or "1.5e-7".
+*/
+func ParseDecimal(s string) (Decimal, error) {
+    v := strings.TrimSpace(s)
+    neg := false
+    if v != "" && (v[0] == '-' || v[0] == '+') {
+        neg = v[0] == '-'
+        v = v[1:]
+    }
+    exp := 0
+    if i := strings.IndexAny(v, "eE"); i >= 0 {
+        n, err := strconv.Atoi(v[i+1:])
+        if err != nil || n > 1000 || n < -1000 {
+            return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+        }
+        exp, v = n, v[:i]
+    }
+    intPart, frac, _ := strings.Cut(v, ".")
+    digits := intPart + frac
+    if digits == "" || strings.Trim(digits, "0123456789") != "" {
+        return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+    }
+
+    // Move the decimal point by the exponent, padding with zeros as needed.
+    point := len(intPart) + exp
+    switch {
+    case point <= 0:
+        intPart, frac = "0", strings.Repeat("0", -point)+digits
+    case point >= len(digits):
+        intPart, frac = digits+strings.Repeat("0", point-len(digits)), ""
+    default:
+        intPart, frac = digits[:point], digits[point:]
+    }
+    intPart = strings.TrimLeft(intPart, "0")
+    frac = strings.TrimRight(frac, "0")
+    if intPart == "" {
+        intPart = "0"
+    }
+    out := intPart
+    if frac != "" {
+        out += "." + frac
+    }
+    if out == "0" {
+        return Decimal{}, nil
+    }
+    if neg {
+        out = "-" + out
+    }
+    return Decimal{s: out}, nil
+}
+
+/*
+DecimalFromFloat returns the shortest decimal that converts back to f
+exactly, so 0.1 stays 0.1 rather than its full binary expansion.
+*/
+func DecimalFromFloat(f float64) Decimal {
+    d, _ := ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+    return d
+}
+
+/*
+String returns d in canonical form.
+*/
+func (d Decimal) String() string {
+    if d.s == "" {
+        return "0"
+    }
+    return d.s
+}
+
+/*
+Float64 returns the float64 nearest to d, for arithmetic.
+*/
+func (d Decimal) Float64() float64 {
+    f, _ := strconv.ParseFloat(d.String(), 64)
+    return f
+}
+
+/*
+Sign returns -1, 0 or 1 as d is negative, zero or positive.
+*/
+func (d Decimal) Sign() int {
+    switch {
+    case d.s == "":
+        return 0
+    case d.s[0] == '-':
+        return -1
+    }
+    return 1
+}
+
+/*
+MarshalJSON writes d as a plain JSON number, never in exponent notation.
+*/
+func (d Decimal) MarshalJSON() ([]byte, error) {
+    return []byte(d.String()), nil
+}
+
+/*
+UnmarshalJSON accepts either a JSON number or a decimal string.
+*/
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+    s := string(b)
+    if s == "null" {
+        return nil
+    }
+    if len(s) >= 2 && s[0] == '"' {
+        if err := json.Unmarshal(b, &s); err != nil {
+            return err
+        }
+    }
+    v, err := ParseDecimal(s)
+    if err != nil {
+        return err
+    }
+    *d = v
+    return nil
+}
+
+/*
+DecimalTick is a StockData snapshot with every price written as a decimal
+string, for clients whose JSON parsers would round them.
+*/
+type DecimalTick struct {
+    Symbol         string       `json:"symbol"`
+    Price          string       `json:"price"`
+    Volume         int64        `json:"volume"`
+    Timestamp      time.Time    `json:"timestamp"`
+    AssetClass     string       `json:"asset_class,omitempty"`
+    Open           string       `json:"open,omitempty"`
+    High           string       `json:"high,omitempty"`
+    Low            string       `json:"low,omitempty"`
+    PreviousClose  string       `json:"previous_close,omitempty"`
+    EarningsDate   *time.Time   `json:"earnings_date,omitempty"`
+    ExDividendDate *time.Time   `json:"ex_dividend_date,omitempty"`
+    Annotations    []Annotation `json:"annotations,omitempty"`
+}
+
+/*
+wantsDecimalStrings reports whether the client asked for prices as strings
+with ?decimals=string.
+*/
+func wantsDecimalStrings(r *http.Request) bool {
+    return r.URL.Query().Get("decimals") == "string"
+}
+
+/*
+decimalTicks converts data to DecimalTicks.
+*/
+func decimalTicks(data []StockData) []DecimalTick {
+    optional := func(f float64) string {
+        if f == 0 {
+            return ""
+        }
+        return DecimalFromFloat(f).String()
+    }
+    out := make([]DecimalTick, len(data))
+    for i, d := range data {
+        out[i] = DecimalTick{
+            Symbol:         d.Symbol,
+            Price:          DecimalFromFloat(d.Price).String(),
+            Volume:         d.Volume,
+            Timestamp:      d.Timestamp,
+            AssetClass:     d.AssetClass,
+            Open:           optional(d.Open),
+            High:           optional(d.High),
+            Low:            optional(d.Low),
+            PreviousClose:  optional(d.PreviousClose),
+            EarningsDate:   d.EarningsDate,
+            ExDividendDate: d.ExDividendDate,
+            Annotations:    d.Annotations,
+        }
+    }
+    return out
+}
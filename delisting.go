@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+QuoteMissingError reports that the quote page for a symbol no longer exists:
+it returned 404, or redirected away from the quote (Yahoo sends unknown
+tickers to its symbol lookup). Location is the final URL after redirects.
+*/
+type QuoteMissingError struct {
+    Symbol   string
+    Status   int
+    Location string
+}
+
+func (e *QuoteMissingError) Error() string {
+    if e.Location != "" {
+        return fmt.Sprintf("quote for %s redirected to %s", e.Symbol, e.Location)
+    }
+    return fmt.Sprintf("quote for %s returned %d", e.Symbol, e.Status)
+}
+
+/*
+InactiveSymbol is a symbol taken out of collection, normally because it
+appears to be delisted. Its stored history stays readable but accepts no new ticks.
+*/
+type InactiveSymbol struct {
+    Symbol        string    `json:"symbol"`
+    Reason        string    `json:"reason"`
+    Misses        int       `json:"misses"`
+    FirstMissAt   time.Time `json:"first_miss_at"`
+    DeactivatedAt time.Time `json:"deactivated_at"`
+}
+
+/*
+DelistingDetector counts consecutive missing-quote fetches per symbol and
+declares a symbol delisted once it has missed DELIST_AFTER times in a row
+(default 10, 0 disables) over at least DELIST_MIN_DURATION (default 1h), so a
+brief outage or a bad deploy on Yahoo's side is not mistaken for a delisting.
+Inactive symbols are saved to INACTIVE_SYMBOLS_FILE when set.
+*/
+type DelistingDetector struct {
+    mu        sync.Mutex
+    misses    map[string]int
+    firstMiss map[string]time.Time
+    inactive  map[string]InactiveSymbol
+    after     int
+    minAge    time.Duration
+    path      string
+}
+
+/*
+NewDelistingDetectorFromEnv creates the detector and loads saved inactive symbols.
+*/
+func NewDelistingDetectorFromEnv() *DelistingDetector {
+    dd := &DelistingDetector{
+        misses:    make(map[string]int),
+        firstMiss: make(map[string]time.Time),
+        inactive:  make(map[string]InactiveSymbol),
+        after:     envInt("DELIST_AFTER", 10),
+        minAge:    envDuration("DELIST_MIN_DURATION", time.Hour),
+        path:      os.Getenv("INACTIVE_SYMBOLS_FILE"),
+    }
+    if dd.path == "" {
+        return dd
+    }
+    raw, err := os.ReadFile(dd.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            slog.Error("inactive symbols unavailable", "err", err)
+        }
+        return dd
+    }
+    if raw, err = openAtRest(raw); err != nil {
+        log.Fatalf("reading %s: %v", dd.path, err)
+    }
+    var list []InactiveSymbol
+    if err := json.Unmarshal(raw, &list); err != nil {
+        slog.Warn("ignoring unreadable file", "path", dd.path, "err", err)
+        return dd
+    }
+    for _, in := range list {
+        dd.inactive[in.Symbol] = in
+    }
+    return dd
+}
+
+/*
+Observe records the outcome of one fetch for symbol and returns the new
+inactive entry when this miss makes the symbol count as delisted.
+*/
+func (dd *DelistingDetector) Observe(symbol string, err error, now time.Time) (InactiveSymbol, bool) {
+    dd.mu.Lock()
+    defer dd.mu.Unlock()
+    missing, ok := err.(*QuoteMissingError)
+    if !ok {
+        // Transient errors neither count nor reset the streak.
+        if err == nil {
+            delete(dd.misses, symbol)
+            delete(dd.firstMiss, symbol)
+        }
+        return InactiveSymbol{}, false
+    }
+    if dd.misses[symbol] == 0 {
+        dd.firstMiss[symbol] = now
+    }
+    dd.misses[symbol]++
+    if dd.after <= 0 || dd.misses[symbol] < dd.after || now.Sub(dd.firstMiss[symbol]) < dd.minAge {
+        return InactiveSymbol{}, false
+    }
+    if _, already := dd.inactive[symbol]; already {
+        return InactiveSymbol{}, false
+    }
+    in := InactiveSymbol{
+        Symbol:        symbol,
+        Reason:        missing.Error(),
+        Misses:        dd.misses[symbol],
+        FirstMissAt:   dd.firstMiss[symbol],
+        DeactivatedAt: now,
+    }
+    dd.inactive[symbol] = in
+    delete(dd.misses, symbol)
+    delete(dd.firstMiss, symbol)
+    dd.save()
+    return in, true
+}
+
+/*
+Inactive reports whether symbol has been deactivated.
+*/
+func (dd *DelistingDetector) Inactive(symbol string) bool {
+    dd.mu.Lock()
+    defer dd.mu.Unlock()
+    _, ok := dd.inactive[symbol]
+    return ok
+}
+
+/*
+List returns the inactive symbols ordered by symbol.
+*/
+func (dd *DelistingDetector) List() []InactiveSymbol {
+    dd.mu.Lock()
+    defer dd.mu.Unlock()
+    out := make([]InactiveSymbol, 0, len(dd.inactive))
+    for _, in := range dd.inactive {
+        out = append(out, in)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Symbol < out[j].Symbol })
+    return out
+}
+
+/*
+Reactivate removes symbol from the inactive set, reporting whether it was there.
+*/
+func (dd *DelistingDetector) Reactivate(symbol string) bool {
+    dd.mu.Lock()
+    defer dd.mu.Unlock()
+    if _, ok := dd.inactive[symbol]; !ok {
+        return false
+    }
+    delete(dd.inactive, symbol)
+    dd.save()
+    return true
+}
+
+/*
+save writes the inactive set to the configured file. Callers must hold dd.mu.
+*/
+func (dd *DelistingDetector) save() {
+    if dd.path == "" {
+        return
+    }
+    list := make([]InactiveSymbol, 0, len(dd.inactive))
+    for _, in := range dd.inactive {
+        list = append(list, in)
+    }
+    raw, err := json.MarshalIndent(list, "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = writeFileAtomic(dd.path, raw)
+    }
+    if err != nil {
+        slog.Error("saving inactive symbols failed", "err", err)
+    }
+}
+
+/*
+observeFetch records a fetch outcome for per-symbol status, the provider's
+scrape budget and the quarantine, feeds it to the delisting detector and
+deactivates symbol when it is judged delisted, reporting whether that happened.
+*/
+func (fp *FinancialProcessor) observeFetch(symbol string, err error) bool {
+    fp.failures.note(symbol, err)
+    fp.observeScrape(symbol, err)
+    switch entered, released := fp.quarantine.Observe(symbol, err, fp.clock.Now()); {
+    case entered:
+        slog.Warn("symbol quarantined after repeated fetch failures", "symbol", symbol, "err", err)
+    case released:
+        slog.Info("symbol released from quarantine", "symbol", symbol)
+    }
+    in, delisted := fp.delisting.Observe(symbol, err, fp.clock.Now())
+    if delisted {
+        fp.deactivateSymbol(in)
+    }
+    return delisted
+}
+
+/*
+deactivateSymbol stops in.Symbol's pipeline, if it has one, and
+notifies the alert channel. Its history is kept.
+*/
+func (fp *FinancialProcessor) deactivateSymbol(in InactiveSymbol) {
+    fp.mutex.Lock()
+    p, ok := fp.pipelines[in.Symbol]
+    delete(fp.pipelines, in.Symbol)
+    delete(fp.collectors, in.Symbol)
+    fp.mutex.Unlock()
+    if ok {
+        fp.pool.Stop(p)
+    }
+    fp.quarantine.Forget(in.Symbol)
+    msg := fmt.Sprintf("%s looks delisted after %d missing quotes since %s (%s); collection stopped",
+        in.Symbol, in.Misses, in.FirstMissAt.Format(time.RFC3339), in.Reason)
+    slog.Warn("symbol looks delisted; collection stopped", "symbol", in.Symbol, "misses", in.Misses, "since", in.FirstMissAt, "reason", in.Reason)
+    fp.fireAlert("symbol_delisted", in.Symbol, float64(in.Misses), msg)
+}
+
+/*
+handleInactiveSymbols exposes GET /api/symbols/inactive.
+*/
+func (fp *FinancialProcessor) handleInactiveSymbols(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(fp.delisting.List())
+}
+
+/*
+handleReactivateSymbol exposes POST /api/admin/symbols/{symbol}/reactivate,
+which undoes a deactivation and resumes collecting the symbol.
+*/
+func (fp *FinancialProcessor) handleReactivateSymbol(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    if !fp.delisting.Reactivate(sym) {
+        http.Error(w, sym+" is not inactive", http.StatusNotFound)
+        return
+    }
+    // The batched loop picks the symbol up again by itself.
+    if _, custom := fp.sources[sym]; custom || envInt("QUOTE_BATCH_SIZE", 0) <= 0 {
+        fp.startPipeline(sym, 0, 0)
+    }
+    fp.publishConfig(sym, ConfigChange{Kind: "symbol_reactivated"})
+    w.WriteHeader(http.StatusNoContent)
+}
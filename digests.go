@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+DigestSubscription asks for a summary of Symbols every IntervalHours, sent
+to WebhookURL as JSON and/or to Email as text, for users who would rather
+read a periodic digest than follow every alert. Each API key has at most one
+subscription, identified by the key's ID. Baseline holds each symbol's price
+and predicted change as of the last digest, so the next one can report what
+changed since.
+*/
+type DigestSubscription struct {
+    ID            string                    `json:"id"`
+    Symbols       []string                  `json:"symbols"`
+    IntervalHours int                       `json:"interval_hours"`
+    WebhookURL    string                    `json:"webhook_url,omitempty"`
+    Email         string                    `json:"email,omitempty"`
+    CreatedAt     time.Time                 `json:"created_at"`
+    LastSentAt    *time.Time                `json:"last_sent_at,omitempty"`
+    NextDigestAt  time.Time                 `json:"next_digest_at"`
+    Baseline      map[string]digestBaseline `json:"baseline,omitempty"`
+}
+
+/*
+digestBaseline is one symbol's state when the last digest was sent.
+*/
+type digestBaseline struct {
+    Price               float64 `json:"price"`
+    PredictedChangePerc float64 `json:"predicted_change_percent"`
+}
+
+/*
+DigestEntry is one symbol's part of a digest. PriceChangePerc is the move
+since the previous digest, DirectionChanged reports a prediction that
+flipped between up and down since then, and Alerts counts the alerts fired
+for the symbol meanwhile, once however many recipients they reached.
+Notable entries are listed first.
+*/
+type DigestEntry struct {
+    Symbol           string      `json:"symbol"`
+    Status           string      `json:"status"`
+    Price            float64     `json:"price,omitempty"`
+    PriceChangePerc  *float64    `json:"price_change_percent,omitempty"`
+    Prediction       *Prediction `json:"prediction,omitempty"`
+    DirectionChanged bool        `json:"direction_changed,omitempty"`
+    Alerts           int         `json:"alerts"`
+    Notable          bool        `json:"notable"`
+}
+
+/*
+Digest is what a subscriber receives.
+*/
+type Digest struct {
+    Subscription string        `json:"subscription"`
+    GeneratedAt  time.Time     `json:"generated_at"`
+    Since        time.Time     `json:"since"`
+    Entries      []DigestEntry `json:"entries"`
+}
+
+/*
+DigestBook keeps the digest subscriptions and sends the digests that are
+due. A move of at least DIGEST_NOTABLE_PERCENT (default 2) since the last
+digest marks an entry notable, as do a flipped prediction and any alert.
+Subscriptions are saved to DIGEST_SUBSCRIPTIONS_FILE when it is set,
+encrypted when a storage key is configured.
+*/
+type DigestBook struct {
+    fp      *FinancialProcessor
+    notable float64
+    path    string
+    mu      sync.Mutex
+    subs    map[string]*DigestSubscription
+}
+
+/*
+NewDigestBookFromEnv loads saved subscriptions.
+*/
+func NewDigestBookFromEnv(fp *FinancialProcessor) *DigestBook {
+    db := &DigestBook{
+        fp:      fp,
+        notable: envFloat("DIGEST_NOTABLE_PERCENT", 2),
+        path:    os.Getenv("DIGEST_SUBSCRIPTIONS_FILE"),
+        subs:    make(map[string]*DigestSubscription),
+    }
+    if db.path != "" {
+        if raw, err := os.ReadFile(db.path); err == nil {
+            var list []*DigestSubscription
+            if raw, err = openAtRest(raw); err != nil {
+                log.Fatalf("reading %s: %v", db.path, err)
+            }
+            if err := json.Unmarshal(raw, &list); err != nil {
+                slog.Warn("ignoring unreadable file", "path", db.path, "err", err)
+            }
+            for _, s := range list {
+                db.subs[s.ID] = s
+            }
+        }
+    }
+    return db
+}
+
+/*
+save writes all subscriptions to the configured file. Callers must hold db.mu.
+*/
+func (db *DigestBook) save() {
+    if db.path == "" {
+        return
+    }
+    list := make([]*DigestSubscription, 0, len(db.subs))
+    for _, s := range db.subs {
+        list = append(list, s)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+    raw, err := json.MarshalIndent(list, "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = writeFileAtomic(db.path, raw)
+    }
+    if err != nil {
+        slog.Error("saving digest subscriptions failed", "err", err)
+    }
+}
+
+/*
+recipient is the alert recipient a subscription's digests are delivered to
+and recorded under.
+*/
+func (s *DigestSubscription) recipient() Recipient {
+    return Recipient{User: "digest:" + s.ID, WebhookURL: s.WebhookURL, Email: s.Email}
+}
+
+/*
+validate normalizes the symbols and checks the interval and channels.
+*/
+func (s *DigestSubscription) validate() error {
+    if len(s.Symbols) == 0 {
+        return fmt.Errorf("symbols is required")
+    }
+    seen := make(map[string]bool)
+    symbols := make([]string, 0, len(s.Symbols))
+    for _, sym := range s.Symbols {
+        sym = strings.ToUpper(strings.TrimSpace(sym))
+        if !symbolPattern.MatchString(sym) {
+            return fmt.Errorf("invalid symbol %q", sym)
+        }
+        if !seen[sym] {
+            seen[sym] = true
+            symbols = append(symbols, sym)
+        }
+    }
+    s.Symbols = symbols
+    if s.IntervalHours < 1 {
+        return fmt.Errorf("interval_hours must be at least 1")
+    }
+    if s.WebhookURL == "" && s.Email == "" {
+        return fmt.Errorf("at least one of webhook_url and email is required")
+    }
+    if s.Email != "" {
+        if _, err := mail.ParseAddress(s.Email); err != nil {
+            return fmt.Errorf("invalid email %q", s.Email)
+        }
+    }
+    return nil
+}
+
+/*
+build assembles the digest for s at now without changing it. Callers must
+hold db.mu.
+*/
+func (db *DigestBook) build(s *DigestSubscription, now time.Time) Digest {
+    fp := db.fp
+    since := s.CreatedAt
+    if s.LastSentAt != nil {
+        since = *s.LastSentAt
+    }
+    d := Digest{Subscription: s.ID, GeneratedAt: now, Since: since, Entries: []DigestEntry{}}
+    for _, sym := range s.Symbols {
+        e := DigestEntry{Symbol: sym, Status: "no_data"}
+        fired := make(map[string]bool)
+        for _, rec := range fp.alerts.history.Query(AlertQuery{Symbol: sym, Since: since}) {
+            fired[rec.Rule+"@"+rec.FiredAt.String()] = true
+        }
+        e.Alerts = len(fired)
+        base, hasBase := s.Baseline[sym]
+        if sd, ok := fp.dataStore.Latest(sym); ok {
+            e.Status, e.Price = "ok", sd.Price
+            if hasBase && base.Price > 0 {
+                change := (sd.Price - base.Price) / base.Price * 100
+                e.PriceChangePerc = &change
+            }
+        }
+        if p, ok := fp.predictions.Latest(sym); ok {
+            e.Prediction = &p
+            if hasBase && base.PredictedChangePerc*p.PredictedChangePerc < 0 {
+                e.DirectionChanged = true
+            }
+        }
+        e.Notable = e.DirectionChanged || e.Alerts > 0 ||
+            e.PriceChangePerc != nil && math.Abs(*e.PriceChangePerc) >= db.notable
+        d.Entries = append(d.Entries, e)
+    }
+    sort.SliceStable(d.Entries, func(i, j int) bool { return d.Entries[i].Notable && !d.Entries[j].Notable })
+    return d
+}
+
+/*
+text renders d as the plain text sent by email.
+*/
+func (d Digest) text() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Forecast digest for %d symbols since %s\n", len(d.Entries), d.Since.UTC().Format("2006-01-02 15:04 MST"))
+    for _, e := range d.Entries {
+        b.WriteString("\n")
+        if e.Notable {
+            b.WriteString("* ")
+        } else {
+            b.WriteString("  ")
+        }
+        if e.Status != "ok" {
+            fmt.Fprintf(&b, "%s: no data", e.Symbol)
+            continue
+        }
+        fmt.Fprintf(&b, "%s %.2f", e.Symbol, e.Price)
+        if e.PriceChangePerc != nil {
+            fmt.Fprintf(&b, " (%+.2f%%)", *e.PriceChangePerc)
+        }
+        if e.Prediction != nil {
+            fmt.Fprintf(&b, ", predicted %.2f (%+.2f%%)", e.Prediction.PredictedPrice, e.Prediction.PredictedChangePerc)
+        }
+        if e.DirectionChanged {
+            b.WriteString(", prediction changed direction")
+        }
+        if e.Alerts > 0 {
+            fmt.Fprintf(&b, ", %d alerts", e.Alerts)
+        }
+    }
+    b.WriteString("\n")
+    return b.String()
+}
+
+/*
+Run checks every minute for subscriptions whose digest is due and sends it,
+unless the forecast_digests flag is off.
+*/
+func (db *DigestBook) Run() {
+    for range time.Tick(time.Minute) {
+        if db.fp.flags.Enabled(flagDigests) {
+            db.sendDue(db.fp.clock.Now())
+        }
+    }
+}
+
+/*
+sendDue sends every digest due at now, records it in the alert history under
+the "forecast_digest" rule and schedules the next one.
+*/
+func (db *DigestBook) sendDue(now time.Time) {
+    type due struct {
+        rc     Recipient
+        digest Digest
+    }
+    var sends []due
+    db.mu.Lock()
+    for _, s := range db.subs {
+        if now.Before(s.NextDigestAt) {
+            continue
+        }
+        d := db.build(s, now)
+        sends = append(sends, due{s.recipient(), d})
+        s.Baseline = make(map[string]digestBaseline)
+        for _, e := range d.Entries {
+            if e.Status != "ok" {
+                continue
+            }
+            base := digestBaseline{Price: e.Price}
+            if e.Prediction != nil {
+                base.PredictedChangePerc = e.Prediction.PredictedChangePerc
+            }
+            s.Baseline[e.Symbol] = base
+        }
+        sent := now
+        s.LastSentAt = &sent
+        s.NextDigestAt = now.Add(time.Duration(s.IntervalHours) * time.Hour)
+    }
+    if len(sends) > 0 {
+        db.save()
+    }
+    db.mu.Unlock()
+
+    for _, send := range sends {
+        notable := 0
+        for _, e := range send.digest.Entries {
+            if e.Notable {
+                notable++
+            }
+        }
+        db.fp.alerts.SendTo(send.rc, AlertRecord{
+            Rule:         "forecast_digest",
+            TriggerValue: float64(notable),
+            Message:      send.digest.text(),
+            FiredAt:      now,
+        }, send.digest)
+    }
+}
+
+/*
+subscriber returns the ID of the request's API key, writing 401 when it
+carries none.
+*/
+func (db *DigestBook) subscriber(w http.ResponseWriter, r *http.Request) (string, bool) {
+    id := db.fp.auth.keyID(r)
+    if id == "" {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+        http.Error(w, "digest subscriptions belong to an API key; send one", http.StatusUnauthorized)
+        return "", false
+    }
+    return id, true
+}
+
+/*
+handleGetDigest exposes GET /api/digest, the caller's subscription.
+*/
+func (db *DigestBook) handleGetDigest(w http.ResponseWriter, r *http.Request) {
+    id, ok := db.subscriber(w, r)
+    if !ok {
+        return
+    }
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    s, found := db.subs[id]
+    if !found {
+        http.Error(w, "no digest subscription", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(s)
+}
+
+/*
+handlePutDigest exposes PUT /api/digest, which creates or replaces the
+caller's subscription from a JSON body with symbols, interval_hours,
+webhook_url and email. The first digest is due one interval later; a
+replaced subscription keeps its schedule and baseline.
+*/
+func (db *DigestBook) handlePutDigest(w http.ResponseWriter, r *http.Request) {
+    id, ok := db.subscriber(w, r)
+    if !ok {
+        return
+    }
+    var req DigestSubscription
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid subscription: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err := req.validate(); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    now := db.fp.clock.Now()
+    s := &DigestSubscription{
+        ID:            id,
+        Symbols:       req.Symbols,
+        IntervalHours: req.IntervalHours,
+        WebhookURL:    req.WebhookURL,
+        Email:         req.Email,
+        CreatedAt:     now,
+        NextDigestAt:  now.Add(time.Duration(req.IntervalHours) * time.Hour),
+    }
+    db.mu.Lock()
+    if old, found := db.subs[id]; found {
+        s.CreatedAt, s.LastSentAt, s.Baseline = old.CreatedAt, old.LastSentAt, old.Baseline
+        if next := old.NextDigestAt; next.Before(s.NextDigestAt) {
+            s.NextDigestAt = next
+        }
+    }
+    db.subs[id] = s
+    db.save()
+    db.mu.Unlock()
+    json.NewEncoder(w).Encode(s)
+}
+
+/*
+handleDeleteDigest exposes DELETE /api/digest, ending the caller's subscription.
+*/
+func (db *DigestBook) handleDeleteDigest(w http.ResponseWriter, r *http.Request) {
+    id, ok := db.subscriber(w, r)
+    if !ok {
+        return
+    }
+    db.mu.Lock()
+    _, found := db.subs[id]
+    delete(db.subs, id)
+    db.save()
+    db.mu.Unlock()
+    if !found {
+        http.Error(w, "no digest subscription", http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+handlePreviewDigest exposes GET /api/digest/preview, the digest the caller
+would receive now, without sending it or moving its baseline.
+*/
+func (db *DigestBook) handlePreviewDigest(w http.ResponseWriter, r *http.Request) {
+    id, ok := db.subscriber(w, r)
+    if !ok {
+        return
+    }
+    db.mu.Lock()
+    s, found := db.subs[id]
+    var d Digest
+    if found {
+        d = db.build(s, db.fp.clock.Now())
+    }
+    db.mu.Unlock()
+    if !found {
+        http.Error(w, "no digest subscription", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(d)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Outbound destinations, each with its own proxy and TLS settings. Settings are
+read from EGRESS_<DEST>_PROXY and EGRESS_<DEST>_CA_FILE, falling back to
+EGRESS_PROXY and EGRESS_CA_FILE.
+*/
+const (
+    egressYahoo    = "yahoo"
+    egressML       = "ml"
+    egressWebhook  = "webhook"
+    egressExchange = "exchange"
+    egressSource   = "source"
+)
+
+var (
+    egressMu         sync.Mutex
+    egressTransports = make(map[string]*http.Transport)
+)
+
+/*
+egressSetting returns EGRESS_<DEST>_<name>, or EGRESS_<name> when unset.
+*/
+func egressSetting(dest, name string) string {
+    if v := os.Getenv("EGRESS_" + strings.ToUpper(dest) + "_" + name); v != "" {
+        return v
+    }
+    return os.Getenv("EGRESS_" + name)
+}
+
+/*
+egressTransport returns the shared transport for dest. The proxy is taken
+from the egress settings, where "direct" disables proxying, and otherwise
+from the standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY variables. A CA file
+adds its certificates to the system roots, for proxies that re-sign TLS.
+Invalid settings are fatal, like other startup configuration errors.
+*/
+func egressTransport(dest string) *http.Transport {
+    egressMu.Lock()
+    defer egressMu.Unlock()
+    if t, ok := egressTransports[dest]; ok {
+        return t
+    }
+    t, err := newEgressTransport(dest)
+    if err != nil {
+        log.Fatalf("egress settings for %s: %v", dest, err)
+    }
+    egressTransports[dest] = t
+    return t
+}
+
+/*
+newEgressTransport builds a transport from dest's egress settings.
+*/
+func newEgressTransport(dest string) (*http.Transport, error) {
+    t := http.DefaultTransport.(*http.Transport).Clone()
+    switch proxy := egressSetting(dest, "PROXY"); proxy {
+    case "":
+        t.Proxy = http.ProxyFromEnvironment
+    case "direct":
+        t.Proxy = nil
+    default:
+        u, err := url.Parse(proxy)
+        if err != nil || u.Host == "" {
+            return nil, fmt.Errorf("invalid proxy URL %q", proxy)
+        }
+        t.Proxy = http.ProxyURL(u)
+    }
+    t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+    if ca := egressSetting(dest, "CA_FILE"); ca != "" {
+        pem, err := os.ReadFile(ca)
+        if err != nil {
+            return nil, err
+        }
+        pool, err := x509.SystemCertPool()
+        if err != nil {
+            pool = x509.NewCertPool()
+        }
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates found in %s", ca)
+        }
+        t.TLSClientConfig.RootCAs = pool
+    }
+    return t, nil
+}
+
+/*
+egressClient returns an HTTP client for dest with the given timeout.
+*/
+func egressClient(dest string, timeout time.Duration) *http.Client {
+    return &http.Client{Transport: egressTransport(dest), Timeout: timeout}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+/*
+encryptedMagic prefixes every file sealed by sealAtRest, and encryptedLinePrefix
+every sealed JSON line, so plaintext written before encryption was enabled
+remains readable.
+*/
+const (
+    encryptedMagic      = "FFENC1"
+    encryptedLinePrefix = "enc:"
+)
+
+var (
+    storageAEADOnce sync.Once
+    storageAEADVal  cipher.AEAD
+)
+
+/*
+storageAEAD returns the AES-256-GCM cipher for data files, or nil when
+encryption at rest is disabled. The 32-byte key is taken, in order, from
+STORAGE_ENCRYPTION_KEY (base64 or hex), STORAGE_ENCRYPTION_KEY_FILE (for
+example a secret mounted by a KMS agent), or the stdout of
+STORAGE_ENCRYPTION_KEY_COMMAND (for example a KMS decrypt CLI call). A key
+that is set but unusable is fatal rather than silently writing plaintext.
+*/
+func storageAEAD() cipher.AEAD {
+    storageAEADOnce.Do(func() {
+        key, err := loadStorageKey()
+        if err != nil {
+            log.Fatalf("storage encryption: %v", err)
+        }
+        if key == nil {
+            return
+        }
+        block, err := aes.NewCipher(key)
+        if err != nil {
+            log.Fatalf("storage encryption: %v", err)
+        }
+        if storageAEADVal, err = cipher.NewGCM(block); err != nil {
+            log.Fatalf("storage encryption: %v", err)
+        }
+    })
+    return storageAEADVal
+}
+
+/*
+loadStorageKey resolves the configured key material, returning nil when none is set.
+*/
+func loadStorageKey() ([]byte, error) {
+    var raw, source string
+    if v := os.Getenv("STORAGE_ENCRYPTION_KEY"); v != "" {
+        raw, source = v, "STORAGE_ENCRYPTION_KEY"
+    } else if path := os.Getenv("STORAGE_ENCRYPTION_KEY_FILE"); path != "" {
+        b, err := os.ReadFile(path)
+        if err != nil {
+            return nil, fmt.Errorf("STORAGE_ENCRYPTION_KEY_FILE: %w", err)
+        }
+        raw, source = string(b), "STORAGE_ENCRYPTION_KEY_FILE"
+    } else if cmd := os.Getenv("STORAGE_ENCRYPTION_KEY_COMMAND"); cmd != "" {
+        out, err := exec.Command("sh", "-c", cmd).Output()
+        if err != nil {
+            return nil, fmt.Errorf("STORAGE_ENCRYPTION_KEY_COMMAND: %w", err)
+        }
+        raw, source = string(out), "STORAGE_ENCRYPTION_KEY_COMMAND"
+    } else {
+        return nil, nil
+    }
+    raw = strings.TrimSpace(raw)
+    if key, err := base64.StdEncoding.DecodeString(raw); err == nil && len(key) == 32 {
+        return key, nil
+    }
+    if key, err := hex.DecodeString(raw); err == nil && len(key) == 32 {
+        return key, nil
+    }
+    return nil, fmt.Errorf("%s must be a 32-byte key encoded as base64 or hex", source)
+}
+
+/*
+sealAtRest encrypts data when a storage key is configured and returns it
+unchanged otherwise.
+*/
+func sealAtRest(data []byte) ([]byte, error) {
+    aead := storageAEAD()
+    if aead == nil {
+        return data, nil
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    out := append([]byte(encryptedMagic), nonce...)
+    return aead.Seal(out, nonce, data, []byte(encryptedMagic)), nil
+}
+
+/*
+openAtRest reverses sealAtRest. Data without the encryption header is
+returned as is.
+*/
+func openAtRest(data []byte) ([]byte, error) {
+    if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+        return data, nil
+    }
+    aead := storageAEAD()
+    if aead == nil {
+        return nil, errors.New("data is encrypted but no storage encryption key is configured")
+    }
+    data = data[len(encryptedMagic):]
+    if len(data) < aead.NonceSize() {
+        return nil, errors.New("encrypted data is truncated")
+    }
+    nonce, ct := data[:aead.NonceSize()], data[aead.NonceSize():]
+    return aead.Open(nil, nonce, ct, []byte(encryptedMagic))
+}
+
+/*
+appendSealedJSONLine appends v to path like appendJSONLine, encrypting the
+line (as "enc:<base64>") when a storage key is configured.
+*/
+func appendSealedJSONLine(path string, v interface{}) error {
+    if storageAEAD() == nil {
+        return appendJSONLine(path, v)
+    }
+    line, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    sealed, err := sealAtRest(line)
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    _, err = f.WriteString(encryptedLinePrefix + base64.StdEncoding.EncodeToString(sealed) + "\n")
+    return err
+}
+
+/*
+openSealedLine returns the JSON held by one line written by
+appendSealedJSONLine, passing plaintext lines through.
+*/
+func openSealedLine(line []byte) ([]byte, error) {
+    enc, ok := bytes.CutPrefix(line, []byte(encryptedLinePrefix))
+    if !ok {
+        return line, nil
+    }
+    sealed, err := base64.StdEncoding.DecodeString(string(enc))
+    if err != nil {
+        return nil, err
+    }
+    return openAtRest(sealed)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+/*
+yahooEarningsCalendar is Yahoo's earnings calendar page, which lists a
+symbol's past and upcoming earnings dates.
+*/
+const yahooEarningsCalendar = "https://finance.yahoo.com/calendar/earnings?symbol="
+
+/*
+EventDates are a symbol's next scheduled earnings and ex-dividend dates as
+Yahoo lists them. Either is nil when none is announced, as for ETFs,
+currencies and crypto.
+*/
+type EventDates struct {
+    Earnings   *time.Time
+    ExDividend *time.Time
+    fetchedAt  time.Time
+}
+
+/*
+FetchEventDates returns symbol's upcoming event dates from the
+calendarEvents quoteSummary module. When that fails the earnings calendar
+page is scraped instead, which only lists earnings dates.
+*/
+func (dc *DataCollector) FetchEventDates(symbol string) (*EventDates, error) {
+    now := time.Now()
+    modules, err := fetchQuoteSummary(symbol, []string{"calendarEvents"})
+    if err == nil {
+        return parseCalendarEvents(modules["calendarEvents"], now)
+    }
+    slog.Debug("calendarEvents failed, scraping earnings calendar", "symbol", symbol, "err", err)
+    return dc.scrapeEarningsCalendar(symbol, now)
+}
+
+/*
+parseCalendarEvents reads the calendarEvents module, keeping only dates
+from the day of now on.
+*/
+func parseCalendarEvents(raw json.RawMessage, now time.Time) (*EventDates, error) {
+    ev := &EventDates{}
+    if len(raw) == 0 {
+        return ev, nil
+    }
+    type yahooDate struct {
+        Raw int64 `json:"raw"`
+    }
+    var body struct {
+        Earnings struct {
+            EarningsDate []yahooDate `json:"earningsDate"`
+        } `json:"earnings"`
+        ExDividendDate *yahooDate `json:"exDividendDate"`
+    }
+    if err := json.Unmarshal(raw, &body); err != nil {
+        return nil, fmt.Errorf("calendarEvents: %w", err)
+    }
+    for _, d := range body.Earnings.EarningsDate {
+        ev.Earnings = earliestUpcoming(ev.Earnings, time.Unix(d.Raw, 0).UTC(), now)
+    }
+    if d := body.ExDividendDate; d != nil && d.Raw > 0 {
+        ev.ExDividend = earliestUpcoming(nil, time.Unix(d.Raw, 0).UTC(), now)
+    }
+    return ev, nil
+}
+
+/*
+earliestUpcoming returns the earlier of cur and t, ignoring t when it falls
+before the day of now.
+*/
+func earliestUpcoming(cur *time.Time, t, now time.Time) *time.Time {
+    if t.Before(now.UTC().Truncate(24 * time.Hour)) {
+        return cur
+    }
+    if cur == nil || t.Before(*cur) {
+        return &t
+    }
+    return cur
+}
+
+/*
+scrapeEarningsCalendar visits the earnings calendar page for symbol and
+returns the earliest earnings date listed from today on. Dates are shown
+like "Jan 30, 2025, 4 PM EST"; only the day is kept.
+*/
+func (dc *DataCollector) scrapeEarningsCalendar(symbol string, now time.Time) (*EventDates, error) {
+    ev := &EventDates{}
+    c := dc.collector.Clone()
+    c.OnHTML("td[aria-label='Earnings Date']", func(e *colly.HTMLElement) {
+        parts := strings.SplitN(strings.TrimSpace(e.Text), ", ", 3)
+        if len(parts) < 2 {
+            return
+        }
+        if t, err := time.Parse("Jan 2, 2006", parts[0]+", "+parts[1]); err == nil {
+            ev.Earnings = earliestUpcoming(ev.Earnings, t, now)
+        }
+    })
+    if err := c.Visit(yahooEarningsCalendar + url.QueryEscape(symbol)); err != nil {
+        return nil, err
+    }
+    c.Wait()
+    return ev, nil
+}
+
+/*
+attachEventDates sets sd's earnings and ex-dividend dates from the
+collector's cached ones, refetching them once they are EVENT_CALENDAR_TTL
+old. A failed fetch keeps the previous dates until the next attempt, one
+TTL later. A nil collector or a zero TTL leaves sd alone.
+*/
+func (dc *DataCollector) attachEventDates(sd *StockData) {
+    if dc == nil || dc.eventsTTL <= 0 {
+        return
+    }
+    dc.eventsMu.Lock()
+    defer dc.eventsMu.Unlock()
+    if dc.events == nil || time.Since(dc.events.fetchedAt) >= dc.eventsTTL {
+        ev, err := dc.FetchEventDates(sd.Symbol)
+        if err != nil {
+            slog.Warn("event calendar fetch failed", "symbol", sd.Symbol, "err", err)
+            ev = &EventDates{}
+            if dc.events != nil {
+                *ev = *dc.events
+            }
+        }
+        ev.fetchedAt = time.Now()
+        dc.events = ev
+    }
+    sd.EarningsDate, sd.ExDividendDate = dc.events.Earnings, dc.events.ExDividend
+}
+
+/*
+daysToEarnings returns, aligned with data, the days from each tick to its
+earnings date, or to the latest tick's for ticks collected without one,
+rounded to two decimals and zero once the date is reached. It returns nil
+when no earnings date is known at all; entries are nil where none applies.
+*/
+func daysToEarnings(data []StockData) []*float64 {
+    if len(data) == 0 {
+        return nil
+    }
+    latest := data[len(data)-1].EarningsDate
+    known := false
+    out := make([]*float64, len(data))
+    for i, d := range data {
+        date := d.EarningsDate
+        if date == nil {
+            date = latest
+        }
+        if date == nil {
+            continue
+        }
+        known = true
+        v := round2(max(0, date.Sub(d.Timestamp).Hours()/24))
+        out[i] = &v
+    }
+    if !known {
+        return nil
+    }
+    return out
+}
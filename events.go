@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Event is one live update: a "tick" carrying StockData, a "prediction"
+carrying a Prediction, an "alert" carrying an AlertRecord, a "config"
+carrying a ConfigChange or a "history" carrying a HistoryChange. Seq numbers
+every published event in order.
+*/
+type Event struct {
+    Seq    int64       `json:"seq"`
+    Time   time.Time   `json:"time"`
+    Type   string      `json:"type"`
+    Symbol string      `json:"symbol"`
+    Data   interface{} `json:"data"`
+}
+
+/*
+EventBus fans live events out to subscribers and keeps the most recent ones
+for the change feed. Publishing never blocks: a subscriber whose buffer is
+full misses the event, which is counted in dropped. Sequence numbers start
+from the process start time in microseconds, so they keep increasing across
+restarts.
+*/
+type EventBus struct {
+    mu       sync.RWMutex
+    subs     map[chan Event]struct{}
+    dropped  atomic.Int64
+    seq      int64
+    recent   []Event
+    capacity int
+}
+
+/*
+NewEventBus creates an empty bus retaining the last capacity events.
+*/
+func NewEventBus(capacity int) *EventBus {
+    return &EventBus{
+        subs:     make(map[chan Event]struct{}),
+        seq:      time.Now().UnixMicro(),
+        capacity: capacity,
+    }
+}
+
+/*
+Subscribe returns a channel receiving every published event and a function
+that unsubscribes and closes it.
+*/
+func (eb *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+    ch := make(chan Event, buffer)
+    eb.mu.Lock()
+    eb.subs[ch] = struct{}{}
+    eb.mu.Unlock()
+    var once sync.Once
+    return ch, func() {
+        once.Do(func() {
+            eb.mu.Lock()
+            delete(eb.subs, ch)
+            eb.mu.Unlock()
+            close(ch)
+        })
+    }
+}
+
+/*
+Publish numbers ev, retains it and delivers it to every subscriber with room for it.
+*/
+func (eb *EventBus) Publish(ev Event) {
+    eb.mu.Lock()
+    defer eb.mu.Unlock()
+    eb.seq++
+    ev.Seq, ev.Time = eb.seq, time.Now().UTC()
+    if eb.capacity > 0 {
+        if len(eb.recent) >= eb.capacity {
+            eb.recent = eb.recent[1:]
+        }
+        eb.recent = append(eb.recent, ev)
+    }
+    for ch := range eb.subs {
+        select {
+        case ch <- ev:
+        default:
+            eb.dropped.Add(1)
+        }
+    }
+}
+
+/*
+Seq returns the sequence number of the last published event.
+*/
+func (eb *EventBus) Seq() int64 {
+    eb.mu.RLock()
+    defer eb.mu.RUnlock()
+    return eb.seq
+}
+
+/*
+Since returns up to limit retained events with a sequence number above
+cursor, oldest first, and the oldest sequence number still retained. A
+negative cursor starts from the oldest retained event. ok is
+false when events after cursor have already been discarded, so the caller
+cannot continue from it without a gap.
+*/
+func (eb *EventBus) Since(cursor int64, limit int) (events []Event, oldest int64, ok bool) {
+    eb.mu.RLock()
+    defer eb.mu.RUnlock()
+    oldest = eb.seq + 1
+    if len(eb.recent) > 0 {
+        oldest = eb.recent[0].Seq
+    }
+    if cursor < 0 {
+        cursor = oldest - 1
+    }
+    if cursor+1 < oldest {
+        return nil, oldest, false
+    }
+    i := int(cursor + 1 - oldest)
+    if i > len(eb.recent) {
+        i = len(eb.recent)
+    }
+    end := len(eb.recent)
+    if limit > 0 && end-i > limit {
+        end = i + limit
+    }
+    return append([]Event(nil), eb.recent[i:end]...), oldest, true
+}
+
+/*
+Subscribers returns the number of current subscribers.
+*/
+func (eb *EventBus) Subscribers() int {
+    eb.mu.RLock()
+    defer eb.mu.RUnlock()
+    return len(eb.subs)
+}
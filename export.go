@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+exportColumns are the columns of a history export, in order.
+*/
+var exportColumns = []string{"symbol", "timestamp", "price", "volume", "open", "high", "low", "previous_close", "asset_class"}
+
+/*
+parseExportTime reads a from/to bound given as RFC 3339 or as a date. A date
+means the start of that day in UTC, or with end set the end of it, so
+to=2025-01-31 includes the whole day.
+*/
+func parseExportTime(v string, end bool) (time.Time, error) {
+    if v == "" {
+        return time.Time{}, nil
+    }
+    if t, err := time.Parse(time.RFC3339, v); err == nil {
+        return t, nil
+    }
+    t, err := time.Parse("2006-01-02", v)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor YYYY-MM-DD", v)
+    }
+    if end {
+        t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+    }
+    return t, nil
+}
+
+/*
+writeTicksCSV writes data as CSV with a header row. Timestamps are RFC 3339
+in UTC and prices are written with the fewest digits that round-trip.
+*/
+func writeTicksCSV(w io.Writer, data []StockData) error {
+    bw := bufio.NewWriterSize(w, 32*1024)
+    cw := csv.NewWriter(bw)
+    cw.Write(exportColumns)
+    num := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+    for _, d := range data {
+        cw.Write([]string{
+            d.Symbol,
+            d.Timestamp.UTC().Format(time.RFC3339Nano),
+            num(d.Price),
+            strconv.FormatInt(d.Volume, 10),
+            num(d.Open),
+            num(d.High),
+            num(d.Low),
+            num(d.PreviousClose),
+            d.AssetClass,
+        })
+    }
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+        return err
+    }
+    return bw.Flush()
+}
+
+/*
+writeTicksParquet writes data as a Parquet file with the export columns;
+timestamps are stored as microseconds since the epoch in UTC.
+*/
+func writeTicksParquet(w io.Writer, data []StockData) error {
+    n := len(data)
+    return writeParquet(w, []parquetColumn{
+        stringColumn("symbol", n, func(i int) string { return data[i].Symbol }),
+        int64Column("timestamp", parquetTimestampMicros, n, func(i int) int64 { return data[i].Timestamp.UnixMicro() }),
+        doubleColumn("price", n, func(i int) float64 { return data[i].Price }),
+        int64Column("volume", parquetNoConversion, n, func(i int) int64 { return data[i].Volume }),
+        doubleColumn("open", n, func(i int) float64 { return data[i].Open }),
+        doubleColumn("high", n, func(i int) float64 { return data[i].High }),
+        doubleColumn("low", n, func(i int) float64 { return data[i].Low }),
+        doubleColumn("previous_close", n, func(i int) float64 { return data[i].PreviousClose }),
+        stringColumn("asset_class", n, func(i int) string { return data[i].AssetClass }),
+    }, n)
+}
+
+/*
+handleExportData exposes GET /api/data/{symbol}/export?format=csv|parquet,
+symbol's collected history as a file that loads straight into pandas. from
+and to (RFC 3339 or YYYY-MM-DD, both optional) bound the range, which is
+read through persistent and cold storage like /api/data. Fields a tick does
+not carry are exported as 0 or empty.
+*/
+func (fp *FinancialProcessor) handleExportData(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    qs := r.URL.Query()
+    format := qs.Get("format")
+    if format == "" {
+        format = "csv"
+    }
+    if format != "csv" && format != "parquet" {
+        http.Error(w, "format must be csv or parquet", http.StatusBadRequest)
+        return
+    }
+    from, err := parseExportTime(qs.Get("from"), false)
+    if err != nil {
+        http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    to, err := parseExportTime(qs.Get("to"), true)
+    if err != nil {
+        http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    if !from.IsZero() && !to.IsZero() && to.Before(from) {
+        http.Error(w, "to is before from", http.StatusBadRequest)
+        return
+    }
+
+    data, err := fp.tickRange(sym, from, to, 0)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if len(data) == 0 {
+        http.Error(w, "no data", http.StatusNotFound)
+        return
+    }
+
+    name := sanitizeFileComponent(sym) + "." + format
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+    if format == "csv" {
+        w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+        err = writeTicksCSV(w, data)
+    } else {
+        w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+        err = writeTicksParquet(w, data)
+    }
+    if err != nil {
+        requestLogger(r).Error("export failed", "symbol", sym, "format", format, "err", err)
+    }
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+/*
+fetchTimes records when a pipeline or the batched loop last fetched and
+when it plans to fetch next, as Unix nanoseconds (0 when unknown).
+*/
+type fetchTimes struct {
+    last atomic.Int64
+    next atomic.Int64
+}
+
+/*
+record notes a fetch started at start, with the next one due at next.
+*/
+func (ft *fetchTimes) record(start, next time.Time) {
+    ft.last.Store(start.UnixNano())
+    ft.next.Store(next.UnixNano())
+}
+
+/*
+snapshot returns the last and next fetch times, nil when unknown.
+*/
+func (ft *fetchTimes) snapshot() (last, next *time.Time) {
+    at := func(ns int64) *time.Time {
+        if ns == 0 {
+            return nil
+        }
+        t := time.Unix(0, ns).UTC()
+        return &t
+    }
+    return at(ft.last.Load()), at(ft.next.Load())
+}
+
+/*
+ScheduleEntry is one symbol's collection schedule. Mode is "pipeline" for a
+per-symbol pipeline on the scrape pool, "batched" for the shared quote API
+loop, "quarantined" for symbols held back after repeated failures, whose
+next fetch is their retry, "inactive" for delisted symbols and "stopped"
+when nothing collects the symbol. StartDelaySeconds is the staggered delay
+before the pipeline's first fetch, of which JitterSeconds was random.
+*/
+type ScheduleEntry struct {
+    Symbol            string     `json:"symbol"`
+    Mode              string     `json:"mode"`
+    Source            string     `json:"source"`
+    NextFetch         *time.Time `json:"next_fetch,omitempty"`
+    LastFetch         *time.Time `json:"last_fetch,omitempty"`
+    LastTick          *time.Time `json:"last_tick,omitempty"`
+    IntervalSeconds   float64    `json:"interval_seconds"`
+    Boosted           bool       `json:"boosted"`
+    StartDelaySeconds float64    `json:"start_delay_seconds"`
+    JitterSeconds     float64    `json:"jitter_seconds"`
+    Restarts          int        `json:"restarts"`
+}
+
+/*
+schedule returns every tracked symbol's schedule, soonest next fetch first.
+*/
+func (fp *FinancialProcessor) schedule() []ScheduleEntry {
+    now := fp.clock.Now()
+    fp.mutex.RLock()
+    pipelines := make(map[string]*symbolPipeline, len(fp.pipelines))
+    for sym, p := range fp.pipelines {
+        pipelines[sym] = p
+    }
+    boosts := make(map[string]time.Time, len(fp.boosts))
+    for sym, until := range fp.boosts {
+        boosts[sym] = until
+    }
+    collecting := fp.collecting
+    fp.mutex.RUnlock()
+    batched := collecting && envInt("QUOTE_BATCH_SIZE", 0) > 0
+
+    out := make([]ScheduleEntry, 0, len(pipelines))
+    for _, sym := range fp.trackedSymbols() {
+        e := ScheduleEntry{
+            Symbol:          sym,
+            Mode:            "stopped",
+            Source:          "yahoo",
+            IntervalSeconds: fp.collectionInterval(sym).Seconds(),
+            Boosted:         now.Before(boosts[sym]),
+        }
+        if src, ok := fp.sources[sym]; ok {
+            e.Source = src.Provider
+        }
+        p, hasLoop := pipelines[sym]
+        retry, held := fp.quarantine.Holds(sym, now)
+        switch {
+        case fp.delisting.Inactive(sym):
+            e.Mode = "inactive"
+        case held:
+            e.Mode = "quarantined"
+            e.NextFetch = &retry
+            if hasLoop {
+                e.LastFetch, _ = p.times.snapshot()
+            }
+        case hasLoop && !p.stopped():
+            e.Mode = "pipeline"
+            e.LastFetch, e.NextFetch = p.times.snapshot()
+            e.StartDelaySeconds = p.delay.Seconds()
+            e.JitterSeconds = p.jitter.Seconds()
+        case batched && e.Source == "yahoo":
+            e.Mode = "batched"
+            e.LastFetch, e.NextFetch = fp.batchTimes.snapshot()
+        }
+        if hasLoop {
+            e.Restarts = p.restarts
+        }
+        if sd, ok := fp.dataStore.Latest(sym); ok {
+            ts := sd.Timestamp
+            e.LastTick = &ts
+        }
+        out = append(out, e)
+    }
+    sort.SliceStable(out, func(i, j int) bool {
+        a, b := out[i].NextFetch, out[j].NextFetch
+        if a == nil || b == nil {
+            return a != nil
+        }
+        return a.Before(*b)
+    })
+    return out
+}
+
+/*
+handleSchedule exposes GET /api/admin/schedule, each symbol's next and last
+fetch, interval and startup jitter, so the stagger and boost logic can be
+checked directly.
+*/
+func (fp *FinancialProcessor) handleSchedule(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(fp.schedule())
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Feature flags gating subsystems that are rolled out gradually. Each is on by
+default, so a deployment keeps its behaviour until an operator turns one off.
+*/
+const (
+    flagMLIndicators   = "ml_indicators"
+    flagBlending       = "prediction_blending"
+    flagPredictBatch   = "prediction_batching"
+    flagForecastLadder = "forecast_ladder"
+    flagDigests        = "forecast_digests"
+    flagQuarantine     = "scrape_quarantine"
+    flagIndicatorCache = "indicator_cache"
+)
+
+/*
+featureFlagInfo describes each flag for GET /api/admin/flags.
+*/
+var featureFlagInfo = map[string]string{
+    flagMLIndicators:   "send ML_INDICATORS series in prediction payloads",
+    flagBlending:       "blend predictions with recent momentum",
+    flagPredictBatch:   "send predictions in /predict_batch calls when PREDICT_BATCH_INTERVAL is set",
+    flagForecastLadder: "refresh multi-horizon forecasts",
+    flagDigests:        "send forecast digests to subscribers",
+    flagQuarantine:     "hold back symbols whose fetches keep failing",
+    flagIndicatorCache: "reuse indicator series computed over unchanged history",
+}
+
+/*
+FeatureFlag is one flag's current state. Source says where the value comes
+from: "default", "config" (the features section of CONFIG_FILE), "env"
+(FEATURE_FLAGS) or "runtime" (set through the admin API).
+*/
+type FeatureFlag struct {
+    Name        string     `json:"name"`
+    Description string     `json:"description"`
+    Enabled     bool       `json:"enabled"`
+    Source      string     `json:"source"`
+    UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+/*
+flagOverride is a value set through the admin API.
+*/
+type flagOverride struct {
+    Enabled   bool      `json:"enabled"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+/*
+FeatureFlags resolves the feature flags. A flag's value comes from the admin
+API when set there, else from FEATURE_FLAGS (comma-separated name=on|off
+pairs), else from the features section of the config file, else it is on.
+Values set through the admin API apply at once and are saved to
+FEATURE_FLAGS_FILE when set, so a rollback survives restarts until the flag
+is reset.
+*/
+type FeatureFlags struct {
+    mu        sync.RWMutex
+    base      map[string]bool
+    sources   map[string]string
+    overrides map[string]flagOverride
+    path      string
+}
+
+/*
+NewFeatureFlagsFromEnv resolves the flags from the config file's features
+and the environment, and loads saved overrides.
+*/
+func NewFeatureFlagsFromEnv(config map[string]bool) *FeatureFlags {
+    ff := &FeatureFlags{
+        base:      make(map[string]bool),
+        sources:   make(map[string]string),
+        overrides: make(map[string]flagOverride),
+        path:      os.Getenv("FEATURE_FLAGS_FILE"),
+    }
+    for name := range featureFlagInfo {
+        ff.base[name], ff.sources[name] = true, "default"
+    }
+    for name, on := range config {
+        ff.base[name], ff.sources[name] = on, "config"
+    }
+    for _, pair := range strings.Split(os.Getenv("FEATURE_FLAGS"), ",") {
+        if pair = strings.TrimSpace(pair); pair == "" {
+            continue
+        }
+        name, value, _ := strings.Cut(pair, "=")
+        name = strings.TrimSpace(name)
+        if _, ok := featureFlagInfo[name]; !ok {
+            log.Fatalf("FEATURE_FLAGS: unknown flag %q", name)
+        }
+        on, err := parseFlagValue(value)
+        if err != nil {
+            log.Fatalf("FEATURE_FLAGS: %s: %v", name, err)
+        }
+        ff.base[name], ff.sources[name] = on, "env"
+    }
+    if ff.path != "" {
+        if raw, err := os.ReadFile(ff.path); err == nil {
+            if raw, err = openAtRest(raw); err != nil {
+                log.Fatalf("reading %s: %v", ff.path, err)
+            }
+            if err := json.Unmarshal(raw, &ff.overrides); err != nil {
+                slog.Warn("ignoring unreadable file", "path", ff.path, "err", err)
+            }
+            for name := range ff.overrides {
+                if _, ok := featureFlagInfo[name]; !ok {
+                    delete(ff.overrides, name)
+                }
+            }
+        }
+    }
+    return ff
+}
+
+/*
+parseFlagValue reads on/off, true/false or 1/0.
+*/
+func parseFlagValue(v string) (bool, error) {
+    switch strings.ToLower(strings.TrimSpace(v)) {
+    case "on", "true", "1":
+        return true, nil
+    case "off", "false", "0":
+        return false, nil
+    }
+    return false, fmt.Errorf("value must be on or off, got %q", v)
+}
+
+/*
+checkFeatures rejects unknown flags in the config file.
+*/
+func checkFeatures(features map[string]bool) error {
+    for name := range features {
+        if _, ok := featureFlagInfo[name]; !ok {
+            return fmt.Errorf("unknown feature flag %q", name)
+        }
+    }
+    return nil
+}
+
+/*
+Enabled reports whether flag is on.
+*/
+func (ff *FeatureFlags) Enabled(flag string) bool {
+    ff.mu.RLock()
+    defer ff.mu.RUnlock()
+    if o, ok := ff.overrides[flag]; ok {
+        return o.Enabled
+    }
+    return ff.base[flag]
+}
+
+/*
+List returns every flag ordered by name.
+*/
+func (ff *FeatureFlags) List() []FeatureFlag {
+    ff.mu.RLock()
+    defer ff.mu.RUnlock()
+    out := make([]FeatureFlag, 0, len(featureFlagInfo))
+    for name, desc := range featureFlagInfo {
+        f := FeatureFlag{Name: name, Description: desc, Enabled: ff.base[name], Source: ff.sources[name]}
+        if o, ok := ff.overrides[name]; ok {
+            at := o.UpdatedAt
+            f.Enabled, f.Source, f.UpdatedAt = o.Enabled, "runtime", &at
+        }
+        out = append(out, f)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    return out
+}
+
+/*
+Set overrides flag at runtime, or with enabled nil removes the override so
+the configured value applies again.
+*/
+func (ff *FeatureFlags) Set(flag string, enabled *bool, now time.Time) error {
+    if _, ok := featureFlagInfo[flag]; !ok {
+        return fmt.Errorf("unknown feature flag %q", flag)
+    }
+    ff.mu.Lock()
+    defer ff.mu.Unlock()
+    if enabled == nil {
+        delete(ff.overrides, flag)
+    } else {
+        ff.overrides[flag] = flagOverride{Enabled: *enabled, UpdatedAt: now}
+    }
+    ff.save()
+    return nil
+}
+
+/*
+save writes the overrides to the configured file. Callers must hold ff.mu.
+*/
+func (ff *FeatureFlags) save() {
+    if ff.path == "" {
+        return
+    }
+    raw, err := json.MarshalIndent(ff.overrides, "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = writeFileAtomic(ff.path, raw)
+    }
+    if err != nil {
+        slog.Error("saving feature flags failed", "err", err)
+    }
+}
+
+/*
+handleFlags exposes GET /api/admin/flags, every feature flag with its value
+and where that comes from.
+*/
+func (fp *FinancialProcessor) handleFlags(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(fp.flags.List())
+}
+
+/*
+handleSetFlag exposes PUT /api/admin/flags/{name}, which turns a flag on or
+off at once from a JSON body such as {"enabled": false}, and DELETE on the
+same path, which drops that override so the configured value applies again.
+*/
+func (fp *FinancialProcessor) handleSetFlag(w http.ResponseWriter, r *http.Request) {
+    name := mux.Vars(r)["name"]
+    var enabled *bool
+    if r.Method == http.MethodPut {
+        var req struct {
+            Enabled *bool `json:"enabled"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+            http.Error(w, `body must be {"enabled": true|false}`, http.StatusBadRequest)
+            return
+        }
+        enabled = req.Enabled
+    }
+    if err := fp.flags.Set(name, enabled, fp.clock.Now()); err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    requestLogger(r).Info("feature flag changed", "flag", name, "enabled", fp.flags.Enabled(name))
+    for _, f := range fp.flags.List() {
+        if f.Name == name {
+            json.NewEncoder(w).Encode(f)
+        }
+    }
+}
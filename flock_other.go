@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+/*
+flockExclusive is a no-op on platforms without flock; only the PostgreSQL
+advisory lock protects against duplicate instances there.
+*/
+func flockExclusive(f *os.File) error {
+    slog.Warn("file-based instance locking is not supported on this platform")
+    return nil
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+/*
+flockExclusive takes a non-blocking exclusive flock on f.
+*/
+func flockExclusive(f *os.File) error {
+    err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+    if errors.Is(err, syscall.EWOULDBLOCK) {
+        return errLockHeld
+    }
+    return err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+HorizonForecast is the latest prediction for one horizon of the forecast
+ladder. PredictedAt and AgeSeconds tell how fresh it is; Status is "ok",
+"pending" before the first attempt, or the reason the ML service gave for not
+predicting (such as insufficient history for a long horizon).
+*/
+type HorizonForecast struct {
+    Horizon             string     `json:"horizon"`
+    HorizonSeconds      float64    `json:"horizon_seconds"`
+    Status              string     `json:"status"`
+    CurrentPrice        float64    `json:"current_price,omitempty"`
+    PredictedPrice      float64    `json:"predicted_price,omitempty"`
+    PredictedChangePerc float64    `json:"predicted_change_percent,omitempty"`
+    PredictedAt         *time.Time `json:"predicted_at,omitempty"`
+    TargetTime          *time.Time `json:"target_time,omitempty"`
+    AgeSeconds          float64    `json:"age_seconds,omitempty"`
+    Stale               bool       `json:"stale,omitempty"`
+    AttemptedAt         *time.Time `json:"attempted_at,omitempty"`
+}
+
+/*
+forecastHorizon is one configured rung of the ladder.
+*/
+type forecastHorizon struct {
+    label string
+    d     time.Duration
+}
+
+/*
+ForecastLadder keeps the latest prediction per symbol at each horizon in
+FORECAST_HORIZONS (default "1h,4h,1d,1w"; d and w suffixes are accepted),
+refreshing each at most every FORECAST_REFRESH (default 5m). A rung whose
+last successful prediction is older than twice the refresh interval is stale.
+*/
+type ForecastLadder struct {
+    fp       *FinancialProcessor
+    horizons []forecastHorizon
+    refresh  time.Duration
+    mu       sync.Mutex
+    latest   map[string]map[string]HorizonForecast
+}
+
+/*
+parseHorizon parses a Go duration, also accepting whole days ("1d") and weeks ("1w").
+*/
+func parseHorizon(s string) (time.Duration, error) {
+    for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+        if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) {
+            return time.Duration(n) * unit, nil
+        }
+    }
+    return time.ParseDuration(s)
+}
+
+/*
+NewForecastLadderFromEnv creates the ladder, or returns nil when
+FORECAST_HORIZONS is set to "off".
+*/
+func NewForecastLadderFromEnv(fp *FinancialProcessor) *ForecastLadder {
+    spec := os.Getenv("FORECAST_HORIZONS")
+    if spec == "off" {
+        return nil
+    }
+    if spec == "" {
+        spec = "1h,4h,1d,1w"
+    }
+    fl := &ForecastLadder{
+        fp:      fp,
+        refresh: envDuration("FORECAST_REFRESH", 5*time.Minute),
+        latest:  make(map[string]map[string]HorizonForecast),
+    }
+    for _, label := range strings.Split(spec, ",") {
+        label = strings.TrimSpace(label)
+        d, err := parseHorizon(label)
+        if err != nil || d <= 0 {
+            log.Fatalf("invalid FORECAST_HORIZONS entry %q", label)
+        }
+        fl.horizons = append(fl.horizons, forecastHorizon{label: label, d: d})
+    }
+    return fl
+}
+
+/*
+Refresh predicts every horizon of symbol whose last attempt is older than the
+refresh interval, from the symbol's current history.
+*/
+func (fl *ForecastLadder) Refresh(symbol string) {
+    data := fl.fp.rawTicks(symbol)
+    if len(data) < 5 {
+        return
+    }
+    now := fl.fp.clock.Now()
+    for _, h := range fl.horizons {
+        fl.mu.Lock()
+        prev, ok := fl.latest[symbol][h.label]
+        due := !ok || prev.AttemptedAt == nil || now.Sub(*prev.AttemptedAt) >= fl.refresh
+        if due {
+            if fl.latest[symbol] == nil {
+                fl.latest[symbol] = make(map[string]HorizonForecast)
+            }
+            // Claim the slot so concurrent ticks do not repeat the call.
+            claimed := prev
+            claimed.AttemptedAt = &now
+            fl.latest[symbol][h.label] = claimed
+        }
+        fl.mu.Unlock()
+        if !due {
+            continue
+        }
+
+        hf := fl.predict(symbol, h, data)
+        hf.AttemptedAt = &now
+        fl.mu.Lock()
+        if hf.Status != "ok" && prev.PredictedAt != nil {
+            // Keep the last good prediction; freshness shows its age.
+            prev.Status, prev.AttemptedAt = hf.Status, &now
+            hf = prev
+        }
+        fl.latest[symbol][h.label] = hf
+        fl.mu.Unlock()
+    }
+}
+
+/*
+predict asks the symbol's ML service for the price h ahead.
+*/
+func (fl *ForecastLadder) predict(symbol string, h forecastHorizon, data []StockData) HorizonForecast {
+    hf := HorizonForecast{Horizon: h.label, HorizonSeconds: h.d.Seconds()}
+    inst := fl.fp.instruments.Get(symbol)
+    body, err := json.Marshal(map[string]interface{}{
+        "symbol":          symbol,
+        "instrument":      inst,
+        "data":            data,
+        "horizon_seconds": h.d.Seconds(),
+    })
+    if err != nil {
+        hf.Status = err.Error()
+        return hf
+    }
+    route, url := fl.fp.mlRoutes.Resolve(symbol, "/predict")
+    if !fl.fp.mlReady.Ready(route) {
+        hf.Status = "ml service not ready"
+        return hf
+    }
+    start := time.Now()
+    resp, err := fl.fp.ml.Post(url, body)
+    elapsed := time.Since(start)
+    fl.fp.latency.Observe(depML, elapsed, err)
+    fl.fp.latency.Observe(depML+":"+route, elapsed, err)
+    if err != nil {
+        hf.Status = "ml service unavailable"
+        return hf
+    }
+    defer resp.Body.Close()
+
+    var result struct {
+        Prediction
+        Error string `json:"error"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        hf.Status = fmt.Sprintf("invalid ML response: %v", err)
+        return hf
+    }
+    if result.Error != "" {
+        hf.Status = result.Error
+        return hf
+    }
+    predictedAt, target := fl.fp.clock.Now(), data[len(data)-1].Timestamp.Add(h.d)
+    hf.Status = "ok"
+    hf.CurrentPrice = result.CurrentPrice
+    hf.PredictedPrice = inst.Round(result.PredictedPrice)
+    hf.PredictedChangePerc = result.PredictedChangePerc
+    hf.PredictedAt, hf.TargetTime = &predictedAt, &target
+    fl.fp.accuracy.Track(symbol, h.label, result.CurrentPrice, result.PredictedPrice, predictedAt, target)
+    return hf
+}
+
+/*
+Ladder returns symbol's rungs in configured order with their ages computed at now.
+*/
+func (fl *ForecastLadder) Ladder(symbol string, now time.Time) []HorizonForecast {
+    fl.mu.Lock()
+    defer fl.mu.Unlock()
+    out := make([]HorizonForecast, 0, len(fl.horizons))
+    for _, h := range fl.horizons {
+        hf, ok := fl.latest[symbol][h.label]
+        if !ok || hf.Status == "" {
+            hf = HorizonForecast{Horizon: h.label, HorizonSeconds: h.d.Seconds(), Status: "pending"}
+        }
+        if hf.PredictedAt != nil {
+            hf.AgeSeconds = now.Sub(*hf.PredictedAt).Seconds()
+            hf.Stale = now.Sub(*hf.PredictedAt) > 2*fl.refresh
+        }
+        out = append(out, hf)
+    }
+    return out
+}
+
+/*
+handleGetForecast exposes GET /api/forecast/{symbol}, returning the latest
+prediction at every configured horizon with freshness timestamps.
+*/
+func (fl *ForecastLadder) handleGetForecast(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    if len(fl.fp.dataStore.Window(sym, 1)) == 0 {
+        http.Error(w, "no data", http.StatusNotFound)
+        return
+    }
+    now := fl.fp.clock.Now()
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "symbol":       sym,
+        "generated_at": now,
+        "horizons":     fl.Ladder(sym, now),
+    })
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+fixtureSecretKeys names JSON fields whose values identify the session or the
+request rather than the market data, and are redacted from fixtures.
+*/
+var fixtureSecretKeys = map[string]bool{
+    "crumb":     true,
+    "cookie":    true,
+    "csrftoken": true,
+    "sessionid": true,
+    "guid":      true,
+    "requestid": true,
+    "token":     true,
+    "userid":    true,
+}
+
+/*
+fixtureSecretPatterns match the same values where they appear inside HTML,
+inline scripts and URLs, with the text kept in front of the value.
+*/
+var fixtureSecretPatterns = []*regexp.Regexp{
+    regexp.MustCompile(`(?i)("(?:crumb|csrfToken|sessionId|guid|requestId|token|userId)"\s*:\s*")[^"]*`),
+    regexp.MustCompile(`(?i)(\bnonce=")[^"]*`),
+    regexp.MustCompile(`(?i)([?&](?:crumb|guccounter|guce_referrer|guce_referrer_sig)=)[^&"'\s]*`),
+}
+
+/*
+FixtureManifest lists what a genfixtures run captured, written as
+manifest.json in the output directory.
+*/
+type FixtureManifest struct {
+    CapturedAt time.Time           `json:"captured_at"`
+    Files      map[string][]string `json:"files"`
+    Errors     map[string]string   `json:"errors,omitempty"`
+}
+
+/*
+sanitizeFixtureHTML redacts session values from a captured page.
+*/
+func sanitizeFixtureHTML(page []byte) []byte {
+    for _, re := range fixtureSecretPatterns {
+        page = re.ReplaceAll(page, []byte("${1}REDACTED"))
+    }
+    return page
+}
+
+/*
+sanitizeFixtureJSON redacts session values from a captured JSON document and
+indents it so fixtures diff cleanly. Numbers are kept exactly as received.
+*/
+func sanitizeFixtureJSON(raw []byte) ([]byte, error) {
+    dec := json.NewDecoder(bytes.NewReader(raw))
+    dec.UseNumber()
+    var doc interface{}
+    if err := dec.Decode(&doc); err != nil {
+        return nil, err
+    }
+    return json.MarshalIndent(redactFixtureValue(doc), "", "  ")
+}
+
+/*
+redactFixtureValue replaces the values of fixtureSecretKeys throughout v.
+*/
+func redactFixtureValue(v interface{}) interface{} {
+    switch t := v.(type) {
+    case map[string]interface{}:
+        for k, child := range t {
+            if fixtureSecretKeys[strings.ToLower(k)] {
+                t[k] = "REDACTED"
+            } else {
+                t[k] = redactFixtureValue(child)
+            }
+        }
+    case []interface{}:
+        for i, child := range t {
+            t[i] = redactFixtureValue(child)
+        }
+    }
+    return v
+}
+
+/*
+fetchFixture requests u the way the collectors do and returns the body,
+failing on any status but 200. The quote API is asked through the Yahoo
+session, which adds the cookie and crumb it requires.
+*/
+func fetchFixture(u string) ([]byte, error) {
+    var resp *http.Response
+    if strings.HasPrefix(u, yahooQuoteAPI) {
+        r, err := yahooSession.Get(u)
+        if err != nil {
+            return nil, err
+        }
+        resp = r
+    } else {
+        req, err := http.NewRequest("GET", u, nil)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("User-Agent", "Mozilla/5.0")
+        if resp, err = quoteClient.Do(req); err != nil {
+            return nil, err
+        }
+    }
+    defer resp.Body.Close()
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("%s returned %s", u, resp.Status)
+    }
+    return body, nil
+}
+
+/*
+fixtureCapture writes one symbol's fixtures into dir, recording each file
+written and the first error met.
+*/
+type fixtureCapture struct {
+    dir   string
+    files []string
+    err   error
+}
+
+/*
+write stores data as name, unless an earlier step failed.
+*/
+func (fc *fixtureCapture) write(name string, data []byte) {
+    if fc.err != nil {
+        return
+    }
+    if fc.err = writeFileAtomic(filepath.Join(fc.dir, name), data); fc.err == nil {
+        fc.files = append(fc.files, name)
+    }
+}
+
+/*
+captureJSON fetches u and writes it sanitized as name, returning the raw body.
+*/
+func (fc *fixtureCapture) captureJSON(name, u string) []byte {
+    if fc.err != nil {
+        return nil
+    }
+    raw, err := fetchFixture(u)
+    if err != nil {
+        fc.err = err
+        return nil
+    }
+    clean, err := sanitizeFixtureJSON(raw)
+    if err != nil {
+        fc.err = fmt.Errorf("%s: %w", name, err)
+        return nil
+    }
+    fc.write(name, clean)
+    return raw
+}
+
+/*
+captureSymbol writes symbol's quote page, quote API and chart API responses,
+the /predict payload built from its recent bars and, with ml set, the ML
+service's response to it.
+*/
+func captureSymbol(fc *fixtureCapture, symbol string, indicators []string, ml bool) {
+    if fc.err != nil {
+        return
+    }
+    page, err := fetchFixture("https://finance.yahoo.com/quote/" + url.PathEscape(symbol))
+    if err != nil {
+        fc.err = err
+        return
+    }
+    fc.write("quote_page.html", sanitizeFixtureHTML(page))
+    fc.captureJSON("quote.json", yahooQuoteAPI+"?symbols="+url.QueryEscape(symbol))
+    fc.captureJSON("chart.json", yahooChartAPI+url.PathEscape(symbol)+"?range=1d&interval=1d")
+    raw := fc.captureJSON("candles.json", yahooChartAPI+url.PathEscape(symbol)+"?range=5d&interval=5m")
+    if fc.err != nil {
+        return
+    }
+
+    var cr chartAPIResponse
+    if err := json.Unmarshal(raw, &cr); err != nil || len(cr.Chart.Result) == 0 {
+        fc.err = fmt.Errorf("candles.json holds no chart result")
+        return
+    }
+    data := chartCandles(symbol, &cr)
+    if len(data) < 5 {
+        fc.err = fmt.Errorf("only %d bars, too few for a prediction", len(data))
+        return
+    }
+    body, _ := json.MarshalIndent(predictionPayload(nil, NewInterpolationFromEnv(), ParseInstrument(symbol), data, indicators), "", "  ")
+    fc.write("predict_payload.json", body)
+    if !ml || fc.err != nil {
+        return
+    }
+
+    client, err := NewMLClientFromEnv()
+    if err != nil {
+        fc.err = err
+        return
+    }
+    _, target := NewMLRouterFromEnv().Resolve(symbol, "/predict")
+    resp, err := client.Post(target, body)
+    if err != nil {
+        fc.err = err
+        return
+    }
+    defer resp.Body.Close()
+    out, err := io.ReadAll(resp.Body)
+    if err != nil {
+        fc.err = err
+        return
+    }
+    if resp.StatusCode != http.StatusOK {
+        fc.err = fmt.Errorf("ML service returned %s: %s", resp.Status, bytes.TrimSpace(out))
+        return
+    }
+    if out, fc.err = sanitizeFixtureJSON(out); fc.err == nil {
+        fc.write("predict_response.json", out)
+    }
+}
+
+/*
+runGenFixtures implements the `genfixtures` subcommand, which captures live
+quote pages, API responses and prediction payloads for the given symbols
+into sanitized files under -out/<SYMBOL>/, so parser and pipeline tests can
+be written against current real-world shapes. Session values such as crumbs,
+cookies and nonces are redacted. A symbol that fails is reported in the
+manifest and does not stop the others.
+*/
+func runGenFixtures(args []string) error {
+    fs := flag.NewFlagSet("genfixtures", flag.ContinueOnError)
+    symbols := fs.String("symbols", "", "comma-separated symbols to capture")
+    out := fs.String("out", "fixtures", "directory to write fixtures into")
+    ml := fs.Bool("ml", false, "also capture the ML service's response to each payload")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    var syms []string
+    for _, s := range strings.Split(*symbols, ",") {
+        if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+            syms = append(syms, s)
+        }
+    }
+    if len(syms) == 0 {
+        return errors.New("-symbols is required")
+    }
+
+    indicators := loadMLIndicators()
+    manifest := FixtureManifest{CapturedAt: time.Now().UTC(), Files: make(map[string][]string)}
+    for _, sym := range syms {
+        fc := &fixtureCapture{dir: filepath.Join(*out, sanitizeFileComponent(sym)), files: []string{}}
+        fc.err = os.MkdirAll(fc.dir, 0o755)
+        captureSymbol(fc, sym, indicators, *ml)
+        manifest.Files[sym] = fc.files
+        if fc.err != nil {
+            if manifest.Errors == nil {
+                manifest.Errors = make(map[string]string)
+            }
+            manifest.Errors[sym] = fc.err.Error()
+            slog.Error("capturing fixtures failed", "symbol", sym, "err", fc.err)
+            continue
+        }
+        slog.Info("captured fixtures", "symbol", sym, "fixtures", len(fc.files))
+    }
+
+    raw, _ := json.MarshalIndent(manifest, "", "  ")
+    if err := writeFileAtomic(filepath.Join(*out, "manifest.json"), raw); err != nil {
+        return err
+    }
+    if len(manifest.Errors) == len(syms) {
+        return errors.New("no symbol could be captured")
+    }
+    return nil
+}
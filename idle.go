@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+/*
+IdleMonitor tracks API activity and puts the processor into a low-activity
+mode once no client has made a request for IDLE_AFTER (default 30m) while
+markets are closed. Any API request or the next market open wakes it.
+*/
+type IdleMonitor struct {
+    after    time.Duration
+    interval time.Duration
+    mu       sync.Mutex
+    lastSeen time.Time
+    idle     bool
+    wake     chan struct{}
+    onIdle   []func()
+}
+
+/*
+NewIdleMonitorFromEnv creates the monitor. IDLE_AFTER=0 disables idle mode;
+IDLE_INTERVAL (default 10m) is the collection interval used while idle.
+*/
+func NewIdleMonitorFromEnv() *IdleMonitor {
+    return &IdleMonitor{
+        after:    envDuration("IDLE_AFTER", 30*time.Minute),
+        interval: envDuration("IDLE_INTERVAL", 10*time.Minute),
+        lastSeen: time.Now(),
+        wake:     make(chan struct{}),
+    }
+}
+
+/*
+OnIdle registers fn to run each time the monitor enters idle mode.
+*/
+func (im *IdleMonitor) OnIdle(fn func()) {
+    im.mu.Lock()
+    im.onIdle = append(im.onIdle, fn)
+    im.mu.Unlock()
+}
+
+/*
+Idle reports whether the monitor is currently in idle mode.
+*/
+func (im *IdleMonitor) Idle() bool {
+    im.mu.Lock()
+    defer im.mu.Unlock()
+    return im.idle
+}
+
+/*
+Wake returns a channel that is closed the next time idle mode ends.
+*/
+func (im *IdleMonitor) Wake() <-chan struct{} {
+    im.mu.Lock()
+    defer im.mu.Unlock()
+    return im.wake
+}
+
+/*
+Touch records client activity, leaving idle mode if it was active.
+*/
+func (im *IdleMonitor) Touch() {
+    im.mu.Lock()
+    defer im.mu.Unlock()
+    im.lastSeen = time.Now()
+    if im.idle {
+        im.wakeLocked("API request")
+    }
+}
+
+/*
+wakeLocked leaves idle mode and releases everything waiting on Wake. Callers
+must hold im.mu.
+*/
+func (im *IdleMonitor) wakeLocked(reason string) {
+    im.idle = false
+    close(im.wake)
+    im.wake = make(chan struct{})
+    slog.Info("leaving idle mode", "reason", reason)
+}
+
+/*
+Check enters or leaves idle mode based on activity and market hours at now.
+*/
+func (im *IdleMonitor) Check(now time.Time) {
+    im.mu.Lock()
+    shouldIdle := im.after > 0 && now.Sub(im.lastSeen) >= im.after && !isMarketOpen(now)
+    if shouldIdle == im.idle {
+        im.mu.Unlock()
+        return
+    }
+    if !shouldIdle {
+        im.wakeLocked("market open")
+        im.mu.Unlock()
+        return
+    }
+    im.idle = true
+    hooks := append([]func(){}, im.onIdle...)
+    im.mu.Unlock()
+
+    slog.Info("entering idle mode: no API requests and markets closed", "idle_after", im.after)
+    for _, fn := range hooks {
+        fn()
+    }
+}
+
+/*
+Run evaluates idle mode once a minute.
+*/
+func (im *IdleMonitor) Run() {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+    for now := range ticker.C {
+        im.Check(now)
+    }
+}
+
+/*
+Middleware counts every request as client activity, except /metrics and
+/api/status so monitoring probes do not keep the service awake.
+*/
+func (im *IdleMonitor) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/metrics" && r.URL.Path != "/api/status" {
+            im.Touch()
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+/*
+shrinkForIdle drops caches that are cheap to rebuild and returns freed memory
+to the operating system.
+*/
+func (fp *FinancialProcessor) shrinkForIdle() {
+    fp.summary.Purge()
+    fp.indCache.Purge()
+    debug.FreeOSMemory()
+}
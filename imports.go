@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+HistoryImporter loads uploaded history (CSV in the export format, or a JSON
+array of ticks) into the tick store. Records are deduplicated by symbol and
+timestamp against each other and against the stored history, so overlapping
+files and retried uploads never create duplicate ticks. An upload sent with
+an Idempotency-Key header is applied once: repeating it within
+IMPORT_KEY_TTL (default 24h) replays the first response without touching
+the history again.
+*/
+type HistoryImporter struct {
+    maxBytes int64
+    ttl      time.Duration
+    mu       sync.Mutex
+    write    sync.Mutex
+    keys     map[string]*importKey
+}
+
+/*
+importKey is the outcome of the upload first sent with an idempotency key.
+body is nil while that upload is still being applied.
+*/
+type importKey struct {
+    digest [sha256.Size]byte
+    at     time.Time
+    body   []byte
+}
+
+/*
+ImportResult summarizes an import. Duplicates counts records whose symbol and
+timestamp appeared earlier in the upload or are already stored; Skipped
+counts records that could not be placed: those of inactive symbols, and
+without storage those older than the in-memory history.
+*/
+type ImportResult struct {
+    Received   int      `json:"received"`
+    Imported   int      `json:"imported"`
+    Duplicates int      `json:"duplicates"`
+    Skipped    int      `json:"skipped"`
+    Symbols    []string `json:"symbols"`
+}
+
+/*
+HistoryChange is the Data of a "history" event, published when an import
+adds Ticks ticks to a symbol's history between From and To. Caches derived
+from that range, including the indicator cache, recompute it on next use.
+*/
+type HistoryChange struct {
+    From  time.Time `json:"from"`
+    To    time.Time `json:"to"`
+    Ticks int       `json:"ticks"`
+}
+
+/*
+errKeyReused and errKeyInProgress reject an Idempotency-Key that belongs to a
+different upload or to one that has not finished yet.
+*/
+var (
+    errKeyReused     = errors.New("Idempotency-Key was already used for a different upload")
+    errKeyInProgress = errors.New("an upload with this Idempotency-Key is still in progress")
+)
+
+/*
+NewHistoryImporterFromEnv creates the importer. IMPORT_MAX_BYTES (default
+64 MiB) bounds the size of an upload.
+*/
+func NewHistoryImporterFromEnv() *HistoryImporter {
+    return &HistoryImporter{
+        maxBytes: int64(envInt("IMPORT_MAX_BYTES", 64<<20)),
+        ttl:      envDuration("IMPORT_KEY_TTL", 24*time.Hour),
+        keys:     make(map[string]*importKey),
+    }
+}
+
+/*
+claim reserves key for an upload with digest. It returns the stored response
+when the key has already completed.
+*/
+func (hi *HistoryImporter) claim(key string, digest [sha256.Size]byte) ([]byte, error) {
+    hi.mu.Lock()
+    defer hi.mu.Unlock()
+    now := time.Now()
+    for k, e := range hi.keys {
+        if e.body != nil && now.Sub(e.at) > hi.ttl {
+            delete(hi.keys, k)
+        }
+    }
+    e, ok := hi.keys[key]
+    switch {
+    case !ok:
+        hi.keys[key] = &importKey{digest: digest, at: now}
+        return nil, nil
+    case e.digest != digest:
+        return nil, errKeyReused
+    case e.body == nil:
+        return nil, errKeyInProgress
+    }
+    return e.body, nil
+}
+
+/*
+settle stores key's response, or releases the key when the upload failed so
+it can be retried.
+*/
+func (hi *HistoryImporter) settle(key string, body []byte) {
+    hi.mu.Lock()
+    defer hi.mu.Unlock()
+    if body == nil {
+        delete(hi.keys, key)
+        return
+    }
+    if e, ok := hi.keys[key]; ok {
+        e.body, e.at = body, time.Now()
+    }
+}
+
+/*
+parseImportCSV reads ticks from CSV whose header row names the columns, as
+written by the export; symbol, timestamp and price are required and the
+other export columns are optional.
+*/
+func parseImportCSV(data []byte) ([]StockData, error) {
+    cr := csv.NewReader(bytes.NewReader(data))
+    header, err := cr.Read()
+    if err != nil {
+        return nil, fmt.Errorf("reading header: %w", err)
+    }
+    col := make(map[string]int)
+    for i, name := range header {
+        col[strings.ToLower(strings.TrimSpace(name))] = i
+    }
+    for _, name := range []string{"symbol", "timestamp", "price"} {
+        if _, ok := col[name]; !ok {
+            return nil, fmt.Errorf("missing %s column", name)
+        }
+    }
+    var out []StockData
+    for line := 2; ; line++ {
+        rec, err := cr.Read()
+        if err == io.EOF {
+            return out, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        get := func(name string) string {
+            if i, ok := col[name]; ok && i < len(rec) {
+                return strings.TrimSpace(rec[i])
+            }
+            return ""
+        }
+        num := func(name string) (float64, error) {
+            v := get(name)
+            if v == "" {
+                return 0, nil
+            }
+            f, err := strconv.ParseFloat(v, 64)
+            if err != nil {
+                return 0, fmt.Errorf("line %d: invalid %s %q", line, name, v)
+            }
+            return f, nil
+        }
+        sd := StockData{Symbol: get("symbol"), AssetClass: get("asset_class")}
+        if sd.Timestamp, err = time.Parse(time.RFC3339, get("timestamp")); err != nil {
+            return nil, fmt.Errorf("line %d: invalid timestamp %q", line, get("timestamp"))
+        }
+        if v := get("volume"); v != "" {
+            if sd.Volume, err = strconv.ParseInt(v, 10, 64); err != nil {
+                return nil, fmt.Errorf("line %d: invalid volume %q", line, v)
+            }
+        }
+        for name, dst := range map[string]*float64{
+            "price": &sd.Price, "open": &sd.Open, "high": &sd.High, "low": &sd.Low, "previous_close": &sd.PreviousClose,
+        } {
+            if *dst, err = num(name); err != nil {
+                return nil, err
+            }
+        }
+        out = append(out, sd)
+    }
+}
+
+/*
+parseImport reads the upload as CSV or JSON according to its content type,
+and checks every record before anything is written.
+*/
+func parseImport(contentType string, data []byte) ([]StockData, error) {
+    mt, _, _ := mime.ParseMediaType(contentType)
+    var ticks []StockData
+    switch mt {
+    case "text/csv":
+        var err error
+        if ticks, err = parseImportCSV(data); err != nil {
+            return nil, err
+        }
+    case "application/json", "":
+        if err := json.Unmarshal(data, &ticks); err != nil {
+            return nil, fmt.Errorf("invalid JSON: %w", err)
+        }
+    default:
+        return nil, fmt.Errorf("unsupported content type %q; use text/csv or application/json", mt)
+    }
+    for i := range ticks {
+        sd := &ticks[i]
+        sd.Symbol = strings.ToUpper(strings.TrimSpace(sd.Symbol))
+        sd.Resolution, sd.Annotations = "", nil
+        switch {
+        case !symbolPattern.MatchString(sd.Symbol):
+            return nil, fmt.Errorf("record %d: invalid symbol %q", i+1, sd.Symbol)
+        case sd.Timestamp.IsZero():
+            return nil, fmt.Errorf("record %d: missing timestamp", i+1)
+        case sd.Price <= 0:
+            return nil, fmt.Errorf("record %d: price must be positive", i+1)
+        }
+        if sd.AssetClass == "" {
+            sd.AssetClass = assetClassOf(sd.Symbol)
+        }
+    }
+    return ticks, nil
+}
+
+/*
+importTicks writes ticks to history, skipping those already present. Ticks
+newer than a symbol's latest go through the tick store like collected ones;
+older ones are written straight to storage, as the in-memory window only
+grows at its end. Each symbol whose history changed gets a "history" event.
+Imports run one at a time so concurrent uploads cannot both see a tick as
+missing.
+*/
+func (fp *FinancialProcessor) importTicks(ticks []StockData) (ImportResult, error) {
+    fp.imports.write.Lock()
+    defer fp.imports.write.Unlock()
+
+    res := ImportResult{Received: len(ticks), Symbols: []string{}}
+    bySymbol := make(map[string][]StockData)
+    for _, sd := range ticks {
+        bySymbol[sd.Symbol] = append(bySymbol[sd.Symbol], sd)
+    }
+    for sym, recs := range bySymbol {
+        res.Symbols = append(res.Symbols, sym)
+        if fp.delisting.Inactive(sym) {
+            res.Skipped += len(recs)
+            continue
+        }
+        sort.SliceStable(recs, func(i, j int) bool { return recs[i].Timestamp.Before(recs[j].Timestamp) })
+        existing, err := fp.tickRange(sym, recs[0].Timestamp, recs[len(recs)-1].Timestamp, 0)
+        if err != nil {
+            return res, err
+        }
+        seen := make(map[int64]bool, len(existing)+len(recs))
+        for _, sd := range existing {
+            seen[sd.Timestamp.UnixNano()] = true
+        }
+        var latest time.Time
+        if sd, ok := fp.dataStore.Latest(sym); ok {
+            latest = sd.Timestamp
+        }
+        var change HistoryChange
+        for _, sd := range recs {
+            ts := sd.Timestamp.UnixNano()
+            if seen[ts] {
+                res.Duplicates++
+                continue
+            }
+            seen[ts] = true
+            fp.instruments.Observe(sd).Normalize(&sd)
+            switch {
+            case sd.Timestamp.After(latest):
+                fp.dataStore.Append(sym, sd)
+            case fp.store != nil:
+                if err := fp.store.SaveTick(sd); err != nil {
+                    return res, fmt.Errorf("storing tick for %s: %w", sym, err)
+                }
+            default:
+                res.Skipped++
+                continue
+            }
+            res.Imported++
+            if change.Ticks == 0 {
+                change.From = sd.Timestamp
+            }
+            change.To = sd.Timestamp
+            change.Ticks++
+        }
+        if change.Ticks > 0 {
+            fp.events.Publish(Event{Type: "history", Symbol: sym, Data: change})
+        }
+    }
+    sort.Strings(res.Symbols)
+    return res, nil
+}
+
+/*
+handleImport exposes POST /api/admin/import, which loads a CSV (Content-Type
+text/csv) or JSON history upload and answers with an ImportResult. A request
+with an Idempotency-Key already used for the same body is answered with the
+original result and an Idempotent-Replayed header; reusing a key for a
+different body is rejected with 422, and a key whose upload is still running
+with 409.
+*/
+func (fp *FinancialProcessor) handleImport(w http.ResponseWriter, r *http.Request) {
+    data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, fp.imports.maxBytes))
+    if err != nil {
+        status := http.StatusBadRequest
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            status = http.StatusRequestEntityTooLarge
+        }
+        http.Error(w, "reading body: "+err.Error(), status)
+        return
+    }
+    key := r.Header.Get("Idempotency-Key")
+    if key != "" {
+        body, err := fp.imports.claim(key, sha256.Sum256(data))
+        if err != nil {
+            status := http.StatusUnprocessableEntity
+            if err == errKeyInProgress {
+                status = http.StatusConflict
+            }
+            http.Error(w, err.Error(), status)
+            return
+        }
+        if body != nil {
+            w.Header().Set("Content-Type", "application/json")
+            w.Header().Set("Idempotent-Replayed", "true")
+            w.Write(body)
+            return
+        }
+    }
+    var body []byte
+    if key != "" {
+        defer func() { fp.imports.settle(key, body) }()
+    }
+
+    ticks, err := parseImport(r.Header.Get("Content-Type"), data)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    res, err := fp.importTicks(ticks)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    out, _ := json.Marshal(res)
+    body = append(out, '\n')
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(body)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+IndicatorCache keeps computed indicator series per symbol, history view,
+indicator and parameters, together with the ticks they were computed over.
+Each request compares the current history with those ticks and recomputes
+only from the first tick that was added, removed or changed, so new ticks
+cost one step each and a late or backfilled tick recomputes the window from
+its position onwards rather than the whole series. Values before that point
+are kept as computed, including those that depended on ticks which have
+since left the window. INDICATOR_CACHE_SIZE (default 256) bounds the number
+of cached series, dropping the least recently used; 0 disables the cache,
+as does enabled reporting false.
+*/
+type IndicatorCache struct {
+    enabled  func() bool
+    max      int
+    mu       sync.Mutex
+    entries  map[string]*indicatorEntry
+    hits     atomic.Int64
+    partial  atomic.Int64
+    full     atomic.Int64
+    computed atomic.Int64
+}
+
+/*
+indicatorEntry is one cached series set and the ticks it covers. mu is held
+while it is brought up to date.
+*/
+type indicatorEntry struct {
+    mu     sync.Mutex
+    used   time.Time
+    times  []time.Time
+    prices []float64
+    series map[string][]float64
+}
+
+/*
+NewIndicatorCacheFromEnv creates the cache, switched on and off by enabled.
+*/
+func NewIndicatorCacheFromEnv(enabled func() bool) *IndicatorCache {
+    return &IndicatorCache{
+        enabled: enabled,
+        max:     envInt("INDICATOR_CACHE_SIZE", 256),
+        entries: make(map[string]*indicatorEntry),
+    }
+}
+
+/*
+entry returns the entry for key, creating it and evicting the least recently
+used one when the cache is full.
+*/
+func (ic *IndicatorCache) entry(key string) *indicatorEntry {
+    ic.mu.Lock()
+    defer ic.mu.Unlock()
+    e, ok := ic.entries[key]
+    if !ok {
+        if len(ic.entries) >= ic.max {
+            var oldest string
+            for k, c := range ic.entries {
+                if oldest == "" || c.used.Before(ic.entries[oldest].used) {
+                    oldest = k
+                }
+            }
+            delete(ic.entries, oldest)
+        }
+        e = &indicatorEntry{}
+        ic.entries[key] = e
+    }
+    e.used = time.Now()
+    return e
+}
+
+/*
+Compute returns indicator over data like computeIndicator, reusing what was
+computed for the same symbol and view on an earlier call. view tells apart
+histories of one symbol that differ, such as raw ticks and ticks with
+aggregated bars. A nil cache computes from scratch.
+*/
+func (ic *IndicatorCache) Compute(symbol, view, indicator string, p IndicatorParams, data []StockData) (map[string][]float64, error) {
+    prices := closingPrices(data)
+    if ic == nil || ic.max <= 0 || !ic.enabled() {
+        return computeIndicator(indicator, prices, p)
+    }
+    if err := checkIndicator(indicator, p); err != nil {
+        return nil, err
+    }
+    key := fmt.Sprintf("%s/%s/%s/%d/%d/%d/%d/%g", symbol, view, indicator, p.Period, p.Fast, p.Slow, p.Signal, p.StdDev)
+    e := ic.entry(key)
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    offset, same := e.align(data, prices)
+    series := make(map[string][]float64)
+    for _, name := range indicatorSeries(indicator) {
+        s := nanSeries(len(data))
+        if offset >= 0 {
+            copy(s[:same], e.series[name][offset:offset+same])
+        }
+        series[name] = s
+    }
+    switch {
+    case same == len(data):
+        ic.hits.Add(1)
+    case same > 0:
+        ic.partial.Add(1)
+    default:
+        ic.full.Add(1)
+    }
+    ic.computed.Add(int64(len(data) - same))
+    extendIndicator(indicator, prices, p, series, same)
+
+    e.times = make([]time.Time, len(data))
+    for i, d := range data {
+        e.times[i] = d.Timestamp
+    }
+    e.prices, e.series = prices, series
+
+    out := make(map[string][]float64)
+    for name, s := range series {
+        if !strings.HasPrefix(name, "_") {
+            out[name] = append([]float64(nil), s...)
+        }
+    }
+    return out, nil
+}
+
+/*
+align locates data in the cached ticks. offset is the cached index of
+data's first tick, or -1 when it is not cached, and same the number of
+leading ticks of data that match the cache in timestamp and price.
+*/
+func (e *indicatorEntry) align(data []StockData, prices []float64) (offset, same int) {
+    if len(data) == 0 || len(e.times) == 0 {
+        return -1, 0
+    }
+    first := data[0].Timestamp
+    offset = sort.Search(len(e.times), func(i int) bool { return !e.times[i].Before(first) })
+    if offset == len(e.times) || !e.times[offset].Equal(first) {
+        return -1, 0
+    }
+    for same < len(data) && offset+same < len(e.times) &&
+        e.times[offset+same].Equal(data[same].Timestamp) && samePrice(e.prices[offset+same], prices[same]) {
+        same++
+    }
+    return offset, same
+}
+
+/*
+samePrice compares prices, treating NaN as equal to itself.
+*/
+func samePrice(a, b float64) bool {
+    return a == b || math.IsNaN(a) && math.IsNaN(b)
+}
+
+/*
+Purge drops every cached series.
+*/
+func (ic *IndicatorCache) Purge() {
+    ic.mu.Lock()
+    defer ic.mu.Unlock()
+    ic.entries = make(map[string]*indicatorEntry)
+}
+
+/*
+writeMetrics appends indicator cache counters in Prometheus text format.
+*/
+func (ic *IndicatorCache) writeMetrics(sb *strings.Builder) {
+    sb.WriteString("# HELP indicator_cache_requests_total Indicator computations by how much of the cached series was reused.\n")
+    sb.WriteString("# TYPE indicator_cache_requests_total counter\n")
+    fmt.Fprintf(sb, "indicator_cache_requests_total{result=\"hit\"} %d\n", ic.hits.Load())
+    fmt.Fprintf(sb, "indicator_cache_requests_total{result=\"partial\"} %d\n", ic.partial.Load())
+    fmt.Fprintf(sb, "indicator_cache_requests_total{result=\"full\"} %d\n", ic.full.Load())
+    sb.WriteString("# HELP indicator_cache_recomputed_points_total Ticks whose indicator values were computed rather than reused.\n")
+    sb.WriteString("# TYPE indicator_cache_recomputed_points_total counter\n")
+    fmt.Fprintf(sb, "indicator_cache_recomputed_points_total %d\n", ic.computed.Load())
+}
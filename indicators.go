@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+IndicatorParams configures an indicator. Period applies to SMA, EMA, RSI and
+Bollinger Bands; Fast, Slow and Signal to MACD; StdDev is the band width of
+Bollinger Bands in standard deviations.
+*/
+type IndicatorParams struct {
+    Period int     `json:"period,omitempty"`
+    Fast   int     `json:"fast,omitempty"`
+    Slow   int     `json:"slow,omitempty"`
+    Signal int     `json:"signal,omitempty"`
+    StdDev float64 `json:"stddev,omitempty"`
+}
+
+/*
+defaultIndicatorParams returns the customary settings for indicator.
+*/
+func defaultIndicatorParams(indicator string) IndicatorParams {
+    switch indicator {
+    case "sma", "ema":
+        return IndicatorParams{Period: 20}
+    case "rsi":
+        return IndicatorParams{Period: 14}
+    case "macd":
+        return IndicatorParams{Fast: 12, Slow: 26, Signal: 9}
+    case "bollinger":
+        return IndicatorParams{Period: 20, StdDev: 2}
+    }
+    return IndicatorParams{}
+}
+
+/*
+IndicatorPoint holds an indicator's values at one tick. Values missing from
+the map are not defined yet at that point, e.g. before Period ticks.
+*/
+type IndicatorPoint struct {
+    Timestamp time.Time          `json:"timestamp"`
+    Values    map[string]float64 `json:"values"`
+}
+
+/*
+checkIndicator reports whether indicator is known and p suits it.
+*/
+func checkIndicator(indicator string, p IndicatorParams) error {
+    switch indicator {
+    case "sma", "ema", "rsi", "bollinger":
+        if p.Period < 1 {
+            return fmt.Errorf("period must be positive")
+        }
+    case "macd":
+        if p.Fast < 1 || p.Slow <= p.Fast || p.Signal < 1 {
+            return fmt.Errorf("macd needs 0 < fast < slow and a positive signal period")
+        }
+    default:
+        return fmt.Errorf("unknown indicator %q (sma, ema, rsi, macd or bollinger)", indicator)
+    }
+    return nil
+}
+
+/*
+indicatorSeries names the series extendIndicator maintains for indicator:
+its outputs, followed by any intermediate series, prefixed with an
+underscore, that later values are derived from.
+*/
+func indicatorSeries(indicator string) []string {
+    switch indicator {
+    case "macd":
+        return []string{"macd", "macd_signal", "macd_histogram", "_macd_fast", "_macd_slow"}
+    case "bollinger":
+        return []string{"bollinger_middle", "bollinger_upper", "bollinger_lower"}
+    }
+    return []string{indicator}
+}
+
+/*
+computeIndicator evaluates indicator over prices, returning one series per
+output aligned with prices and holding NaN where it is not defined. sma, ema
+and rsi have a single output of the same name; macd has macd, macd_signal and
+macd_histogram; bollinger has bollinger_middle, bollinger_upper and
+bollinger_lower.
+*/
+func computeIndicator(indicator string, prices []float64, p IndicatorParams) (map[string][]float64, error) {
+    if err := checkIndicator(indicator, p); err != nil {
+        return nil, err
+    }
+    series := make(map[string][]float64)
+    for _, key := range indicatorSeries(indicator) {
+        series[key] = nanSeries(len(prices))
+    }
+    extendIndicator(indicator, prices, p, series, 0)
+    for key := range series {
+        if strings.HasPrefix(key, "_") {
+            delete(series, key)
+        }
+    }
+    return series, nil
+}
+
+/*
+extendIndicator fills series, which holds every series named by
+indicatorSeries aligned with prices, from index from onwards, taking the
+values before from as already computed over the same prices. Computing from
+0 evaluates the whole history; the indicator cache uses a later start to
+recompute only what a change in history affects.
+*/
+func extendIndicator(indicator string, prices []float64, p IndicatorParams, series map[string][]float64, from int) {
+    switch indicator {
+    case "sma":
+        extendSMA(prices, series["sma"], p.Period, from)
+    case "ema":
+        extendEMA(prices, series["ema"], p.Period, from)
+    case "rsi":
+        out := series["rsi"]
+        for i := from; i < len(prices); i++ {
+            out[i] = math.NaN()
+            if v, ok := rsi(prices[:i+1], p.Period); ok {
+                out[i] = v
+            }
+        }
+    case "macd":
+        fast, slow := series["_macd_fast"], series["_macd_slow"]
+        line, signal, hist := series["macd"], series["macd_signal"], series["macd_histogram"]
+        extendEMA(prices, fast, p.Fast, from)
+        extendEMA(prices, slow, p.Slow, from)
+        for i := from; i < len(prices); i++ {
+            line[i] = fast[i] - slow[i]
+        }
+        extendEMA(line, signal, p.Signal, from)
+        for i := from; i < len(prices); i++ {
+            hist[i] = line[i] - signal[i]
+        }
+    case "bollinger":
+        middle, upper, lower := series["bollinger_middle"], series["bollinger_upper"], series["bollinger_lower"]
+        extendSMA(prices, middle, p.Period, from)
+        for i := from; i < len(prices); i++ {
+            upper[i], lower[i] = math.NaN(), math.NaN()
+            if i < p.Period-1 {
+                continue
+            }
+            var sq float64
+            for _, v := range prices[i-p.Period+1 : i+1] {
+                sq += (v - middle[i]) * (v - middle[i])
+            }
+            sd := math.Sqrt(sq / float64(p.Period))
+            upper[i], lower[i] = middle[i]+p.StdDev*sd, middle[i]-p.StdDev*sd
+        }
+    }
+}
+
+/*
+nanSeries returns n NaNs.
+*/
+func nanSeries(n int) []float64 {
+    out := make([]float64, n)
+    for i := range out {
+        out[i] = math.NaN()
+    }
+    return out
+}
+
+/*
+extendSMA fills out from index from onwards with the simple moving average
+over period values.
+*/
+func extendSMA(values, out []float64, period, from int) {
+    lo := from - period + 1
+    if lo < 0 {
+        lo = 0
+    }
+    var sum float64
+    for _, v := range values[lo:from] {
+        sum += v
+    }
+    for i := from; i < len(values); i++ {
+        sum += values[i]
+        if i-lo >= period {
+            sum -= values[lo]
+            lo++
+        }
+        out[i] = math.NaN()
+        if i >= period-1 {
+            out[i] = sum / float64(period)
+        }
+    }
+}
+
+/*
+extendEMA fills out from index from onwards with the exponential moving
+average with smoothing 2/(period+1), seeded with the simple average of the
+first period defined values. Leading NaNs in values are skipped. Once the
+average is seeded each value follows from the one before, so only a start
+before the seed recomputes the series from the beginning.
+*/
+func extendEMA(values, out []float64, period, from int) {
+    k := 2 / float64(period+1)
+    if from == 0 || math.IsNaN(out[from-1]) {
+        for i := range out {
+            out[i] = math.NaN()
+        }
+        start := 0
+        for start < len(values) && math.IsNaN(values[start]) {
+            start++
+        }
+        if len(values)-start < period {
+            return
+        }
+        var sum float64
+        for _, v := range values[start : start+period] {
+            sum += v
+        }
+        from = start + period
+        out[from-1] = sum / float64(period)
+    }
+    prev := out[from-1]
+    for i := from; i < len(values); i++ {
+        prev = values[i]*k + prev*(1-k)
+        out[i] = prev
+    }
+}
+
+/*
+rsi computes the relative strength index over the last period price changes
+of prices, using simple averages of gains and losses.
+*/
+func rsi(prices []float64, period int) (float64, bool) {
+    if period <= 0 || len(prices) <= period {
+        return 0, false
+    }
+    prices = prices[len(prices)-period-1:]
+    var gain, loss float64
+    for i := 1; i < len(prices); i++ {
+        if d := prices[i] - prices[i-1]; d > 0 {
+            gain += d
+        } else {
+            loss -= d
+        }
+    }
+    if loss == 0 {
+        return 100, true
+    }
+    rs := gain / loss
+    return 100 - 100/(1+rs), true
+}
+
+/*
+closingPrices returns the price of every tick in data.
+*/
+func closingPrices(data []StockData) []float64 {
+    out := make([]float64, len(data))
+    for i, d := range data {
+        out[i] = d.Price
+    }
+    return out
+}
+
+/*
+loadMLIndicators reads ML_INDICATORS, a comma-separated list of indicators
+(with default parameters) to include in every payload sent to the ML service.
+*/
+func loadMLIndicators() []string {
+    var out []string
+    for _, name := range strings.Split(os.Getenv("ML_INDICATORS"), ",") {
+        if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+            continue
+        }
+        if err := checkIndicator(name, defaultIndicatorParams(name)); err != nil {
+            log.Fatalf("ML_INDICATORS: %v", err)
+        }
+        out = append(out, name)
+    }
+    return out
+}
+
+/*
+indicatorPayload computes the given indicators over symbol's ticks data for
+the ML payload, through ic under view when ic is not nil: one series per
+output, aligned with data, with null where undefined.
+*/
+func indicatorPayload(ic *IndicatorCache, symbol, view string, data []StockData, indicators []string) map[string][]*float64 {
+    out := make(map[string][]*float64)
+    for _, name := range indicators {
+        series, err := ic.Compute(symbol, view, name, defaultIndicatorParams(name), data)
+        if err != nil {
+            continue
+        }
+        for key, values := range series {
+            col := make([]*float64, len(values))
+            for i, v := range values {
+                if !math.IsNaN(v) {
+                    v := v
+                    col[i] = &v
+                }
+            }
+            out[key] = col
+        }
+    }
+    return out
+}
+
+/*
+handleIndicators exposes GET /api/indicators/{symbol}?indicator=rsi&period=14,
+evaluating an indicator over the symbol's stored history through the
+indicator cache. MACD takes fast, slow and signal instead of period, and
+Bollinger Bands take stddev as well.
+*/
+func (fp *FinancialProcessor) handleIndicators(w http.ResponseWriter, r *http.Request) {
+    if fp.shed.Sheds(shedIndicators) {
+        w.Header().Set("Retry-After", "60")
+        http.Error(w, "indicators are unavailable while shedding load", http.StatusServiceUnavailable)
+        return
+    }
+    sym := mux.Vars(r)["symbol"]
+    qs := r.URL.Query()
+    name := strings.ToLower(qs.Get("indicator"))
+    params := defaultIndicatorParams(name)
+    for key, dst := range map[string]*int{"period": &params.Period, "fast": &params.Fast, "slow": &params.Slow, "signal": &params.Signal} {
+        if v := qs.Get(key); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil {
+                http.Error(w, "invalid "+key, http.StatusBadRequest)
+                return
+            }
+            *dst = n
+        }
+    }
+    if v := qs.Get("stddev"); v != "" {
+        f, err := strconv.ParseFloat(v, 64)
+        if err != nil || f <= 0 {
+            http.Error(w, "invalid stddev", http.StatusBadRequest)
+            return
+        }
+        params.StdDev = f
+    }
+
+    data := fp.dataStore.Window(sym, 0)
+    series, err := fp.indCache.Compute(sym, "window", name, params, data)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if len(data) == 0 {
+        http.Error(w, "no data", http.StatusNotFound)
+        return
+    }
+    points := make([]IndicatorPoint, 0, len(data))
+    for i, d := range data {
+        values := make(map[string]float64)
+        for key, s := range series {
+            if !math.IsNaN(s[i]) {
+                values[key] = s[i]
+            }
+        }
+        if len(values) > 0 {
+            points = append(points, IndicatorPoint{Timestamp: d.Timestamp, Values: values})
+        }
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "symbol":    sym,
+        "indicator": name,
+        "params":    params,
+        "points":    points,
+    })
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+instanceLockKey is the PostgreSQL advisory lock key held by a running instance.
+*/
+const instanceLockKey int64 = 0x66696e666f7265 // "finfore"
+
+/*
+errLockHeld is returned when another instance already holds the lock.
+*/
+var errLockHeld = errors.New("lock held by another instance")
+
+/*
+InstanceLock guards shared storage against two instances collecting into it
+at once. It holds either an flock on a file or a PostgreSQL advisory lock on
+a dedicated connection, for the lifetime of the process.
+*/
+type InstanceLock struct {
+    desc string
+    file *os.File
+    db   *sql.DB
+    conn *sql.Conn
+}
+
+/*
+acquireInstanceLock locks INSTANCE_LOCK_FILE when set, otherwise the
+configured storage: an advisory lock for PostgreSQL or "<database>.lock" next
+to a SQLite file. It returns nil when there is nothing to protect, and for
+Redis, whose replicas elect a leader to collect instead.
+*/
+func acquireInstanceLock() (*InstanceLock, error) {
+    if path := os.Getenv("INSTANCE_LOCK_FILE"); path != "" {
+        return lockInstanceFile(path)
+    }
+    dsn := os.Getenv("STORAGE_DSN")
+    if dsn == "" {
+        return nil, nil
+    }
+    switch os.Getenv("STORAGE_DRIVER") {
+    case "postgres":
+        return lockInstancePostgres()
+    case "redis":
+        return nil, nil
+    }
+    path := strings.TrimPrefix(dsn, "file:")
+    if i := strings.IndexByte(path, '?'); i >= 0 {
+        path = path[:i]
+    }
+    if path == "" || path == ":memory:" {
+        return nil, nil
+    }
+    return lockInstanceFile(path + ".lock")
+}
+
+/*
+lockInstanceFile takes an exclusive, non-blocking flock on path and records
+this process's PID and start time in it for diagnostics.
+*/
+func lockInstanceFile(path string) (*InstanceLock, error) {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+    if err != nil {
+        return nil, fmt.Errorf("instance lock %s: %w", path, err)
+    }
+    if err := flockExclusive(f); err != nil {
+        holder, _ := os.ReadFile(path)
+        f.Close()
+        if errors.Is(err, errLockHeld) {
+            return nil, fmt.Errorf("another instance is already running against this storage (lock %s held by %s)",
+                path, strings.TrimSpace(string(holder)))
+        }
+        return nil, fmt.Errorf("instance lock %s: %w", path, err)
+    }
+    f.Truncate(0)
+    f.WriteAt([]byte("pid "+strconv.Itoa(os.Getpid())+" since "+time.Now().Format(time.RFC3339)+"\n"), 0)
+    return &InstanceLock{desc: path, file: f}, nil
+}
+
+/*
+lockInstancePostgres takes a session-level advisory lock on its own connection,
+so the lock is released automatically if the process dies. Deployments with
+different NAMESPACE values lock different keys.
+*/
+func lockInstancePostgres() (*InstanceLock, error) {
+    db, _, err := openStorageDB()
+    if err != nil {
+        return nil, fmt.Errorf("instance lock: %w", err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("instance lock: %w", err)
+    }
+    key := namespacedLockKey(instanceLockKey)
+    var ok bool
+    if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+        conn.Close()
+        db.Close()
+        return nil, fmt.Errorf("instance lock: %w", err)
+    }
+    if !ok {
+        conn.Close()
+        db.Close()
+        return nil, fmt.Errorf("another instance is already running against this storage (PostgreSQL advisory lock %d is held)", key)
+    }
+    return &InstanceLock{desc: fmt.Sprintf("postgres advisory lock %d", key), db: db, conn: conn}, nil
+}
+
+/*
+Release drops the lock.
+*/
+func (il *InstanceLock) Release() {
+    if il.file != nil {
+        il.file.Close()
+    }
+    if il.conn != nil {
+        il.conn.Close()
+        il.db.Close()
+    }
+}
+
+/*
+allowMultipleInstances reports whether the lock was overridden with
+ALLOW_MULTIPLE_INSTANCES=true or the --allow-multiple-instances flag.
+*/
+func allowMultipleInstances(args []string) bool {
+    for _, a := range args {
+        if a == "--allow-multiple-instances" || a == "-allow-multiple-instances" {
+            return true
+        }
+    }
+    return os.Getenv("ALLOW_MULTIPLE_INSTANCES") == "true"
+}
+
+/*
+mustLockInstance acquires the instance lock or exits with an explanation,
+unless multiple instances were explicitly allowed or collectors are
+sharded.
+*/
+func mustLockInstance(args []string) *InstanceLock {
+    if allowMultipleInstances(args) {
+        slog.Warn("instance lock disabled: multiple instances allowed")
+        return nil
+    }
+    if os.Getenv("SHARD_MODE") != "" {
+        slog.Info("instance lock skipped: sharded collectors share storage")
+        return nil
+    }
+    il, err := acquireInstanceLock()
+    if err != nil {
+        log.Fatalf("%v; stop the other instance, or pass --allow-multiple-instances (ALLOW_MULTIPLE_INSTANCES=true) if this is intentional", err)
+    }
+    if il != nil {
+        slog.Info("holding instance lock", "lock", il.desc)
+    }
+    return il
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Instrument is what a tracked symbol stands for: its asset class, the base
+and quote of a crypto or FX pair, the currency it is priced in and the
+number of decimals its prices carry. Collected ticks are normalized to it,
+calendars are chosen by it and the ML service receives it with every
+payload, so a universe mixing equities, ETFs, crypto, FX and indexes is
+handled per class rather than as if every symbol were a US stock.
+*/
+type Instrument struct {
+    Symbol    string `json:"symbol"`
+    Class     string `json:"class"`
+    Base      string `json:"base,omitempty"`
+    Quote     string `json:"quote,omitempty"`
+    Currency  string `json:"currency"`
+    Precision int    `json:"precision"`
+}
+
+/*
+ParseInstrument derives symbol's instrument from Yahoo's notation: ^GSPC is
+an index, BTC-USD a crypto pair, EURUSD=X an FX pair (and JPY=X the dollar
+against the yen), and anything else an equity until its source says it is
+an ETF.
+*/
+func ParseInstrument(symbol string) Instrument {
+    in := Instrument{Symbol: symbol, Class: assetEquity, Currency: quoteCurrency(symbol)}
+    switch {
+    case strings.HasPrefix(symbol, "^"):
+        in.Class = assetIndex
+    case isCryptoSymbol(symbol):
+        in.Class = assetCrypto
+        in.Base, in.Quote, _ = strings.Cut(symbol, "-")
+    case strings.HasSuffix(symbol, "=X") && (len(symbol) == 5 || len(symbol) == 8):
+        in.Class = assetFX
+        pair := strings.TrimSuffix(symbol, "=X")
+        in.Base, in.Quote = "USD", pair
+        if len(pair) == 6 {
+            in.Base, in.Quote = pair[:3], pair[3:]
+        }
+    }
+    in.Precision = classPrecision(in.Class, in.Quote)
+    return in
+}
+
+/*
+classPrecision is the number of decimals prices of class carry: 8 for
+crypto, 5 for FX (3 for yen quotes), 2 for indexes and 4 for equities and
+ETFs, enough for sub-dollar quotes.
+*/
+func classPrecision(class, quote string) int {
+    switch class {
+    case assetCrypto:
+        return 8
+    case assetFX:
+        if quote == "JPY" {
+            return 3
+        }
+        return 5
+    case assetIndex:
+        return 2
+    }
+    return 4
+}
+
+/*
+Round rounds v to the instrument's precision.
+*/
+func (in Instrument) Round(v float64) float64 {
+    scale := math.Pow10(in.Precision)
+    return math.Round(v*scale) / scale
+}
+
+/*
+Normalize rounds sd's prices to the instrument's precision, shedding the
+binary noise some sources add, and records its asset class.
+*/
+func (in Instrument) Normalize(sd *StockData) {
+    for _, p := range []*float64{&sd.Price, &sd.Open, &sd.High, &sd.Low, &sd.PreviousClose} {
+        *p = in.Round(*p)
+    }
+    sd.AssetClass = in.Class
+}
+
+/*
+InstrumentBook keeps the instrument of every symbol seen. A symbol starts
+from its notation; a class reported by its source, such as Yahoo's ETF
+quote type, refines an equity, while pairs and indexes keep the class their
+notation implies.
+*/
+type InstrumentBook struct {
+    mu    sync.RWMutex
+    known map[string]Instrument
+}
+
+/*
+NewInstrumentBook creates an empty book.
+*/
+func NewInstrumentBook() *InstrumentBook {
+    return &InstrumentBook{known: make(map[string]Instrument)}
+}
+
+/*
+Get returns symbol's instrument.
+*/
+func (ib *InstrumentBook) Get(symbol string) Instrument {
+    ib.mu.RLock()
+    in, ok := ib.known[symbol]
+    ib.mu.RUnlock()
+    if !ok {
+        in = ParseInstrument(symbol)
+    }
+    return in
+}
+
+/*
+Observe learns from the class sd's source reported and returns sd's
+instrument.
+*/
+func (ib *InstrumentBook) Observe(sd StockData) Instrument {
+    in := ib.Get(sd.Symbol)
+    if in.Class == assetEquity && assetClasses[sd.AssetClass] {
+        in.Class = sd.AssetClass
+        in.Precision = classPrecision(in.Class, in.Quote)
+    }
+    ib.mu.Lock()
+    ib.known[sd.Symbol] = in
+    ib.mu.Unlock()
+    return in
+}
+
+/*
+handleInstruments exposes GET /api/instruments, the instrument of every
+tracked symbol.
+*/
+func (fp *FinancialProcessor) handleInstruments(w http.ResponseWriter, r *http.Request) {
+    symbols := fp.trackedSymbols()
+    out := make([]Instrument, len(symbols))
+    for i, sym := range symbols {
+        out[i] = fp.instruments.Get(sym)
+    }
+    json.NewEncoder(w).Encode(out)
+}
+
+/*
+handleInstrument exposes GET /api/instruments/{symbol}.
+*/
+func (fp *FinancialProcessor) handleInstrument(w http.ResponseWriter, r *http.Request) {
+    sym := strings.ToUpper(mux.Vars(r)["symbol"])
+    if !symbolPattern.MatchString(sym) {
+        http.Error(w, "invalid symbol", http.StatusBadRequest)
+        return
+    }
+    json.NewEncoder(w).Encode(fp.instruments.Get(sym))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Interpolation fills small gaps in the history sent to the ML service, so
+the model sees evenly spaced ticks where a fetch or two was missed.
+ML_INTERPOLATION picks how missing ticks are made up: none (the default)
+leaves the history as collected, linear draws price and volume on a straight
+line between the ticks either side of the gap, and previous repeats the tick
+before it. Only gaps of at most ML_INTERPOLATION_MAX_GAP missing ticks
+(default 3) are filled, judged against the median spacing of the history,
+so market closes and outages stay visible. Only the payload is affected;
+stored history, indicators served over HTTP and accuracy tracking all keep
+the real ticks.
+*/
+type Interpolation struct {
+    mode   string
+    maxGap int
+}
+
+/*
+NewInterpolationFromEnv reads ML_INTERPOLATION and ML_INTERPOLATION_MAX_GAP.
+*/
+func NewInterpolationFromEnv() *Interpolation {
+    in := &Interpolation{
+        mode:   strings.ToLower(strings.TrimSpace(os.Getenv("ML_INTERPOLATION"))),
+        maxGap: envInt("ML_INTERPOLATION_MAX_GAP", 3),
+    }
+    switch in.mode {
+    case "":
+        in.mode = "none"
+    case "none", "linear", "previous":
+    default:
+        log.Fatalf("ML_INTERPOLATION must be none, linear or previous, got %q", in.mode)
+    }
+    if in.maxGap < 1 {
+        log.Fatalf("ML_INTERPOLATION_MAX_GAP must be at least 1, got %d", in.maxGap)
+    }
+    return in
+}
+
+/*
+Enabled reports whether payloads are interpolated. A nil Interpolation is
+not.
+*/
+func (in *Interpolation) Enabled() bool {
+    return in != nil && in.mode != "none"
+}
+
+/*
+Fill returns data with its small gaps filled and a mask aligned with the
+result that is true for the made-up ticks. data itself is not modified.
+*/
+func (in *Interpolation) Fill(data []StockData) ([]StockData, []bool) {
+    if !in.Enabled() {
+        return data, make([]bool, len(data))
+    }
+    step := medianSpacing(data)
+    out := make([]StockData, 0, len(data))
+    mask := make([]bool, 0, len(data))
+    for i, d := range data {
+        if i > 0 {
+            prev := data[i-1]
+            gap := d.Timestamp.Sub(prev.Timestamp)
+            missing := 0
+            if step > 0 {
+                missing = int(math.Round(float64(gap)/float64(step))) - 1
+            }
+            if missing >= 1 && missing <= in.maxGap {
+                for j := 1; j <= missing; j++ {
+                    out = append(out, in.synthesize(prev, d, float64(j)/float64(missing+1)))
+                    mask = append(mask, true)
+                }
+            }
+        }
+        out = append(out, d)
+        mask = append(mask, false)
+    }
+    return out, mask
+}
+
+/*
+synthesize makes up the tick a fraction frac of the way from a to b.
+*/
+func (in *Interpolation) synthesize(a, b StockData, frac float64) StockData {
+    sd := a
+    sd.Annotations = nil
+    sd.Timestamp = a.Timestamp.Add(time.Duration(frac * float64(b.Timestamp.Sub(a.Timestamp))))
+    if in.mode == "linear" {
+        sd.Price = a.Price + frac*(b.Price-a.Price)
+        sd.Volume = a.Volume + int64(math.Round(frac*float64(b.Volume-a.Volume)))
+    }
+    return sd
+}
+
+/*
+medianSpacing returns the median time between consecutive ticks of data, or
+0 when there are fewer than two.
+*/
+func medianSpacing(data []StockData) time.Duration {
+    if len(data) < 2 {
+        return 0
+    }
+    gaps := make([]time.Duration, 0, len(data)-1)
+    for i := 1; i < len(data); i++ {
+        gaps = append(gaps, data[i].Timestamp.Sub(data[i-1].Timestamp))
+    }
+    sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+    return gaps[len(gaps)/2]
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+redisRenewLease extends the leader lease only while this instance still
+holds it. KEYS: the lease. ARGV: this instance's ID, the lease in ms.
+*/
+var redisRenewLease = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+/*
+LeaderElection decides which of the replicas sharing a Redis store
+collects. Each replica tries to take a lease key with SET NX for
+LEADER_LEASE (default 15s) and the one that gets it renews it every third
+of the lease; the others keep serving the API from the shared history and
+retry at the same pace, so one of them takes over within a lease of the
+leader dying. A leader that cannot renew before its lease runs out exits
+rather than risk collecting alongside its successor, and is expected to be
+restarted as a follower.
+*/
+type LeaderElection struct {
+    client *redis.Client
+    key    string
+    id     string
+    lease  time.Duration
+    leader atomic.Bool
+    since  atomic.Int64
+}
+
+/*
+NewLeaderElectionFromEnv creates the election over client. The instance ID
+is the host name and process ID.
+*/
+func NewLeaderElectionFromEnv(client *redis.Client) *LeaderElection {
+    host, _ := os.Hostname()
+    le := &LeaderElection{
+        client: client,
+        key:    namespacedKey("forecaster", ":") + ":leader",
+        id:     fmt.Sprintf("%s:%d", host, os.Getpid()),
+        lease:  envDuration("LEADER_LEASE", 15*time.Second),
+    }
+    if le.lease < time.Second {
+        log.Fatalf("LEADER_LEASE must be at least 1s, got %s", le.lease)
+    }
+    return le
+}
+
+/*
+Campaign waits until this instance holds the lease, runs elected and then
+keeps renewing the lease for the life of the process.
+*/
+func (le *LeaderElection) Campaign(elected func()) {
+    every := le.lease / 3
+    for {
+        ok, err := le.client.SetNX(context.Background(), le.key, le.id, le.lease).Result()
+        if err != nil {
+            slog.Warn("leader election failed", "err", err)
+        }
+        if ok {
+            break
+        }
+        time.Sleep(every)
+    }
+    renewed := time.Now()
+    le.leader.Store(true)
+    le.since.Store(renewed.Unix())
+    slog.Info("elected leader; starting collection", "instance", le.id)
+    go elected()
+    for {
+        time.Sleep(every)
+        n, err := redisRenewLease.Run(context.Background(), le.client, []string{le.key}, le.id, le.lease.Milliseconds()).Int()
+        switch {
+        case err == nil && n == 1:
+            renewed = time.Now()
+        case err == nil:
+            log.Fatalf("lost the leader lease to %s; exiting so only one instance collects", le.holder())
+        case time.Since(renewed) >= le.lease:
+            log.Fatalf("could not renew the leader lease within %s (%v); exiting so only one instance collects", le.lease, err)
+        default:
+            slog.Warn("renewing the leader lease failed", "err", err)
+        }
+    }
+}
+
+/*
+holder returns the ID of the instance holding the lease, for logs.
+*/
+func (le *LeaderElection) holder() string {
+    id, err := le.client.Get(context.Background(), le.key).Result()
+    if err != nil {
+        return "another instance"
+    }
+    return id
+}
+
+/*
+writeMetrics appends whether this instance is the leader in Prometheus text
+format. A nil LeaderElection writes nothing.
+*/
+func (le *LeaderElection) writeMetrics(sb *strings.Builder) {
+    if le == nil {
+        return
+    }
+    leader := 0
+    if le.leader.Load() {
+        leader = 1
+    }
+    sb.WriteString("# HELP leader Whether this replica holds the Redis leader lease and collects.\n")
+    sb.WriteString("# TYPE leader gauge\n")
+    fmt.Fprintf(sb, "leader{instance=%q} %d\n", le.id, leader)
+    sb.WriteString("# HELP leader_since_seconds When this replica became the leader, as a Unix time.\n")
+    sb.WriteString("# TYPE leader_since_seconds gauge\n")
+    fmt.Fprintf(sb, "leader_since_seconds %d\n", le.since.Load())
+}
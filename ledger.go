@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+LedgerEntry is one line of a ledger day: a tick, prediction or trade signal
+as it was recorded.
+*/
+type LedgerEntry struct {
+    Kind string          `json:"kind"`
+    At   time.Time       `json:"at"`
+    Data json.RawMessage `json:"data"`
+}
+
+/*
+LedgerDay is the manifest sealing one day of the ledger. Digest is the hex
+SHA-256 of "<prev_digest>\n<date>\n<file_sha256>", so every day commits to
+all days before it; Signature, when a signing key is configured, is the
+base64 Ed25519 signature of Digest under PublicKey.
+*/
+type LedgerDay struct {
+    Date       string    `json:"date"`
+    Entries    int       `json:"entries"`
+    FileSHA256 string    `json:"file_sha256"`
+    PrevDigest string    `json:"prev_digest"`
+    Digest     string    `json:"digest"`
+    SealedAt   time.Time `json:"sealed_at"`
+    PublicKey  string    `json:"public_key,omitempty"`
+    Signature  string    `json:"signature,omitempty"`
+}
+
+/*
+Ledger is an append-only daily record of ticks, predictions and signals in
+LEDGER_DIR (under a NAMESPACE subdirectory when one is set). Each UTC day is
+written to <date>.jsonl and, once the day is over, sealed by a <date>.json
+manifest chaining its hash to the previous day's digest. Sealed files are
+made read-only. LEDGER_SIGNING_KEY, a 32-byte Ed25519 seed in base64 or hex,
+signs each digest.
+*/
+type Ledger struct {
+    mu      sync.Mutex
+    dir     string
+    key     ed25519.PrivateKey
+    clock   func() time.Time
+    day     string
+    file    *os.File
+    entries int
+    last    string
+}
+
+var ledgerDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+/*
+NewLedgerFromEnv opens the ledger, sealing any day left open by a previous
+run, or returns nil when LEDGER_DIR is unset.
+*/
+func NewLedgerFromEnv(clock func() time.Time) *Ledger {
+    dir := namespacedDir(os.Getenv("LEDGER_DIR"))
+    if dir == "" {
+        return nil
+    }
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        slog.Error("ledger disabled", "err", err)
+        return nil
+    }
+    lg := &Ledger{dir: dir, clock: clock}
+    if v := strings.TrimSpace(os.Getenv("LEDGER_SIGNING_KEY")); v != "" {
+        seed, err := base64.StdEncoding.DecodeString(v)
+        if err != nil || len(seed) != ed25519.SeedSize {
+            seed, err = hex.DecodeString(v)
+        }
+        if err != nil || len(seed) != ed25519.SeedSize {
+            log.Fatal("LEDGER_SIGNING_KEY must be a 32-byte Ed25519 seed encoded as base64 or hex")
+        }
+        lg.key = ed25519.NewKeyFromSeed(seed)
+    }
+    days, err := lg.Days()
+    if err != nil {
+        log.Fatalf("reading ledger: %v", err)
+    }
+    if len(days) > 0 {
+        lg.last = days[len(days)-1].Digest
+    }
+    open, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+    sort.Strings(open)
+    today := lg.clock().UTC().Format("2006-01-02")
+    for _, path := range open {
+        date := strings.TrimSuffix(filepath.Base(path), ".jsonl")
+        if _, err := os.Stat(filepath.Join(dir, date+".json")); err == nil {
+            continue
+        }
+        lg.day = date
+        if date == today {
+            if err := lg.reopen(); err != nil {
+                log.Fatalf("reopening ledger day %s: %v", date, err)
+            }
+            continue
+        }
+        if err := lg.seal(); err != nil {
+            log.Fatalf("sealing ledger day %s: %v", date, err)
+        }
+    }
+    return lg
+}
+
+/*
+reopen continues appending to the current day's file. Callers must hold lg.mu
+or own lg exclusively.
+*/
+func (lg *Ledger) reopen() error {
+    raw, err := os.ReadFile(filepath.Join(lg.dir, lg.day+".jsonl"))
+    if err != nil {
+        return err
+    }
+    lg.entries = bytes.Count(raw, []byte("\n"))
+    lg.file, err = os.OpenFile(filepath.Join(lg.dir, lg.day+".jsonl"), os.O_APPEND|os.O_WRONLY, 0o644)
+    return err
+}
+
+/*
+Record appends v to the current day's file under kind, sealing the previous
+day first when the date has changed.
+*/
+func (lg *Ledger) Record(kind string, v interface{}) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        slog.Error("ledger: encoding entry failed", "kind", kind, "err", err)
+        return
+    }
+    now := lg.clock().UTC()
+    line, _ := json.Marshal(LedgerEntry{Kind: kind, At: now, Data: data})
+    lg.mu.Lock()
+    defer lg.mu.Unlock()
+    if err := lg.rollTo(now.Format("2006-01-02")); err != nil {
+        slog.Error("ledger error", "err", err)
+        return
+    }
+    if _, err := lg.file.Write(append(line, '\n')); err != nil {
+        slog.Error("ledger: writing entry failed", "kind", kind, "err", err)
+        return
+    }
+    lg.entries++
+}
+
+/*
+rollTo makes date the open day, sealing the day before it. Callers must hold lg.mu.
+*/
+func (lg *Ledger) rollTo(date string) error {
+    if lg.file != nil && lg.day == date {
+        return nil
+    }
+    if lg.file != nil {
+        if date < lg.day {
+            return fmt.Errorf("clock moved back from %s to %s; not recording", lg.day, date)
+        }
+        if err := lg.seal(); err != nil {
+            return fmt.Errorf("sealing %s: %w", lg.day, err)
+        }
+    }
+    f, err := os.OpenFile(filepath.Join(lg.dir, date+".jsonl"), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return fmt.Errorf("opening %s: %w", date, err)
+    }
+    lg.day, lg.file, lg.entries = date, f, 0
+    return nil
+}
+
+/*
+seal closes the open day and writes its manifest. Callers must hold lg.mu.
+*/
+func (lg *Ledger) seal() error {
+    if lg.file != nil {
+        lg.file.Close()
+        lg.file = nil
+    }
+    path := filepath.Join(lg.dir, lg.day+".jsonl")
+    sum, entries, err := hashLedgerFile(path)
+    if err != nil {
+        return err
+    }
+    day := LedgerDay{
+        Date:       lg.day,
+        Entries:    entries,
+        FileSHA256: sum,
+        PrevDigest: lg.last,
+        Digest:     ledgerDigest(lg.last, lg.day, sum),
+        SealedAt:   time.Now().UTC(),
+    }
+    if lg.key != nil {
+        day.PublicKey = base64.StdEncoding.EncodeToString(lg.key.Public().(ed25519.PublicKey))
+        day.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(lg.key, []byte(day.Digest)))
+    }
+    raw, err := json.MarshalIndent(day, "", "  ")
+    if err != nil {
+        return err
+    }
+    manifest := filepath.Join(lg.dir, lg.day+".json")
+    if err := writeFileAtomic(manifest, raw); err != nil {
+        return err
+    }
+    os.Chmod(path, 0o444)
+    os.Chmod(manifest, 0o444)
+    lg.last = day.Digest
+    slog.Info("ledger: day sealed", "date", day.Date, "entries", day.Entries, "digest", day.Digest)
+    return nil
+}
+
+/*
+Run seals the open day shortly after midnight UTC even when nothing new is recorded.
+*/
+func (lg *Ledger) Run() {
+    for range time.Tick(time.Minute) {
+        lg.mu.Lock()
+        if lg.file != nil && lg.clock().UTC().Format("2006-01-02") > lg.day {
+            if err := lg.seal(); err != nil {
+                slog.Error("ledger: sealing day failed", "date", lg.day, "err", err)
+            }
+        }
+        lg.mu.Unlock()
+    }
+}
+
+/*
+Days returns the sealed day manifests in date order.
+*/
+func (lg *Ledger) Days() ([]LedgerDay, error) {
+    paths, err := filepath.Glob(filepath.Join(lg.dir, "*.json"))
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(paths)
+    days := make([]LedgerDay, 0, len(paths))
+    for _, path := range paths {
+        raw, err := os.ReadFile(path)
+        if err != nil {
+            return nil, err
+        }
+        var day LedgerDay
+        if err := json.Unmarshal(raw, &day); err != nil {
+            return nil, fmt.Errorf("%s: %w", path, err)
+        }
+        days = append(days, day)
+    }
+    return days, nil
+}
+
+/*
+LedgerVerification is the outcome of re-checking the hash chain.
+*/
+type LedgerVerification struct {
+    Valid   bool   `json:"valid"`
+    Days    int    `json:"days"`
+    Digest  string `json:"digest,omitempty"`
+    BadDate string `json:"bad_date,omitempty"`
+    Problem string `json:"problem,omitempty"`
+}
+
+/*
+Verify recomputes every sealed day's file hash, digest chain and signature.
+*/
+func (lg *Ledger) Verify() LedgerVerification {
+    days, err := lg.Days()
+    if err != nil {
+        return LedgerVerification{Problem: err.Error()}
+    }
+    prev := ""
+    for i, day := range days {
+        bad := func(problem string) LedgerVerification {
+            return LedgerVerification{Days: i, Digest: prev, BadDate: day.Date, Problem: problem}
+        }
+        sum, entries, err := hashLedgerFile(filepath.Join(lg.dir, day.Date+".jsonl"))
+        switch {
+        case err != nil:
+            return bad(err.Error())
+        case sum != day.FileSHA256 || entries != day.Entries:
+            return bad("day file does not match its manifest")
+        case day.PrevDigest != prev:
+            return bad("chain broken: prev_digest does not match the previous day")
+        case ledgerDigest(prev, day.Date, sum) != day.Digest:
+            return bad("digest does not match")
+        }
+        if day.Signature != "" {
+            pub, err1 := base64.StdEncoding.DecodeString(day.PublicKey)
+            sig, err2 := base64.StdEncoding.DecodeString(day.Signature)
+            if err1 != nil || err2 != nil || len(pub) != ed25519.PublicKeySize ||
+                !ed25519.Verify(ed25519.PublicKey(pub), []byte(day.Digest), sig) {
+                return bad("invalid signature")
+            }
+        }
+        prev = day.Digest
+    }
+    return LedgerVerification{Valid: true, Days: len(days), Digest: prev}
+}
+
+/*
+hashLedgerFile returns the hex SHA-256 of the file at path and its line count.
+*/
+func hashLedgerFile(path string) (string, int, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return "", 0, err
+    }
+    defer f.Close()
+    h := sha256.New()
+    lines := 0
+    r := bufio.NewReader(io.TeeReader(f, h))
+    for {
+        _, err := r.ReadBytes('\n')
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return "", 0, err
+        }
+        lines++
+    }
+    return hex.EncodeToString(h.Sum(nil)), lines, nil
+}
+
+/*
+ledgerDigest chains a day's file hash onto the previous digest.
+*/
+func ledgerDigest(prev, date, fileSum string) string {
+    sum := sha256.Sum256([]byte(prev + "\n" + date + "\n" + fileSum))
+    return hex.EncodeToString(sum[:])
+}
+
+/*
+handleLedgerDays exposes GET /api/ledger, the sealed day manifests in date order.
+*/
+func (lg *Ledger) handleLedgerDays(w http.ResponseWriter, r *http.Request) {
+    days, err := lg.Days()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    json.NewEncoder(w).Encode(days)
+}
+
+/*
+handleLedgerFile exposes GET /api/ledger/{date}, the JSON lines file for one day.
+*/
+func (lg *Ledger) handleLedgerFile(w http.ResponseWriter, r *http.Request) {
+    date := mux.Vars(r)["date"]
+    if !ledgerDatePattern.MatchString(date) {
+        http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+        return
+    }
+    path := filepath.Join(lg.dir, date+".jsonl")
+    if _, err := os.Stat(path); err != nil {
+        http.Error(w, "no ledger for "+date, http.StatusNotFound)
+        return
+    }
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    http.ServeFile(w, r, path)
+}
+
+/*
+handleLedgerVerify exposes GET /api/ledger/verify.
+*/
+func (lg *Ledger) handleLedgerVerify(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(lg.Verify())
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Load shedding levels, in the order they are entered. Each level keeps the
+measures of the levels before it.
+*/
+const (
+    shedNone = iota
+    shedIndicators
+    shedHistory
+    shedSymbols
+)
+
+/*
+shedLevelNames names each level's measure as reported in /api/status.
+*/
+var shedLevelNames = []string{"none", "indicators", "history", "low_priority_symbols"}
+
+/*
+DegradationState reports how much load is being shed and why.
+*/
+type DegradationState struct {
+    Level         int        `json:"level"`
+    Shedding      []string   `json:"shedding"`
+    MemoryBytes   uint64     `json:"memory_bytes"`
+    LimitBytes    uint64     `json:"limit_bytes"`
+    Since         *time.Time `json:"since,omitempty"`
+    HistoryLimit  int        `json:"history_limit,omitempty"`
+    PausedSymbols []string   `json:"paused_symbols,omitempty"`
+}
+
+/*
+LoadShedder samples the memory held by the runtime every LOAD_SHED_INTERVAL
+(default 10s) and, as it passes each fraction of the limit in
+LOAD_SHED_THRESHOLDS (default 0.7,0.8,0.9), sheds load in order: indicator
+computation stops, retained history shrinks to LOAD_SHED_HISTORY ticks per
+symbol (default half of MAX_HISTORY), and symbols marked low_priority in
+their settings stop being collected. A level is left once usage falls 5
+points below its threshold. The limit is MEMORY_LIMIT_MB, or GOMEMLIMIT when
+that is not set; without either, load is never shed.
+*/
+type LoadShedder struct {
+    limit      uint64
+    thresholds []float64
+    interval   time.Duration
+    history    int
+    mu         sync.RWMutex
+    level      int
+    memory     uint64
+    since      time.Time
+    onChange   func(level int)
+}
+
+/*
+NewLoadShedderFromEnv returns nil when no memory limit is known.
+*/
+func NewLoadShedderFromEnv(maxHistory int) *LoadShedder {
+    limit := uint64(envInt("MEMORY_LIMIT_MB", 0)) << 20
+    if limit == 0 {
+        if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+            limit = uint64(l)
+        }
+    }
+    if limit == 0 {
+        return nil
+    }
+    ls := &LoadShedder{
+        limit:      limit,
+        thresholds: []float64{0.7, 0.8, 0.9},
+        interval:   envDuration("LOAD_SHED_INTERVAL", 10*time.Second),
+        history:    envInt("LOAD_SHED_HISTORY", maxHistory/2),
+    }
+    if v := os.Getenv("LOAD_SHED_THRESHOLDS"); v != "" {
+        parts := strings.Split(v, ",")
+        if len(parts) != len(ls.thresholds) {
+            log.Fatalf("LOAD_SHED_THRESHOLDS needs %d fractions", len(ls.thresholds))
+        }
+        prev := 0.0
+        for i, p := range parts {
+            f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+            if err != nil || f <= prev || f > 1 {
+                log.Fatalf("LOAD_SHED_THRESHOLDS must be increasing fractions up to 1, got %q", v)
+            }
+            ls.thresholds[i], prev = f, f
+        }
+    }
+    return ls
+}
+
+/*
+Level returns the current shedding level; a nil shedder never sheds.
+*/
+func (ls *LoadShedder) Level() int {
+    if ls == nil {
+        return shedNone
+    }
+    ls.mu.RLock()
+    defer ls.mu.RUnlock()
+    return ls.level
+}
+
+/*
+Sheds reports whether the measure of level is in effect.
+*/
+func (ls *LoadShedder) Sheds(level int) bool {
+    return ls.Level() >= level
+}
+
+/*
+Run samples memory until the process exits.
+*/
+func (ls *LoadShedder) Run() {
+    if ls == nil {
+        return
+    }
+    for {
+        ls.Check()
+        time.Sleep(ls.interval)
+    }
+}
+
+/*
+Check samples memory once and moves to the level it calls for, running the
+change callback when the level changes.
+*/
+func (ls *LoadShedder) Check() {
+    var ms runtime.MemStats
+    runtime.ReadMemStats(&ms)
+    used := ms.Sys - ms.HeapReleased
+    frac := float64(used) / float64(ls.limit)
+
+    ls.mu.Lock()
+    level := ls.level
+    for level < len(ls.thresholds) && frac >= ls.thresholds[level] {
+        level++
+    }
+    for level > shedNone && frac < ls.thresholds[level-1]-0.05 {
+        level--
+    }
+    changed := level != ls.level
+    if changed {
+        slog.Warn("load shedding level changed", "memory_percent", math.Round(frac*100),
+            "from", ls.level, "to", level, "measures", shedLevelNames[level])
+        ls.level, ls.since = level, time.Now()
+    }
+    ls.memory = used
+    onChange := ls.onChange
+    ls.mu.Unlock()
+    if changed && onChange != nil {
+        onChange(level)
+    }
+}
+
+/*
+State reports the current degradation, or nil for a nil shedder.
+*/
+func (ls *LoadShedder) State() *DegradationState {
+    if ls == nil {
+        return nil
+    }
+    ls.mu.RLock()
+    defer ls.mu.RUnlock()
+    st := &DegradationState{Level: ls.level, Shedding: []string{}, MemoryBytes: ls.memory, LimitBytes: ls.limit}
+    if ls.level > shedNone {
+        since := ls.since
+        st.Since = &since
+        st.Shedding = append(st.Shedding, shedLevelNames[1:ls.level+1]...)
+    }
+    if ls.level >= shedHistory {
+        st.HistoryLimit = ls.history
+    }
+    return st
+}
+
+/*
+capacitySetter is implemented by series whose retention can change at runtime.
+*/
+type capacitySetter interface {
+    SetCapacity(capacity int)
+}
+
+/*
+applyShedding resizes retained history for level, returning the freed memory
+to the operating system when history shrinks. The other measures are checked
+where they apply.
+*/
+func (fp *FinancialProcessor) applyShedding(level int) {
+    cs, ok := fp.dataStore.(capacitySetter)
+    if !ok {
+        return
+    }
+    if level >= shedHistory {
+        cs.SetCapacity(fp.shed.history)
+        debug.FreeOSMemory()
+    } else {
+        cs.SetCapacity(fp.config.MaxHistory)
+    }
+}
+
+/*
+pausedForLoad reports whether symbol's collection is paused because load is
+being shed.
+*/
+func (fp *FinancialProcessor) pausedForLoad(symbol string) bool {
+    return fp.shed.Sheds(shedSymbols) && fp.settings.Get(symbol).LowPriority
+}
+
+/*
+degradation reports the shedding state for /api/status, including which
+symbols are paused.
+*/
+func (fp *FinancialProcessor) degradation() *DegradationState {
+    st := fp.shed.State()
+    if st == nil || st.Level < shedSymbols {
+        return st
+    }
+    for _, sym := range fp.trackedSymbols() {
+        if fp.pausedForLoad(sym) {
+            st.PausedSymbols = append(st.PausedSymbols, sym)
+        }
+    }
+    return st
+}
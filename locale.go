@@ -0,0 +1,213 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+NumberFormat describes how a client locale renders numbers and the quote
+currency. It is metadata only; raw numeric fields are never altered.
+*/
+type NumberFormat struct {
+    Locale           string `json:"locale"`
+    Currency         string `json:"currency"`
+    CurrencySymbol   string `json:"currency_symbol"`
+    CurrencyPosition string `json:"currency_position"`
+    DecimalSeparator string `json:"decimal_separator"`
+    GroupSeparator   string `json:"group_separator"`
+}
+
+/*
+localeConventions maps supported language tags to their separators and the
+side of the amount the currency symbol is written on.
+*/
+var localeConventions = map[string]NumberFormat{
+    "en-US": {Locale: "en-US", DecimalSeparator: ".", GroupSeparator: ",", CurrencyPosition: "prefix"},
+    "en-GB": {Locale: "en-GB", DecimalSeparator: ".", GroupSeparator: ",", CurrencyPosition: "prefix"},
+    "de-DE": {Locale: "de-DE", DecimalSeparator: ",", GroupSeparator: ".", CurrencyPosition: "suffix"},
+    "es-ES": {Locale: "es-ES", DecimalSeparator: ",", GroupSeparator: ".", CurrencyPosition: "suffix"},
+    "fr-FR": {Locale: "fr-FR", DecimalSeparator: ",", GroupSeparator: " ", CurrencyPosition: "suffix"},
+    "it-IT": {Locale: "it-IT", DecimalSeparator: ",", GroupSeparator: ".", CurrencyPosition: "suffix"},
+    "ja-JP": {Locale: "ja-JP", DecimalSeparator: ".", GroupSeparator: ",", CurrencyPosition: "prefix"},
+    "de-CH": {Locale: "de-CH", DecimalSeparator: ".", GroupSeparator: "’", CurrencyPosition: "prefix"},
+}
+
+/*
+languageFallbacks resolves a bare language tag to its default region.
+*/
+var languageFallbacks = map[string]string{
+    "en": "en-US", "de": "de-DE", "es": "es-ES", "fr": "fr-FR", "it": "it-IT", "ja": "ja-JP",
+}
+
+/*
+currencySymbols maps ISO currency codes to display symbols.
+*/
+var currencySymbols = map[string]string{
+    "USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "INR": "₹", "CHF": "CHF", "CAD": "CA$", "HKD": "HK$",
+}
+
+/*
+quoteCurrency infers the currency a symbol is quoted in from Yahoo's exchange
+suffixes and crypto and FX pair notation, defaulting to USD.
+*/
+func quoteCurrency(symbol string) string {
+    if pair, ok := strings.CutSuffix(symbol, "=X"); ok && len(pair) >= 3 {
+        return pair[len(pair)-3:]
+    }
+    if i := strings.LastIndex(symbol, "-"); i > 0 {
+        if c := symbol[i+1:]; len(c) == 3 && currencySymbols[c] != "" {
+            return c
+        }
+    }
+    switch {
+    case strings.HasSuffix(symbol, ".L"):
+        return "GBP"
+    case strings.HasSuffix(symbol, ".DE"), strings.HasSuffix(symbol, ".PA"),
+        strings.HasSuffix(symbol, ".MI"), strings.HasSuffix(symbol, ".MC"), strings.HasSuffix(symbol, ".AS"):
+        return "EUR"
+    case strings.HasSuffix(symbol, ".T"):
+        return "JPY"
+    case strings.HasSuffix(symbol, ".NS"), strings.HasSuffix(symbol, ".BO"):
+        return "INR"
+    case strings.HasSuffix(symbol, ".SW"):
+        return "CHF"
+    case strings.HasSuffix(symbol, ".TO"):
+        return "CAD"
+    case strings.HasSuffix(symbol, ".HK"):
+        return "HKD"
+    }
+    return "USD"
+}
+
+/*
+negotiateNumberFormat picks the best supported locale from the request's
+Accept-Language header (honouring q-values) and combines it with the
+symbol's quote currency. It falls back to en-US.
+*/
+func negotiateNumberFormat(r *http.Request, symbol string) NumberFormat {
+    type candidate struct {
+        tag string
+        q   float64
+    }
+    var cands []candidate
+    for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+        part = strings.TrimSpace(part)
+        if part == "" {
+            continue
+        }
+        tag, params, _ := strings.Cut(part, ";")
+        q := 1.0
+        if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            if f, err := strconv.ParseFloat(v, 64); err == nil {
+                q = f
+            }
+        }
+        cands = append(cands, candidate{strings.TrimSpace(tag), q})
+    }
+    sort.SliceStable(cands, func(i, j int) bool { return cands[i].q > cands[j].q })
+
+    nf := localeConventions["en-US"]
+    for _, c := range cands {
+        lang, region, _ := strings.Cut(c.tag, "-")
+        key := strings.ToLower(lang)
+        if region != "" {
+            key += "-" + strings.ToUpper(region)
+        }
+        if f, ok := localeConventions[key]; ok {
+            nf = f
+            break
+        }
+        if fb, ok := languageFallbacks[strings.ToLower(lang)]; ok {
+            nf = localeConventions[fb]
+            break
+        }
+    }
+    nf.Currency = quoteCurrency(symbol)
+    nf.CurrencySymbol = currencySymbols[nf.Currency]
+    return nf
+}
+
+/*
+FormatNumber renders v with the given number of decimals using the locale's separators.
+*/
+func (nf NumberFormat) FormatNumber(v float64, decimals int) string {
+    neg := v < 0
+    s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+    intPart, frac, _ := strings.Cut(s, ".")
+
+    var sb strings.Builder
+    for i, d := range intPart {
+        if i > 0 && (len(intPart)-i)%3 == 0 {
+            sb.WriteString(nf.GroupSeparator)
+        }
+        sb.WriteRune(d)
+    }
+    out := sb.String()
+    if frac != "" {
+        out += nf.DecimalSeparator + frac
+    }
+    if neg {
+        out = "-" + out
+    }
+    return out
+}
+
+/*
+FormatMoney renders a price with the currency symbol on the locale's preferred side.
+*/
+func (nf NumberFormat) FormatMoney(v float64) string {
+    decimals := 2
+    if nf.Currency == "JPY" {
+        decimals = 0
+    } else if math.Abs(v) < 1 && v != 0 {
+        decimals = 6
+    }
+    amount := nf.FormatNumber(v, decimals)
+    if nf.CurrencyPosition == "suffix" {
+        return amount + " " + nf.CurrencySymbol
+    }
+    return nf.CurrencySymbol + amount
+}
+
+/*
+wantsLocalized reports whether the client asked for formatting metadata with ?localize=true.
+*/
+func wantsLocalized(r *http.Request) bool {
+    v := r.URL.Query().Get("localize")
+    return v == "1" || v == "true"
+}
+
+/*
+FormattedTick carries display strings for one StockData snapshot.
+*/
+type FormattedTick struct {
+    Price  string `json:"price"`
+    Volume string `json:"volume"`
+}
+
+/*
+LocalizedData wraps raw history with formatting metadata and per-point display strings.
+*/
+type LocalizedData struct {
+    Data       []StockData     `json:"data"`
+    Formatting NumberFormat    `json:"formatting"`
+    Formatted  []FormattedTick `json:"formatted"`
+}
+
+/*
+localizeTicks builds the localized response for data.
+*/
+func localizeTicks(data []StockData, nf NumberFormat) LocalizedData {
+    out := LocalizedData{Data: data, Formatting: nf, Formatted: make([]FormattedTick, len(data))}
+    for i, d := range data {
+        out.Formatted[i] = FormattedTick{
+            Price:  nf.FormatMoney(d.Price),
+            Volume: nf.FormatNumber(float64(d.Volume), 0),
+        }
+    }
+    return out
+}
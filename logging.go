@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+setupLogging installs the structured logger every component logs through.
+LOG_FORMAT selects text (the default) or json output, for ingestion by Loki
+or ELK, and LOG_LEVEL the minimum level: debug, info (the default), warn or
+error. Output of the standard log package, used for fatal startup errors,
+goes through the same logger at info level.
+*/
+func setupLogging() {
+    var level slog.Level
+    if v := os.Getenv("LOG_LEVEL"); v != "" {
+        if err := level.UnmarshalText([]byte(v)); err != nil {
+            log.Fatalf("LOG_LEVEL must be debug, info, warn or error, got %q", v)
+        }
+    }
+    opts := &slog.HandlerOptions{Level: level}
+    var h slog.Handler
+    switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+    case "", "text":
+        h = slog.NewTextHandler(os.Stderr, opts)
+    case "json":
+        h = slog.NewJSONHandler(os.Stderr, opts)
+    default:
+        log.Fatalf("LOG_FORMAT must be text or json, got %q", format)
+    }
+    slog.SetDefault(slog.New(h))
+}
+
+/*
+requestIDKey is the context key holding a request's ID.
+*/
+type requestIDKey struct{}
+
+/*
+requestLogger returns the logger for work done on behalf of r, which carries
+its request ID.
+*/
+func requestLogger(r *http.Request) *slog.Logger {
+    if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+        return slog.With("request_id", id)
+    }
+    return slog.Default()
+}
+
+/*
+statusRecorder captures the status code written through it. It passes
+flushing and hijacking through, for streamed responses and websockets.
+*/
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+    if sr.status == 0 {
+        sr.status = code
+    }
+    sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+    if sr.status == 0 {
+        sr.status = http.StatusOK
+    }
+    return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Flush() {
+    if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    h, ok := sr.ResponseWriter.(http.Hijacker)
+    if !ok {
+        return nil, nil, fmt.Errorf("response does not support hijacking")
+    }
+    sr.status = http.StatusSwitchingProtocols
+    return h.Hijack()
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+    return sr.ResponseWriter
+}
+
+/*
+logRequests gives every request an ID, taken from its X-Request-ID header
+or generated, echoes it in the response's X-Request-ID header and logs the
+request at debug level, or at warn level when it fails with a 5xx status.
+*/
+func logRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get("X-Request-ID")
+        if id == "" || len(id) > 128 {
+            b := make([]byte, 8)
+            rand.Read(b)
+            id = hex.EncodeToString(b)
+        }
+        w.Header().Set("X-Request-ID", id)
+        r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+        start := time.Now()
+        sr := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(sr, r)
+        if sr.status == 0 {
+            sr.status = http.StatusOK
+        }
+        level := slog.LevelDebug
+        if sr.status >= 500 {
+            level = slog.LevelWarn
+        }
+        slog.Log(r.Context(), level, "request", "request_id", id, "method", r.Method, "path", r.URL.Path,
+            "status", sr.status, "latency", time.Since(start))
+    })
+}
@@ -6,12 +6,14 @@ machine learning microservice.
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,10 +28,19 @@ StockData represents a single snapshot of a stock's market data,
 including the symbol, current price, volume, and timestamp.
 */
 type StockData struct {
-    Symbol    string    `json:"symbol"`
-    Price     float64   `json:"price"`
-    Volume    int64     `json:"volume"`
-    Timestamp time.Time `json:"timestamp"`
+    Symbol         string       `json:"symbol"`
+    Price          float64      `json:"price"`
+    Volume         int64        `json:"volume"`
+    Timestamp      time.Time    `json:"timestamp"`
+    AssetClass     string       `json:"asset_class,omitempty"`
+    Open           float64      `json:"open,omitempty"`
+    High           float64      `json:"high,omitempty"`
+    Low            float64      `json:"low,omitempty"`
+    PreviousClose  float64      `json:"previous_close,omitempty"`
+    EarningsDate   *time.Time   `json:"earnings_date,omitempty"`
+    ExDividendDate *time.Time   `json:"ex_dividend_date,omitempty"`
+    Resolution     string       `json:"resolution,omitempty"`
+    Annotations    []Annotation `json:"annotations,omitempty"`
 }
 
 /*
@@ -37,32 +48,50 @@ Prediction holds the output from the ML service, including the symbol,
 current and predicted prices, and the percentage change.
 */
 type Prediction struct {
-    Symbol              string    `json:"symbol"`
-    CurrentPrice        float64   `json:"current_price"`
-    PredictedPrice      float64   `json:"predicted_price"`
-    PredictedChange     float64   `json:"predicted_change"`
-    PredictedChangePerc float64   `json:"predicted_change_percent"`
-    Timestamp           time.Time `json:"timestamp"`
+    Symbol              string           `json:"symbol"`
+    CurrentPrice        float64          `json:"current_price"`
+    PredictedPrice      float64          `json:"predicted_price"`
+    PredictedChange     float64          `json:"predicted_change"`
+    PredictedChangePerc float64          `json:"predicted_change_percent"`
+    Timestamp           time.Time        `json:"timestamp"`
+    Frozen              string           `json:"frozen,omitempty"`
+    Blended             *BlendedForecast `json:"blended,omitempty"`
 }
 
 /*
-DataCollector encapsulates a Colly collector to fetch stock data from Yahoo Finance.
+DataCollector fetches stock data from Yahoo Finance through the chart API,
+falling back to scraping the quote page with a Colly collector, and keeps
+the symbol's upcoming earnings and ex-dividend dates.
 */
 type DataCollector struct {
     collector *colly.Collector
+    chart     bool
+    created   time.Time
+    eventsTTL time.Duration
+    eventsMu  sync.Mutex
+    events    *EventDates
 }
 
 /*
 NewDataCollector initializes a Colly collector with a random delay and proper headers
-to safely scrape Yahoo Finance data.
+to safely scrape Yahoo Finance data. YAHOO_CHART_API=off skips the chart API
+and always scrapes. Event dates are refetched every EVENT_CALENDAR_TTL
+(default 6h, 0 disables them).
 */
 func NewDataCollector() *DataCollector {
     c := colly.NewCollector(
         colly.UserAgent("Mozilla/5.0"),
         colly.AllowedDomains("finance.yahoo.com"),
+        colly.AllowURLRevisit(),
     )
     c.Limit(&colly.LimitRule{DomainGlob: "*", RandomDelay: 5 * time.Second})
-    return &DataCollector{collector: c}
+    c.WithTransport(egressTransport(egressYahoo))
+    return &DataCollector{
+        collector: c,
+        chart:     os.Getenv("YAHOO_CHART_API") != "off",
+        created:   time.Now(),
+        eventsTTL: envDuration("EVENT_CALENDAR_TTL", 6*time.Hour),
+    }
 }
 
 /*
@@ -75,18 +104,61 @@ func CleanNumberString(s string) string {
 }
 
 /*
-FetchStockData visits the Yahoo Finance quote page for the given symbol,
-extracts the regular market price and volume, and returns a StockData struct.
+FetchStockData returns the latest snapshot for symbol from the chart API,
+which also fills in the day's open, high and low and the previous close. If
+the chart API fails for any reason other than an unknown symbol, the quote
+page is scraped instead. Either way the snapshot carries the symbol's
+upcoming event dates.
 */
 func (dc *DataCollector) FetchStockData(symbol string) (*StockData, error) {
-    sd := &StockData{Symbol: symbol, Timestamp: time.Now()}
+    sd, err := dc.fetchQuote(symbol)
+    if err == nil {
+        dc.attachEventDates(sd)
+    }
+    return sd, err
+}
 
-    c := colly.NewCollector(
-        colly.UserAgent("Mozilla/5.0"),
-        colly.AllowedDomains("finance.yahoo.com"),
-    )
+/*
+fetchQuote returns the latest snapshot for symbol from the chart API or,
+failing that, the quote page.
+*/
+func (dc *DataCollector) fetchQuote(symbol string) (*StockData, error) {
+    if !dc.chart {
+        return dc.scrapeQuotePage(symbol)
+    }
+    sd, err := FetchChart(symbol)
+    if err == nil {
+        return sd, nil
+    }
+    if _, missing := err.(*QuoteMissingError); missing {
+        return nil, err
+    }
+    slog.Warn("chart API failed, scraping quote page", "symbol", symbol, "err", err)
+    return dc.scrapeQuotePage(symbol)
+}
+
+/*
+scrapeQuotePage visits the Yahoo Finance quote page for the given symbol,
+extracts the regular market price and volume, and returns a StockData struct.
+A 404 or a redirect away from the quote page yields a *QuoteMissingError.
+*/
+func (dc *DataCollector) scrapeQuotePage(symbol string) (*StockData, error) {
+    sd := &StockData{Symbol: symbol}
+
+    c := dc.collector.Clone()
 
     url := fmt.Sprintf("https://finance.yahoo.com/quote/%s", symbol)
+    var missing *QuoteMissingError
+    c.OnError(func(r *colly.Response, err error) {
+        if r.StatusCode == http.StatusNotFound {
+            missing = &QuoteMissingError{Symbol: symbol, Status: r.StatusCode}
+        }
+    })
+    c.OnResponse(func(r *colly.Response) {
+        if !strings.HasPrefix(r.Request.URL.Path, "/quote/") {
+            missing = &QuoteMissingError{Symbol: symbol, Status: r.StatusCode, Location: r.Request.URL.String()}
+        }
+    })
     c.OnHTML("fin-streamer[data-field='regularMarketPrice']", func(e *colly.HTMLElement) {
         txt := e.Text
         if txt == "" {
@@ -111,9 +183,15 @@ func (dc *DataCollector) FetchStockData(symbol string) (*StockData, error) {
     })
 
     if err := c.Visit(url); err != nil {
+        if missing != nil {
+            return nil, missing
+        }
         return nil, err
     }
     c.Wait()
+    if missing != nil {
+        return nil, missing
+    }
 
     // Fallback or further parsing omitted for brevity
     return sd, nil
@@ -124,143 +202,882 @@ FinancialProcessor manages concurrent data collection for multiple symbols
 and forwards batches to the ML microservice for prediction.
 */
 type FinancialProcessor struct {
-    collectors map[string]*DataCollector
-    dataStore  map[string][]StockData
-    symbols    []string
-    mutex      sync.RWMutex
-    wg         sync.WaitGroup
+    collectors  map[string]*DataCollector
+    dataStore   TimeSeriesStore[StockData]
+    predictions TimeSeriesStore[Prediction]
+    symbols     []string
+    symbolsFile string
+    mutex       sync.RWMutex
+    wg          sync.WaitGroup
+    pending     sync.WaitGroup
+    archive     *PayloadArchive
+    latency     *DependencyMetrics
+    startedAt   time.Time
+    boosts      map[string]time.Time
+    news        *NewsCollector
+    residuals   *ResidualTracker
+    positions   *PositionBook
+    summary     *QuoteSummaryFetcher
+    mlRoutes    *MLRouter
+    mlReady     *MLHandshake
+    alerts      *AlertDispatcher
+    ml          *MLClient
+    pipelines   map[string]*symbolPipeline
+    pool        *ScrapePool
+    recycler    *CollectorRecycler
+    idle        *IdleMonitor
+    sources     map[string]*SymbolSource
+    tradingView *TradingViewAdapter
+    ramp        *RampLimiter
+    sched       *Scheduler
+    orderBooks  *OrderBookCollector
+    auth        *KeyAuth
+    capacity    *CapacityBenchmark
+    annotations *AnnotationStore
+    watchdog    *Watchdog
+    delisting   *DelistingDetector
+    forecasts   *ForecastLadder
+    clock       Clock
+    store       Store
+    alertRules  *AlertRuleBook
+    collecting  bool
+    blender     *PredictionBlender
+    ledger      *Ledger
+    events      *EventBus
+    failures    fetchFailures
+    config      Config
+    cfgMu       sync.RWMutex
+    reloadMu    sync.Mutex
+    batchTimes  fetchTimes
+    cold        Store
+    indicators  []string
+    interp      *Interpolation
+    notifier    *PredictionNotifier
+    clusters    *ClusterBook
+    leader      *LeaderElection
+    shards      *ShardRing
+    settings    *SymbolSettingsBook
+    shed        *LoadShedder
+    predSLO     *PredictionSLO
+    calendars   *CalendarSet
+    watchlists  *WatchlistIndexes
+    accuracy    *AccuracyTracker
+    batcher     *PredictionBatcher
+    scrapes     *ScrapeBudget
+    snapshots   *SnapshotCache
+    imports     *HistoryImporter
+    digests     *DigestBook
+    quarantine  *ScrapeQuarantine
+    indCache    *IndicatorCache
+    flags       *FeatureFlags
+    instruments *InstrumentBook
 }
 
 /*
-NewFinancialProcessor initializes the processor with a list of symbols to track.
+NewFinancialProcessor initializes the processor from cfg, tracking cfg.Symbols.
 */
-func NewFinancialProcessor(symbols []string) *FinancialProcessor {
+func NewFinancialProcessor(cfg Config) *FinancialProcessor {
+    symbols := cfg.Symbols
     cols := make(map[string]*DataCollector)
     for _, s := range symbols {
         cols[s] = NewDataCollector()
     }
-    return &FinancialProcessor{
-        collectors: cols,
-        dataStore:  make(map[string][]StockData),
-        symbols:    symbols,
+    ml, err := NewMLClientFromEnv()
+    if err != nil {
+        log.Fatalf("ML client configuration: %v", err)
     }
+    sources, err := loadSymbolSourcesFromEnv()
+    if err != nil {
+        log.Fatal(err)
+    }
+    var listed []string
+    for sym, src := range sources {
+        if _, ok := cols[sym]; src.Listed && !ok {
+            cols[sym] = NewDataCollector()
+            listed = append(listed, sym)
+        }
+    }
+    sort.Strings(listed)
+    symbols = append(symbols, listed...)
+    auth, err := NewKeyAuthFromEnv()
+    if err != nil {
+        log.Fatal(err)
+    }
+    calendars, err := NewCalendarSet(cfg.Calendars)
+    if err != nil {
+        log.Fatal(err)
+    }
+    watchlists, err := NewWatchlistIndexes(cfg.Watchlists)
+    if err != nil {
+        log.Fatal(err)
+    }
+    ticks := NewMemorySeries[StockData](cfg.MaxHistory)
+    if cfg.RetentionTiers != "" {
+        tiers, err := parseRetentionTiers(cfg.RetentionTiers)
+        if err != nil {
+            log.Fatal(err)
+        }
+        ticks = NewTieredSeries(tiers, cfg.MaxHistory)
+    }
+    fp := &FinancialProcessor{
+        collectors:  cols,
+        dataStore:   ticks,
+        predictions: NewMemorySeries[Prediction](envInt("PREDICTION_HISTORY", 100)),
+        symbols:     symbols,
+        symbolsFile: os.Getenv("SYMBOLS_FILE"),
+        archive:     NewPayloadArchiveFromEnv(),
+        latency:     NewDependencyMetrics(depYahoo, depML),
+        startedAt:   time.Now(),
+        boosts:      make(map[string]time.Time),
+        residuals:   NewResidualTrackerFromEnv(),
+        positions:   NewPositionBookFromEnv(),
+        mlRoutes:    NewMLRouterFromEnv(),
+        alerts:      NewAlertDispatcherFromEnv(NewAlertHistoryFromEnv()),
+        ml:          ml,
+        pipelines:   make(map[string]*symbolPipeline),
+        idle:        NewIdleMonitorFromEnv(),
+        sources:     sources,
+        ramp:        NewRampLimiterFromEnv(),
+        sched:       NewSchedulerFromEnv(),
+        auth:        auth,
+        annotations: NewAnnotationStoreFromEnv(),
+        delisting:   NewDelistingDetectorFromEnv(),
+        alertRules:  NewAlertRuleBookFromEnv(),
+        blender:     NewPredictionBlenderFromEnv(),
+        events:      NewEventBus(envInt("CHANGE_FEED_SIZE", 10000)),
+        clock:       systemClock{},
+        config:      cfg,
+        settings:    NewSymbolSettingsBookFromEnv(),
+        indicators:  loadMLIndicators(),
+        interp:      NewInterpolationFromEnv(),
+        notifier:    NewPredictionNotifierFromEnv(),
+        shed:        NewLoadShedderFromEnv(cfg.MaxHistory),
+        predSLO:     NewPredictionSLOFromEnv(),
+        calendars:   calendars,
+        watchlists:  watchlists,
+        accuracy:    NewAccuracyTrackerFromEnv(),
+        scrapes:     NewScrapeBudgetFromEnv(),
+        flags:       NewFeatureFlagsFromEnv(cfg.Features),
+        instruments: NewInstrumentBook(),
+    }
+    fp.mlReady = NewMLHandshakeFromEnv(ml, fp.mlRoutes)
+    fp.ledger = NewLedgerFromEnv(func() time.Time { return fp.clock.Now() })
+    fp.news = NewNewsCollectorFromEnv(fp)
+    fp.summary = NewQuoteSummaryFetcherFromEnv(fp.latency)
+    fp.tradingView = NewTradingViewAdapterFromEnv(fp.latency)
+    fp.orderBooks = NewOrderBookCollectorFromEnv(symbols, fp.latency)
+    fp.capacity = NewCapacityBenchmarkFromEnv(fp)
+    fp.watchdog = NewWatchdogFromEnv(fp)
+    fp.pool = NewScrapePoolFromEnv(fp)
+    fp.recycler = NewCollectorRecyclerFromEnv()
+    fp.forecasts = NewForecastLadderFromEnv(fp)
+    fp.batcher = NewPredictionBatcherFromEnv(fp)
+    fp.snapshots = NewSnapshotCacheFromEnv(fp.events.Seq)
+    fp.imports = NewHistoryImporterFromEnv()
+    fp.digests = NewDigestBookFromEnv(fp)
+    fp.clusters = NewClusterBookFromEnv(fp)
+    fp.shards = NewShardRingFromEnv(fp)
+    fp.quarantine = NewScrapeQuarantineFromEnv(func() bool { return fp.flags.Enabled(flagQuarantine) })
+    fp.indCache = NewIndicatorCacheFromEnv(func() bool { return fp.flags.Enabled(flagIndicatorCache) })
+    fp.idle.OnIdle(fp.shrinkForIdle)
+    if fp.shed != nil {
+        fp.shed.onChange = fp.applyShedding
+    }
+    return fp
 }
 
 /*
-Start launches a goroutine for each symbol to periodically scrape and predict.
+Start queues a pipeline for each symbol on the scrape pool to periodically
+scrape and predict, staggering their first fetch across STARTUP_STAGGER
+(default 30s), or runs a single batched loop over the quote API when
+QUOTE_BATCH_SIZE is set (plus pipelines for symbols with a custom source). With BACKFILL_DAYS set,
+history is backfilled from the chart API before any loop starts.
 */
 func (fp *FinancialProcessor) Start() {
+    go fp.latency.monitorSLOs(30 * time.Second)
+    if fp.mlReady != nil {
+        fp.mlReady.Run()
+    }
+    go fp.idle.Run()
+    go fp.capacity.Run()
+    go fp.watchdog.Run()
+    go fp.shed.Run()
+    go fp.alerts.RunSummaries()
+    go fp.alertRules.Run()
+    go fp.digests.Run()
+    go fp.accuracy.Run()
+    go fp.clusters.Run()
+    if fp.ledger != nil {
+        go fp.ledger.Run()
+    }
+    if fp.news != nil {
+        go fp.news.Run()
+    }
+    if fp.orderBooks != nil {
+        go fp.orderBooks.Run()
+    }
+    fp.shards.Join()
+    fp.mutex.Lock()
+    fp.collecting = true
+    var symbols []string
     for _, sym := range fp.symbols {
+        if fp.shards.Owns(sym) {
+            symbols = append(symbols, sym)
+        }
+    }
+    fp.mutex.Unlock()
+    if days := envInt("BACKFILL_DAYS", 0); days > 0 {
+        fp.backfill(symbols, days)
+    }
+    if batch := envInt("QUOTE_BATCH_SIZE", 0); batch > 0 {
         fp.wg.Add(1)
-        go fp.periodicCollection(sym)
+        go fp.batchedCollection(batch)
+        for _, sym := range fp.sourceOverrides() {
+            if !fp.delisting.Inactive(sym) && fp.shards.Owns(sym) {
+                fp.startPipeline(sym, 0, 0)
+            }
+        }
+        return
+    }
+    spread := envDuration("STARTUP_STAGGER", 30*time.Second)
+    offsets := staggerOffsets(len(symbols), spread)
+    for i, sym := range symbols {
+        if !fp.delisting.Inactive(sym) {
+            // Each offset is its evenly spaced slot plus up to one slot of jitter.
+            jitter := offsets[i] - time.Duration(i)*(spread/time.Duration(len(symbols)))
+            fp.startPipeline(sym, offsets[i], jitter)
+        }
     }
 }
 
 /*
-periodicCollection fetches new data every 30s, stores up to 100 points,
-and triggers prediction once enough history is collected.
+collectOnce makes one scheduled fetch for p on a scrape worker, handing the
+snapshot to recordTick, which stores it and triggers prediction, and returns
+when the next one is due: a collectionInterval (COLLECTION_INTERVAL, or
+faster while a news boost is active) later, or when its retry is due for a
+quarantined symbol, which is not fetched meanwhile. ok is false once the
+pipeline is stopped or the symbol deactivated; a panic ends only this
+pipeline, leaving the watchdog to restart it.
 */
-func (fp *FinancialProcessor) periodicCollection(symbol string) {
-    defer fp.wg.Done()
-    ticker := time.NewTicker(30 * time.Second)
-    defer ticker.Stop()
-
-    // Initial fetch
-    if sd, err := fp.collectors[symbol].FetchStockData(symbol); err == nil {
-        fp.mutex.Lock()
-        fp.dataStore[symbol] = append(fp.dataStore[symbol], *sd)
-        fp.mutex.Unlock()
-        if len(fp.dataStore[symbol]) >= 5 {
-            go fp.getPrediction(symbol)
+func (fp *FinancialProcessor) collectOnce(p *symbolPipeline) (next time.Time, ok bool) {
+    defer func() {
+        if r := recover(); r != nil {
+            slog.Error("collection panicked", "symbol", p.symbol, "panic", r)
+            ok = false
         }
+    }()
+    if p.stopped() {
+        return next, false
+    }
+    start := fp.clock.Now()
+    next = start.Add(fp.collectionInterval(p.symbol))
+    retry, held := fp.quarantine.Holds(p.symbol, start)
+    if held {
+        next = retry
+    }
+    p.times.record(start, next)
+    if held || fp.pausedForLoad(p.symbol) {
+        // Count the skipped fetch as a tick so the watchdog leaves the pipeline alone.
+        p.lastTick.Store(start.UnixNano())
+        return next, true
     }
+    sd, err := fp.fetch(p.symbol)
+    if p.stopped() || fp.observeFetch(p.symbol, err) {
+        return next, false
+    }
+    if err == nil {
+        fp.recordTick(*sd)
+        p.lastTick.Store(fp.clock.Now().UnixNano())
+    }
+    return next, true
+}
+
+/*
+fetch retrieves one snapshot for symbol through its configured source, or its
+Yahoo collector by default, records the call latency against that dependency
+and logs the outcome, failures at warn level and successes at debug. A
+snapshot whose source reported no quote time of its own is stamped with the
+processor's clock.
+*/
+func (fp *FinancialProcessor) fetch(symbol string) (*StockData, error) {
+    start := time.Now()
+    var sd *StockData
+    var err error
+    dep := depYahoo
+    if src, ok := fp.sources[symbol]; ok {
+        dep = "source:" + src.Provider
+        fp.ramp.Wait()
+        sd, err = src.Fetch(symbol)
+    } else {
+        dc := fp.collectorFor(symbol)
+        fp.ramp.Wait()
+        sd, err = dc.FetchStockData(symbol)
+    }
+    elapsed := time.Since(start)
+    fp.latency.Observe(dep, elapsed, err)
+    if err == nil && sd.Timestamp.IsZero() {
+        sd.Timestamp = fp.clock.Now()
+    }
+    if err != nil {
+        slog.Warn("fetch failed", "symbol", symbol, "source", fp.scrapeProvider(symbol), "latency", elapsed, "err", err)
+    } else {
+        slog.Debug("fetched", "symbol", symbol, "source", fp.scrapeProvider(symbol), "latency", elapsed, "price", sd.Price)
+    }
+    return sd, err
+}
 
-    for range ticker.C {
-        if sd, err := fp.collectors[symbol].FetchStockData(symbol); err == nil {
-            fp.mutex.Lock()
-            arr := fp.dataStore[symbol]
-            arr = append(arr, *sd)
-            if len(arr) > 100 {
-                arr = arr[len(arr)-100:]
+/*
+recordTick appends a snapshot to the symbol's history, which keeps at most
+MAX_HISTORY raw ticks (or what RETENTION_TIERS retains), and triggers
+prediction once enough history is collected, unless the service is idle. It
+is the single ingestion path shared by live collection and replay, and
+normalizes each tick to its symbol's instrument. Ticks for inactive (delisted) symbols are
+dropped so their history stays read-only.
+*/
+func (fp *FinancialProcessor) recordTick(sd StockData) {
+    if fp.delisting.Inactive(sd.Symbol) {
+        return
+    }
+    fp.instruments.Observe(sd).Normalize(&sd)
+    n := fp.dataStore.Append(sd.Symbol, sd)
+    if fp.ledger != nil {
+        fp.ledger.Record("tick", sd)
+    }
+    fp.events.Publish(Event{Type: "tick", Symbol: sd.Symbol, Data: sd})
+
+    fp.residuals.Resolve(sd)
+    resolved := fp.accuracy.Resolve(sd)
+    if fp.store != nil {
+        for _, rec := range resolved {
+            if err := fp.store.SaveAccuracy(rec); err != nil {
+                slog.Error("storing daily accuracy failed", "symbol", sd.Symbol, "err", err)
             }
-            fp.dataStore[symbol] = arr
-            fp.mutex.Unlock()
-            go fp.getPrediction(symbol)
         }
     }
+    if fp.blender != nil {
+        fp.blender.Resolve(sd)
+    }
+    fp.checkAlertRules(sd)
+    fp.recordIndexes(sd)
+
+    if n >= 5 && !fp.idle.Idle() {
+        fp.predSLO.Open(sd.Symbol, fp.clock.Now())
+        fp.pending.Add(1)
+        fp.sched.Background(func() {
+            defer fp.pending.Done()
+            fp.getPrediction(sd.Symbol)
+            if fp.forecasts != nil && fp.flags.Enabled(flagForecastLadder) {
+                fp.forecasts.Refresh(sd.Symbol)
+            }
+        })
+    }
+}
+
+/*
+predictionPayload builds the request body sent to the ML service's /predict
+endpoint for the history of inst, computing indicators through ic when it is
+not nil. When interp is enabled the history has its small gaps filled first,
+and the payload says how under interpolation and which ticks were made up in
+interpolated, a mask aligned with data. days_to_earnings, aligned with data
+too, is added once an earnings date is known.
+*/
+func predictionPayload(ic *IndicatorCache, interp *Interpolation, inst Instrument, data []StockData, indicators []string) map[string]interface{} {
+    view := "raw"
+    payload := map[string]interface{}{"symbol": inst.Symbol, "instrument": inst}
+    if interp.Enabled() {
+        var mask []bool
+        data, mask = interp.Fill(data)
+        view = "raw/" + interp.mode
+        payload["interpolation"] = interp.mode
+        payload["interpolated"] = mask
+    }
+    payload["data"] = data
+    if days := daysToEarnings(data); days != nil {
+        payload["days_to_earnings"] = days
+    }
+    if len(indicators) > 0 {
+        payload["indicators"] = indicatorPayload(ic, inst.Symbol, view, data, indicators)
+    }
+    return payload
+}
+
+/*
+predictionJob is one symbol's pending call to the ML service.
+*/
+type predictionJob struct {
+    symbol string
+    inst   Instrument
+    route  string
+    url    string
+    data   []StockData
+    freeze string
+    body   []byte
+}
+
+/*
+mlPrediction is the ML service's answer to one /predict request.
+*/
+type mlPrediction struct {
+    Prediction
+    Error string `json:"error"`
 }
 
 /*
-getPrediction sends the last batch of data to the ML service
-and logs the returned Prediction struct.
+getPrediction sends the last batch of data to the ML service routed for
+the symbol and logs the returned Prediction struct, or hands it to the
+batcher when predictions are batched.
 */
 func (fp *FinancialProcessor) getPrediction(symbol string) {
-    fp.mutex.RLock()
-    data := fp.dataStore[symbol]
-    fp.mutex.RUnlock()
-    if len(data) < 5 {
+    job := fp.preparePrediction(symbol)
+    if job == nil {
         return
     }
+    if fp.batcher != nil && fp.flags.Enabled(flagPredictBatch) {
+        fp.batcher.Add(job)
+        return
+    }
+    res, err := fp.postPrediction(job)
+    fp.completePrediction(job, res, err)
+}
 
-    payload := map[string]interface{}{"symbol": symbol, "data": data}
-    body, _ := json.Marshal(payload)
+/*
+preparePrediction builds symbol's /predict request from its history, or
+returns nil when no prediction should be made. Inside an open/close freeze
+window the prediction is skipped, or with FREEZE_MODE=flag made but marked
+frozen and kept away from risk alerts and trade signals. Nothing is sent
+until the routed ML service has passed its startup handshake.
+*/
+func (fp *FinancialProcessor) preparePrediction(symbol string) *predictionJob {
+    data := fp.rawTicks(symbol)
+    if len(data) < 5 {
+        return nil
+    }
+    var freeze string
+    if mc := fp.calendars.For(symbol); mc != nil {
+        if window, frozen := sessionFreeze(mc, data[len(data)-1].Timestamp); frozen {
+            if os.Getenv("FREEZE_MODE") != "flag" {
+                fp.predSLO.Excuse(symbol)
+                return nil
+            }
+            freeze = window
+        }
+    }
 
-    host := os.Getenv("ML_SERVICE_HOST")
-    if host == "" {
-        host = "localhost"
+    route, url := fp.mlRoutes.Resolve(symbol, "/predict")
+    if !fp.mlReady.Ready(route) {
+        return nil
     }
-    port := os.Getenv("ML_PORT")
-    if port == "" {
-        port = "5001"
+    inst := fp.instruments.Get(symbol)
+    indicators := fp.indicators
+    if fp.shed.Sheds(shedIndicators) || !fp.flags.Enabled(flagMLIndicators) {
+        indicators = nil
     }
-    url := fmt.Sprintf("http://%s:%s/predict", host, port)
+    body, _ := json.Marshal(predictionPayload(fp.indCache, fp.interp, inst, data, indicators))
 
-    resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+    if fp.archive != nil {
+        if _, err := fp.archive.Save(symbol, fp.clock.Now(), body); err != nil {
+            slog.Error("payload archive error", "symbol", symbol, "err", err)
+        }
+    }
+    return &predictionJob{symbol: symbol, inst: inst, route: route, url: url, data: data, freeze: freeze, body: body}
+}
+
+/*
+postPrediction sends job to its ML service on its own.
+*/
+func (fp *FinancialProcessor) postPrediction(job *predictionJob) (*mlPrediction, error) {
+    start := time.Now()
+    resp, err := fp.ml.Post(job.url, job.body)
+    elapsed := time.Since(start)
+    fp.latency.Observe(depML, elapsed, err)
+    fp.latency.Observe(depML+":"+job.route, elapsed, err)
+    slog.Debug("ML call", "symbol", job.symbol, "route", job.route, "latency", elapsed, "err", err)
     if err != nil {
-        log.Printf("prediction error: %v", err)
-        return
+        return nil, err
     }
     defer resp.Body.Close()
 
-    var p Prediction
-    if err := json.NewDecoder(resp.Body).Decode(&p); err == nil {
-        log.Printf("Prediction for %s: %.2f → %.2f (%.2f%%)",
-            p.Symbol, p.CurrentPrice, p.PredictedPrice, p.PredictedChangePerc)
+    var result mlPrediction
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("decoding response: %w", err)
+    }
+    return &result, nil
+}
+
+/*
+completePrediction records the ML service's answer to job and acts on it.
+*/
+func (fp *FinancialProcessor) completePrediction(job *predictionJob, result *mlPrediction, err error) {
+    symbol, data, freeze := job.symbol, job.data, job.freeze
+    if err != nil {
+        slog.Warn("prediction failed", "symbol", symbol, "err", err)
+        return
+    }
+    if result.Error != "" {
+        slog.Info("prediction unavailable", "symbol", symbol, "reason", result.Error)
+        return
+    }
+    p := result.Prediction
+    p.PredictedPrice = job.inst.Round(p.PredictedPrice)
+    p.Frozen = freeze
+    if fp.blender != nil && fp.flags.Enabled(flagBlending) {
+        p.Blended = fp.blender.Blend(p, data)
+    }
+    slog.Info("prediction", "symbol", p.Symbol, "current_price", p.CurrentPrice,
+        "predicted_price", p.PredictedPrice, "predicted_change_percent", p.PredictedChangePerc)
+    fp.predictions.Append(symbol, p)
+    fp.predSLO.Predicted(symbol, fp.clock.Now())
+    if fp.ledger != nil {
+        fp.ledger.Record("prediction", p)
+    }
+    fp.events.Publish(Event{Type: "prediction", Symbol: symbol, Data: p})
+    fp.residuals.Track(symbol, data, p.PredictedPrice, p.Timestamp)
+    fp.accuracy.Track(symbol, horizonNext, p.CurrentPrice, p.PredictedPrice, p.Timestamp, data[len(data)-1].Timestamp.Add(time.Nanosecond))
+    if freeze != "" {
+        slog.Info("prediction made during a freeze window; not acting on it", "symbol", symbol, "freeze", freeze)
+        return
+    }
+    fp.checkPredictionAlerts(p)
+    fp.notifyPrediction(p)
+    if math.Abs(p.PredictedChangePerc) < fp.predictionThreshold(symbol) {
+        return
+    }
+    if fp.tradingView != nil {
+        if sig := fp.tradingView.Publish(p); sig != nil && fp.ledger != nil {
+            sig.Passphrase = ""
+            fp.ledger.Record("signal", sig)
+        }
+    }
+    if alert, active, raised := fp.positions.Evaluate(p); active {
+        msg := fmt.Sprintf("predicted %.2f%% move against %s %s position (exposure %.2f, expected loss %.2f)",
+            alert.PredictedChangePerc, alert.Side, alert.Symbol, alert.Exposure, alert.ExpectedLoss)
+        slog.Warn("position risk", "symbol", alert.Symbol, "detail", msg)
+        if raised {
+            fp.fireAlert("position_risk", alert.Symbol, alert.PredictedChangePerc, msg)
+        }
+    }
+}
+
+/*
+mlServiceURL builds the URL for the given ML service path from the
+ML_SCHEME (default http), ML_SERVICE_HOST and ML_PORT environment variables.
+*/
+func mlServiceURL(path string) string {
+    scheme := os.Getenv("ML_SCHEME")
+    if scheme == "" {
+        scheme = "http"
+    }
+    host := os.Getenv("ML_SERVICE_HOST")
+    if host == "" {
+        host = "localhost"
     }
+    port := os.Getenv("ML_PORT")
+    if port == "" {
+        port = "5001"
+    }
+    return fmt.Sprintf("%s://%s:%s%s", scheme, host, port, path)
 }
 
 /*
 handleGetData exposes an HTTP GET endpoint to retrieve stored history
-for a given symbol.
+for a given symbol, with annotations attached to the nearest ticks. With
+?localize=true the raw history is wrapped together with Accept-Language-aware
+formatting metadata and display strings. from, to, limit, order and cursor
+select a time range instead of the recent window, read from persistent
+storage when it is configured, a page at a time: a page that reaches limit
+names the next one in its X-Next-Cursor and Link headers. The plain recent
+window is served from a snapshot.
 */
 func (fp *FinancialProcessor) handleGetData(w http.ResponseWriter, r *http.Request) {
     sym := mux.Vars(r)["symbol"]
-    fp.mutex.RLock()
-    data, ok := fp.dataStore[sym]
-    fp.mutex.RUnlock()
-    if !ok {
+    qs := r.URL.Query()
+    if len(qs) == 0 && !wantsDecimalStrings(r) && !wantsLocalized(r) {
+        served := fp.snapshots.Serve(w, "data:"+sym, func() (interface{}, bool) {
+            data := fp.dataStore.Window(sym, 0)
+            fp.annotations.attachAnnotations(sym, data)
+            return data, len(data) > 0
+        })
+        if !served {
+            http.Error(w, "no data", http.StatusNotFound)
+        }
+        return
+    }
+    var data []StockData
+    if isDataQuery(qs) {
+        q, err := parseDataQuery(qs)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if q.desc {
+            data, err = fp.tickRangeDesc(sym, q.from, q.to, q.limit)
+        } else {
+            data, err = fp.tickRange(sym, q.from, q.to, q.limit)
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        if q.limit > 0 && len(data) == q.limit {
+            setNextPage(w, r, data[len(data)-1].Timestamp)
+        }
+    } else {
+        data = fp.dataStore.Window(sym, 0)
+    }
+    if len(data) == 0 {
+        if qs.Get("cursor") != "" {
+            // Past the last page.
+            streamJSONArray(w, data)
+            return
+        }
         http.Error(w, "no data", http.StatusNotFound)
         return
     }
-    json.NewEncoder(w).Encode(data)
+    fp.annotations.attachAnnotations(sym, data)
+    if wantsDecimalStrings(r) {
+        json.NewEncoder(w).Encode(decimalTicks(data))
+        return
+    }
+    if wantsLocalized(r) {
+        nf := negotiateNumberFormat(r, sym)
+        w.Header().Set("Content-Language", nf.Locale)
+        w.Header().Add("Vary", "Accept-Language")
+        json.NewEncoder(w).Encode(localizeTicks(data, nf))
+        return
+    }
+    streamJSONArray(w, data)
 }
 
 /*
-main initializes the FinancialProcessor, starts scraping/prediction routines,
-and runs the HTTP server on the configured port.
+newRouter registers the HTTP API routes served by the processor.
 */
-func main() {
-    symbols := []string{"AAPL", "MSFT", "GOOGL", "AMZN", "META"}
-    fp := NewFinancialProcessor(symbols)
-    fp.Start()
-
+func newRouter(fp *FinancialProcessor) *mux.Router {
     r := mux.NewRouter()
+    r.Use(logRequests, fp.auth.Middleware, fp.idle.Middleware, fp.sched.Middleware)
+    r.HandleFunc("/", fp.handleDashboard).Methods("GET")
+    r.HandleFunc("/api/dashboard/quotes", fp.handleDashboardQuotes).Methods("GET")
+    r.HandleFunc("/ws", fp.handleWebSocket).Methods("GET")
+    r.HandleFunc("/api/stream", fp.handleStream).Methods("GET")
     r.HandleFunc("/api/data/{symbol}", fp.handleGetData).Methods("GET")
+    r.HandleFunc("/api/data/{symbol}/export", fp.handleExportData).Methods("GET")
+    r.HandleFunc("/api/resample/{symbol}", fp.handleResample).Methods("GET")
+    r.HandleFunc("/api/candles/{symbol}", fp.handleCandles).Methods("GET")
+    r.HandleFunc("/api/indicators/{symbol}", fp.handleIndicators).Methods("GET")
+    r.HandleFunc("/api/status", fp.handleStatus).Methods("GET")
+    r.HandleFunc("/api/changes", fp.handleChanges).Methods("GET")
+    r.HandleFunc("/api/slo", fp.handleSLO).Methods("GET")
+    r.HandleFunc("/api/accuracy/{symbol}", fp.handleAccuracy).Methods("GET")
+    r.HandleFunc("/api/accuracy/{symbol}/daily", fp.handleDailyAccuracy).Methods("GET")
+    r.HandleFunc("/api/market/hours", fp.handleMarketHours).Methods("GET")
+    r.HandleFunc("/api/instruments", fp.handleInstruments).Methods("GET")
+    r.HandleFunc("/api/instruments/{symbol}", fp.handleInstrument).Methods("GET")
+    r.HandleFunc("/api/symbols", fp.handleListSymbols).Methods("GET")
+    r.HandleFunc("/api/symbols", fp.handleAddSymbol).Methods("POST")
+    r.HandleFunc("/api/symbols/settings", fp.handleListSymbolSettings).Methods("GET")
+    r.HandleFunc("/api/symbols/settings", fp.handlePatchSymbolSettings).Methods("PATCH")
+    r.HandleFunc("/api/symbols/{symbol}", fp.handleRemoveSymbol).Methods("DELETE")
+    r.HandleFunc("/api/watchlists", fp.handleListWatchlists).Methods("GET")
+    r.HandleFunc("/api/debug/raw/{symbol}", fp.handleDebugRaw).Methods("GET")
+    r.HandleFunc("/api/annotations", fp.annotations.handleListAnnotations).Methods("GET")
+    r.HandleFunc("/api/annotations", fp.annotations.handleCreateAnnotation).Methods("POST")
+    r.HandleFunc("/api/annotations/{id}", fp.annotations.handleDeleteAnnotation).Methods("DELETE")
+    r.HandleFunc("/api/export/residuals", fp.handleExportResiduals).Methods("GET")
+    r.HandleFunc("/api/screener", fp.handleScreener).Methods("GET")
+    r.HandleFunc("/api/backtest", fp.handleBacktest).Methods("POST")
+    r.HandleFunc("/api/clusters", fp.clusters.handleClusters).Methods("GET")
+    r.HandleFunc("/api/consensus/{symbol}", fp.handleGetConsensus).Methods("GET")
+    r.HandleFunc("/api/predictions", fp.handleListPredictions).Methods("GET")
+    r.HandleFunc("/api/predictions/{symbol}", fp.handleGetPredictions).Methods("GET")
+    r.HandleFunc("/api/summary/{symbol}", fp.handleGetSummary).Methods("GET")
+    r.HandleFunc("/api/positions", fp.positions.handleListPositions).Methods("GET")
+    r.HandleFunc("/api/positions/{symbol}", fp.positions.handlePutPosition).Methods("PUT")
+    r.HandleFunc("/api/positions/{symbol}", fp.positions.handleDeletePosition).Methods("DELETE")
+    r.HandleFunc("/api/risk/alerts", fp.positions.handleRiskAlerts).Methods("GET")
+    r.HandleFunc("/api/alerts/history", fp.alerts.handleAlertHistory).Methods("GET")
+    r.HandleFunc("/api/symbols/inactive", fp.handleInactiveSymbols).Methods("GET")
+    r.HandleFunc("/api/alerts/recipients", fp.alerts.recipients.handleListRecipients).Methods("GET")
+    r.HandleFunc("/api/alerts/recipients/{user}", fp.alerts.recipients.handlePutRecipient).Methods("PUT")
+    r.HandleFunc("/api/alerts/recipients/{user}", fp.alerts.recipients.handleDeleteRecipient).Methods("DELETE")
+    r.HandleFunc("/api/alerts", fp.alertRules.handleListAlertRules).Methods("GET")
+    r.HandleFunc("/api/alerts", fp.alertRules.handleCreateAlertRule).Methods("POST")
+    r.HandleFunc("/api/alerts/{id:[0-9]+}", fp.alertRules.handleDeleteAlertRule).Methods("DELETE")
+    r.HandleFunc("/api/alerts/price", fp.alertRules.handleListPriceAlerts).Methods("GET")
+    r.HandleFunc("/api/alerts/price", fp.alertRules.handleCreatePriceAlert).Methods("POST")
+    r.HandleFunc("/api/alerts/price/{id}", fp.alertRules.handleDeleteAlertRule).Methods("DELETE")
+    r.HandleFunc("/api/digest", fp.digests.handleGetDigest).Methods("GET")
+    r.HandleFunc("/api/digest", fp.digests.handlePutDigest).Methods("PUT")
+    r.HandleFunc("/api/digest", fp.digests.handleDeleteDigest).Methods("DELETE")
+    r.HandleFunc("/api/digest/preview", fp.digests.handlePreviewDigest).Methods("GET")
+    r.HandleFunc("/metrics", fp.handleMetrics).Methods("GET")
+    registerAdminRoutes(r, fp)
+    if fp.news != nil {
+        r.HandleFunc("/api/news/{symbol}", fp.news.handleGetNews).Methods("GET")
+    }
+    if fp.orderBooks != nil {
+        r.HandleFunc("/api/orderbook/{symbol}", fp.orderBooks.handleGetOrderBook).Methods("GET")
+    }
+    if fp.ledger != nil {
+        r.HandleFunc("/api/ledger", fp.ledger.handleLedgerDays).Methods("GET")
+        r.HandleFunc("/api/ledger/verify", fp.ledger.handleLedgerVerify).Methods("GET")
+        r.HandleFunc("/api/ledger/{date}", fp.ledger.handleLedgerFile).Methods("GET")
+    }
+    if fp.forecasts != nil {
+        r.HandleFunc("/api/forecast/{symbol}", fp.forecasts.handleGetForecast).Methods("GET")
+    }
+    return r
+}
 
+/*
+listenPort returns the HTTP port from the PORT environment variable, defaulting to 8080.
+*/
+func listenPort() string {
     port := os.Getenv("PORT")
     if port == "" {
         port = "8080"
     }
-    log.Printf("Listening on :%s", port)
+    return port
+}
+
+/*
+envInt reads an integer environment variable, returning def when unset or invalid.
+*/
+func envInt(key string, def int) int {
+    if v := os.Getenv(key); v != "" {
+        if n, err := strconv.Atoi(v); err == nil {
+            return n
+        }
+        slog.Warn("invalid setting, using default", "key", key, "value", v, "default", def)
+    }
+    return def
+}
+
+/*
+envFloat reads a float environment variable, returning def when unset or invalid.
+*/
+func envFloat(key string, def float64) float64 {
+    if v := os.Getenv(key); v != "" {
+        if f, err := strconv.ParseFloat(v, 64); err == nil {
+            return f
+        }
+        slog.Warn("invalid setting, using default", "key", key, "value", v, "default", def)
+    }
+    return def
+}
+
+/*
+envDuration reads a time.Duration environment variable (e.g. "90s", "72h"),
+returning def when unset or invalid.
+*/
+func envDuration(key string, def time.Duration) time.Duration {
+    if v := os.Getenv(key); v != "" {
+        if d, err := time.ParseDuration(v); err == nil {
+            return d
+        }
+        slog.Warn("invalid setting, using default", "key", key, "value", v, "default", def)
+    }
+    return def
+}
+
+/*
+main dispatches subcommands, or by default initializes the FinancialProcessor,
+starts scraping/prediction routines, and runs the HTTP server on the configured port.
+With --mode=collector it scrapes without serving the data API, and with
+--mode=api it serves the API without scraping; both require STORAGE_DSN,
+through which they share tick history.
+*/
+func main() {
+    setupLogging()
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "replay":
+            if err := runReplay(os.Args[2:]); err != nil {
+                log.Fatalf("replay: %v", err)
+            }
+            return
+        case "migrate":
+            if err := runMigrate(os.Args[2:]); err != nil {
+                log.Fatalf("migrate: %v", err)
+            }
+            return
+        case "reproduce":
+            if err := runReproduce(os.Args[2:]); err != nil {
+                log.Fatalf("reproduce: %v", err)
+            }
+            return
+        case "genfixtures":
+            if err := runGenFixtures(os.Args[2:]); err != nil {
+                log.Fatalf("genfixtures: %v", err)
+            }
+            return
+        case "validate":
+            if err := runValidate(os.Args[2:]); err != nil {
+                log.Fatalf("validate: %v", err)
+            }
+            return
+        }
+    }
+
+    mode, err := parseRunMode(os.Args[1:])
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // Any number of API processes may share storage; only collectors lock it.
+    if mode != modeAPI {
+        lock := mustLockInstance(os.Args[1:])
+        if lock != nil {
+            defer lock.Release()
+        }
+        if err := autoMigrate(); err != nil {
+            log.Fatalf("storage migration failed: %v", err)
+        }
+    }
+
+    cfg, err := LoadConfig()
+    if err != nil {
+        // Report whatever else is wrong too, not only the configuration.
+        mustPassStartupChecks(mode, nil, err)
+    }
+    configured := cfg.Symbols
+    cfg.Symbols = loadSymbolsFromEnv(cfg.Symbols)
+    symbols := cfg.Symbols
+    fp := NewFinancialProcessor(cfg)
+    // Reloads diff the configured symbols, not those saved in SYMBOLS_FILE.
+    fp.config.Symbols = configured
+    mustPassStartupChecks(mode, fp, nil)
+    go fp.reloadOnSIGHUP()
+    store, err := NewStoreFromEnv()
+    if err != nil {
+        log.Fatalf("storage: %v", err)
+    }
+    shared, replicated := store.(*redisStore)
+    switch {
+    case store == nil && mode != modeAll:
+        log.Fatalf("--mode=%s needs STORAGE_DSN so collector and API processes share history", mode)
+    case store != nil && (mode == modeAPI || replicated):
+        // Redis replicas all read the shared history, whichever is leader.
+        fp.store, fp.dataStore = store, NewStoreSeries(store, cfg.MaxHistory)
+    case store != nil:
+        warm := append(append([]string(nil), symbols...), fp.watchlists.Symbols()...)
+        fp.store, fp.dataStore = store, NewPersistentSeries(fp.dataStore, store, warm, cfg.MaxHistory, fp.warmCacheSince)
+    }
+    if fp.cold, err = NewColdStoreFromEnv(); err != nil {
+        log.Fatalf("cold storage: %v", err)
+    }
+
+    r := newRouter(fp)
+    switch {
+    case mode == modeAPI:
+    case replicated && fp.shards == nil:
+        fp.leader = NewLeaderElectionFromEnv(shared.client)
+        go fp.leader.Campaign(fp.Start)
+    default:
+        fp.Start()
+    }
+    if mode == modeCollector {
+        r = newCollectorRouter(fp)
+    }
+    port := listenPort()
+    slog.Info("listening", "port", port, "mode", mode)
     log.Fatal(http.ListenAndServe(":"+port, r))
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+/*
+newTestProcessor builds a processor from the environment with env set for the
+duration of the test. SYMBOLS_FILE points into a temporary directory, unless
+env names one, so a watchlist saved on the machine running the tests is
+neither read nor overwritten.
+*/
+func newTestProcessor(t *testing.T, env map[string]string) *FinancialProcessor {
+    t.Helper()
+    t.Setenv("SYMBOLS_FILE", filepath.Join(t.TempDir(), "symbols.json"))
+    for k, v := range env {
+        t.Setenv(k, v)
+    }
+    cfg, err := LoadConfig()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return NewFinancialProcessor(cfg)
+}
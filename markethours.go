@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+/*
+marketLocation is the exchange time zone used for US equity session checks.
+*/
+var marketLocation = loadMarketLocation()
+
+/*
+loadMarketLocation resolves America/New_York, falling back to UTC.
+*/
+func loadMarketLocation() *time.Location {
+    loc, err := time.LoadLocation("America/New_York")
+    if err != nil {
+        slog.Warn("market time zone unavailable, using UTC", "err", err)
+        return time.UTC
+    }
+    return loc
+}
+
+/*
+CalendarSpec configures a trading calendar in the config file. Open and Close
+are "HH:MM" in Timezone; USHolidays adds the NYSE holiday and early close
+rules to the listed Holidays and EarlyCloses ("YYYY-MM-DD" dates, early
+closes mapped to their closing time). Symbols trade on this calendar; a spec
+named NYSE replaces the built-in one used for every other equity.
+*/
+type CalendarSpec struct {
+    Name        string            `yaml:"name"`
+    Timezone    string            `yaml:"timezone"`
+    Open        string            `yaml:"open"`
+    Close       string            `yaml:"close"`
+    USHolidays  bool              `yaml:"us_holidays"`
+    Holidays    []string          `yaml:"holidays"`
+    EarlyCloses map[string]string `yaml:"early_closes"`
+    Symbols     []string          `yaml:"symbols"`
+}
+
+/*
+MarketCalendar knows an exchange's regular session on weekdays, in minutes
+after midnight local time, and the holidays and early closes it skips.
+*/
+type MarketCalendar struct {
+    Name        string
+    loc         *time.Location
+    open        int
+    close       int
+    usHolidays  bool
+    holidays    map[string]bool
+    earlyCloses map[string]int
+}
+
+/*
+nyseCalendar is the built-in NYSE/NASDAQ calendar: 9:30 to 16:00 Eastern with
+the exchange holidays and 13:00 early closes.
+*/
+var nyseCalendar = &MarketCalendar{Name: "NYSE", loc: marketLocation, open: 9*60 + 30, close: 16 * 60, usHolidays: true}
+
+/*
+fxCalendar is the built-in FX calendar: around the clock from Sunday 22:00
+to Friday 22:00 UTC, the usual FX trading week, as whole-day sessions in a
+UTC+2 zone.
+*/
+var fxCalendar = &MarketCalendar{Name: "FX", loc: time.FixedZone("FX", 2*60*60), open: 0, close: 24 * 60}
+
+/*
+newMarketCalendar builds a calendar from its spec.
+*/
+func newMarketCalendar(spec CalendarSpec) (*MarketCalendar, error) {
+    mc := &MarketCalendar{
+        Name:        strings.ToUpper(spec.Name),
+        usHolidays:  spec.USHolidays,
+        holidays:    make(map[string]bool),
+        earlyCloses: make(map[string]int),
+    }
+    if mc.Name == "" {
+        return nil, fmt.Errorf("calendar without a name")
+    }
+    var err error
+    if mc.loc, err = time.LoadLocation(spec.Timezone); err != nil || spec.Timezone == "" {
+        return nil, fmt.Errorf("calendar %s: invalid timezone %q", mc.Name, spec.Timezone)
+    }
+    if mc.open, err = clockMinutes(spec.Open); err != nil {
+        return nil, fmt.Errorf("calendar %s: open: %v", mc.Name, err)
+    }
+    if mc.close, err = clockMinutes(spec.Close); err != nil {
+        return nil, fmt.Errorf("calendar %s: close: %v", mc.Name, err)
+    }
+    if mc.close <= mc.open {
+        return nil, fmt.Errorf("calendar %s: close must be after open", mc.Name)
+    }
+    for _, d := range spec.Holidays {
+        if _, err := time.Parse("2006-01-02", d); err != nil {
+            return nil, fmt.Errorf("calendar %s: invalid holiday %q", mc.Name, d)
+        }
+        mc.holidays[d] = true
+    }
+    for d, at := range spec.EarlyCloses {
+        if _, err := time.Parse("2006-01-02", d); err != nil {
+            return nil, fmt.Errorf("calendar %s: invalid early close date %q", mc.Name, d)
+        }
+        if mc.earlyCloses[d], err = clockMinutes(at); err != nil {
+            return nil, fmt.Errorf("calendar %s: early close %s: %v", mc.Name, d, err)
+        }
+    }
+    return mc, nil
+}
+
+/*
+session returns the trading session on the local calendar day of t, and
+false when the market does not open that day.
+*/
+func (mc *MarketCalendar) session(t time.Time) (open, close time.Time, ok bool) {
+    lt := t.In(mc.loc)
+    if lt.Weekday() == time.Saturday || lt.Weekday() == time.Sunday {
+        return open, close, false
+    }
+    date := lt.Format("2006-01-02")
+    if mc.holidays[date] || mc.usHolidays && usMarketHoliday(lt) {
+        return open, close, false
+    }
+    closeMin := mc.close
+    if m, ok := mc.earlyCloses[date]; ok {
+        closeMin = m
+    } else if mc.usHolidays && usEarlyClose(lt) {
+        closeMin = 13 * 60
+    }
+    midnight := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, mc.loc)
+    return midnight.Add(time.Duration(mc.open) * time.Minute), midnight.Add(time.Duration(closeMin) * time.Minute), true
+}
+
+/*
+IsOpen reports whether t falls inside a trading session.
+*/
+func (mc *MarketCalendar) IsOpen(t time.Time) bool {
+    open, close, ok := mc.session(t)
+    return ok && !t.Before(open) && t.Before(close)
+}
+
+/*
+NextOpen returns the start of the first session after t, or t itself while
+the market is open. It gives up after 30 days without a session.
+*/
+func (mc *MarketCalendar) NextOpen(t time.Time) time.Time {
+    if mc.IsOpen(t) {
+        return t
+    }
+    for day := 0; day < 30; day++ {
+        d := t.In(mc.loc).AddDate(0, 0, day)
+        if open, _, ok := mc.session(d); ok && open.After(t) {
+            return open
+        }
+    }
+    return t.AddDate(0, 0, 30)
+}
+
+/*
+NextClose returns the end of the current session, or of the next one while
+the market is closed.
+*/
+func (mc *MarketCalendar) NextClose(t time.Time) time.Time {
+    _, close, _ := mc.session(mc.NextOpen(t))
+    return close
+}
+
+/*
+usMarketHoliday reports whether the NYSE is closed for a holiday on the
+calendar day of t (in Eastern time): New Year's Day, Martin Luther King Jr.
+Day, Washington's Birthday, Good Friday, Memorial Day, Juneteenth (from
+2022), Independence Day, Labor Day, Thanksgiving and Christmas. Holidays on a
+Sunday move to the Monday and, except New Year's Day, those on a Saturday to
+the Friday.
+*/
+func usMarketHoliday(t time.Time) bool {
+    y, m, d := t.Date()
+    date := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+    observed := func(month time.Month, day int, saturdayToFriday bool) bool {
+        h := time.Date(y, month, day, 0, 0, 0, 0, time.UTC)
+        switch h.Weekday() {
+        case time.Sunday:
+            h = h.AddDate(0, 0, 1)
+        case time.Saturday:
+            if !saturdayToFriday {
+                return false
+            }
+            h = h.AddDate(0, 0, -1)
+        }
+        return h.Equal(date)
+    }
+    nth := func(month time.Month, weekday time.Weekday, n int) bool {
+        if m != month || date.Weekday() != weekday {
+            return false
+        }
+        if n < 0 {
+            return d+7 > daysIn(month, y)
+        }
+        return (d-1)/7 == n-1
+    }
+    easter := easterSunday(y)
+    switch {
+    case observed(time.January, 1, false),
+        observed(time.July, 4, true),
+        observed(time.December, 25, true),
+        y >= 2022 && observed(time.June, 19, true),
+        nth(time.January, time.Monday, 3),
+        nth(time.February, time.Monday, 3),
+        nth(time.May, time.Monday, -1),
+        nth(time.September, time.Monday, 1),
+        nth(time.November, time.Thursday, 4),
+        date.Equal(easter.AddDate(0, 0, -2)):
+        return true
+    }
+    return false
+}
+
+/*
+usEarlyClose reports whether the NYSE closes at 13:00 on the calendar day of
+t: the day before Independence Day, the day after Thanksgiving and Christmas
+Eve, when they are trading days.
+*/
+func usEarlyClose(t time.Time) bool {
+    _, m, d := t.Date()
+    if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday || usMarketHoliday(t) {
+        return false
+    }
+    switch {
+    case m == time.July && d == 3, m == time.December && d == 24:
+        return true
+    case m == time.November && t.Weekday() == time.Friday:
+        return usMarketHoliday(t.AddDate(0, 0, -1))
+    }
+    return false
+}
+
+/*
+daysIn returns the number of days in month of year.
+*/
+func daysIn(month time.Month, year int) int {
+    return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+/*
+easterSunday computes Western Easter for year with the anonymous Gregorian
+algorithm.
+*/
+func easterSunday(year int) time.Time {
+    a := year % 19
+    b, c := year/100, year%100
+    d, e := b/4, b%4
+    f := (b + 8) / 25
+    g := (b - f + 1) / 3
+    h := (19*a + b - d - g + 15) % 30
+    i, k := c/4, c%4
+    l := (32 + 2*e + 2*i - h - k) % 7
+    m := (a + 11*h + 22*l) / 451
+    month := (h + l - 7*m + 114) / 31
+    day := (h+l-7*m+114)%31 + 1
+    return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+/*
+CalendarSet assigns every symbol its trading calendar: symbols listed in a
+configured calendar use it, crypto pairs have none and trade around the
+clock, FX pairs use the FX calendar and every other symbol uses NYSE.
+*/
+type CalendarSet struct {
+    calendars map[string]*MarketCalendar
+    symbols   map[string]*MarketCalendar
+}
+
+/*
+NewCalendarSet builds the calendars configured in specs on top of the
+built-in NYSE and FX calendars.
+*/
+func NewCalendarSet(specs []CalendarSpec) (*CalendarSet, error) {
+    cs := &CalendarSet{
+        calendars: map[string]*MarketCalendar{nyseCalendar.Name: nyseCalendar, fxCalendar.Name: fxCalendar},
+        symbols:   make(map[string]*MarketCalendar),
+    }
+    for _, spec := range specs {
+        mc, err := newMarketCalendar(spec)
+        if err != nil {
+            return nil, err
+        }
+        cs.calendars[mc.Name] = mc
+        for _, sym := range spec.Symbols {
+            cs.symbols[strings.ToUpper(sym)] = mc
+        }
+    }
+    return cs, nil
+}
+
+/*
+For returns symbol's calendar, or nil for a symbol that always trades.
+*/
+func (cs *CalendarSet) For(symbol string) *MarketCalendar {
+    if mc, ok := cs.symbols[symbol]; ok {
+        return mc
+    }
+    switch assetClassOf(symbol) {
+    case assetCrypto:
+        return nil
+    case assetFX:
+        return cs.calendars[fxCalendar.Name]
+    }
+    return cs.calendars[nyseCalendar.Name]
+}
+
+/*
+isMarketOpen reports whether t falls inside a regular NYSE/NASDAQ session,
+taking exchange holidays and early closes into account.
+*/
+func isMarketOpen(t time.Time) bool {
+    return nyseCalendar.IsOpen(t)
+}
+
+/*
+sessionFreeze reports whether t falls within FREEZE_OPEN after the open or
+FREEZE_CLOSE before the close of a session of mc (both default 0, off), when
+auction prints make predictions unreliable, and names the window.
+*/
+func sessionFreeze(mc *MarketCalendar, t time.Time) (string, bool) {
+    if !mc.IsOpen(t) {
+        return "", false
+    }
+    start, end, _ := mc.session(t)
+    if d := envDuration("FREEZE_OPEN", 0); d > 0 && t.Before(start.Add(d)) {
+        return "open", true
+    }
+    if d := envDuration("FREEZE_CLOSE", 0); d > 0 && !t.Before(end.Add(-d)) {
+        return "close", true
+    }
+    return "", false
+}
+
+/*
+MarketHours is a calendar's state at a point in time, as served at
+/api/market/hours.
+*/
+type MarketHours struct {
+    Calendar  string     `json:"calendar"`
+    Timezone  string     `json:"timezone"`
+    Open      bool       `json:"open"`
+    NextOpen  *time.Time `json:"next_open,omitempty"`
+    NextClose *time.Time `json:"next_close,omitempty"`
+    Symbols   []string   `json:"symbols,omitempty"`
+}
+
+/*
+hours reports mc's state at now.
+*/
+func (mc *MarketCalendar) hours(now time.Time) MarketHours {
+    close := mc.NextClose(now)
+    mh := MarketHours{Calendar: mc.Name, Timezone: mc.loc.String(), Open: mc.IsOpen(now), NextClose: &close}
+    if !mh.Open {
+        next := mc.NextOpen(now)
+        mh.NextOpen = &next
+    }
+    return mh
+}
+
+/*
+handleMarketHours exposes GET /api/market/hours, whether each calendar's
+market is open with its next open and close and the tracked symbols on it.
+Crypto pairs are listed under an always-open "24/7" entry.
+*/
+func (fp *FinancialProcessor) handleMarketHours(w http.ResponseWriter, r *http.Request) {
+    now := fp.clock.Now()
+    byCal := make(map[string][]string)
+    var always []string
+    for _, sym := range fp.trackedSymbols() {
+        if mc := fp.calendars.For(sym); mc != nil {
+            byCal[mc.Name] = append(byCal[mc.Name], sym)
+        } else {
+            always = append(always, sym)
+        }
+    }
+    out := []MarketHours{}
+    for name, mc := range fp.calendars.calendars {
+        mh := mc.hours(now)
+        mh.Symbols = byCal[name]
+        out = append(out, mh)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Calendar < out[j].Calendar })
+    if len(always) > 0 {
+        out = append(out, MarketHours{Calendar: "24/7", Timezone: "UTC", Open: true, Symbols: always})
+    }
+    json.NewEncoder(w).Encode(out)
+}
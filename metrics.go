@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Dependency names used when recording outbound call latency.
+*/
+const (
+    depYahoo = "yahoo"
+    depML    = "ml"
+)
+
+/*
+latencyQuantiles are the percentiles reported for every dependency.
+*/
+var latencyQuantiles = []float64{0.50, 0.95, 0.99}
+
+/*
+LatencyTracker keeps a fixed-size window of recent call durations for one
+dependency, from which percentiles are computed on demand.
+*/
+type LatencyTracker struct {
+    mu      sync.Mutex
+    samples []time.Duration
+    next    int
+    full    bool
+    count   uint64
+    errors  uint64
+}
+
+/*
+LatencySnapshot is a point-in-time summary of a LatencyTracker.
+*/
+type LatencySnapshot struct {
+    P50Ms  float64 `json:"p50_ms"`
+    P95Ms  float64 `json:"p95_ms"`
+    P99Ms  float64 `json:"p99_ms"`
+    Window int     `json:"window"`
+    Count  uint64  `json:"count"`
+    Errors uint64  `json:"errors"`
+}
+
+/*
+NewLatencyTracker creates a tracker that retains the last size samples; size
+must be at least 1.
+*/
+func NewLatencyTracker(size int) *LatencyTracker {
+    return &LatencyTracker{samples: make([]time.Duration, size)}
+}
+
+/*
+Observe records the duration of one call; failed calls are counted as errors
+but still contribute their latency.
+*/
+func (lt *LatencyTracker) Observe(d time.Duration, err error) {
+    lt.mu.Lock()
+    defer lt.mu.Unlock()
+    lt.samples[lt.next] = d
+    lt.next = (lt.next + 1) % len(lt.samples)
+    if lt.next == 0 {
+        lt.full = true
+    }
+    lt.count++
+    if err != nil {
+        lt.errors++
+    }
+}
+
+/*
+Quantile returns the q-th percentile (0 < q <= 1) of the retained samples.
+*/
+func (lt *LatencyTracker) Quantile(q float64) time.Duration {
+    lt.mu.Lock()
+    window := lt.window()
+    lt.mu.Unlock()
+    return quantileOf(window, q)
+}
+
+/*
+Snapshot summarizes the retained samples.
+*/
+func (lt *LatencyTracker) Snapshot() LatencySnapshot {
+    lt.mu.Lock()
+    window := lt.window()
+    snap := LatencySnapshot{Window: len(window), Count: lt.count, Errors: lt.errors}
+    lt.mu.Unlock()
+
+    snap.P50Ms = durationMs(quantileOf(window, 0.50))
+    snap.P95Ms = durationMs(quantileOf(window, 0.95))
+    snap.P99Ms = durationMs(quantileOf(window, 0.99))
+    return snap
+}
+
+/*
+window returns a copy of the retained samples. Callers must hold lt.mu.
+*/
+func (lt *LatencyTracker) window() []time.Duration {
+    n := lt.next
+    if lt.full {
+        n = len(lt.samples)
+    }
+    out := make([]time.Duration, n)
+    copy(out, lt.samples[:n])
+    return out
+}
+
+/*
+quantileOf returns the nearest-rank percentile of samples, or 0 when empty.
+*/
+func quantileOf(samples []time.Duration, q float64) time.Duration {
+    if len(samples) == 0 {
+        return 0
+    }
+    sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+    idx := int(q*float64(len(samples))+0.5) - 1
+    if idx < 0 {
+        idx = 0
+    }
+    if idx >= len(samples) {
+        idx = len(samples) - 1
+    }
+    return samples[idx]
+}
+
+/*
+durationMs converts a duration to fractional milliseconds for reporting.
+*/
+func durationMs(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
+
+/*
+SLOBreach describes a dependency percentile currently above its configured threshold.
+*/
+type SLOBreach struct {
+    Dependency  string    `json:"dependency"`
+    Quantile    string    `json:"quantile"`
+    ThresholdMs float64   `json:"threshold_ms"`
+    ObservedMs  float64   `json:"observed_ms"`
+    Since       time.Time `json:"since"`
+}
+
+/*
+DependencyMetrics tracks latency per outbound dependency and evaluates
+percentile SLO thresholds configured through SLO_<DEP>_P50/P95/P99
+environment variables, e.g. SLO_YAHOO_P95=2s or SLO_ML_P99=5s.
+*/
+type DependencyMetrics struct {
+    mu       sync.Mutex
+    window   int
+    trackers map[string]*LatencyTracker
+    slos     map[string]map[float64]time.Duration
+    breaches map[string]*SLOBreach
+}
+
+/*
+NewDependencyMetrics creates trackers for the given dependencies, each
+keeping the last LATENCY_WINDOW samples, and reads their SLO thresholds.
+*/
+func NewDependencyMetrics(deps ...string) *DependencyMetrics {
+    dm := &DependencyMetrics{
+        window:   envInt("LATENCY_WINDOW", 1000),
+        trackers: make(map[string]*LatencyTracker),
+        slos:     make(map[string]map[float64]time.Duration),
+        breaches: make(map[string]*SLOBreach),
+    }
+    if dm.window < 1 {
+        log.Fatalf("LATENCY_WINDOW must be at least 1, got %d", dm.window)
+    }
+    for _, dep := range deps {
+        dm.trackers[dep] = NewLatencyTracker(dm.window)
+        for _, q := range latencyQuantiles {
+            key := fmt.Sprintf("SLO_%s_%s", strings.ToUpper(dep), quantileLabel(q))
+            if os.Getenv(key) == "" {
+                continue
+            }
+            if d := envDuration(key, 0); d > 0 {
+                if dm.slos[dep] == nil {
+                    dm.slos[dep] = make(map[float64]time.Duration)
+                }
+                dm.slos[dep][q] = d
+            }
+        }
+    }
+    return dm
+}
+
+/*
+quantileLabel formats 0.95 as "P95".
+*/
+func quantileLabel(q float64) string {
+    return fmt.Sprintf("P%d", int(q*100+0.5))
+}
+
+/*
+Observe records one call to dep. Unknown dependencies get a tracker on first use.
+*/
+func (dm *DependencyMetrics) Observe(dep string, d time.Duration, err error) {
+    dm.mu.Lock()
+    lt, ok := dm.trackers[dep]
+    if !ok {
+        lt = NewLatencyTracker(dm.window)
+        dm.trackers[dep] = lt
+    }
+    dm.mu.Unlock()
+    lt.Observe(d, err)
+}
+
+/*
+Snapshots returns the current latency summary of every dependency.
+*/
+func (dm *DependencyMetrics) Snapshots() map[string]LatencySnapshot {
+    dm.mu.Lock()
+    defer dm.mu.Unlock()
+    out := make(map[string]LatencySnapshot, len(dm.trackers))
+    for dep, lt := range dm.trackers {
+        out[dep] = lt.Snapshot()
+    }
+    return out
+}
+
+/*
+Breaches returns the SLOs currently in breach, ordered by dependency and quantile.
+*/
+func (dm *DependencyMetrics) Breaches() []SLOBreach {
+    dm.mu.Lock()
+    defer dm.mu.Unlock()
+    out := make([]SLOBreach, 0, len(dm.breaches))
+    for _, b := range dm.breaches {
+        out = append(out, *b)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Dependency != out[j].Dependency {
+            return out[i].Dependency < out[j].Dependency
+        }
+        return out[i].Quantile < out[j].Quantile
+    })
+    return out
+}
+
+/*
+CheckSLOs compares each configured percentile against its threshold and logs a
+warning when an SLO enters or leaves breach.
+*/
+func (dm *DependencyMetrics) CheckSLOs(now time.Time) {
+    dm.mu.Lock()
+    defer dm.mu.Unlock()
+    for dep, thresholds := range dm.slos {
+        lt := dm.trackers[dep]
+        for q, limit := range thresholds {
+            label := quantileLabel(q)
+            observed := lt.Quantile(q)
+            key := dep + "/" + label
+            if observed > limit {
+                if b, ok := dm.breaches[key]; ok {
+                    b.ObservedMs = durationMs(observed)
+                    continue
+                }
+                dm.breaches[key] = &SLOBreach{
+                    Dependency:  dep,
+                    Quantile:    label,
+                    ThresholdMs: durationMs(limit),
+                    ObservedMs:  durationMs(observed),
+                    Since:       now,
+                }
+                slog.Warn("latency exceeds SLO", "dependency", dep, "quantile", label, "latency", observed, "slo", limit)
+            } else if _, ok := dm.breaches[key]; ok {
+                delete(dm.breaches, key)
+                slog.Info("latency back within SLO", "dependency", dep, "quantile", label, "latency", observed, "slo", limit)
+            }
+        }
+    }
+}
+
+/*
+monitorSLOs evaluates SLO thresholds every interval for the life of the process.
+*/
+func (dm *DependencyMetrics) monitorSLOs(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for now := range ticker.C {
+        dm.CheckSLOs(now)
+    }
+}
+
+/*
+handleMetrics serves dependency latency in the Prometheus text exposition format.
+*/
+func (fp *FinancialProcessor) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    snaps := fp.latency.Snapshots()
+    deps := make([]string, 0, len(snaps))
+    for dep := range snaps {
+        deps = append(deps, dep)
+    }
+    sort.Strings(deps)
+
+    breached := make(map[string]bool)
+    for _, b := range fp.latency.Breaches() {
+        breached[b.Dependency+"/"+b.Quantile] = true
+    }
+
+    var sb strings.Builder
+    sb.WriteString("# HELP dependency_latency_seconds Outbound call latency by dependency.\n")
+    sb.WriteString("# TYPE dependency_latency_seconds summary\n")
+    for _, dep := range deps {
+        s := snaps[dep]
+        for _, q := range []struct {
+            label string
+            ms    float64
+        }{{"0.5", s.P50Ms}, {"0.95", s.P95Ms}, {"0.99", s.P99Ms}} {
+            fmt.Fprintf(&sb, "dependency_latency_seconds{dependency=%q,quantile=%q} %g\n", dep, q.label, q.ms/1000)
+        }
+        fmt.Fprintf(&sb, "dependency_latency_seconds_count{dependency=%q} %d\n", dep, s.Count)
+    }
+    sb.WriteString("# HELP dependency_errors_total Failed outbound calls by dependency.\n")
+    sb.WriteString("# TYPE dependency_errors_total counter\n")
+    for _, dep := range deps {
+        fmt.Fprintf(&sb, "dependency_errors_total{dependency=%q} %d\n", dep, snaps[dep].Errors)
+    }
+    sb.WriteString("# HELP dependency_slo_breached Whether a latency SLO is currently breached.\n")
+    sb.WriteString("# TYPE dependency_slo_breached gauge\n")
+    for _, dep := range deps {
+        for _, q := range latencyQuantiles {
+            if _, ok := fp.latency.slos[dep][q]; !ok {
+                continue
+            }
+            label := quantileLabel(q)
+            v := 0
+            if breached[dep+"/"+label] {
+                v = 1
+            }
+            fmt.Fprintf(&sb, "dependency_slo_breached{dependency=%q,quantile=%q} %d\n", dep, label, v)
+        }
+    }
+    sb.WriteString("# HELP websocket_clients Connected /ws and /api/stream clients.\n")
+    sb.WriteString("# TYPE websocket_clients gauge\n")
+    fmt.Fprintf(&sb, "websocket_clients %d\n", fp.events.Subscribers())
+    sb.WriteString("# HELP websocket_events_dropped_total Events not delivered to clients that fell behind.\n")
+    sb.WriteString("# TYPE websocket_events_dropped_total counter\n")
+    fmt.Fprintf(&sb, "websocket_events_dropped_total %d\n", fp.events.dropped.Load())
+    fp.sched.writeMetrics(&sb)
+    fp.pool.writeMetrics(&sb)
+    fp.recycler.writeMetrics(&sb)
+    fp.clusters.writeMetrics(&sb)
+    fp.leader.writeMetrics(&sb)
+    fp.shards.writeMetrics(&sb)
+    fp.alerts.writeMetrics(&sb)
+    fp.auth.writeMetrics(&sb)
+    fp.predSLO.writeMetrics(&sb)
+    fp.scrapes.writeMetrics(&sb)
+    fp.snapshots.writeMetrics(&sb)
+    fp.indCache.writeMetrics(&sb)
+    fp.quarantine.writeMetrics(&sb)
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+migrationFiles holds the versioned schema migrations shipped with the binary.
+Each version N has an NNNN_name.up.sql and a matching NNNN_name.down.sql.
+*/
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+/*
+Migration is one versioned schema change with its forward and reverse SQL.
+*/
+type Migration struct {
+    Version int
+    Name    string
+    Up      string
+    Down    string
+}
+
+/*
+loadMigrations parses the embedded migration files, ordered by version.
+*/
+func loadMigrations() ([]Migration, error) {
+    entries, err := fs.ReadDir(migrationFiles, "migrations")
+    if err != nil {
+        return nil, err
+    }
+    byVersion := make(map[int]*Migration)
+    for _, e := range entries {
+        name := e.Name()
+        var direction string
+        switch {
+        case strings.HasSuffix(name, ".up.sql"):
+            direction = "up"
+        case strings.HasSuffix(name, ".down.sql"):
+            direction = "down"
+        default:
+            continue
+        }
+        base := strings.TrimSuffix(name, "."+direction+".sql")
+        prefix, label, ok := strings.Cut(base, "_")
+        if !ok {
+            return nil, fmt.Errorf("migration %s: expected NNNN_name.%s.sql", name, direction)
+        }
+        version, err := strconv.Atoi(prefix)
+        if err != nil {
+            return nil, fmt.Errorf("migration %s: bad version: %w", name, err)
+        }
+        body, err := migrationFiles.ReadFile("migrations/" + name)
+        if err != nil {
+            return nil, err
+        }
+        m := byVersion[version]
+        if m == nil {
+            m = &Migration{Version: version, Name: label}
+            byVersion[version] = m
+        }
+        if direction == "up" {
+            m.Up = string(body)
+        } else {
+            m.Down = string(body)
+        }
+    }
+
+    out := make([]Migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == "" || m.Down == "" {
+            return nil, fmt.Errorf("migration %04d_%s is missing its up or down file", m.Version, m.Name)
+        }
+        out = append(out, *m)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+    return out, nil
+}
+
+/*
+openStorageDB opens the database configured by STORAGE_DRIVER ("sqlite" or
+"postgres", default "sqlite") and STORAGE_DSN. It returns nil when no DSN is set.
+*/
+func openStorageDB() (*sql.DB, string, error) {
+    dsn := os.Getenv("STORAGE_DSN")
+    if dsn == "" {
+        return nil, "", nil
+    }
+    driver := os.Getenv("STORAGE_DRIVER")
+    if driver == "" {
+        driver = "sqlite"
+    }
+    var sqlDriver string
+    switch driver {
+    case "sqlite":
+        sqlDriver = "sqlite3"
+    case "postgres":
+        sqlDriver = "postgres"
+    default:
+        return nil, "", fmt.Errorf("unsupported STORAGE_DRIVER %q", driver)
+    }
+    db, err := sql.Open(sqlDriver, dsn)
+    if err != nil {
+        return nil, "", err
+    }
+    if err := db.Ping(); err != nil {
+        db.Close()
+        return nil, "", err
+    }
+    return db, driver, nil
+}
+
+/*
+rebind rewrites "?" placeholders to "$1", "$2", ... for PostgreSQL.
+*/
+func rebind(driver, query string) string {
+    if driver != "postgres" {
+        return query
+    }
+    var sb strings.Builder
+    n := 0
+    for _, r := range query {
+        if r == '?' {
+            n++
+            sb.WriteString("$" + strconv.Itoa(n))
+            continue
+        }
+        sb.WriteRune(r)
+    }
+    return sb.String()
+}
+
+/*
+Migrator applies and reverts embedded migrations, recording applied versions
+in the schema_migrations table.
+*/
+type Migrator struct {
+    db         *sql.DB
+    driver     string
+    migrations []Migration
+}
+
+/*
+NewMigrator loads the embedded migrations and ensures the schema_migrations table exists.
+*/
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+    migrations, err := loadMigrations()
+    if err != nil {
+        return nil, err
+    }
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+        version    INTEGER PRIMARY KEY,
+        name       TEXT NOT NULL,
+        applied_at BIGINT NOT NULL
+    )`)
+    if err != nil {
+        return nil, fmt.Errorf("create schema_migrations: %w", err)
+    }
+    return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+/*
+Current returns the highest applied migration version, or 0 for an empty schema.
+*/
+func (m *Migrator) Current() (int, error) {
+    var v sql.NullInt64
+    if err := m.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+        return 0, err
+    }
+    return int(v.Int64), nil
+}
+
+/*
+Latest returns the highest version shipped with this binary.
+*/
+func (m *Migrator) Latest() int {
+    if len(m.migrations) == 0 {
+        return 0
+    }
+    return m.migrations[len(m.migrations)-1].Version
+}
+
+/*
+Up applies every pending migration up to and including target, each in its
+own transaction. A target of 0 means the latest version.
+*/
+func (m *Migrator) Up(target int) error {
+    current, err := m.Current()
+    if err != nil {
+        return err
+    }
+    if current > m.Latest() {
+        return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, m.Latest())
+    }
+    if target == 0 {
+        target = m.Latest()
+    }
+    for _, mig := range m.migrations {
+        if mig.Version <= current || mig.Version > target {
+            continue
+        }
+        err := m.apply(mig.Up, func(tx *sql.Tx) error {
+            _, err := tx.Exec(rebind(m.driver, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+                mig.Version, mig.Name, time.Now().Unix())
+            return err
+        })
+        if err != nil {
+            return fmt.Errorf("migration %04d_%s up: %w", mig.Version, mig.Name, err)
+        }
+        slog.Info("applied migration", "version", mig.Version, "name", mig.Name)
+    }
+    return nil
+}
+
+/*
+Down reverts the most recently applied steps migrations.
+*/
+func (m *Migrator) Down(steps int) error {
+    for i := 0; i < steps; i++ {
+        current, err := m.Current()
+        if err != nil {
+            return err
+        }
+        if current == 0 {
+            return nil
+        }
+        var mig *Migration
+        for j := range m.migrations {
+            if m.migrations[j].Version == current {
+                mig = &m.migrations[j]
+            }
+        }
+        if mig == nil {
+            return fmt.Errorf("applied migration %d is not known to this binary", current)
+        }
+        err = m.apply(mig.Down, func(tx *sql.Tx) error {
+            _, err := tx.Exec(rebind(m.driver, `DELETE FROM schema_migrations WHERE version = ?`), mig.Version)
+            return err
+        })
+        if err != nil {
+            return fmt.Errorf("migration %04d_%s down: %w", mig.Version, mig.Name, err)
+        }
+        slog.Info("reverted migration", "version", mig.Version, "name", mig.Name)
+    }
+    return nil
+}
+
+/*
+apply runs script and record inside a single transaction.
+*/
+func (m *Migrator) apply(script string, record func(*sql.Tx) error) error {
+    tx, err := m.db.Begin()
+    if err != nil {
+        return err
+    }
+    if _, err := tx.Exec(script); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := record(tx); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+/*
+autoMigrate brings the configured storage schema up to date at startup unless
+STORAGE_AUTO_MIGRATE=false. It is a no-op when no storage is configured and
+for Redis, which has no schema.
+*/
+func autoMigrate() error {
+    if os.Getenv("STORAGE_AUTO_MIGRATE") == "false" || os.Getenv("STORAGE_DRIVER") == "redis" {
+        return nil
+    }
+    db, driver, err := openStorageDB()
+    if err != nil || db == nil {
+        return err
+    }
+    defer db.Close()
+    m, err := NewMigrator(db, driver)
+    if err != nil {
+        return err
+    }
+    return m.Up(0)
+}
+
+/*
+runMigrate implements the `migrate` subcommand:
+
+    migrate up [-to N]     apply pending migrations (optionally stopping at N)
+    migrate down [-steps N] revert the last N migrations (default 1)
+    migrate status         print the current and latest versions
+*/
+func runMigrate(args []string) error {
+    if len(args) == 0 {
+        return errors.New("usage: migrate up|down|status [flags]")
+    }
+    fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+    to := fs.Int("to", 0, "target version for up (0 = latest)")
+    steps := fs.Int("steps", 1, "number of migrations to revert for down")
+    if err := fs.Parse(args[1:]); err != nil {
+        return err
+    }
+
+    db, driver, err := openStorageDB()
+    if err != nil {
+        return err
+    }
+    if db == nil {
+        return errors.New("STORAGE_DSN is not set")
+    }
+    defer db.Close()
+    m, err := NewMigrator(db, driver)
+    if err != nil {
+        return err
+    }
+
+    switch args[0] {
+    case "up":
+        return m.Up(*to)
+    case "down":
+        return m.Down(*steps)
+    case "status":
+        current, err := m.Current()
+        if err != nil {
+            return err
+        }
+        fmt.Printf("current version: %d\nlatest version:  %d\n", current, m.Latest())
+        for _, mig := range m.migrations {
+            state := "pending"
+            if mig.Version <= current {
+                state = "applied"
+            }
+            fmt.Printf("  %04d_%s\t%s\n", mig.Version, mig.Name, state)
+        }
+        return nil
+    }
+    return fmt.Errorf("unknown migrate command %q", args[0])
+}
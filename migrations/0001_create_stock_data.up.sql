@@ -0,0 +1,7 @@
+CREATE TABLE stock_data (
+    symbol TEXT NOT NULL,
+    price  DOUBLE PRECISION NOT NULL,
+    volume BIGINT NOT NULL,
+    ts     BIGINT NOT NULL,
+    PRIMARY KEY (symbol, ts)
+);
@@ -0,0 +1,2 @@
+DROP TABLE accuracy_daily;
+DROP TABLE bars;
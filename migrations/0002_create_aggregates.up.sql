@@ -0,0 +1,46 @@
+CREATE TABLE bars (
+    symbol     TEXT NOT NULL,
+    resolution TEXT NOT NULL,
+    ts         BIGINT NOT NULL,
+    open       DOUBLE PRECISION NOT NULL,
+    high       DOUBLE PRECISION NOT NULL,
+    low        DOUBLE PRECISION NOT NULL,
+    close      DOUBLE PRECISION NOT NULL,
+    volume     BIGINT NOT NULL,
+    ticks      BIGINT NOT NULL,
+    open_ts    BIGINT NOT NULL,
+    close_ts   BIGINT NOT NULL,
+    PRIMARY KEY (symbol, resolution, ts)
+);
+
+CREATE TABLE accuracy_daily (
+    symbol             TEXT NOT NULL,
+    horizon            TEXT NOT NULL,
+    day                BIGINT NOT NULL,
+    samples            BIGINT NOT NULL,
+    abs_error          DOUBLE PRECISION NOT NULL,
+    squared_error      DOUBLE PRECISION NOT NULL,
+    baseline_abs_error DOUBLE PRECISION NOT NULL,
+    direction_hits     BIGINT NOT NULL,
+    PRIMARY KEY (symbol, horizon, day)
+);
+
+INSERT INTO bars (symbol, resolution, ts, open, high, low, close, volume, ticks, open_ts, close_ts)
+SELECT symbol, resolution, bucket, MIN(first_price), MAX(price), MIN(price), MIN(last_price),
+       SUM(traded), COUNT(*), MIN(ts), MAX(ts)
+FROM (
+    SELECT symbol, resolution, bucket, ts, price,
+           FIRST_VALUE(price) OVER (PARTITION BY symbol, resolution, bucket ORDER BY ts) AS first_price,
+           FIRST_VALUE(price) OVER (PARTITION BY symbol, resolution, bucket ORDER BY ts DESC) AS last_price,
+           CASE WHEN prev IS NULL THEN 0 WHEN volume < prev THEN volume ELSE volume - prev END AS traded
+    FROM (
+        SELECT t.symbol, r.resolution, t.ts - t.ts % r.width AS bucket, t.ts, t.price, t.volume,
+               LAG(t.volume) OVER (PARTITION BY t.symbol, r.resolution ORDER BY t.ts) AS prev
+        FROM stock_data t, (
+            SELECT '5m' AS resolution, 300000000000 AS width
+            UNION ALL SELECT '1h', 3600000000000
+            UNION ALL SELECT '1d', 86400000000000
+        ) r
+    ) lagged
+) windowed
+GROUP BY symbol, resolution, bucket;
@@ -0,0 +1,6 @@
+ALTER TABLE stock_data DROP COLUMN ex_dividend_date;
+ALTER TABLE stock_data DROP COLUMN earnings_date;
+ALTER TABLE stock_data DROP COLUMN previous_close;
+ALTER TABLE stock_data DROP COLUMN low;
+ALTER TABLE stock_data DROP COLUMN high;
+ALTER TABLE stock_data DROP COLUMN open;
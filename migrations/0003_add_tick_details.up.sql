@@ -0,0 +1,6 @@
+ALTER TABLE stock_data ADD COLUMN open DOUBLE PRECISION;
+ALTER TABLE stock_data ADD COLUMN high DOUBLE PRECISION;
+ALTER TABLE stock_data ADD COLUMN low DOUBLE PRECISION;
+ALTER TABLE stock_data ADD COLUMN previous_close DOUBLE PRECISION;
+ALTER TABLE stock_data ADD COLUMN earnings_date BIGINT;
+ALTER TABLE stock_data ADD COLUMN ex_dividend_date BIGINT;
@@ -3,9 +3,12 @@
 ml_service.py
 
 This module implements a Flask-based microservice for training and predicting stock prices.
-It exposes two endpoints:
+It exposes these endpoints:
   1. POST /predict   - Train or predict using incoming stock data
-  2. GET  /data/<symbol> - Retrieve stored historical data for a given symbol
+  2. POST /predict_batch - Answer several /predict requests in one call
+  3. GET  /data/<symbol> - Retrieve stored historical data for a given symbol
+  4. POST /retrain   - Retrain models from residual records exported by the Go service
+  5. GET  /ready     - Report readiness, loaded models and the API schema version
 
 The service maintains in-memory stores for models and raw data. A background thread
 periodically retrains models on accumulated data.
@@ -23,7 +26,11 @@ from sklearn.exceptions import NotFittedError
 
 
 import os
-from datetime import datetime
+import json
+import hmac
+import hashlib
+import ssl
+from datetime import datetime, timezone
 import time
 import threading
 
@@ -35,19 +42,100 @@ CORS(app)
 models = {}
 data_store = {}
 
+HMAC_SECRET = os.environ.get('ML_HMAC_SECRET', '').encode()
+HMAC_MAX_SKEW = int(os.environ.get('ML_HMAC_MAX_SKEW', 300))
+
+# Version of the /predict request and response format. The Go service refuses
+# to send predictions to a service reporting a different version.
+SCHEMA_VERSION = 1
+
+warmed_up = threading.Event()
+
+
+@app.before_request
+def verify_signature():
+    """
+    When ML_HMAC_SECRET is set, reject requests whose X-Signature header is not
+    the hex HMAC-SHA256 of "<X-Signature-Timestamp>.<body>", or whose timestamp
+    is more than ML_HMAC_MAX_SKEW seconds (default 300) away from now.
+    """
+    if not HMAC_SECRET:
+        return None
+    ts = request.headers.get('X-Signature-Timestamp', '')
+    signature = request.headers.get('X-Signature', '')
+    try:
+        skew = abs(time.time() - int(ts))
+    except ValueError:
+        return jsonify({"error": "missing or invalid signature timestamp"}), 401
+    if skew > HMAC_MAX_SKEW:
+        return jsonify({"error": "signature timestamp outside allowed skew"}), 401
+    expected = hmac.new(HMAC_SECRET, ts.encode() + b"." + request.get_data(), hashlib.sha256).hexdigest()
+    if not hmac.compare_digest(expected, signature):
+        return jsonify({"error": "invalid signature"}), 401
+    return None
+
+BASE_FEATURES = ['price', 'volume', 'SMA_5', 'price_change_1d']
+
+
+def with_indicators(stock_data, indicators):
+    """
+    Copy the indicator series sent by the Go service (aligned with stock_data,
+    null where undefined) onto each row as extra ind_<name> columns.
+    """
+    if not indicators:
+        return stock_data
+    rows = [dict(row) for row in stock_data]
+    for name, series in indicators.items():
+        for row, value in zip(rows, series):
+            row[f"ind_{name}"] = value
+    return rows
+
+
+def with_interpolation_mask(stock_data, mask):
+    """
+    Mark the rows the Go service made up to fill small gaps (mask is aligned
+    with stock_data) with an ind_interpolated column of 1.0, real rows 0.0,
+    so the model can learn to weigh them differently.
+    """
+    if not mask:
+        return stock_data
+    rows = [dict(row) for row in stock_data]
+    for row, synthetic in zip(rows, mask):
+        row["ind_interpolated"] = 1.0 if synthetic else 0.0
+    return rows
+
+
+def with_days_to_earnings(stock_data, days):
+    """
+    Copy the days from each row to the symbol's next earnings date, sent by
+    the Go service aligned with stock_data, onto the rows as an
+    ind_days_to_earnings column. Rows without a known date get -1.0 rather
+    than being dropped from training.
+    """
+    if not days:
+        return stock_data
+    rows = [dict(row) for row in stock_data]
+    for row, value in zip(rows, days):
+        row["ind_days_to_earnings"] = -1.0 if value is None else float(value)
+    return rows
+
+
 class StockPriceModel:
     """
     Encapsulates a RandomForestRegressor for a given stock symbol,
     along with a StandardScaler for feature normalization.
     """
 
-    def __init__(self, symbol):
+    def __init__(self, symbol, horizon_steps=1):
         """
-        Initialize model and scaler for the given symbol.
+        Initialize model and scaler for the given symbol, predicting the price
+        horizon_steps observations ahead.
         """
         self.symbol = symbol
+        self.horizon_steps = horizon_steps
         self.model = RandomForestRegressor(n_estimators=100, random_state=42)
         self.scaler = StandardScaler()
+        self.features = list(BASE_FEATURES)
 
     def _prepare_features(self, df):
         """
@@ -59,19 +147,20 @@ class StockPriceModel:
           - volume
           - SMA_5 (5-period simple moving average)
           - price_change_1d (1-day percent change)
+          - any ind_* indicator columns supplied with the data
 
         Target:
-          - next day's price
+          - the price horizon_steps observations later (the next one by default)
 
         Returns:
           X (DataFrame of features), y (Series of targets)
         """
         df['SMA_5'] = df['price'].rolling(window=5).mean()
         df['price_change_1d'] = df['price'].pct_change(1)
-        df['target'] = df['price'].shift(-1)
-        df = df.dropna()
-        features = ['price', 'volume', 'SMA_5', 'price_change_1d']
-        return df[features], df['target']
+        df['target'] = df['price'].shift(-self.horizon_steps)
+        self.features = BASE_FEATURES + sorted(c for c in df.columns if c.startswith('ind_'))
+        df = df.dropna(subset=self.features + ['target'])
+        return df[self.features], df['target']
 
     def train(self, historical_data):
         """
@@ -110,7 +199,13 @@ class StockPriceModel:
         df['timestamp'] = pd.to_datetime(df['timestamp'])
         df = df.sort_values('timestamp')
 
-        X, _ = self._prepare_features(df)
+        # Prediction only needs features for the last row, not a target.
+        df['SMA_5'] = df['price'].rolling(window=5).mean()
+        df['price_change_1d'] = df['price'].pct_change(1)
+        missing = [c for c in self.features if c not in df.columns]
+        if missing:
+            return {"error": f"Missing features: {', '.join(missing)}"}
+        X = df[self.features].dropna()
         if X.empty:
             return {"error": "Not enough data for prediction"}
 
@@ -128,7 +223,7 @@ class StockPriceModel:
             "predicted_price": prediction,
             "predicted_change": prediction - current_price,
             "predicted_change_percent": (prediction - current_price) / current_price * 100,
-            "timestamp": datetime.now().isoformat()
+            "timestamp": datetime.now(timezone.utc).isoformat()
         }
 
 def background_training():
@@ -148,22 +243,96 @@ def background_training():
 
 threading.Thread(target=background_training, daemon=True).start()
 
+def warm_up():
+    """
+    Preload models before reporting ready. When ML_PRELOAD_FILE names a residual
+    export from the Go service (JSON lines or a JSON array), one model per
+    symbol is trained from it; a missing or unreadable file is logged and skipped.
+    """
+    preload = os.environ.get('ML_PRELOAD_FILE')
+    if preload:
+        try:
+            with open(preload) as f:
+                body = f.read().strip()
+            if body.startswith('['):
+                records = json.loads(body)
+            else:
+                records = [json.loads(line) for line in body.splitlines() if line.strip()]
+            for symbol, history in history_from_residuals(records).items():
+                candidate = StockPriceModel(symbol)
+                if "error" not in candidate.train(history):
+                    models[symbol] = candidate
+                    data_store[symbol] = history
+            print(f"Preloaded {len(models)} model(s) from {preload}")
+        except (OSError, ValueError, KeyError) as e:
+            print(f"Skipping model preload from {preload}: {e}")
+    warmed_up.set()
+
 @app.route('/predict', methods=['POST'])
 def predict_endpoint():
     """
     POST /predict
-    Body JSON: { "symbol": <symbol>, "data": [ {symbol, price, volume, timestamp}, ... ] }
+    Body JSON: { "symbol": <symbol>, "data": [ {symbol, price, volume, timestamp}, ... ],
+                 "horizon_seconds": <optional>, "indicators": <optional>,
+                 "interpolated": <optional>, "days_to_earnings": <optional> }
 
     - Stores incoming data in data_store.
     - If no model exists, attempts initial training.
     - Returns prediction or pending status if still training.
+
+    With horizon_seconds the prediction targets that far ahead instead of the
+    next observation, using a separate model per horizon; the horizon is
+    converted to a number of observations from the median spacing of data.
+    """
+    result, status = predict_one(request.json or {})
+    return jsonify(result), status
+
+@app.route('/predict_batch', methods=['POST'])
+def predict_batch_endpoint():
+    """
+    POST /predict_batch
+    Body JSON: { "requests": [ <a /predict body>, ... ] }
+
+    Answers every request as /predict would and returns
+    { "results": [ <a /predict reply>, ... ] } in the same order. A request
+    that /predict would reject gets a result with an "error" field instead
+    of failing the whole batch.
     """
     payload = request.json or {}
+    batch = payload.get('requests')
+    if not isinstance(batch, list):
+        return jsonify({"error": "requests list required"}), 400
+    results = [predict_one(p if isinstance(p, dict) else {})[0] for p in batch]
+    return jsonify({"results": results})
+
+def predict_one(payload):
+    """
+    Answer a single /predict request body, returning the reply and its HTTP
+    status.
+    """
     symbol = payload.get('symbol')
     stock_data = payload.get('data')
     if not symbol or not stock_data:
-        return jsonify({"error": "Symbol and data required"}), 400
-
+        return {"error": "Symbol and data required"}, 400
+    stock_data = with_indicators(stock_data, payload.get('indicators'))
+    stock_data = with_interpolation_mask(stock_data, payload.get('interpolated'))
+    stock_data = with_days_to_earnings(stock_data, payload.get('days_to_earnings'))
+
+    horizon_seconds = payload.get('horizon_seconds')
+    if horizon_seconds:
+        steps = horizon_steps(stock_data, float(horizon_seconds))
+        if steps is None:
+            return {"error": "Not enough history for this horizon",
+                    "status": "insufficient_history"}, 200
+        candidate = StockPriceModel(symbol, steps)
+        result = candidate.train(stock_data)
+        if "error" in result:
+            return {"error": result["error"], "status": "pending_training"}, 200
+        models[f"{symbol}@{steps}"] = candidate
+        prediction = candidate.predict(stock_data)
+        if "error" not in prediction:
+            prediction["horizon_steps"] = steps
+        return prediction, 200
 
     data_store[symbol] = stock_data
 
@@ -172,14 +341,94 @@ def predict_endpoint():
         candidate = StockPriceModel(symbol)
         result = candidate.train(stock_data)
         if "error" in result:
-            return jsonify({"error": result["error"], "status": "pending_training"}), 200
+            return {"error": result["error"], "status": "pending_training"}, 200
         models[symbol] = candidate
 
    
-    prediction = models[symbol].predict(stock_data)
-    if "error" in prediction:
-        return jsonify(prediction), 200
-    return jsonify(prediction)
+    return models[symbol].predict(stock_data), 200
+
+def horizon_steps(stock_data, horizon_seconds):
+    """
+    Convert a horizon in seconds into a number of observations using the median
+    spacing of stock_data. Returns None when the history is too short to train
+    a model that far ahead (at least 10 training rows must remain).
+    """
+    times = pd.to_datetime(pd.Series([d['timestamp'] for d in stock_data])).sort_values()
+    spacing = times.diff().dt.total_seconds().median()
+    if not spacing or spacing <= 0 or pd.isna(spacing):
+        return None
+    steps = max(1, int(round(horizon_seconds / spacing)))
+    if len(stock_data) - 4 - steps < 10:
+        return None
+    return steps
+
+def history_from_residuals(records):
+    """
+    Rebuild per-symbol training histories from residual records, each of the form
+    { symbol, data: [...], actual_price, realized_at, ... } as emitted by
+    GET /api/export/residuals. The realized outcome of every record is appended
+    as the next point after its input window, and duplicates are dropped by timestamp.
+
+    Returns a dict mapping symbol to a list of {symbol, price, volume, timestamp}.
+    """
+    histories = {}
+    for rec in records:
+        symbol = rec.get('symbol')
+        window = rec.get('data') or []
+        if not symbol or not window:
+            continue
+        points = histories.setdefault(symbol, {})
+        for point in window:
+            points[point['timestamp']] = point
+        points[rec['realized_at']] = {
+            "symbol": symbol,
+            "price": rec['actual_price'],
+            "volume": window[-1].get('volume', 0),
+            "timestamp": rec['realized_at'],
+        }
+    return {
+        symbol: sorted(points.values(), key=lambda p: pd.to_datetime(p['timestamp']))
+        for symbol, points in histories.items()
+    }
+
+@app.route('/retrain', methods=['POST'])
+def retrain_endpoint():
+    """
+    POST /retrain
+    Body: newline-delimited JSON residual records (or a JSON array of them),
+    as exported by the Go service at /api/export/residuals.
+
+    Retrains one model per symbol on the reconstructed history and returns
+    the training result for each symbol.
+    """
+    body = request.get_data(as_text=True).strip()
+    if not body:
+        return jsonify({"error": "No residual records supplied"}), 400
+    if body.startswith('['):
+        records = json.loads(body)
+    else:
+        records = [json.loads(line) for line in body.splitlines() if line.strip()]
+
+    results = {}
+    for symbol, history in history_from_residuals(records).items():
+        candidate = StockPriceModel(symbol)
+        result = candidate.train(history)
+        if "error" not in result:
+            models[symbol] = candidate
+            data_store[symbol] = history
+        results[symbol] = result
+    return jsonify(results)
+
+@app.route('/ready', methods=['GET'])
+def ready_endpoint():
+    """
+    GET /ready
+    Returns 200 with { ready, schema_version, models } once warm-up has finished,
+    and 503 with ready false while models are still being preloaded.
+    """
+    body = {"ready": warmed_up.is_set(), "schema_version": SCHEMA_VERSION,
+            "models": sorted(models)}
+    return jsonify(body), (200 if body["ready"] else 503)
 
 @app.route('/data/<symbol>', methods=['GET'])
 def get_data(symbol):
@@ -191,10 +440,81 @@ def get_data(symbol):
         return jsonify({"error": "No data for this symbol"}), 404
     return jsonify(data_store[symbol])
 
+def process_batch_dir(batch_dir):
+    """
+    Answer every pending request file in <batch_dir>/requests by dispatching it
+    through the Flask routes in-process, writing the reply atomically to
+    <batch_dir>/responses/<id>.json. Returns the number of requests handled.
+    """
+    requests_dir = os.path.join(batch_dir, 'requests')
+    responses_dir = os.path.join(batch_dir, 'responses')
+    os.makedirs(requests_dir, exist_ok=True)
+    os.makedirs(responses_dir, exist_ok=True)
+    client = app.test_client()
+    handled = 0
+    for name in sorted(os.listdir(requests_dir)):
+        if not name.endswith('.json'):
+            continue
+        path = os.path.join(requests_dir, name)
+        try:
+            os.rename(path, path + '.processing')
+        except OSError:
+            continue  # withdrawn by the Go service or claimed by another worker
+        with open(path + '.processing') as f:
+            envelope = json.load(f)
+        body = b'' if envelope.get('body') is None else json.dumps(envelope.get('body')).encode()
+        resp = client.open(envelope.get('path', '/predict'), method=envelope.get('method', 'POST'),
+                           data=body, headers=envelope.get('headers') or {},
+                           content_type='application/json')
+        reply = {"id": envelope['id'], "status": resp.status_code,
+                 "body": resp.get_json(silent=True)}
+        out = os.path.join(responses_dir, envelope['id'] + '.json')
+        with open(out + '.tmp', 'w') as f:
+            json.dump(reply, f)
+        os.replace(out + '.tmp', out)
+        os.remove(path + '.processing')
+        handled += 1
+    return handled
+
+
 if __name__ == '__main__':
     """
     Entry point for the Flask app.
-    Uses the ML_PORT environment variable (default 5001).
+    Uses the ML_PORT environment variable (default 5001), and serves over TLS
+    when ML_TLS_CERT_FILE and ML_TLS_KEY_FILE are set. With ML_BATCH_DIR set it
+    runs without any network listener instead: it answers the request files
+    written by the Go service's filesystem transport and exits, or keeps
+    polling every ML_BATCH_POLL seconds when ML_BATCH_WATCH=true. NAMESPACE
+    selects the same per-deployment subdirectory the Go service uses. Models
+    in ML_PRELOAD_FILE are loaded first; /ready answers 503 until they are.
     """
+    batch_dir = os.environ.get('ML_BATCH_DIR')
+    if batch_dir and os.environ.get('NAMESPACE'):
+        batch_dir = os.path.join(batch_dir, os.environ['NAMESPACE'])
+    if batch_dir:
+        warm_up()
+        watch = os.environ.get('ML_BATCH_WATCH') == 'true'
+        poll = float(os.environ.get('ML_BATCH_POLL', 1))
+        while True:
+            handled = process_batch_dir(batch_dir)
+            if handled:
+                print(f"Answered {handled} batch request(s)")
+            if not watch:
+                break
+            time.sleep(poll)
+        raise SystemExit(0)
+
+    threading.Thread(target=warm_up, daemon=True).start()
     port = int(os.environ.get('ML_PORT', 5001))
-    app.run(host='0.0.0.0', port=port, debug=True)
+    ssl_context = None
+    cert_file = os.environ.get('ML_TLS_CERT_FILE')
+    key_file = os.environ.get('ML_TLS_KEY_FILE')
+    if cert_file and key_file:
+        # Serve over TLS; with ML_TLS_CLIENT_CA_FILE, require client certificates (mutual TLS).
+        ssl_context = ssl.SSLContext(ssl.PROTOCOL_TLS_SERVER)
+        ssl_context.load_cert_chain(cert_file, key_file)
+        client_ca = os.environ.get('ML_TLS_CLIENT_CA_FILE')
+        if client_ca:
+            ssl_context.load_verify_locations(client_ca)
+            ssl_context.verify_mode = ssl.CERT_REQUIRED
+    app.run(host='0.0.0.0', port=port, debug=True, ssl_context=ssl_context)
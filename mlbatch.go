@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+PredictionBatcher collects the symbols due for prediction and sends them to
+their ML service together as one /predict_batch call, at most
+PREDICT_BATCH_INTERVAL after the first of them became due or as soon as
+PREDICT_BATCH_SIZE (default 50) are waiting. A symbol that becomes due again
+before its batch is sent is predicted once, from its latest history. ML
+services without /predict_batch are sent one /predict call per symbol as
+before.
+*/
+type PredictionBatcher struct {
+    fp          *FinancialProcessor
+    interval    time.Duration
+    size        int
+    mu          sync.Mutex
+    queues      map[string][]*predictionJob
+    unsupported map[string]bool
+}
+
+/*
+predictBatchRequest is the body of a /predict_batch call.
+*/
+type predictBatchRequest struct {
+    Requests []json.RawMessage `json:"requests"`
+}
+
+/*
+predictBatchResponse is the ML service's answer to a /predict_batch call,
+one result per request in the same order.
+*/
+type predictBatchResponse struct {
+    Results []mlPrediction `json:"results"`
+}
+
+/*
+errBatchUnsupported reports an ML service that has no /predict_batch endpoint.
+*/
+var errBatchUnsupported = errors.New("/predict_batch not supported")
+
+/*
+NewPredictionBatcherFromEnv returns a batcher for fp when
+PREDICT_BATCH_INTERVAL is set, and nil otherwise.
+*/
+func NewPredictionBatcherFromEnv(fp *FinancialProcessor) *PredictionBatcher {
+    interval := envDuration("PREDICT_BATCH_INTERVAL", 0)
+    if interval <= 0 {
+        return nil
+    }
+    pb := &PredictionBatcher{
+        fp:          fp,
+        interval:    interval,
+        size:        envInt("PREDICT_BATCH_SIZE", 50),
+        queues:      make(map[string][]*predictionJob),
+        unsupported: make(map[string]bool),
+    }
+    if pb.size < 1 {
+        log.Fatalf("PREDICT_BATCH_SIZE must be at least 1, got %d", pb.size)
+    }
+    return pb
+}
+
+/*
+Add queues job for its route's next batch, replacing any job for the same
+symbol that is still waiting.
+*/
+func (pb *PredictionBatcher) Add(job *predictionJob) {
+    pb.fp.pending.Add(1)
+    pb.mu.Lock()
+    queue := pb.queues[job.route]
+    replaced := false
+    for i, queued := range queue {
+        if queued.symbol == job.symbol {
+            queue[i], replaced = job, true
+            break
+        }
+    }
+    if !replaced {
+        if len(queue) == 0 {
+            time.AfterFunc(pb.interval, func() { pb.flush(job.route) })
+        }
+        queue = append(queue, job)
+    }
+    var full []*predictionJob
+    if len(queue) >= pb.size {
+        full = queue
+        delete(pb.queues, job.route)
+    } else {
+        pb.queues[job.route] = queue
+    }
+    pb.mu.Unlock()
+
+    if replaced {
+        pb.fp.pending.Done()
+    }
+    if full != nil {
+        go pb.send(job.route, full)
+    }
+}
+
+/*
+flush sends whatever is waiting for route.
+*/
+func (pb *PredictionBatcher) flush(route string) {
+    pb.mu.Lock()
+    jobs := pb.queues[route]
+    delete(pb.queues, route)
+    pb.mu.Unlock()
+    if len(jobs) > 0 {
+        pb.send(route, jobs)
+    }
+}
+
+/*
+send predicts jobs, all routed to route, and completes each of them.
+*/
+func (pb *PredictionBatcher) send(route string, jobs []*predictionJob) {
+    fp := pb.fp
+    defer func() {
+        for range jobs {
+            fp.pending.Done()
+        }
+    }()
+
+    pb.mu.Lock()
+    unsupported := pb.unsupported[route]
+    pb.mu.Unlock()
+    if !unsupported {
+        results, err := pb.post(route, jobs)
+        if err != errBatchUnsupported {
+            for i, job := range jobs {
+                if err != nil {
+                    fp.completePrediction(job, nil, err)
+                } else {
+                    fp.completePrediction(job, &results[i], nil)
+                }
+            }
+            return
+        }
+        slog.Warn("ML route does not support /predict_batch; predicting symbols one at a time", "route", route)
+        pb.mu.Lock()
+        pb.unsupported[route] = true
+        pb.mu.Unlock()
+    }
+    for _, job := range jobs {
+        res, err := fp.postPrediction(job)
+        fp.completePrediction(job, res, err)
+    }
+}
+
+/*
+post sends jobs as one /predict_batch call and returns the results in order.
+*/
+func (pb *PredictionBatcher) post(route string, jobs []*predictionJob) ([]mlPrediction, error) {
+    fp := pb.fp
+    req := predictBatchRequest{Requests: make([]json.RawMessage, len(jobs))}
+    for i, job := range jobs {
+        req.Requests[i] = job.body
+    }
+    body, err := json.Marshal(req)
+    if err != nil {
+        return nil, err
+    }
+    _, url := fp.mlRoutes.Resolve(jobs[0].symbol, "/predict_batch")
+
+    start := time.Now()
+    resp, err := fp.ml.Post(url, body)
+    elapsed := time.Since(start)
+    fp.latency.Observe(depML, elapsed, err)
+    fp.latency.Observe(depML+":"+route, elapsed, err)
+    slog.Debug("ML batch call", "route", route, "symbols", len(jobs), "latency", elapsed, "err", err)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    switch {
+    case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+        return nil, errBatchUnsupported
+    case resp.StatusCode != http.StatusOK:
+        return nil, fmt.Errorf("/predict_batch answered %s", resp.Status)
+    }
+
+    var out predictBatchResponse
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+        return nil, fmt.Errorf("decoding batch response: %w", err)
+    }
+    if len(out.Results) != len(jobs) {
+        return nil, fmt.Errorf("/predict_batch returned %d results for %d requests", len(out.Results), len(jobs))
+    }
+    return out.Results, nil
+}
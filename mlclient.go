@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+Headers carrying the HMAC request signature expected by ml_service.py.
+*/
+const (
+    signatureHeader          = "X-Signature"
+    signatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+/*
+MLClient posts requests to the ML service, optionally over mutual TLS and with
+an HMAC-SHA256 signature over "<unix timestamp>.<body>" so deployments that
+cross network boundaries can verify integrity and authenticity of traffic.
+*/
+type MLClient struct {
+    http   *http.Client
+    secret []byte
+}
+
+/*
+NewMLClientFromEnv builds the ML client. ML_HMAC_SECRET enables request
+signing. ML_TLS_CA_FILE sets the CA bundle used to verify the ML service, and
+ML_TLS_CERT_FILE/ML_TLS_KEY_FILE present a client certificate for mutual TLS;
+use ML_SCHEME=https so requests are sent over TLS. ML_TRANSPORT=fs exchanges
+requests as files under ML_FS_DIR instead of using the network. Proxying and
+any extra CA certificates otherwise follow the ml egress settings.
+*/
+func NewMLClientFromEnv() (*MLClient, error) {
+    base := egressTransport(egressML)
+    tlsConfig := base.TLSClientConfig.Clone()
+    if ca := os.Getenv("ML_TLS_CA_FILE"); ca != "" {
+        pem, err := os.ReadFile(ca)
+        if err != nil {
+            return nil, fmt.Errorf("ML_TLS_CA_FILE: %w", err)
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("ML_TLS_CA_FILE: no certificates found in %s", ca)
+        }
+        tlsConfig.RootCAs = pool
+    }
+    certFile, keyFile := os.Getenv("ML_TLS_CERT_FILE"), os.Getenv("ML_TLS_KEY_FILE")
+    if certFile != "" || keyFile != "" {
+        cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+        if err != nil {
+            return nil, fmt.Errorf("ML client certificate: %w", err)
+        }
+        tlsConfig.Certificates = []tls.Certificate{cert}
+    }
+
+    var transport http.RoundTripper
+    if os.Getenv("ML_TRANSPORT") == "fs" {
+        ft, err := newFSTransport(namespacedDir(os.Getenv("ML_FS_DIR")))
+        if err != nil {
+            return nil, err
+        }
+        transport = ft
+    } else {
+        t := base.Clone()
+        t.TLSClientConfig = tlsConfig
+        transport = t
+    }
+    return &MLClient{
+        http:   &http.Client{Transport: transport, Timeout: envDuration("ML_TIMEOUT", 30*time.Second)},
+        secret: []byte(os.Getenv("ML_HMAC_SECRET")),
+    }, nil
+}
+
+/*
+Post sends body as JSON to url, signing it when a secret is configured.
+*/
+func (mc *MLClient) Post(url string, body []byte) (*http.Response, error) {
+    return mc.do("POST", url, body)
+}
+
+/*
+Get requests url, signing the empty body when a secret is configured.
+*/
+func (mc *MLClient) Get(url string) (*http.Response, error) {
+    return mc.do("GET", url, nil)
+}
+
+/*
+do sends a signed request with an optional JSON body.
+*/
+func (mc *MLClient) do(method, url string, body []byte) (*http.Response, error) {
+    req, err := http.NewRequest(method, url, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if len(mc.secret) > 0 {
+        ts := strconv.FormatInt(time.Now().Unix(), 10)
+        req.Header.Set(signatureTimestampHeader, ts)
+        req.Header.Set(signatureHeader, signPayload(mc.secret, ts, body))
+    }
+    return mc.http.Do(req)
+}
+
+/*
+signPayload returns the hex HMAC-SHA256 of "<ts>.<body>" under secret.
+*/
+func signPayload(secret []byte, ts string, body []byte) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(ts))
+    mac.Write([]byte("."))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+fsEnvelope is the on-disk form of an ML request or response in filesystem
+transport mode.
+*/
+type fsEnvelope struct {
+    ID      string            `json:"id"`
+    Method  string            `json:"method,omitempty"`
+    Path    string            `json:"path,omitempty"`
+    Headers map[string]string `json:"headers,omitempty"`
+    Status  int               `json:"status,omitempty"`
+    Body    json.RawMessage   `json:"body"`
+}
+
+/*
+fsTransport carries ML requests over a shared directory instead of the
+network, for air-gapped setups. Each request is written atomically to
+<dir>/requests/<id>.json; the ML service, running as a batch process, writes
+the reply to <dir>/responses/<id>.json, which is polled until the request's
+deadline (ML_TIMEOUT). dir is ML_FS_DIR/<NAMESPACE> when a namespace is set.
+*/
+type fsTransport struct {
+    dir  string
+    poll time.Duration
+}
+
+/*
+newFSTransport prepares the request and response directories under dir.
+*/
+func newFSTransport(dir string) (*fsTransport, error) {
+    if dir == "" {
+        return nil, fmt.Errorf("ML_TRANSPORT=fs requires ML_FS_DIR")
+    }
+    for _, sub := range []string{"requests", "responses"} {
+        if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+            return nil, fmt.Errorf("ML_FS_DIR: %w", err)
+        }
+    }
+    return &fsTransport{dir: dir, poll: envDuration("ML_FS_POLL", 250*time.Millisecond)}, nil
+}
+
+/*
+writeFileAtomic writes data to a temporary file and renames it into place so
+readers never see a partial file.
+*/
+func writeFileAtomic(path string, data []byte) error {
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+/*
+RoundTrip implements http.RoundTripper.
+*/
+func (ft *fsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    var body []byte
+    if req.Body != nil {
+        var err error
+        if body, err = io.ReadAll(req.Body); err != nil {
+            return nil, err
+        }
+        req.Body.Close()
+    }
+    if len(body) == 0 {
+        body = []byte("null")
+    }
+    idBytes := make([]byte, 8)
+    rand.Read(idBytes)
+    id := fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(idBytes))
+
+    env := fsEnvelope{ID: id, Method: req.Method, Path: req.URL.Path, Headers: map[string]string{}, Body: body}
+    for k := range req.Header {
+        env.Headers[k] = req.Header.Get(k)
+    }
+    raw, err := json.Marshal(env)
+    if err != nil {
+        return nil, err
+    }
+    reqPath := filepath.Join(ft.dir, "requests", id+".json")
+    if err := writeFileAtomic(reqPath, raw); err != nil {
+        return nil, err
+    }
+
+    respPath := filepath.Join(ft.dir, "responses", id+".json")
+    ticker := time.NewTicker(ft.poll)
+    defer ticker.Stop()
+    for {
+        raw, err := os.ReadFile(respPath)
+        if err == nil {
+            os.Remove(respPath)
+            var resp fsEnvelope
+            if err := json.Unmarshal(raw, &resp); err != nil {
+                return nil, fmt.Errorf("invalid ML response file %s: %w", respPath, err)
+            }
+            if resp.Status == 0 {
+                resp.Status = http.StatusOK
+            }
+            return &http.Response{
+                Status:     fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+                StatusCode: resp.Status,
+                Proto:      "HTTP/1.1",
+                ProtoMajor: 1,
+                ProtoMinor: 1,
+                Header:     http.Header{"Content-Type": {"application/json"}},
+                Body:       io.NopCloser(bytes.NewReader(resp.Body)),
+                Request:    req,
+            }, nil
+        }
+        if !os.IsNotExist(err) {
+            return nil, err
+        }
+        select {
+        case <-req.Context().Done():
+            // Withdraw the request unless the batch process already claimed it.
+            os.Remove(reqPath)
+            return nil, fmt.Errorf("no ML response in %s: %w", strings.TrimSuffix(respPath, ".json"), req.Context().Err())
+        case <-ticker.C:
+        }
+    }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+mlSchemaVersion is the version of the /predict request and response format
+this service speaks; the ML service must report the same version from /ready.
+*/
+const mlSchemaVersion = 1
+
+/*
+MLReadiness is the handshake state of one ML route, as shown in /api/status.
+*/
+type MLReadiness struct {
+    Route         string     `json:"route"`
+    URL           string     `json:"url"`
+    Ready         bool       `json:"ready"`
+    SchemaVersion int        `json:"schema_version,omitempty"`
+    Models        []string   `json:"models"`
+    Attempts      int        `json:"attempts"`
+    CheckedAt     *time.Time `json:"checked_at,omitempty"`
+    ReadyAt       *time.Time `json:"ready_at,omitempty"`
+    Error         string     `json:"error,omitempty"`
+}
+
+/*
+MLHandshake holds back predictions until each ML service has passed a
+startup handshake: its /ready endpoint must answer 200 with the expected
+schema version. Services still warming up answer 503 and are retried every
+ML_READY_POLL (default 2s), backing off to 30s, so a cold start is waited out
+instead of flooded with failing /predict calls. ML_HANDSHAKE=off skips it.
+*/
+type MLHandshake struct {
+    mu     sync.Mutex
+    routes map[string]*MLReadiness
+    poll   time.Duration
+    ml     *MLClient
+}
+
+/*
+NewMLHandshakeFromEnv prepares a handshake for the default ML service and
+every route in router, or returns nil when ML_HANDSHAKE=off.
+*/
+func NewMLHandshakeFromEnv(ml *MLClient, router *MLRouter) *MLHandshake {
+    if os.Getenv("ML_HANDSHAKE") == "off" {
+        return nil
+    }
+    hs := &MLHandshake{
+        routes: map[string]*MLReadiness{"default": {Route: "default", URL: mlServiceURL("/ready")}},
+        poll:   envDuration("ML_READY_POLL", 2*time.Second),
+        ml:     ml,
+    }
+    for _, rt := range router.Routes() {
+        hs.routes[rt.Name] = &MLReadiness{Route: rt.Name, URL: rt.BaseURL + "/ready"}
+    }
+    return hs
+}
+
+/*
+Run performs the handshake with every route concurrently until each passes.
+*/
+func (hs *MLHandshake) Run() {
+    for _, st := range hs.routes {
+        go hs.await(st)
+    }
+}
+
+/*
+await retries the handshake for one route until it passes.
+*/
+func (hs *MLHandshake) await(st *MLReadiness) {
+    wait := hs.poll
+    for {
+        ready, err := hs.check(st)
+        if ready {
+            slog.Info("ML service ready", "route", st.Route, "schema", mlSchemaVersion, "models", len(st.Models))
+            return
+        }
+        slog.Warn("ML service not ready", "route", st.Route, "err", err, "retry_in", wait)
+        time.Sleep(wait)
+        if wait *= 2; wait > 30*time.Second {
+            wait = 30 * time.Second
+        }
+    }
+}
+
+/*
+check calls /ready once and records the outcome on st.
+*/
+func (hs *MLHandshake) check(st *MLReadiness) (bool, error) {
+    var body struct {
+        Ready         bool     `json:"ready"`
+        SchemaVersion int      `json:"schema_version"`
+        Models        []string `json:"models"`
+    }
+    err := func() error {
+        resp, err := hs.ml.Get(st.URL)
+        if err != nil {
+            return err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+            return fmt.Errorf("/ready returned %s", resp.Status)
+        }
+        if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+            return fmt.Errorf("invalid /ready response: %w", err)
+        }
+        switch {
+        case body.SchemaVersion != mlSchemaVersion:
+            return fmt.Errorf("schema version %d, want %d", body.SchemaVersion, mlSchemaVersion)
+        case !body.Ready || resp.StatusCode != http.StatusOK:
+            return fmt.Errorf("warming up")
+        }
+        return nil
+    }()
+
+    now := time.Now()
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    st.Attempts++
+    st.CheckedAt = &now
+    st.SchemaVersion = body.SchemaVersion
+    st.Models = body.Models
+    if err != nil {
+        st.Error = err.Error()
+        return false, err
+    }
+    st.Ready, st.ReadyAt, st.Error = true, &now, ""
+    return true, nil
+}
+
+/*
+Ready reports whether route has passed its handshake. Without a handshake
+every route counts as ready.
+*/
+func (hs *MLHandshake) Ready(route string) bool {
+    if hs == nil {
+        return true
+    }
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    st, ok := hs.routes[route]
+    return !ok || st.Ready
+}
+
+/*
+Snapshot returns the handshake state of every route ordered by route name.
+*/
+func (hs *MLHandshake) Snapshot() []MLReadiness {
+    if hs == nil {
+        return nil
+    }
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    out := make([]MLReadiness, 0, len(hs.routes))
+    for _, st := range hs.routes {
+        cp := *st
+        cp.Models = append([]string{}, st.Models...)
+        out = append(out, cp)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+    return out
+}
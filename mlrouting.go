@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+MLRoute sends predictions for symbols matching Pattern to the ML service at
+BaseURL. Name labels the route in metrics.
+*/
+type MLRoute struct {
+    Name    string
+    Pattern string
+    BaseURL string
+}
+
+/*
+MLRouter picks the ML service for each symbol from an ordered routing table.
+Symbols matching no route use the default service from ML_SERVICE_HOST/ML_PORT.
+*/
+type MLRouter struct {
+    routes []MLRoute
+}
+
+/*
+NewMLRouterFromEnv parses ML_ROUTES, a comma-separated list of
+[name:]pattern=baseURL entries evaluated in order, for example
+
+    ML_ROUTES="crypto:*-USD=http://crypto-ml:5002,BRK-B=http://value-ml:5003"
+
+Patterns use path.Match syntax against the symbol. Unnamed routes are labeled
+by their pattern.
+*/
+func NewMLRouterFromEnv() *MLRouter {
+    mr := &MLRouter{}
+    for _, entry := range strings.Split(os.Getenv("ML_ROUTES"), ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        lhs, target, ok := strings.Cut(entry, "=")
+        if !ok || target == "" {
+            slog.Warn("ignoring malformed ML_ROUTES entry", "entry", entry)
+            continue
+        }
+        name, pattern, named := strings.Cut(lhs, ":")
+        if !named {
+            name, pattern = lhs, lhs
+        }
+        if _, err := path.Match(pattern, ""); err != nil {
+            slog.Warn("ignoring ML_ROUTES entry", "entry", entry, "err", err)
+            continue
+        }
+        mr.routes = append(mr.routes, MLRoute{
+            Name:    name,
+            Pattern: pattern,
+            BaseURL: strings.TrimRight(target, "/"),
+        })
+    }
+    return mr
+}
+
+/*
+Resolve returns the route name and full URL for calling endpoint (e.g. "/predict")
+on the ML service responsible for symbol.
+*/
+func (mr *MLRouter) Resolve(symbol, endpoint string) (string, string) {
+    for _, rt := range mr.routes {
+        if ok, _ := path.Match(rt.Pattern, symbol); ok {
+            return rt.Name, rt.BaseURL + endpoint
+        }
+    }
+    return "default", mlServiceURL(endpoint)
+}
+
+/*
+Routes returns the configured routing table.
+*/
+func (mr *MLRouter) Routes() []MLRoute {
+    return mr.routes
+}
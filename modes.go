@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Run modes select which parts of the service a process runs. Collector
+processes scrape and predict; API processes only serve reads; both share
+history through the configured storage.
+*/
+const (
+    modeAll       = "all"
+    modeCollector = "collector"
+    modeAPI       = "api"
+)
+
+/*
+parseRunMode reads --mode=<mode> (or "--mode <mode>") from args, falling back
+to RUN_MODE and then "all".
+*/
+func parseRunMode(args []string) (string, error) {
+    mode := os.Getenv("RUN_MODE")
+    for i, a := range args {
+        switch {
+        case strings.HasPrefix(a, "--mode=") || strings.HasPrefix(a, "-mode="):
+            mode = a[strings.Index(a, "=")+1:]
+        case (a == "--mode" || a == "-mode") && i+1 < len(args):
+            mode = args[i+1]
+        }
+    }
+    switch mode {
+    case "":
+        return modeAll, nil
+    case modeAll, modeCollector, modeAPI:
+        return mode, nil
+    }
+    return "", fmt.Errorf("unknown mode %q (want all, collector or api)", mode)
+}
+
+/*
+newCollectorRouter serves only health, metrics, symbol management, the
+alert and position routes and the admin API, for processes running in
+collector mode. Alert rules and positions are evaluated where ticks and
+predictions arrive, so they must be managed on the collector.
+*/
+func newCollectorRouter(fp *FinancialProcessor) *mux.Router {
+    r := mux.NewRouter()
+    r.Use(logRequests, fp.auth.Middleware)
+    r.HandleFunc("/api/status", fp.handleStatus).Methods("GET")
+    r.HandleFunc("/api/changes", fp.handleChanges).Methods("GET")
+    r.HandleFunc("/api/stream", fp.handleStream).Methods("GET")
+    r.HandleFunc("/api/slo", fp.handleSLO).Methods("GET")
+    r.HandleFunc("/api/accuracy/{symbol}", fp.handleAccuracy).Methods("GET")
+    r.HandleFunc("/api/symbols", fp.handleListSymbols).Methods("GET")
+    r.HandleFunc("/api/symbols", fp.handleAddSymbol).Methods("POST")
+    r.HandleFunc("/api/symbols/settings", fp.handleListSymbolSettings).Methods("GET")
+    r.HandleFunc("/api/symbols/settings", fp.handlePatchSymbolSettings).Methods("PATCH")
+    r.HandleFunc("/api/symbols/{symbol}", fp.handleRemoveSymbol).Methods("DELETE")
+    r.HandleFunc("/api/debug/raw/{symbol}", fp.handleDebugRaw).Methods("GET")
+    r.HandleFunc("/api/positions", fp.positions.handleListPositions).Methods("GET")
+    r.HandleFunc("/api/positions/{symbol}", fp.positions.handlePutPosition).Methods("PUT")
+    r.HandleFunc("/api/positions/{symbol}", fp.positions.handleDeletePosition).Methods("DELETE")
+    r.HandleFunc("/api/risk/alerts", fp.positions.handleRiskAlerts).Methods("GET")
+    r.HandleFunc("/api/alerts/history", fp.alerts.handleAlertHistory).Methods("GET")
+    r.HandleFunc("/api/alerts/recipients", fp.alerts.recipients.handleListRecipients).Methods("GET")
+    r.HandleFunc("/api/alerts/recipients/{user}", fp.alerts.recipients.handlePutRecipient).Methods("PUT")
+    r.HandleFunc("/api/alerts/recipients/{user}", fp.alerts.recipients.handleDeleteRecipient).Methods("DELETE")
+    r.HandleFunc("/api/alerts", fp.alertRules.handleListAlertRules).Methods("GET")
+    r.HandleFunc("/api/alerts", fp.alertRules.handleCreateAlertRule).Methods("POST")
+    r.HandleFunc("/api/alerts/{id:[0-9]+}", fp.alertRules.handleDeleteAlertRule).Methods("DELETE")
+    r.HandleFunc("/api/alerts/price", fp.alertRules.handleListPriceAlerts).Methods("GET")
+    r.HandleFunc("/api/alerts/price", fp.alertRules.handleCreatePriceAlert).Methods("POST")
+    r.HandleFunc("/api/alerts/price/{id}", fp.alertRules.handleDeleteAlertRule).Methods("DELETE")
+    r.HandleFunc("/metrics", fp.handleMetrics).Methods("GET")
+    registerAdminRoutes(r, fp)
+    return r
+}
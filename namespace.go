@@ -0,0 +1,67 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+/*
+namespace is the per-deployment prefix from NAMESPACE (e.g. "staging"), applied
+to every name this service creates in infrastructure it may share with other
+environments. Empty means no prefix.
+*/
+var namespace = loadNamespace()
+
+/*
+validNamespace limits NAMESPACE to characters safe in paths, keys and topics.
+*/
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+/*
+loadNamespace reads and validates NAMESPACE.
+*/
+func loadNamespace() string {
+    ns := os.Getenv("NAMESPACE")
+    if ns != "" && !validNamespace.MatchString(ns) {
+        log.Fatalf("invalid NAMESPACE %q: use letters, digits, '.', '_' and '-'", ns)
+    }
+    return ns
+}
+
+/*
+namespacedKey prefixes a shared key, topic or object name with the namespace
+and sep, e.g. "staging:alerts" for sep ":".
+*/
+func namespacedKey(name, sep string) string {
+    if namespace == "" {
+        return name
+    }
+    return namespace + sep + name
+}
+
+/*
+namespacedDir places a shared directory's contents under a namespace
+subdirectory, so environments pointed at the same mount do not collide.
+*/
+func namespacedDir(dir string) string {
+    if namespace == "" || dir == "" {
+        return dir
+    }
+    return filepath.Join(dir, namespace)
+}
+
+/*
+namespacedLockKey derives a PostgreSQL advisory lock key for the namespace
+from base; without a namespace it is base itself.
+*/
+func namespacedLockKey(base int64) int64 {
+    if namespace == "" {
+        return base
+    }
+    h := fnv.New64a()
+    h.Write([]byte(namespacedKey("instance", ":")))
+    return base ^ int64(h.Sum64()>>1)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+yahooHeadlineFeed is Yahoo Finance's per-symbol RSS headline feed.
+*/
+const yahooHeadlineFeed = "https://feeds.finance.yahoo.com/rss/2.0/headline"
+
+/*
+NewsItem is a single headline associated with a tracked symbol.
+*/
+type NewsItem struct {
+    Symbol     string    `json:"symbol"`
+    Title      string    `json:"title"`
+    Link       string    `json:"link"`
+    Published  time.Time `json:"published"`
+    AfterHours bool      `json:"after_hours"`
+}
+
+/*
+rssFeed mirrors the parts of an RSS 2.0 document we consume.
+*/
+type rssFeed struct {
+    Channel struct {
+        Items []struct {
+            Title   string `xml:"title"`
+            Link    string `xml:"link"`
+            GUID    string `xml:"guid"`
+            PubDate string `xml:"pubDate"`
+        } `xml:"item"`
+    } `xml:"channel"`
+}
+
+/*
+NewsCollector polls the headline feed for every tracked symbol. When a new
+headline appears outside market hours it boosts that symbol's collection
+cadence so the after-hours reaction is captured. Headlines are remembered
+by ID, with when they were first seen, for seenTTL; older ones are
+forgotten, and headlines published longer ago than that are ignored so a
+forgotten one still in a feed is not taken for new.
+*/
+type NewsCollector struct {
+    fp       *FinancialProcessor
+    interval time.Duration
+    window   time.Duration
+    seenTTL  time.Duration
+    since    time.Time
+    seen     map[string]time.Time
+    items    TimeSeriesStore[NewsItem]
+    mu       sync.Mutex
+}
+
+/*
+NewNewsCollectorFromEnv returns a collector when NEWS_ENABLED=true, polling every
+NEWS_POLL_INTERVAL (default 5m), boosting for NEWS_BOOST_WINDOW (default 30m)
+and remembering headlines for NEWS_SEEN_TTL (default 72h).
+*/
+func NewNewsCollectorFromEnv(fp *FinancialProcessor) *NewsCollector {
+    if os.Getenv("NEWS_ENABLED") != "true" {
+        return nil
+    }
+    nc := &NewsCollector{
+        fp:       fp,
+        interval: envDuration("NEWS_POLL_INTERVAL", 5*time.Minute),
+        window:   envDuration("NEWS_BOOST_WINDOW", 30*time.Minute),
+        seenTTL:  envDuration("NEWS_SEEN_TTL", 72*time.Hour),
+        since:    time.Now(),
+        seen:     make(map[string]time.Time),
+        items:    NewMemorySeries[NewsItem](100),
+    }
+    if nc.seenTTL <= 0 {
+        log.Fatalf("NEWS_SEEN_TTL must be positive, got %s", nc.seenTTL)
+    }
+    return nc
+}
+
+/*
+Run polls the feeds until the process exits.
+*/
+func (nc *NewsCollector) Run() {
+    ticker := time.NewTicker(nc.interval)
+    defer ticker.Stop()
+    for {
+        for _, sym := range nc.fp.trackedSymbols() {
+            if err := nc.poll(sym); err != nil {
+                slog.Warn("news poll failed", "symbol", sym, "err", err)
+            }
+        }
+        nc.forget(time.Now())
+        nc.fp.expireBoosts(nc.fp.clock.Now())
+        <-ticker.C
+    }
+}
+
+/*
+poll fetches the headline feed for symbol and handles unseen items. Headlines
+published before the collector started are recorded but never trigger a boost.
+*/
+func (nc *NewsCollector) poll(symbol string) error {
+    u := fmt.Sprintf("%s?s=%s&region=US&lang=en-US", yahooHeadlineFeed, url.QueryEscape(symbol))
+    req, err := http.NewRequest("GET", u, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("User-Agent", "Mozilla/5.0")
+    resp, err := quoteClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("feed returned %s", resp.Status)
+    }
+
+    var feed rssFeed
+    if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+        return err
+    }
+
+    now := time.Now()
+    for _, it := range feed.Channel.Items {
+        key := it.GUID
+        if key == "" {
+            key = it.Link
+        }
+        published, _ := time.Parse(time.RFC1123Z, strings.TrimSpace(it.PubDate))
+        if !published.IsZero() && now.Sub(published) >= nc.seenTTL {
+            continue
+        }
+        nc.mu.Lock()
+        if _, ok := nc.seen[key]; ok {
+            nc.mu.Unlock()
+            continue
+        }
+        nc.seen[key] = now
+        item := NewsItem{
+            Symbol:     symbol,
+            Title:      strings.TrimSpace(it.Title),
+            Link:       it.Link,
+            Published:  published,
+            AfterHours: !published.IsZero() && !isMarketOpen(published),
+        }
+        nc.mu.Unlock()
+        nc.items.Append(symbol, item)
+
+        if item.AfterHours && published.After(nc.since) && !isMarketOpen(nc.fp.clock.Now()) {
+            slog.Info("after-hours headline, boosting collection", "symbol", symbol,
+                "boost", nc.window, "headline", item.Title)
+            nc.fp.boost(symbol, nc.fp.clock.Now().Add(nc.window))
+        }
+    }
+    return nil
+}
+
+/*
+forget drops the headlines first seen seenTTL or longer before now.
+*/
+func (nc *NewsCollector) forget(now time.Time) {
+    nc.mu.Lock()
+    defer nc.mu.Unlock()
+    for key, at := range nc.seen {
+        if now.Sub(at) >= nc.seenTTL {
+            delete(nc.seen, key)
+        }
+    }
+}
+
+/*
+handleGetNews exposes an HTTP GET endpoint returning recent headlines for a symbol.
+*/
+func (nc *NewsCollector) handleGetNews(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    items := nc.items.Window(sym, 0)
+    if len(items) == 0 {
+        http.Error(w, "no news", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(items)
+}
+
+/*
+boost raises symbol's collection cadence to NEWS_BOOST_INTERVAL until the given time.
+*/
+func (fp *FinancialProcessor) boost(symbol string, until time.Time) {
+    fp.mutex.Lock()
+    if fp.boosts == nil {
+        fp.boosts = make(map[string]time.Time)
+    }
+    if until.After(fp.boosts[symbol]) {
+        fp.boosts[symbol] = until
+    }
+    fp.mutex.Unlock()
+}
+
+/*
+expireBoosts drops the boosts that ended by now, so boosts only holds symbols
+still being collected faster.
+*/
+func (fp *FinancialProcessor) expireBoosts(now time.Time) {
+    fp.mutex.Lock()
+    defer fp.mutex.Unlock()
+    for sym, until := range fp.boosts {
+        if !now.Before(until) {
+            delete(fp.boosts, sym)
+        }
+    }
+}
+
+/*
+collectionInterval returns how long symbol's loop waits before its next fetch:
+NEWS_BOOST_INTERVAL (default 5s) while a boost is active, otherwise the
+symbol's configured collection interval adjusted for market hours.
+*/
+func (fp *FinancialProcessor) collectionInterval(symbol string) time.Duration {
+    fp.mutex.RLock()
+    until, boosted := fp.boosts[symbol]
+    fp.mutex.RUnlock()
+    if boosted && fp.clock.Now().Before(until) {
+        return envDuration("NEWS_BOOST_INTERVAL", 5*time.Second)
+    }
+    return fp.marketInterval(symbol, fp.symbolInterval(symbol))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewsCollectorForgetsOldHeadlines(t *testing.T) {
+    now := time.Now()
+    feed := fmt.Sprintf(`<rss><channel>
+<item><title>Fresh</title><guid>fresh</guid><pubDate>%s</pubDate></item>
+<item><title>Stale</title><guid>stale</guid><pubDate>%s</pubDate></item>
+</channel></rss>`, now.Add(-time.Hour).Format(time.RFC1123Z), now.Add(-100*time.Hour).Format(time.RFC1123Z))
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, feed)
+    }))
+    defer srv.Close()
+    prev := quoteClient
+    quoteClient = &http.Client{Transport: redirectTransport{srv}}
+    t.Cleanup(func() { quoteClient = prev })
+
+    t.Setenv("NEWS_ENABLED", "true")
+    t.Setenv("NEWS_SEEN_TTL", "72h")
+    nc := NewNewsCollectorFromEnv(&FinancialProcessor{clock: systemClock{}})
+    for i := 0; i < 2; i++ {
+        if err := nc.poll("AAPL"); err != nil {
+            t.Fatal(err)
+        }
+    }
+    items := nc.items.Window("AAPL", 0)
+    if len(items) != 1 || items[0].Title != "Fresh" {
+        t.Errorf("recorded %+v, want only the fresh headline, once", items)
+    }
+    if len(nc.seen) != 1 {
+        t.Errorf("remembering %d headlines, want 1", len(nc.seen))
+    }
+
+    nc.forget(now.Add(71 * time.Hour))
+    if len(nc.seen) != 1 {
+        t.Errorf("forgot a headline before NEWS_SEEN_TTL")
+    }
+    nc.forget(now.Add(73 * time.Hour))
+    if len(nc.seen) != 0 {
+        t.Errorf("still remembering %d headlines after NEWS_SEEN_TTL", len(nc.seen))
+    }
+}
+
+func TestExpireBoostsDropsEndedBoosts(t *testing.T) {
+    now := time.Now()
+    fp := &FinancialProcessor{}
+    fp.boost("AAPL", now.Add(-time.Minute))
+    fp.boost("MSFT", now.Add(time.Minute))
+    fp.expireBoosts(now)
+    if _, ok := fp.boosts["AAPL"]; ok {
+        t.Error("ended boost still held")
+    }
+    if _, ok := fp.boosts["MSFT"]; !ok {
+        t.Error("active boost dropped")
+    }
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Notification is one message to deliver: a subject for channels that have
+one, the text, and the payload generic JSON webhooks receive instead.
+*/
+type Notification struct {
+    Subject string
+    Text    string
+    Payload interface{}
+}
+
+/*
+Notifier delivers notifications on one kind of channel to a target there: a
+webhook URL, a Telegram chat ID or an email address.
+*/
+type Notifier interface {
+    Notify(target string, n Notification) error
+}
+
+/*
+postJSON sends payload as JSON to url and fails on a non-2xx answer.
+*/
+func postJSON(client *http.Client, url string, payload interface{}) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
+
+/*
+webhookNotifier posts the notification's payload as JSON.
+*/
+type webhookNotifier struct {
+    client *http.Client
+}
+
+func (wn webhookNotifier) Notify(target string, n Notification) error {
+    return postJSON(wn.client, target, n.Payload)
+}
+
+/*
+slackNotifier posts the text to a Slack incoming webhook.
+*/
+type slackNotifier struct {
+    client *http.Client
+}
+
+func (sn slackNotifier) Notify(target string, n Notification) error {
+    return postJSON(sn.client, target, map[string]string{"text": n.Text})
+}
+
+/*
+discordNotifier posts the text to a Discord channel webhook, which takes at
+most 2000 characters.
+*/
+type discordNotifier struct {
+    client *http.Client
+}
+
+func (dn discordNotifier) Notify(target string, n Notification) error {
+    text := n.Text
+    if r := []rune(text); len(r) > 2000 {
+        text = string(r[:1999]) + "…"
+    }
+    return postJSON(dn.client, target, map[string]string{"content": text})
+}
+
+/*
+telegramNotifier sends the text to a chat through the Telegram bot named by
+TELEGRAM_BOT_TOKEN.
+*/
+type telegramNotifier struct {
+    client *http.Client
+    token  string
+}
+
+func (tn telegramNotifier) Notify(target string, n Notification) error {
+    if tn.token == "" {
+        return fmt.Errorf("telegram delivery is not configured (TELEGRAM_BOT_TOKEN)")
+    }
+    url := "https://api.telegram.org/bot" + tn.token + "/sendMessage"
+    if err := postJSON(tn.client, url, map[string]string{"chat_id": target, "text": n.Text}); err != nil {
+        // The URL holds the bot token; keep it out of errors and logs.
+        return fmt.Errorf("telegram sendMessage failed: %s", strings.ReplaceAll(err.Error(), tn.token, "***"))
+    }
+    return nil
+}
+
+/*
+emailNotifier mails the subject and text through the SMTP relay, when one is
+configured.
+*/
+type emailNotifier struct {
+    smtp *smtpSender
+}
+
+func (en emailNotifier) Notify(target string, n Notification) error {
+    if en.smtp == nil {
+        return fmt.Errorf("email delivery is not configured (ALERT_SMTP_ADDR)")
+    }
+    return en.smtp.send(target, n.Subject, n.Text)
+}
+
+/*
+newNotifiersFromEnv returns the notifier for each channel name a Recipient
+can use, sending over client.
+*/
+func newNotifiersFromEnv(client *http.Client) map[string]Notifier {
+    return map[string]Notifier{
+        "webhook":  webhookNotifier{client},
+        "slack":    slackNotifier{client},
+        "discord":  discordNotifier{client},
+        "telegram": telegramNotifier{client, os.Getenv("TELEGRAM_BOT_TOKEN")},
+        "email":    emailNotifier{newSMTPSenderFromEnv()},
+    }
+}
+
+/*
+PredictionNotifier announces large predicted moves on the channels set by
+NOTIFY_SLACK_WEBHOOK_URL, NOTIFY_DISCORD_WEBHOOK_URL, NOTIFY_TELEGRAM_CHAT_ID
+(with TELEGRAM_BOT_TOKEN) and NOTIFY_EMAIL_TO (through the alert SMTP
+relay). Every prediction whose change reaches NOTIFY_THRESHOLD_PERCENT
+(default 2) either way is sent to every configured channel, except that a
+symbol is announced at most once per NOTIFY_COOLDOWN (default 1h, 0 sends
+every one) while its predictions keep pointing the same way; a move in the
+other direction is announced at once. Each notification is recorded in the
+alert history under the "prediction_move" rule and the "notify" recipient.
+*/
+type PredictionNotifier struct {
+    threshold float64
+    cooldown  time.Duration
+    channels  Recipient
+    mu        sync.Mutex
+    last      map[string]notifiedMove
+}
+
+/*
+notifiedMove is the direction of a symbol's last announced move, 1 for up
+and -1 for down, and when it was announced.
+*/
+type notifiedMove struct {
+    side int
+    at   time.Time
+}
+
+/*
+NewPredictionNotifierFromEnv returns nil unless some channel is configured.
+*/
+func NewPredictionNotifierFromEnv() *PredictionNotifier {
+    pn := &PredictionNotifier{
+        threshold: envFloat("NOTIFY_THRESHOLD_PERCENT", 2),
+        cooldown:  envDuration("NOTIFY_COOLDOWN", time.Hour),
+        channels: Recipient{
+            User:           "notify",
+            SlackURL:       os.Getenv("NOTIFY_SLACK_WEBHOOK_URL"),
+            DiscordURL:     os.Getenv("NOTIFY_DISCORD_WEBHOOK_URL"),
+            TelegramChatID: os.Getenv("NOTIFY_TELEGRAM_CHAT_ID"),
+            Email:          os.Getenv("NOTIFY_EMAIL_TO"),
+        },
+        last: make(map[string]notifiedMove),
+    }
+    if len(pn.channels.channels()) == 0 {
+        return nil
+    }
+    if pn.threshold <= 0 {
+        log.Fatalf("NOTIFY_THRESHOLD_PERCENT must be positive, got %g", pn.threshold)
+    }
+    if pn.cooldown < 0 {
+        log.Fatalf("NOTIFY_COOLDOWN must not be negative, got %s", pn.cooldown)
+    }
+    return pn
+}
+
+/*
+Due reports whether p, made at now, should be announced: its change reaches
+the threshold and its symbol was not announced moving the same way within
+the cooldown. A due prediction is remembered as announced.
+*/
+func (pn *PredictionNotifier) Due(p Prediction, now time.Time) bool {
+    if math.Abs(p.PredictedChangePerc) < pn.threshold {
+        return false
+    }
+    side := 1
+    if p.PredictedChangePerc < 0 {
+        side = -1
+    }
+    pn.mu.Lock()
+    defer pn.mu.Unlock()
+    if prev, ok := pn.last[p.Symbol]; ok && prev.side == side && now.Sub(prev.at) < pn.cooldown {
+        return false
+    }
+    pn.last[p.Symbol] = notifiedMove{side, now}
+    return true
+}
+
+/*
+notifyPrediction sends p to the prediction channels when it reaches the
+notification threshold outside the cooldown, and publishes the resulting
+record as an "alert" event.
+*/
+func (fp *FinancialProcessor) notifyPrediction(p Prediction) {
+    now := fp.clock.Now()
+    if fp.notifier == nil || !fp.notifier.Due(p, now) {
+        return
+    }
+    rec := AlertRecord{
+        Rule:         "prediction_move",
+        Symbol:       p.Symbol,
+        TriggerValue: p.PredictedChangePerc,
+        Message: fmt.Sprintf("%s predicted to move %+.2f%% to %.2f from %.2f",
+            p.Symbol, p.PredictedChangePerc, p.PredictedPrice, p.CurrentPrice),
+        FiredAt: now,
+    }
+    if leader, ok := fp.clusters.Suppress(rec.Rule, rec.Symbol, rec.TriggerValue, rec.FiredAt); ok {
+        rec = fp.alerts.Suppressed(rec.Rule, rec.Symbol, rec.TriggerValue, rec.Message, leader)
+    } else {
+        rec = fp.alerts.SendTo(fp.notifier.channels, rec, nil)
+    }
+    fp.events.Publish(Event{Type: "alert", Symbol: p.Symbol, Data: rec})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPredictionNotifierDue(t *testing.T) {
+    start := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+    type step struct {
+        after  time.Duration
+        symbol string
+        change float64
+        want   bool
+    }
+    tests := []struct {
+        name     string
+        cooldown time.Duration
+        steps    []step
+    }{
+        {"below threshold", time.Hour, []step{
+            {0, "AAPL", 1.9, false},
+            {0, "AAPL", -1.9, false},
+        }},
+        {"every exceeding prediction once cooled down", time.Hour, []step{
+            {0, "AAPL", 2.5, true},
+            {10 * time.Minute, "AAPL", 3, false},
+            {20 * time.Minute, "AAPL", 1, false},
+            {30 * time.Minute, "AAPL", 2.2, false},
+            {time.Hour, "AAPL", 2.1, true},
+        }},
+        {"direction change is announced at once", time.Hour, []step{
+            {0, "AAPL", 2.5, true},
+            {time.Minute, "AAPL", -2.5, true},
+            {2 * time.Minute, "AAPL", 2.5, true},
+        }},
+        {"symbols cool down separately", time.Hour, []step{
+            {0, "AAPL", 2.5, true},
+            {0, "MSFT", 2.5, true},
+            {time.Minute, "MSFT", 2.5, false},
+        }},
+        {"no cooldown", 0, []step{
+            {0, "AAPL", 2.5, true},
+            {0, "AAPL", 2.5, true},
+            {time.Second, "AAPL", 2.5, true},
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pn := &PredictionNotifier{threshold: 2, cooldown: tt.cooldown, last: make(map[string]notifiedMove)}
+            for i, s := range tt.steps {
+                p := Prediction{Symbol: s.symbol, PredictedChangePerc: s.change}
+                if got := pn.Due(p, start.Add(s.after)); got != s.want {
+                    t.Errorf("step %d: Due(%s %+.1f%% at +%v) = %v, want %v", i, s.symbol, s.change, s.after, got, s.want)
+                }
+            }
+        })
+    }
+}
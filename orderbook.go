@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+BookLevel is one aggregated price level of an order book.
+*/
+type BookLevel struct {
+    Price float64 `json:"price"`
+    Size  float64 `json:"size"`
+}
+
+/*
+OrderBookSnapshot holds the top levels of a crypto order book plus derived
+order-flow measures. Imbalance is (bid size - ask size) / total size over the
+captured levels, from -1 (all asks) to 1 (all bids).
+*/
+type OrderBookSnapshot struct {
+    Symbol    string      `json:"symbol"`
+    Exchange  string      `json:"exchange"`
+    Bids      []BookLevel `json:"bids"`
+    Asks      []BookLevel `json:"asks"`
+    Mid       float64     `json:"mid"`
+    Spread    float64     `json:"spread"`
+    Imbalance float64     `json:"imbalance"`
+    Timestamp time.Time   `json:"timestamp"`
+}
+
+/*
+isCryptoSymbol reports whether symbol uses Yahoo's crypto pair notation, e.g. BTC-USD.
+*/
+func isCryptoSymbol(symbol string) bool {
+    base, quote, ok := strings.Cut(symbol, "-")
+    return ok && base != "" && len(quote) == 3 && currencySymbols[quote] != ""
+}
+
+/*
+OrderBookCollector periodically snapshots order book depth for the crypto
+symbols being tracked and keeps a bounded history of snapshots per symbol.
+*/
+type OrderBookCollector struct {
+    exchange string
+    depth    int
+    interval time.Duration
+    symbols  []string
+    store    TimeSeriesStore[OrderBookSnapshot]
+    latency  *DependencyMetrics
+    client   *http.Client
+}
+
+/*
+NewOrderBookCollectorFromEnv returns nil unless ORDERBOOK_ENABLED=true and at
+least one tracked symbol is a crypto pair. ORDERBOOK_EXCHANGE selects coinbase
+(default) or binance, ORDERBOOK_DEPTH the number of levels per side (default
+10), and ORDERBOOK_INTERVAL the snapshot interval (default 30s).
+*/
+func NewOrderBookCollectorFromEnv(symbols []string, latency *DependencyMetrics) *OrderBookCollector {
+    if os.Getenv("ORDERBOOK_ENABLED") != "true" {
+        return nil
+    }
+    var crypto []string
+    for _, s := range symbols {
+        if isCryptoSymbol(s) {
+            crypto = append(crypto, s)
+        }
+    }
+    if len(crypto) == 0 {
+        return nil
+    }
+    exchange := os.Getenv("ORDERBOOK_EXCHANGE")
+    if exchange == "" {
+        exchange = "coinbase"
+    }
+    if exchange != "coinbase" && exchange != "binance" {
+        slog.Warn("unknown ORDERBOOK_EXCHANGE, order book disabled", "exchange", exchange)
+        return nil
+    }
+    return &OrderBookCollector{
+        exchange: exchange,
+        depth:    envInt("ORDERBOOK_DEPTH", 10),
+        interval: envDuration("ORDERBOOK_INTERVAL", 30*time.Second),
+        symbols:  crypto,
+        store:    NewMemorySeries[OrderBookSnapshot](100),
+        latency:  latency,
+        client:   egressClient(egressExchange, 10*time.Second),
+    }
+}
+
+/*
+Run snapshots every crypto symbol each interval.
+*/
+func (oc *OrderBookCollector) Run() {
+    for {
+        for _, sym := range oc.symbols {
+            start := time.Now()
+            snap, err := oc.fetch(sym)
+            oc.latency.Observe("orderbook:"+oc.exchange, time.Since(start), err)
+            if err != nil {
+                slog.Warn("order book fetch failed", "symbol", sym, "source", oc.exchange, "latency", time.Since(start), "err", err)
+                continue
+            }
+            oc.store.Append(sym, *snap)
+        }
+        time.Sleep(oc.interval)
+    }
+}
+
+/*
+fetch retrieves one snapshot from the configured exchange.
+*/
+func (oc *OrderBookCollector) fetch(symbol string) (*OrderBookSnapshot, error) {
+    var u string
+    switch oc.exchange {
+    case "binance":
+        pair := strings.ReplaceAll(symbol, "-", "")
+        if strings.HasSuffix(pair, "USD") {
+            pair += "T"
+        }
+        u = "https://api.binance.com/api/v3/depth?limit=" + strconv.Itoa(binanceDepthLimit(oc.depth)) + "&symbol=" + url.QueryEscape(pair)
+    default:
+        u = "https://api.exchange.coinbase.com/products/" + url.PathEscape(symbol) + "/book?level=2"
+    }
+    req, err := http.NewRequest("GET", u, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", "financial-forecaster")
+    resp, err := oc.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("%s returned %s", oc.exchange, resp.Status)
+    }
+
+    // Both exchanges return levels as arrays whose first two entries are
+    // price and size strings; Coinbase appends an order count.
+    var book struct {
+        Bids [][]json.RawMessage `json:"bids"`
+        Asks [][]json.RawMessage `json:"asks"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&book); err != nil {
+        return nil, err
+    }
+    snap := &OrderBookSnapshot{
+        Symbol:    symbol,
+        Exchange:  oc.exchange,
+        Bids:      parseBookLevels(book.Bids, oc.depth),
+        Asks:      parseBookLevels(book.Asks, oc.depth),
+        Timestamp: time.Now(),
+    }
+    if len(snap.Bids) == 0 || len(snap.Asks) == 0 {
+        return nil, fmt.Errorf("empty order book")
+    }
+    bid, ask := snap.Bids[0].Price, snap.Asks[0].Price
+    snap.Mid = (bid + ask) / 2
+    snap.Spread = ask - bid
+    var bidSize, askSize float64
+    for _, l := range snap.Bids {
+        bidSize += l.Size
+    }
+    for _, l := range snap.Asks {
+        askSize += l.Size
+    }
+    if total := bidSize + askSize; total > 0 {
+        snap.Imbalance = (bidSize - askSize) / total
+    }
+    return snap, nil
+}
+
+/*
+binanceDepthLimit rounds depth up to a limit Binance accepts.
+*/
+func binanceDepthLimit(depth int) int {
+    for _, l := range []int{5, 10, 20, 50, 100, 500, 1000} {
+        if depth <= l {
+            return l
+        }
+    }
+    return 1000
+}
+
+/*
+parseBookLevels decodes up to depth [price, size, ...] levels.
+*/
+func parseBookLevels(raw [][]json.RawMessage, depth int) []BookLevel {
+    out := make([]BookLevel, 0, depth)
+    for _, lvl := range raw {
+        if len(out) >= depth {
+            break
+        }
+        if len(lvl) < 2 {
+            continue
+        }
+        var ps, ss string
+        if json.Unmarshal(lvl[0], &ps) != nil || json.Unmarshal(lvl[1], &ss) != nil {
+            continue
+        }
+        p, err1 := strconv.ParseFloat(ps, 64)
+        s, err2 := strconv.ParseFloat(ss, 64)
+        if err1 != nil || err2 != nil {
+            continue
+        }
+        out = append(out, BookLevel{Price: p, Size: s})
+    }
+    return out
+}
+
+/*
+handleGetOrderBook exposes GET /api/orderbook/{symbol}, returning the latest
+snapshot, or the last n snapshots with ?history=n.
+*/
+func (oc *OrderBookCollector) handleGetOrderBook(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    if v := r.URL.Query().Get("history"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            http.Error(w, "history must be a positive integer", http.StatusBadRequest)
+            return
+        }
+        snaps := oc.store.Window(sym, n)
+        if len(snaps) == 0 {
+            http.Error(w, "no order book data", http.StatusNotFound)
+            return
+        }
+        json.NewEncoder(w).Encode(snaps)
+        return
+    }
+    snap, ok := oc.store.Latest(sym)
+    if !ok {
+        http.Error(w, "no order book data", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(snap)
+}
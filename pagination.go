@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*
+dataQuery is a time range request on /api/data/{symbol}: ticks between from
+and to (zero bounds are open), at most limit of them when limit > 0, newest
+first when desc.
+*/
+type dataQuery struct {
+    from  time.Time
+    to    time.Time
+    limit int
+    desc  bool
+}
+
+/*
+dataQueryParams are the query parameters that turn /api/data/{symbol} into a
+time range query.
+*/
+var dataQueryParams = []string{"from", "to", "since", "until", "limit", "order", "cursor"}
+
+/*
+isDataQuery reports whether qs asks for a time range rather than the recent
+window.
+*/
+func isDataQuery(qs url.Values) bool {
+    for _, p := range dataQueryParams {
+        if qs.Get(p) != "" {
+            return true
+        }
+    }
+    return false
+}
+
+/*
+parseDataQuery reads from and to (RFC 3339 or YYYY-MM-DD; since and until
+are older names for them), limit, order (asc, the default, or desc) and
+cursor, which continues after the tick it names in that order.
+*/
+func parseDataQuery(qs url.Values) (dataQuery, error) {
+    var q dataQuery
+    var err error
+    from, to := qs.Get("from"), qs.Get("to")
+    if from == "" {
+        from = qs.Get("since")
+    }
+    if to == "" {
+        to = qs.Get("until")
+    }
+    if q.from, err = parseExportTime(from, false); err != nil {
+        return q, fmt.Errorf("invalid from: %w", err)
+    }
+    if q.to, err = parseExportTime(to, true); err != nil {
+        return q, fmt.Errorf("invalid to: %w", err)
+    }
+    if v := qs.Get("limit"); v != "" {
+        if q.limit, err = strconv.Atoi(v); err != nil || q.limit < 0 {
+            return q, fmt.Errorf("invalid limit")
+        }
+    }
+    switch qs.Get("order") {
+    case "", "asc":
+    case "desc":
+        q.desc = true
+    default:
+        return q, fmt.Errorf("order must be asc or desc")
+    }
+    if v := qs.Get("cursor"); v != "" {
+        after, err := decodeDataCursor(v)
+        if err != nil {
+            return q, err
+        }
+        if q.desc {
+            if next := after.Add(-time.Nanosecond); q.to.IsZero() || next.Before(q.to) {
+                q.to = next
+            }
+        } else if next := after.Add(time.Nanosecond); next.After(q.from) {
+            q.from = next
+        }
+    }
+    return q, nil
+}
+
+/*
+encodeDataCursor returns the opaque cursor naming the tick at ts.
+*/
+func encodeDataCursor(ts time.Time) string {
+    return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(ts.UnixNano(), 10)))
+}
+
+/*
+decodeDataCursor reads a cursor made by encodeDataCursor.
+*/
+func decodeDataCursor(cursor string) (time.Time, error) {
+    raw, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid cursor")
+    }
+    ns, err := strconv.ParseInt(string(raw), 10, 64)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid cursor")
+    }
+    return time.Unix(0, ns).UTC(), nil
+}
+
+/*
+setNextPage points the client at the page after the tick at last, both as an
+X-Next-Cursor header and as a Link header repeating the request with that
+cursor.
+*/
+func setNextPage(w http.ResponseWriter, r *http.Request, last time.Time) {
+    cursor := encodeDataCursor(last)
+    qs := r.URL.Query()
+    qs.Set("cursor", cursor)
+    w.Header().Set("X-Next-Cursor", cursor)
+    w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, qs.Encode()))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+/*
+Parquet physical and converted types used by the exporter, as numbered in
+the Parquet format specification.
+*/
+const (
+    parquetInt64     = 2
+    parquetDouble    = 5
+    parquetByteArray = 6
+
+    parquetNoConversion    = -1
+    parquetUTF8            = 0
+    parquetTimestampMicros = 10
+)
+
+/*
+parquetColumn is one required column of a Parquet file, with its values
+already PLAIN encoded.
+*/
+type parquetColumn struct {
+    name      string
+    kind      int32
+    converted int32
+    data      []byte
+}
+
+/*
+int64Column PLAIN encodes n integers read through at.
+*/
+func int64Column(name string, converted int32, n int, at func(i int) int64) parquetColumn {
+    data := make([]byte, 8*n)
+    for i := 0; i < n; i++ {
+        binary.LittleEndian.PutUint64(data[8*i:], uint64(at(i)))
+    }
+    return parquetColumn{name: name, kind: parquetInt64, converted: converted, data: data}
+}
+
+/*
+doubleColumn PLAIN encodes n floats read through at.
+*/
+func doubleColumn(name string, n int, at func(i int) float64) parquetColumn {
+    data := make([]byte, 8*n)
+    for i := 0; i < n; i++ {
+        binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(at(i)))
+    }
+    return parquetColumn{name: name, kind: parquetDouble, converted: parquetNoConversion, data: data}
+}
+
+/*
+stringColumn PLAIN encodes n UTF-8 strings read through at.
+*/
+func stringColumn(name string, n int, at func(i int) string) parquetColumn {
+    var data []byte
+    for i := 0; i < n; i++ {
+        s := at(i)
+        data = binary.LittleEndian.AppendUint32(data, uint32(len(s)))
+        data = append(data, s...)
+    }
+    return parquetColumn{name: name, kind: parquetByteArray, converted: parquetUTF8, data: data}
+}
+
+/*
+writeParquet writes rows rows of cols as an uncompressed Parquet file with a
+single row group and one data page per column. Every column is required, so
+no definition or repetition levels are written.
+*/
+func writeParquet(w io.Writer, cols []parquetColumn, rows int) error {
+    magic := []byte("PAR1")
+    file := append([]byte(nil), magic...)
+    offsets := make([]int64, len(cols))
+    sizes := make([]int64, len(cols))
+    for i, c := range cols {
+        var ph thriftWriter
+        ph.begin()
+        ph.i32(1, 0) // DATA_PAGE
+        ph.i32(2, int64(len(c.data)))
+        ph.i32(3, int64(len(c.data)))
+        ph.structField(5)
+        ph.i32(1, int64(rows))
+        ph.i32(2, 0) // PLAIN
+        ph.i32(3, 3) // RLE
+        ph.i32(4, 3)
+        ph.end()
+        ph.end()
+        offsets[i] = int64(len(file))
+        sizes[i] = int64(len(ph.b) + len(c.data))
+        file = append(file, ph.b...)
+        file = append(file, c.data...)
+    }
+
+    var md thriftWriter
+    md.begin()
+    md.i32(1, 1)
+    md.list(2, thriftStruct, len(cols)+1)
+    md.begin()
+    md.str(4, "schema")
+    md.i32(5, int64(len(cols)))
+    md.end()
+    for _, c := range cols {
+        md.begin()
+        md.i32(1, int64(c.kind))
+        md.i32(3, 0) // REQUIRED
+        md.str(4, c.name)
+        if c.converted != parquetNoConversion {
+            md.i32(6, int64(c.converted))
+        }
+        md.end()
+    }
+    md.i64(3, int64(rows))
+    md.list(4, thriftStruct, 1)
+    md.begin()
+    md.list(1, thriftStruct, len(cols))
+    var total int64
+    for i, c := range cols {
+        md.begin()
+        md.i64(2, offsets[i])
+        md.structField(3)
+        md.i32(1, int64(c.kind))
+        md.list(2, thriftI32, 2)
+        md.varint(0)
+        md.varint(zigzag(3))
+        md.list(3, thriftBinary, 1)
+        md.varint(uint64(len(c.name)))
+        md.b = append(md.b, c.name...)
+        md.i32(4, 0) // UNCOMPRESSED
+        md.i64(5, int64(rows))
+        md.i64(6, sizes[i])
+        md.i64(7, sizes[i])
+        md.i64(9, offsets[i])
+        md.end()
+        md.end()
+        total += sizes[i]
+    }
+    md.i64(2, total)
+    md.i64(3, int64(rows))
+    md.end()
+    md.str(6, "financial-forecaster")
+    md.end()
+
+    file = append(file, md.b...)
+    file = binary.LittleEndian.AppendUint32(file, uint32(len(md.b)))
+    file = append(file, magic...)
+    _, err := w.Write(file)
+    return err
+}
+
+/*
+Thrift compact protocol type codes used in Parquet metadata.
+*/
+const (
+    thriftI32    = 5
+    thriftI64    = 6
+    thriftBinary = 8
+    thriftList   = 9
+    thriftStruct = 12
+)
+
+/*
+thriftWriter encodes structs in the Thrift compact protocol, which Parquet
+uses for page headers and file metadata. last holds the previous field id
+of each struct being written, innermost last.
+*/
+type thriftWriter struct {
+    b    []byte
+    last []int16
+}
+
+/*
+zigzag maps signed integers onto unsigned ones so small magnitudes stay short.
+*/
+func zigzag(v int64) uint64 {
+    return uint64(v<<1) ^ uint64(v>>63)
+}
+
+/*
+varint appends u as an unsigned LEB128 varint.
+*/
+func (t *thriftWriter) varint(u uint64) {
+    t.b = binary.AppendUvarint(t.b, u)
+}
+
+/*
+field writes a field header, using the short delta form when it fits.
+*/
+func (t *thriftWriter) field(id int16, kind byte) {
+    top := &t.last[len(t.last)-1]
+    if d := id - *top; d > 0 && d <= 15 {
+        t.b = append(t.b, byte(d)<<4|kind)
+    } else {
+        t.b = append(t.b, kind)
+        t.varint(zigzag(int64(id)))
+    }
+    *top = id
+}
+
+/*
+begin starts a struct written as a list element or as the top-level value.
+*/
+func (t *thriftWriter) begin() {
+    t.last = append(t.last, 0)
+}
+
+/*
+end closes the innermost struct, however it was opened.
+*/
+func (t *thriftWriter) end() {
+    t.b = append(t.b, 0)
+    t.last = t.last[:len(t.last)-1]
+}
+
+/*
+structField starts a struct-valued field.
+*/
+func (t *thriftWriter) structField(id int16) {
+    t.field(id, thriftStruct)
+    t.begin()
+}
+
+/*
+i32 writes a 32-bit integer field.
+*/
+func (t *thriftWriter) i32(id int16, v int64) {
+    t.field(id, thriftI32)
+    t.varint(zigzag(v))
+}
+
+/*
+i64 writes a 64-bit integer field.
+*/
+func (t *thriftWriter) i64(id int16, v int64) {
+    t.field(id, thriftI64)
+    t.varint(zigzag(v))
+}
+
+/*
+str writes a string field.
+*/
+func (t *thriftWriter) str(id int16, s string) {
+    t.field(id, thriftBinary)
+    t.varint(uint64(len(s)))
+    t.b = append(t.b, s...)
+}
+
+/*
+list writes the header of a list of n elements of kind; the caller writes
+the elements.
+*/
+func (t *thriftWriter) list(id int16, kind byte, n int) {
+    t.field(id, thriftList)
+    if n < 15 {
+        t.b = append(t.b, byte(n)<<4|kind)
+    } else {
+        t.b = append(t.b, 0xf0|kind)
+        t.varint(uint64(n))
+    }
+}
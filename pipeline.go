@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+symbolPipeline is one symbol's collection schedule, run on the scrape pool.
+Closing stop asks it to end; done is closed once it has. delay is how long
+it waits before its first fetch, jitter the random part of it. due, order
+and index are its place in the pool's queue, guarded by the pool.
+*/
+type symbolPipeline struct {
+    symbol    string
+    stop      chan struct{}
+    done      chan struct{}
+    startedAt time.Time
+    restarts  int
+    delay     time.Duration
+    jitter    time.Duration
+    lastTick  atomic.Int64
+    times     fetchTimes
+    halted    sync.Once
+    due       time.Time
+    order     int64
+    index     int
+}
+
+/*
+halt closes stop; it is safe to call more than once.
+*/
+func (p *symbolPipeline) halt() {
+    p.halted.Do(func() { close(p.stop) })
+}
+
+/*
+stopped reports whether the pipeline has been asked to exit.
+*/
+func (p *symbolPipeline) stopped() bool {
+    select {
+    case <-p.stop:
+        return true
+    default:
+        return false
+    }
+}
+
+/*
+startPipeline gives symbol a fresh collector and queues its pipeline on the
+scrape pool, which makes its first fetch after delay, jitter of which was
+random.
+*/
+func (fp *FinancialProcessor) startPipeline(symbol string, delay, jitter time.Duration) *symbolPipeline {
+    p := &symbolPipeline{
+        symbol:    symbol,
+        stop:      make(chan struct{}),
+        done:      make(chan struct{}),
+        startedAt: fp.clock.Now(),
+        delay:     delay,
+        jitter:    jitter,
+        index:     -1,
+    }
+    fp.mutex.Lock()
+    if old, ok := fp.pipelines[symbol]; ok {
+        p.restarts = old.restarts + 1
+    }
+    fp.collectors[symbol] = NewDataCollector()
+    fp.pipelines[symbol] = p
+    fp.mutex.Unlock()
+
+    fp.pool.Add(p)
+    return p
+}
+
+/*
+stopPipeline stops symbol's pipeline and drops its collector while leaving
+it tracked, reporting false when it had no pipeline.
+*/
+func (fp *FinancialProcessor) stopPipeline(symbol string) bool {
+    fp.mutex.Lock()
+    p, ok := fp.pipelines[symbol]
+    delete(fp.pipelines, symbol)
+    delete(fp.collectors, symbol)
+    fp.mutex.Unlock()
+    if ok {
+        fp.pool.Stop(p)
+    }
+    return ok
+}
+
+/*
+restartSymbol stops symbol's pipeline and starts a new one with fresh
+scraper state, releasing it from quarantine and forgetting its failed
+fetches so the new pipeline fetches at once. A fetch that is already in flight is given up to wait to
+finish; if it is still running afterwards its result is discarded. It reports
+false when symbol has no pipeline, e.g. under batched collection or once
+it has been deactivated as delisted.
+*/
+func (fp *FinancialProcessor) restartSymbol(symbol string, wait time.Duration) (*symbolPipeline, bool) {
+    fp.mutex.RLock()
+    old, ok := fp.pipelines[symbol]
+    fp.mutex.RUnlock()
+    if !ok {
+        return nil, false
+    }
+    fp.pool.Stop(old)
+    select {
+    case <-old.done:
+    case <-time.After(wait):
+        slog.Warn("previous pipeline still fetching, detaching it", "symbol", symbol)
+    }
+    fp.quarantine.Forget(symbol)
+    fp.failures.note(symbol, nil)
+    p := fp.startPipeline(symbol, 0, 0)
+    slog.Info("pipeline restarted", "symbol", symbol, "restarts", p.restarts)
+    return p, true
+}
+
+/*
+handleRestartSymbol exposes POST /api/admin/symbols/{symbol}/restart, which
+tears down and recreates a single symbol's collector and pipeline without
+restarting the process.
+*/
+func (fp *FinancialProcessor) handleRestartSymbol(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    p, ok := fp.restartSymbol(sym, 15*time.Second)
+    if !ok {
+        http.Error(w, "no per-symbol pipeline for "+sym, http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "symbol":     p.symbol,
+        "started_at": p.startedAt,
+        "restarts":   p.restarts,
+    })
+}
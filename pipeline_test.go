@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestartSymbolReleasesQuarantine(t *testing.T) {
+    fp := newTestProcessor(t, map[string]string{"SYMBOLS": "AAPL", "QUARANTINE_AFTER": "2"})
+    fp.clock = NewVirtualClock(time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC))
+    // Keep the pool's workers from starting so nothing is actually fetched.
+    fp.pool.started.Do(func() {})
+    fp.startPipeline("AAPL", time.Hour, 0)
+
+    now := fp.clock.Now()
+    fetchErr := errors.New("quote not found")
+    for i := 0; i < 2; i++ {
+        fp.quarantine.Observe("AAPL", fetchErr, now)
+        fp.failures.note("AAPL", fetchErr)
+    }
+    if _, held := fp.quarantine.Holds("AAPL", now); !held {
+        t.Fatal("AAPL not quarantined after 2 failed fetches")
+    }
+
+    p, ok := fp.restartSymbol("AAPL", time.Second)
+    if !ok || p.restarts != 1 {
+        t.Fatalf("restartSymbol = %+v, %v; want a pipeline restarted once", p, ok)
+    }
+    if _, held := fp.quarantine.Holds("AAPL", now); held {
+        t.Error("AAPL still quarantined after restart")
+    }
+    if msg, ok := fp.failures.last("AAPL"); ok {
+        t.Errorf("last failure after restart = %q, want none", msg)
+    }
+    // The failure streak starts over: one more failure must not quarantine it again.
+    fp.quarantine.Observe("AAPL", fetchErr, now)
+    if _, held := fp.quarantine.Holds("AAPL", now); held {
+        t.Error("AAPL quarantined again by the first failure after restart")
+    }
+}
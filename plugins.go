@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+pluginRequest is one line written to a plugin's stdin. Method is "fetch",
+for the quote of Symbol, or "symbols", for the symbols the plugin serves.
+*/
+type pluginRequest struct {
+    ID     int64  `json:"id"`
+    Method string `json:"method"`
+    Symbol string `json:"symbol,omitempty"`
+}
+
+/*
+pluginResponse is one line read from a plugin's stdout, answering the
+request with the same ID. A fetch is answered with the quote fields, a
+symbols request with Symbols, and either with Error when it fails.
+Timestamp defaults to the time of the answer.
+*/
+type pluginResponse struct {
+    ID            int64      `json:"id"`
+    Error         string     `json:"error,omitempty"`
+    Symbols       []string   `json:"symbols,omitempty"`
+    Price         float64    `json:"price"`
+    Volume        int64      `json:"volume"`
+    Timestamp     *time.Time `json:"timestamp,omitempty"`
+    Open          float64    `json:"open,omitempty"`
+    High          float64    `json:"high,omitempty"`
+    Low           float64    `json:"low,omitempty"`
+    PreviousClose float64    `json:"previous_close,omitempty"`
+    AssetClass    string     `json:"asset_class,omitempty"`
+}
+
+/*
+execPlugin is a data source running as an external program that speaks
+newline-delimited JSON over stdio: one pluginRequest per line on stdin, one
+pluginResponse per line on stdout, one request at a time. Whatever it writes
+to stderr is logged. The program is started on first use and restarted
+after it exits or fails to answer within PLUGIN_TIMEOUT (default 15s).
+*/
+type execPlugin struct {
+    command string
+    args    []string
+    timeout time.Duration
+    mu      sync.Mutex
+    cmd     *exec.Cmd
+    stdin   io.WriteCloser
+    lines   chan []byte
+    nextID  int64
+}
+
+var (
+    pluginMu sync.Mutex
+    plugins  = make(map[string]*execPlugin)
+)
+
+/*
+execPluginFor returns the plugin running spec, a program path followed by
+space-separated arguments, shared by every symbol configured with the same spec.
+*/
+func execPluginFor(spec string) (*execPlugin, error) {
+    fields := strings.Fields(spec)
+    if len(fields) == 0 {
+        return nil, fmt.Errorf("missing plugin command")
+    }
+    key := strings.Join(fields, " ")
+    pluginMu.Lock()
+    defer pluginMu.Unlock()
+    if p, ok := plugins[key]; ok {
+        return p, nil
+    }
+    path, err := exec.LookPath(fields[0])
+    if err != nil {
+        return nil, err
+    }
+    p := &execPlugin{command: path, args: fields[1:], timeout: envDuration("PLUGIN_TIMEOUT", 15*time.Second)}
+    plugins[key] = p
+    return p, nil
+}
+
+/*
+start launches the program. Callers must hold p.mu.
+*/
+func (p *execPlugin) start() error {
+    cmd := exec.Command(p.command, p.args...)
+    cmd.Env = os.Environ()
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return err
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return err
+    }
+    stderr, err := cmd.StderrPipe()
+    if err != nil {
+        return err
+    }
+    if err := cmd.Start(); err != nil {
+        return fmt.Errorf("starting plugin %s: %w", p.command, err)
+    }
+    var logged sync.WaitGroup
+    logged.Add(1)
+    go func() {
+        defer logged.Done()
+        sc := bufio.NewScanner(stderr)
+        for sc.Scan() {
+            slog.Info("plugin output", "plugin", p.command, "line", sc.Text())
+        }
+    }()
+    lines := make(chan []byte)
+    go func() {
+        r := bufio.NewReaderSize(stdout, 64*1024)
+        for {
+            line, err := r.ReadBytes('\n')
+            if len(line) > 0 {
+                lines <- line
+            }
+            if err != nil {
+                break
+            }
+        }
+        logged.Wait()
+        if err := cmd.Wait(); err != nil {
+            slog.Warn("plugin exited", "plugin", p.command, "err", err)
+        }
+        close(lines)
+    }()
+    p.cmd, p.stdin, p.lines = cmd, stdin, lines
+    return nil
+}
+
+/*
+stop kills the program so the next call starts it afresh. Callers must hold p.mu.
+*/
+func (p *execPlugin) stop() {
+    if p.cmd == nil {
+        return
+    }
+    p.stdin.Close()
+    p.cmd.Process.Kill()
+    for range p.lines {
+    }
+    p.cmd, p.stdin, p.lines = nil, nil, nil
+}
+
+/*
+call sends one request and waits for its answer.
+*/
+func (p *execPlugin) call(method, symbol string) (*pluginResponse, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.cmd == nil {
+        if err := p.start(); err != nil {
+            return nil, err
+        }
+    }
+    p.nextID++
+    req, _ := json.Marshal(pluginRequest{ID: p.nextID, Method: method, Symbol: symbol})
+    if _, err := p.stdin.Write(append(req, '\n')); err != nil {
+        p.stop()
+        return nil, fmt.Errorf("plugin %s: %w", p.command, err)
+    }
+    deadline := time.After(p.timeout)
+    for {
+        select {
+        case line, ok := <-p.lines:
+            if !ok {
+                p.stop()
+                return nil, fmt.Errorf("plugin %s exited", p.command)
+            }
+            var resp pluginResponse
+            if err := json.Unmarshal(line, &resp); err != nil {
+                slog.Warn("plugin: ignoring malformed line", "plugin", p.command, "err", err)
+                continue
+            }
+            if resp.ID != p.nextID {
+                continue
+            }
+            if resp.Error != "" {
+                return nil, fmt.Errorf("plugin %s: %s", p.command, resp.Error)
+            }
+            return &resp, nil
+        case <-deadline:
+            p.stop()
+            return nil, fmt.Errorf("plugin %s did not answer within %s", p.command, p.timeout)
+        }
+    }
+}
+
+/*
+execSource fetches quotes from the plugin program given as the provider
+argument, e.g. exec:/opt/plugins/spot --market lme.
+*/
+func execSource(arg string) (FetchFunc, error) {
+    p, err := execPluginFor(arg)
+    if err != nil {
+        return nil, err
+    }
+    return func(symbol string) (*StockData, error) {
+        resp, err := p.call("fetch", symbol)
+        if err != nil {
+            return nil, err
+        }
+        if resp.Price <= 0 {
+            return nil, fmt.Errorf("plugin %s returned no price for %s", p.command, symbol)
+        }
+        sd := &StockData{
+            Symbol:        symbol,
+            Price:         resp.Price,
+            Volume:        resp.Volume,
+            AssetClass:    resp.AssetClass,
+            Open:          resp.Open,
+            High:          resp.High,
+            Low:           resp.Low,
+            PreviousClose: resp.PreviousClose,
+        }
+        if resp.Timestamp != nil {
+            sd.Timestamp = *resp.Timestamp
+        }
+        return sd, nil
+    }, nil
+}
+
+/*
+loadPluginUniverses asks each plugin in SOURCE_PLUGINS (semicolon-separated
+commands) for the symbols it serves and adds an exec source for each to
+sources, unless SYMBOL_SOURCES already configures the symbol. These symbols
+are Listed, so they are tracked without being configured in SYMBOLS.
+*/
+func loadPluginUniverses(sources map[string]*SymbolSource) error {
+    for _, spec := range strings.Split(os.Getenv("SOURCE_PLUGINS"), ";") {
+        if spec = strings.TrimSpace(spec); spec == "" {
+            continue
+        }
+        fetch, err := execSource(spec)
+        if err != nil {
+            return fmt.Errorf("SOURCE_PLUGINS: %w", err)
+        }
+        p, _ := execPluginFor(spec)
+        resp, err := p.call("symbols", "")
+        if err != nil {
+            return fmt.Errorf("SOURCE_PLUGINS: listing symbols: %w", err)
+        }
+        for _, sym := range resp.Symbols {
+            sym = strings.ToUpper(strings.TrimSpace(sym))
+            if !symbolPattern.MatchString(sym) {
+                return fmt.Errorf("SOURCE_PLUGINS: %s listed invalid symbol %q", p.command, sym)
+            }
+            if _, ok := sources[sym]; !ok {
+                sources[sym] = &SymbolSource{Provider: "exec", Arg: spec, Fetch: fetch, Listed: true}
+            }
+        }
+        slog.Info("plugin symbols listed", "plugin", p.command, "symbols", len(resp.Symbols))
+    }
+    return nil
+}
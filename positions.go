@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Position is an open holding in a tracked symbol. A negative Quantity is a short.
+Quantity and AvgPrice are kept exactly as entered.
+*/
+type Position struct {
+    Symbol   string    `json:"symbol"`
+    Quantity Decimal   `json:"quantity"`
+    AvgPrice Decimal   `json:"avg_price"`
+    OpenedAt time.Time `json:"opened_at"`
+}
+
+/*
+RiskAlert flags a prediction that moves against an open position. Exposure is
+the position's market value at the predicted-from price, and ExpectedLoss the
+portion of it the prediction implies would be lost.
+*/
+type RiskAlert struct {
+    Symbol              string    `json:"symbol"`
+    Side                string    `json:"side"`
+    Quantity            Decimal   `json:"quantity"`
+    Exposure            float64   `json:"exposure"`
+    PredictedChangePerc float64   `json:"predicted_change_percent"`
+    ExpectedLoss        float64   `json:"expected_loss"`
+    Timestamp           time.Time `json:"timestamp"`
+}
+
+/*
+PositionBook stores open positions and the risk alerts currently raised
+against them. Positions are saved to POSITIONS_FILE when it is set, encrypted
+when a storage key is configured.
+*/
+type PositionBook struct {
+    mu        sync.RWMutex
+    positions map[string]Position
+    alerts    map[string]RiskAlert
+    threshold float64
+    path      string
+}
+
+/*
+NewPositionBookFromEnv loads positions from POSITIONS_FILE if present and raises
+alerts for predicted adverse moves of at least RISK_ADVERSE_PERCENT (default 3).
+*/
+func NewPositionBookFromEnv() *PositionBook {
+    pb := &PositionBook{
+        positions: make(map[string]Position),
+        alerts:    make(map[string]RiskAlert),
+        threshold: 3,
+        path:      os.Getenv("POSITIONS_FILE"),
+    }
+    if v := os.Getenv("RISK_ADVERSE_PERCENT"); v != "" {
+        if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+            pb.threshold = f
+        } else {
+            slog.Warn("invalid setting, using default", "key", "RISK_ADVERSE_PERCENT", "value", v, "default", pb.threshold)
+        }
+    }
+    if pb.path != "" {
+        if raw, err := os.ReadFile(pb.path); err == nil {
+            var list []Position
+            if raw, err = openAtRest(raw); err != nil {
+                log.Fatalf("reading %s: %v", pb.path, err)
+            }
+            if err := json.Unmarshal(raw, &list); err != nil {
+                slog.Warn("ignoring unreadable file", "path", pb.path, "err", err)
+            }
+            for _, p := range list {
+                pb.positions[p.Symbol] = p
+            }
+        }
+    }
+    return pb
+}
+
+/*
+save writes all positions to the configured file. Callers must hold pb.mu.
+*/
+func (pb *PositionBook) save() {
+    if pb.path == "" {
+        return
+    }
+    list := make([]Position, 0, len(pb.positions))
+    for _, p := range pb.positions {
+        list = append(list, p)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+    raw, err := json.MarshalIndent(list, "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = os.WriteFile(pb.path, raw, 0o644)
+    }
+    if err != nil {
+        slog.Error("saving positions failed", "err", err)
+    }
+}
+
+/*
+Evaluate raises, refreshes, or clears the risk alert for p.Symbol depending on
+whether the prediction moves against the held position by at least the threshold.
+It returns the alert when one is active, and whether it was newly raised.
+*/
+func (pb *PositionBook) Evaluate(p Prediction) (alert RiskAlert, active, raised bool) {
+    pb.mu.Lock()
+    defer pb.mu.Unlock()
+    pos, ok := pb.positions[p.Symbol]
+    if !ok || pos.Quantity.Sign() == 0 {
+        delete(pb.alerts, p.Symbol)
+        return RiskAlert{}, false, false
+    }
+
+    side := "long"
+    adverse := -p.PredictedChangePerc
+    if pos.Quantity.Sign() < 0 {
+        side = "short"
+        adverse = p.PredictedChangePerc
+    }
+    if adverse < pb.threshold {
+        delete(pb.alerts, p.Symbol)
+        return RiskAlert{}, false, false
+    }
+
+    exposure := math.Abs(pos.Quantity.Float64()) * p.CurrentPrice
+    alert = RiskAlert{
+        Symbol:              p.Symbol,
+        Side:                side,
+        Quantity:            pos.Quantity,
+        Exposure:            exposure,
+        PredictedChangePerc: p.PredictedChangePerc,
+        ExpectedLoss:        exposure * adverse / 100,
+        Timestamp:           p.Timestamp,
+    }
+    _, already := pb.alerts[p.Symbol]
+    pb.alerts[p.Symbol] = alert
+    return alert, true, !already
+}
+
+/*
+Alerts returns the active risk alerts ordered by exposure, largest first.
+*/
+func (pb *PositionBook) Alerts() []RiskAlert {
+    pb.mu.RLock()
+    defer pb.mu.RUnlock()
+    out := make([]RiskAlert, 0, len(pb.alerts))
+    for _, a := range pb.alerts {
+        out = append(out, a)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Exposure > out[j].Exposure })
+    return out
+}
+
+/*
+handleListPositions exposes GET /api/positions.
+*/
+func (pb *PositionBook) handleListPositions(w http.ResponseWriter, r *http.Request) {
+    pb.mu.RLock()
+    list := make([]Position, 0, len(pb.positions))
+    for _, p := range pb.positions {
+        list = append(list, p)
+    }
+    pb.mu.RUnlock()
+    sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+    json.NewEncoder(w).Encode(list)
+}
+
+/*
+handlePutPosition exposes PUT /api/positions/{symbol}, creating or replacing
+the position from a JSON body with quantity and avg_price.
+*/
+func (pb *PositionBook) handlePutPosition(w http.ResponseWriter, r *http.Request) {
+    var pos Position
+    if err := json.NewDecoder(r.Body).Decode(&pos); err != nil {
+        http.Error(w, "invalid position: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    pos.Symbol = mux.Vars(r)["symbol"]
+    if pos.OpenedAt.IsZero() {
+        pos.OpenedAt = time.Now()
+    }
+    pb.mu.Lock()
+    pb.positions[pos.Symbol] = pos
+    pb.save()
+    pb.mu.Unlock()
+    json.NewEncoder(w).Encode(pos)
+}
+
+/*
+handleDeletePosition exposes DELETE /api/positions/{symbol}.
+*/
+func (pb *PositionBook) handleDeletePosition(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    pb.mu.Lock()
+    _, ok := pb.positions[sym]
+    delete(pb.positions, sym)
+    delete(pb.alerts, sym)
+    pb.save()
+    pb.mu.Unlock()
+    if !ok {
+        http.Error(w, "no position", http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+handleRiskAlerts exposes GET /api/risk/alerts, listing active position risk
+alerts prioritized by exposure.
+*/
+func (pb *PositionBook) handleRiskAlerts(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(pb.Alerts())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+PredictionHistory is a symbol's latest prediction together with its recent
+predictions, oldest first.
+*/
+type PredictionHistory struct {
+    Symbol  string       `json:"symbol"`
+    Latest  Prediction   `json:"latest"`
+    History []Prediction `json:"history"`
+}
+
+/*
+PredictionEntry is one symbol's latest prediction, if any, with the status
+of the data behind it.
+*/
+type PredictionEntry struct {
+    Symbol string `json:"symbol"`
+    *Prediction
+    Status EntryStatus `json:"status"`
+}
+
+/*
+handleListPredictions exposes GET /api/predictions, the latest prediction for
+every tracked symbol or symbol with predictions, ordered by symbol. Symbols
+without a prediction are listed with a status saying why. The list is served
+from a snapshot.
+*/
+func (fp *FinancialProcessor) handleListPredictions(w http.ResponseWriter, r *http.Request) {
+    fp.snapshots.Serve(w, "predictions", func() (interface{}, bool) { return fp.predictionEntries(), true })
+}
+
+/*
+predictionEntries lists the latest prediction of every symbol for
+/api/predictions.
+*/
+func (fp *FinancialProcessor) predictionEntries() []PredictionEntry {
+    seen := make(map[string]bool)
+    symbols := fp.predictions.Keys()
+    for _, sym := range symbols {
+        seen[sym] = true
+    }
+    for _, sym := range fp.trackedSymbols() {
+        if !seen[sym] {
+            symbols = append(symbols, sym)
+        }
+    }
+    sort.Strings(symbols)
+
+    out := make([]PredictionEntry, 0, len(symbols))
+    for _, sym := range symbols {
+        e := PredictionEntry{Symbol: sym}
+        var latest *time.Time
+        if sd, ok := fp.dataStore.Latest(sym); ok {
+            latest = &sd.Timestamp
+        }
+        e.Status = fp.entryStatus(sym, latest)
+        if p, ok := fp.predictions.Latest(sym); ok {
+            e.Prediction = &p
+        } else if e.Status.Status == "ok" {
+            e.Status = EntryStatus{Status: "no_data", Error: "no prediction yet", Retriable: true,
+                RetryAfterSeconds: int(fp.collectionInterval(sym).Seconds())}
+        }
+        out = append(out, e)
+    }
+    return out
+}
+
+/*
+handleGetPredictions exposes GET /api/predictions/{symbol}, the symbol's
+latest prediction and up to limit recent ones (all retained by default).
+*/
+func (fp *FinancialProcessor) handleGetPredictions(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    limit := 0
+    if v := r.URL.Query().Get("limit"); v != "" {
+        var err error
+        if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+            http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+            return
+        }
+    }
+    history := fp.predictions.Window(sym, limit)
+    if len(history) == 0 {
+        http.Error(w, "no predictions", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(PredictionHistory{Symbol: sym, Latest: history[len(history)-1], History: history})
+}
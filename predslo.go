@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+PredictionSLO tracks, per symbol, how many collection intervals produced a
+fresh prediction within PREDICTION_SLO_SECONDS (default 30) of the tick that
+opened them. An interval opens when a tick schedules a prediction and closes
+when the prediction arrives or, without one, when the next tick does. Only
+the last PREDICTION_SLO_WINDOW intervals (default 1000) count, and a symbol
+is in breach when fewer than PREDICTION_SLO_OBJECTIVE percent (default 99)
+of them met the target.
+*/
+type PredictionSLO struct {
+    target    time.Duration
+    objective float64
+    window    int
+    mu        sync.Mutex
+    series    map[string]*sloSeries
+}
+
+/*
+sloSeries is one symbol's open interval and recent outcomes, kept as a ring.
+*/
+type sloSeries struct {
+    open     time.Time
+    outcomes []bool
+    next     int
+    met      int
+    latency  time.Duration
+}
+
+/*
+SLOReport is one symbol's compliance as served at /api/slo.
+*/
+type SLOReport struct {
+    Symbol             string  `json:"symbol"`
+    Intervals          int     `json:"intervals"`
+    Met                int     `json:"met"`
+    CompliancePercent  float64 `json:"compliance_percent"`
+    ObjectivePercent   float64 `json:"objective_percent"`
+    TargetSeconds      float64 `json:"target_seconds"`
+    Breaching          bool    `json:"breaching"`
+    LastLatencySeconds float64 `json:"last_latency_seconds,omitempty"`
+    Pending            bool    `json:"pending"`
+}
+
+/*
+NewPredictionSLOFromEnv creates the tracker.
+*/
+func NewPredictionSLOFromEnv() *PredictionSLO {
+    return &PredictionSLO{
+        target:    envDuration("PREDICTION_SLO_SECONDS", 30*time.Second),
+        objective: envFloat("PREDICTION_SLO_OBJECTIVE", 99),
+        window:    envInt("PREDICTION_SLO_WINDOW", 1000),
+        series:    make(map[string]*sloSeries),
+    }
+}
+
+/*
+get returns symbol's series, creating it. Callers must hold ps.mu.
+*/
+func (ps *PredictionSLO) get(symbol string) *sloSeries {
+    s, ok := ps.series[symbol]
+    if !ok {
+        s = &sloSeries{}
+        ps.series[symbol] = s
+    }
+    return s
+}
+
+/*
+record adds one closed interval to s, evicting the oldest beyond window.
+*/
+func (s *sloSeries) record(met bool, window int) {
+    if len(s.outcomes) < window {
+        s.outcomes = append(s.outcomes, met)
+    } else {
+        if s.outcomes[s.next] {
+            s.met--
+        }
+        s.outcomes[s.next] = met
+        s.next = (s.next + 1) % window
+    }
+    if met {
+        s.met++
+    }
+}
+
+/*
+Open starts an interval for symbol at at, closing any interval still
+waiting for its prediction as missed.
+*/
+func (ps *PredictionSLO) Open(symbol string, at time.Time) {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    s := ps.get(symbol)
+    if !s.open.IsZero() {
+        s.record(false, ps.window)
+    }
+    s.open = at
+}
+
+/*
+Predicted closes symbol's open interval with a prediction made at at.
+*/
+func (ps *PredictionSLO) Predicted(symbol string, at time.Time) {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    s := ps.get(symbol)
+    if s.open.IsZero() {
+        return
+    }
+    s.latency = at.Sub(s.open)
+    s.record(s.latency <= ps.target, ps.window)
+    s.open = time.Time{}
+}
+
+/*
+Excuse drops symbol's open interval without counting it, for predictions
+skipped on purpose such as during a session freeze.
+*/
+func (ps *PredictionSLO) Excuse(symbol string) {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    if s, ok := ps.series[symbol]; ok {
+        s.open = time.Time{}
+    }
+}
+
+/*
+Report returns the compliance of every symbol with at least one interval,
+ordered by symbol.
+*/
+func (ps *PredictionSLO) Report() []SLOReport {
+    ps.mu.Lock()
+    defer ps.mu.Unlock()
+    out := make([]SLOReport, 0, len(ps.series))
+    for sym, s := range ps.series {
+        rep := SLOReport{
+            Symbol:             sym,
+            Intervals:          len(s.outcomes),
+            Met:                s.met,
+            CompliancePercent:  100,
+            ObjectivePercent:   ps.objective,
+            TargetSeconds:      ps.target.Seconds(),
+            LastLatencySeconds: s.latency.Seconds(),
+            Pending:            !s.open.IsZero(),
+        }
+        if rep.Intervals > 0 {
+            rep.CompliancePercent = 100 * float64(s.met) / float64(rep.Intervals)
+        }
+        rep.Breaching = rep.CompliancePercent < ps.objective
+        out = append(out, rep)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Symbol < out[j].Symbol })
+    return out
+}
+
+/*
+writeMetrics appends each symbol's compliance in Prometheus text format.
+*/
+func (ps *PredictionSLO) writeMetrics(sb *strings.Builder) {
+    sb.WriteString("# HELP prediction_slo_compliance_percent Intervals with a fresh prediction within the target, by symbol.\n")
+    sb.WriteString("# TYPE prediction_slo_compliance_percent gauge\n")
+    for _, rep := range ps.Report() {
+        fmt.Fprintf(sb, "prediction_slo_compliance_percent{symbol=%q} %g\n", rep.Symbol, rep.CompliancePercent)
+    }
+}
+
+/*
+handleSLO exposes GET /api/slo with every symbol's prediction freshness
+compliance, optionally only for ?symbols=AAPL,MSFT or only those in breach
+with ?breaching=true.
+*/
+func (fp *FinancialProcessor) handleSLO(w http.ResponseWriter, r *http.Request) {
+    qs := r.URL.Query()
+    want := make(map[string]bool)
+    for _, s := range strings.Split(qs.Get("symbols"), ",") {
+        if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+            want[s] = true
+        }
+    }
+    breaching := qs.Get("breaching") == "true"
+    out := []SLOReport{}
+    for _, rep := range fp.predSLO.Report() {
+        if len(want) > 0 && !want[rep.Symbol] || breaching && !rep.Breaching {
+            continue
+        }
+        out = append(out, rep)
+    }
+    json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+QuarantinedSymbol is a symbol whose fetches keep failing, reported by
+GET /api/status. Failures counts consecutive failed fetches, including the
+retries made while quarantined, and NextRetryAt is when the next one is due.
+*/
+type QuarantinedSymbol struct {
+    Symbol        string    `json:"symbol"`
+    Failures      int       `json:"failures"`
+    LastError     string    `json:"last_error"`
+    QuarantinedAt time.Time `json:"quarantined_at"`
+    NextRetryAt   time.Time `json:"next_retry_at"`
+    RetrySeconds  float64   `json:"retry_interval_seconds"`
+}
+
+/*
+ScrapeQuarantine counts consecutive failed fetches per symbol and, after
+QUARANTINE_AFTER of them (default 5, 0 disables), stops fetching the symbol
+at its collection interval: it is retried after QUARANTINE_RETRY (default
+5m), then at twice the previous wait after every failed retry, up to
+QUARANTINE_MAX_RETRY (default 6h). The first successful fetch releases it.
+Unlike a delisting, which needs the quote to be reported missing, any error
+counts, so a mistyped ticker or a source that keeps failing to parse stops
+costing a fetch every interval. While enabled reports false nothing is
+counted or held back.
+*/
+type ScrapeQuarantine struct {
+    enabled  func() bool
+    after    int
+    retry    time.Duration
+    maxRetry time.Duration
+    mu       sync.Mutex
+    symbols  map[string]*quarantineState
+}
+
+/*
+quarantineState is one failing symbol's streak; wait is zero until the
+symbol is quarantined.
+*/
+type quarantineState struct {
+    failures int
+    lastErr  string
+    since    time.Time
+    wait     time.Duration
+    next     time.Time
+}
+
+/*
+NewScrapeQuarantineFromEnv creates the quarantine, switched on and off by enabled.
+*/
+func NewScrapeQuarantineFromEnv(enabled func() bool) *ScrapeQuarantine {
+    sq := &ScrapeQuarantine{
+        enabled:  enabled,
+        after:    envInt("QUARANTINE_AFTER", 5),
+        retry:    envDuration("QUARANTINE_RETRY", 5*time.Minute),
+        maxRetry: envDuration("QUARANTINE_MAX_RETRY", 6*time.Hour),
+        symbols:  make(map[string]*quarantineState),
+    }
+    if sq.retry <= 0 || sq.maxRetry < sq.retry {
+        log.Fatalf("QUARANTINE_RETRY must be positive and at most QUARANTINE_MAX_RETRY")
+    }
+    return sq
+}
+
+/*
+Observe records the outcome of one fetch for symbol at now. It reports
+whether the symbol entered quarantine with this failure, or left it with
+this success.
+*/
+func (sq *ScrapeQuarantine) Observe(symbol string, err error, now time.Time) (entered, released bool) {
+    if sq.after <= 0 {
+        return false, false
+    }
+    sq.mu.Lock()
+    defer sq.mu.Unlock()
+    st := sq.symbols[symbol]
+    if err == nil || !sq.enabled() {
+        delete(sq.symbols, symbol)
+        return false, st != nil && st.wait > 0
+    }
+    if st == nil {
+        st = &quarantineState{}
+        sq.symbols[symbol] = st
+    }
+    st.failures++
+    st.lastErr = err.Error()
+    switch {
+    case st.failures < sq.after:
+        return false, false
+    case st.wait == 0:
+        st.since, st.wait, entered = now, sq.retry, true
+    default:
+        if st.wait *= 2; st.wait > sq.maxRetry {
+            st.wait = sq.maxRetry
+        }
+    }
+    st.next = now.Add(st.wait)
+    return entered, false
+}
+
+/*
+Holds reports whether symbol is quarantined and not yet due for a retry at
+now, and when the retry is due.
+*/
+func (sq *ScrapeQuarantine) Holds(symbol string, now time.Time) (time.Time, bool) {
+    sq.mu.Lock()
+    defer sq.mu.Unlock()
+    st := sq.symbols[symbol]
+    if st == nil || st.wait == 0 || !now.Before(st.next) || !sq.enabled() {
+        return time.Time{}, false
+    }
+    return st.next, true
+}
+
+/*
+Forget drops symbol's streak, for a symbol that is no longer collected.
+*/
+func (sq *ScrapeQuarantine) Forget(symbol string) {
+    sq.mu.Lock()
+    defer sq.mu.Unlock()
+    delete(sq.symbols, symbol)
+}
+
+/*
+List returns the quarantined symbols ordered by symbol.
+*/
+func (sq *ScrapeQuarantine) List() []QuarantinedSymbol {
+    sq.mu.Lock()
+    defer sq.mu.Unlock()
+    out := []QuarantinedSymbol{}
+    for sym, st := range sq.symbols {
+        if st.wait == 0 {
+            continue
+        }
+        out = append(out, QuarantinedSymbol{
+            Symbol:        sym,
+            Failures:      st.failures,
+            LastError:     st.lastErr,
+            QuarantinedAt: st.since,
+            NextRetryAt:   st.next,
+            RetrySeconds:  st.wait.Seconds(),
+        })
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Symbol < out[j].Symbol })
+    return out
+}
+
+/*
+writeMetrics appends the number of quarantined symbols in Prometheus text format.
+*/
+func (sq *ScrapeQuarantine) writeMetrics(sb *strings.Builder) {
+    sb.WriteString("# HELP quarantined_symbols Symbols whose fetches are held back after repeated failures.\n")
+    sb.WriteString("# TYPE quarantined_symbols gauge\n")
+    fmt.Fprintf(sb, "quarantined_symbols %d\n", len(sq.List()))
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+QuietWindow is a recurring daily do-not-disturb period in the recipient's time
+zone. Start and End are "HH:MM"; a window whose end is before its start wraps
+past midnight. Days limits it to the named weekdays ("mon".."sun") on which it
+starts; empty means every day.
+*/
+type QuietWindow struct {
+    Days  []string `json:"days,omitempty"`
+    Start string   `json:"start"`
+    End   string   `json:"end"`
+}
+
+/*
+Recipient is a user who receives alert notifications at a webhook, a Slack
+or Discord webhook, a Telegram chat and/or an email address, with optional
+quiet hours and a one-off do-not-disturb deadline. Alerts arriving while
+quiet are queued and delivered afterwards as a single summary.
+*/
+type Recipient struct {
+    User           string        `json:"user"`
+    WebhookURL     string        `json:"webhook_url,omitempty"`
+    SlackURL       string        `json:"slack_webhook_url,omitempty"`
+    DiscordURL     string        `json:"discord_webhook_url,omitempty"`
+    TelegramChatID string        `json:"telegram_chat_id,omitempty"`
+    Email          string        `json:"email,omitempty"`
+    Timezone       string        `json:"timezone,omitempty"`
+    QuietHours     []QuietWindow `json:"quiet_hours,omitempty"`
+    DNDUntil       *time.Time    `json:"dnd_until,omitempty"`
+}
+
+/*
+alertChannel is one way of reaching a recipient: "webhook", "slack",
+"discord", "telegram" or "email", with the URL, chat ID or address to send
+to.
+*/
+type alertChannel struct {
+    name   string
+    target string
+}
+
+/*
+channels lists the recipient's configured channels.
+*/
+func (rc Recipient) channels() []alertChannel {
+    var out []alertChannel
+    if rc.WebhookURL != "" {
+        out = append(out, alertChannel{"webhook", rc.WebhookURL})
+    }
+    if rc.SlackURL != "" {
+        out = append(out, alertChannel{"slack", rc.SlackURL})
+    }
+    if rc.DiscordURL != "" {
+        out = append(out, alertChannel{"discord", rc.DiscordURL})
+    }
+    if rc.TelegramChatID != "" {
+        out = append(out, alertChannel{"telegram", rc.TelegramChatID})
+    }
+    if rc.Email != "" {
+        out = append(out, alertChannel{"email", rc.Email})
+    }
+    return out
+}
+
+/*
+clockMinutes parses "HH:MM" into minutes after midnight.
+*/
+func clockMinutes(s string) (int, error) {
+    t, err := time.Parse("15:04", s)
+    if err != nil {
+        return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+    }
+    return t.Hour()*60 + t.Minute(), nil
+}
+
+/*
+validate checks the recipient's time zone and windows.
+*/
+func (rc Recipient) validate() error {
+    if len(rc.channels()) == 0 {
+        return fmt.Errorf("at least one of webhook_url, slack_webhook_url, discord_webhook_url, telegram_chat_id and email is required")
+    }
+    if rc.Email != "" {
+        if _, err := mail.ParseAddress(rc.Email); err != nil {
+            return fmt.Errorf("invalid email %q", rc.Email)
+        }
+    }
+    if _, err := time.LoadLocation(rc.Timezone); err != nil {
+        return fmt.Errorf("invalid timezone %q", rc.Timezone)
+    }
+    for _, w := range rc.QuietHours {
+        if _, err := clockMinutes(w.Start); err != nil {
+            return err
+        }
+        if _, err := clockMinutes(w.End); err != nil {
+            return err
+        }
+        for _, d := range w.Days {
+            if _, ok := weekdayNames[strings.ToLower(d)]; !ok {
+                return fmt.Errorf("invalid day %q", d)
+            }
+        }
+    }
+    return nil
+}
+
+/*
+weekdayNames maps short day names to weekdays.
+*/
+var weekdayNames = map[string]time.Weekday{
+    "sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+    "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+/*
+quiet reports whether notifications to rc are held at now.
+*/
+func (rc Recipient) quiet(now time.Time) bool {
+    if rc.DNDUntil != nil && now.Before(*rc.DNDUntil) {
+        return true
+    }
+    loc, err := time.LoadLocation(rc.Timezone)
+    if err != nil {
+        loc = time.UTC
+    }
+    local := now.In(loc)
+    minute := local.Hour()*60 + local.Minute()
+    onDay := func(w QuietWindow, day time.Weekday) bool {
+        if len(w.Days) == 0 {
+            return true
+        }
+        for _, d := range w.Days {
+            if weekdayNames[strings.ToLower(d)] == day {
+                return true
+            }
+        }
+        return false
+    }
+    for _, w := range rc.QuietHours {
+        start, _ := clockMinutes(w.Start)
+        end, _ := clockMinutes(w.End)
+        switch {
+        case start <= end:
+            if minute >= start && minute < end && onDay(w, local.Weekday()) {
+                return true
+            }
+        case minute >= start:
+            if onDay(w, local.Weekday()) {
+                return true
+            }
+        case minute < end:
+            if onDay(w, local.AddDate(0, 0, -1).Weekday()) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+/*
+RecipientBook holds alert recipients and the alerts queued for each during
+quiet hours. Recipients are saved to ALERT_RECIPIENTS_FILE when set; queued
+alerts live in memory only.
+*/
+type RecipientBook struct {
+    mu         sync.Mutex
+    recipients map[string]Recipient
+    queued     map[string][]AlertRecord
+    path       string
+}
+
+/*
+NewRecipientBookFromEnv loads saved recipients. When ALERT_WEBHOOK_URL,
+ALERT_SLACK_WEBHOOK_URL or ALERT_EMAIL_TO is set and no "default" recipient
+was saved, they make up the "default" recipient.
+*/
+func NewRecipientBookFromEnv() *RecipientBook {
+    rb := &RecipientBook{
+        recipients: make(map[string]Recipient),
+        queued:     make(map[string][]AlertRecord),
+        path:       os.Getenv("ALERT_RECIPIENTS_FILE"),
+    }
+    if rb.path != "" {
+        if raw, err := os.ReadFile(rb.path); err == nil {
+            var list []Recipient
+            if raw, err = openAtRest(raw); err != nil {
+                log.Fatalf("reading %s: %v", rb.path, err)
+            }
+            if err := json.Unmarshal(raw, &list); err != nil {
+                slog.Warn("ignoring unreadable file", "path", rb.path, "err", err)
+            }
+            for _, rc := range list {
+                rb.recipients[rc.User] = rc
+            }
+        }
+    }
+    def := Recipient{
+        User:       "default",
+        WebhookURL: os.Getenv("ALERT_WEBHOOK_URL"),
+        SlackURL:   os.Getenv("ALERT_SLACK_WEBHOOK_URL"),
+        Email:      os.Getenv("ALERT_EMAIL_TO"),
+    }
+    if _, ok := rb.recipients["default"]; !ok && len(def.channels()) > 0 {
+        rb.recipients["default"] = def
+    }
+    return rb
+}
+
+/*
+list returns all recipients ordered by user. Callers must hold rb.mu.
+*/
+func (rb *RecipientBook) list() []Recipient {
+    out := make([]Recipient, 0, len(rb.recipients))
+    for _, rc := range rb.recipients {
+        out = append(out, rc)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+    return out
+}
+
+/*
+save writes all recipients to the configured file. Callers must hold rb.mu.
+*/
+func (rb *RecipientBook) save() {
+    if rb.path == "" {
+        return
+    }
+    raw, err := json.MarshalIndent(rb.list(), "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = os.WriteFile(rb.path, raw, 0o600)
+    }
+    if err != nil {
+        slog.Error("saving alert recipients failed", "err", err)
+    }
+}
+
+/*
+Recipients returns a snapshot of all recipients.
+*/
+func (rb *RecipientBook) Recipients() []Recipient {
+    rb.mu.Lock()
+    defer rb.mu.Unlock()
+    return rb.list()
+}
+
+/*
+Queue holds rec for user until their quiet period ends.
+*/
+func (rb *RecipientBook) Queue(user string, rec AlertRecord) {
+    rb.mu.Lock()
+    rb.queued[user] = append(rb.queued[user], rec)
+    rb.mu.Unlock()
+}
+
+/*
+Due removes and returns the queued alerts of every recipient no longer quiet at now.
+*/
+func (rb *RecipientBook) Due(now time.Time) map[string][]AlertRecord {
+    rb.mu.Lock()
+    defer rb.mu.Unlock()
+    out := make(map[string][]AlertRecord)
+    for user, recs := range rb.queued {
+        rc, ok := rb.recipients[user]
+        if !ok {
+            delete(rb.queued, user)
+            continue
+        }
+        if len(recs) > 0 && !rc.quiet(now) {
+            out[user] = recs
+            delete(rb.queued, user)
+        }
+    }
+    return out
+}
+
+/*
+handleListRecipients exposes GET /api/alerts/recipients, including how many
+alerts are currently queued for each.
+*/
+func (rb *RecipientBook) handleListRecipients(w http.ResponseWriter, r *http.Request) {
+    type view struct {
+        Recipient
+        Quiet  bool `json:"quiet"`
+        Queued int  `json:"queued"`
+    }
+    now := time.Now()
+    rb.mu.Lock()
+    out := []view{}
+    for _, rc := range rb.list() {
+        out = append(out, view{Recipient: rc, Quiet: rc.quiet(now), Queued: len(rb.queued[rc.User])})
+    }
+    rb.mu.Unlock()
+    json.NewEncoder(w).Encode(out)
+}
+
+/*
+handlePutRecipient exposes PUT /api/alerts/recipients/{user}, creating or
+replacing the user's channels, quiet hours and do-not-disturb deadline.
+*/
+func (rb *RecipientBook) handlePutRecipient(w http.ResponseWriter, r *http.Request) {
+    var rc Recipient
+    if err := json.NewDecoder(r.Body).Decode(&rc); err != nil {
+        http.Error(w, "invalid recipient: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    rc.User = mux.Vars(r)["user"]
+    if err := rc.validate(); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    rb.mu.Lock()
+    rb.recipients[rc.User] = rc
+    rb.save()
+    rb.mu.Unlock()
+    json.NewEncoder(w).Encode(rc)
+}
+
+/*
+handleDeleteRecipient exposes DELETE /api/alerts/recipients/{user}. Alerts
+still queued for the user are dropped.
+*/
+func (rb *RecipientBook) handleDeleteRecipient(w http.ResponseWriter, r *http.Request) {
+    user := mux.Vars(r)["user"]
+    rb.mu.Lock()
+    _, ok := rb.recipients[user]
+    delete(rb.recipients, user)
+    delete(rb.queued, user)
+    rb.save()
+    rb.mu.Unlock()
+    if !ok {
+        http.Error(w, "no recipient", http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
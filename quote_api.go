@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+yahooQuoteAPI is Yahoo's JSON quote endpoint, which accepts a comma-separated
+list of symbols and returns one result per symbol.
+*/
+const yahooQuoteAPI = "https://query1.finance.yahoo.com/v7/finance/quote"
+
+/*
+quoteAPIResponse mirrors the parts of the v7 quote response we consume.
+*/
+type quoteAPIResponse struct {
+    QuoteResponse struct {
+        Result []struct {
+            Symbol              string  `json:"symbol"`
+            QuoteType           string  `json:"quoteType"`
+            RegularMarketPrice  float64 `json:"regularMarketPrice"`
+            RegularMarketVolume int64   `json:"regularMarketVolume"`
+            RegularMarketTime   int64   `json:"regularMarketTime"`
+            RegularMarketOpen   float64 `json:"regularMarketOpen"`
+            DayHigh             float64 `json:"regularMarketDayHigh"`
+            DayLow              float64 `json:"regularMarketDayLow"`
+            PreviousClose       float64 `json:"regularMarketPreviousClose"`
+        } `json:"result"`
+        Error *struct {
+            Code        string `json:"code"`
+            Description string `json:"description"`
+        } `json:"error"`
+    } `json:"quoteResponse"`
+}
+
+/*
+quoteClient is the HTTP client used for Yahoo JSON API calls.
+*/
+var quoteClient = egressClient(egressYahoo, 15*time.Second)
+
+/*
+FetchQuotes retrieves snapshots for several symbols in a single request to the
+Yahoo quote API, made through the shared Yahoo session since the endpoint
+needs a cookie and crumb. Symbols missing from the response are absent from
+the result map.
+*/
+func FetchQuotes(symbols []string) (map[string]*StockData, error) {
+    resp, err := yahooSession.Get(yahooQuoteAPI + "?symbols=" + url.QueryEscape(strings.Join(symbols, ",")))
+    if err != nil {
+        return nil, fmt.Errorf("quote API: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("quote API returned %s", resp.Status)
+    }
+
+    var qr quoteAPIResponse
+    if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+        return nil, err
+    }
+    if e := qr.QuoteResponse.Error; e != nil {
+        return nil, fmt.Errorf("quote API error %s: %s", e.Code, e.Description)
+    }
+
+    now := time.Now()
+    out := make(map[string]*StockData, len(qr.QuoteResponse.Result))
+    for _, q := range qr.QuoteResponse.Result {
+        ts := now
+        if q.RegularMarketTime > 0 {
+            ts = time.Unix(q.RegularMarketTime, 0)
+        }
+        out[q.Symbol] = &StockData{
+            Symbol:        q.Symbol,
+            Price:         q.RegularMarketPrice,
+            Volume:        q.RegularMarketVolume,
+            Timestamp:     ts,
+            AssetClass:    assetClassFromYahoo(q.QuoteType, q.Symbol),
+            Open:          q.RegularMarketOpen,
+            High:          q.DayHigh,
+            Low:           q.DayLow,
+            PreviousClose: q.PreviousClose,
+        }
+    }
+    return out, nil
+}
+
+/*
+chunkSymbols splits symbols into consecutive groups of at most size elements.
+*/
+func chunkSymbols(symbols []string, size int) [][]string {
+    var chunks [][]string
+    for len(symbols) > size {
+        chunks = append(chunks, symbols[:size])
+        symbols = symbols[size:]
+    }
+    if len(symbols) > 0 {
+        chunks = append(chunks, symbols)
+    }
+    return chunks
+}
+
+/*
+batchedCollection replaces the per-symbol pipelines when QUOTE_BATCH_SIZE is set:
+each cycle it fetches the symbols that are due through the quote API in
+groups of batchSize and records each returned snapshot. Symbols are due every
+collectionInterval, as their pipelines would be: their collection interval
+or interval_seconds setting adjusted for market hours, so equities slow down
+while idle or closed and crypto pairs do not, or NEWS_BOOST_INTERVAL while a
+headline boost is active. Symbols with a SYMBOL_SOURCES override keep their
+own pipelines.
+*/
+func (fp *FinancialProcessor) batchedCollection(batchSize int) {
+    defer fp.wg.Done()
+    next := make(map[string]time.Time)
+    for {
+        start := fp.clock.Now()
+        var batched []string
+        var interval time.Duration
+        for _, sym := range fp.trackedSymbols() {
+            if _, custom := fp.sources[sym]; custom || fp.delisting.Inactive(sym) || fp.pausedForLoad(sym) || !fp.shards.Owns(sym) {
+                continue
+            }
+            iv := fp.collectionInterval(sym)
+            if interval == 0 || iv < interval {
+                interval = iv
+            }
+            if start.Before(next[sym]) {
+                continue
+            }
+            if _, held := fp.quarantine.Holds(sym, start); held {
+                continue
+            }
+            next[sym] = start.Add(iv)
+            batched = append(batched, sym)
+        }
+        if interval == 0 {
+            interval = fp.currentConfig().Interval
+        }
+        for _, chunk := range chunkSymbols(batched, batchSize) {
+            fp.collectBatch(chunk)
+        }
+        fp.batchTimes.record(start, start.Add(interval))
+        select {
+        case <-fp.idle.Wake():
+            next = make(map[string]time.Time)
+        case <-fp.clock.After(start.Add(interval).Sub(fp.clock.Now())):
+        }
+    }
+}
+
+/*
+collectBatch fetches chunk through the quote API and records each returned
+snapshot. When Yahoo refuses the quote API even with a fresh cookie and
+crumb, each symbol is fetched on its own through its collector instead, as
+its pipeline would without QUOTE_BATCH_SIZE.
+*/
+func (fp *FinancialProcessor) collectBatch(chunk []string) {
+    fp.ramp.Wait()
+    start := time.Now()
+    quotes, err := FetchQuotes(chunk)
+    fp.latency.Observe(depYahoo, time.Since(start), err)
+    if errors.Is(err, errYahooUnauthorized) {
+        slog.Warn("quote API refused, fetching symbols one at a time", "symbols", chunk, "err", err)
+        for _, sym := range chunk {
+            sd, err := fp.fetch(sym)
+            if !fp.observeFetch(sym, err) && err == nil {
+                fp.recordTick(*sd)
+            }
+        }
+        return
+    }
+    if err != nil {
+        slog.Warn("batched quote fetch failed", "symbols", chunk, "source", "quote-api", "err", err)
+        for _, sym := range chunk {
+            fp.observeFetch(sym, err)
+        }
+        return
+    }
+    for _, sym := range chunk {
+        if sd, ok := quotes[sym]; ok {
+            fp.observeFetch(sym, nil)
+            fp.collectorFor(sym).attachEventDates(sd)
+            fp.recordTick(*sd)
+        } else {
+            slog.Warn("batched quote fetch returned no result", "symbol", sym, "source", "quote-api")
+            fp.observeFetch(sym, &QuoteMissingError{Symbol: sym, Status: http.StatusNotFound})
+        }
+    }
+}
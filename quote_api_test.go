@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+fakeYahoo imitates the parts of Yahoo that need a session: fc.yahoo.com sets
+a cookie, getcrumb issues a crumb for it, and the quote and quoteSummary
+APIs answer 401 unless both match. refuse makes them refuse every request.
+The chart API needs no session and always answers.
+*/
+type fakeYahoo struct {
+    mu         sync.Mutex
+    handshakes int
+    summaries  int
+    charts     int
+    crumb      string
+    expireNext bool
+    refuse     bool
+}
+
+func (fy *fakeYahoo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    fy.mu.Lock()
+    defer fy.mu.Unlock()
+    cookie, _ := r.Cookie("A3")
+    switch {
+    case r.Host == "fc.yahoo.com":
+        fy.handshakes++
+        http.SetCookie(w, &http.Cookie{Name: "A3", Value: fmt.Sprintf("session-%d", fy.handshakes), Domain: "yahoo.com", Path: "/"})
+        http.NotFound(w, r)
+    case r.URL.Path == "/v1/test/getcrumb":
+        if cookie == nil {
+            http.Error(w, "no cookie", http.StatusUnauthorized)
+            return
+        }
+        fy.crumb = "crumb-for-" + cookie.Value
+        fmt.Fprint(w, fy.crumb)
+    case strings.HasPrefix(r.URL.Path, "/v10/finance/quoteSummary/"):
+        if fy.refuse || cookie == nil || fy.crumb != r.URL.Query().Get("crumb") {
+            http.Error(w, `{"finance":{"error":{"code":"Unauthorized","description":"Invalid Crumb"}}}`, http.StatusUnauthorized)
+            return
+        }
+        fy.summaries++
+        var modules []string
+        for _, m := range strings.Split(r.URL.Query().Get("modules"), ",") {
+            modules = append(modules, fmt.Sprintf(`%q:{"symbol":%q}`, m, strings.TrimPrefix(r.URL.Path, "/v10/finance/quoteSummary/")))
+        }
+        fmt.Fprintf(w, `{"quoteSummary":{"result":[{%s}],"error":null}}`, strings.Join(modules, ","))
+    case r.URL.Path == "/v7/finance/quote":
+        if fy.refuse || cookie == nil || r.URL.Query().Get("crumb") != "crumb-for-"+cookie.Value || fy.crumb != r.URL.Query().Get("crumb") {
+            http.Error(w, `{"finance":{"error":{"code":"Unauthorized","description":"Invalid Crumb"}}}`, http.StatusUnauthorized)
+            return
+        }
+        if fy.expireNext {
+            fy.expireNext, fy.crumb = false, ""
+            http.Error(w, `{"finance":{"error":{"code":"Unauthorized","description":"Invalid Crumb"}}}`, http.StatusUnauthorized)
+            return
+        }
+        var results []string
+        for _, sym := range strings.Split(r.URL.Query().Get("symbols"), ",") {
+            results = append(results, fmt.Sprintf(`{"symbol":%q,"quoteType":"EQUITY","regularMarketPrice":187.5,"regularMarketVolume":1000}`, sym))
+        }
+        fmt.Fprintf(w, `{"quoteResponse":{"result":[%s],"error":null}}`, strings.Join(results, ","))
+    case strings.HasPrefix(r.URL.Path, "/v8/finance/chart/"):
+        fy.charts++
+        fmt.Fprintf(w, `{"chart":{"result":[{"meta":{"symbol":%q,"instrumentType":"EQUITY","regularMarketPrice":190,"regularMarketVolume":10}}],"error":null}}`,
+            strings.TrimPrefix(r.URL.Path, "/v8/finance/chart/"))
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+/*
+redirectTransport sends every request to srv, keeping its original Host.
+*/
+type redirectTransport struct{ srv *httptest.Server }
+
+func (rt redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+    r = r.Clone(r.Context())
+    r.Host = r.URL.Host
+    r.URL.Scheme, r.URL.Host = "http", strings.TrimPrefix(rt.srv.URL, "http://")
+    return http.DefaultTransport.RoundTrip(r)
+}
+
+/*
+useFakeYahoo points the shared Yahoo session and the JSON API client at fy
+for the rest of the test.
+*/
+func useFakeYahoo(t *testing.T, fy *fakeYahoo) {
+    srv := httptest.NewServer(fy)
+    t.Cleanup(srv.Close)
+    prevSession, prevClient := yahooSession, quoteClient
+    quoteClient = &http.Client{Transport: redirectTransport{srv}}
+    yahooSession = NewYahooSession(quoteClient)
+    t.Cleanup(func() { yahooSession, quoteClient = prevSession, prevClient })
+}
+
+func TestFetchQuotesCrumb(t *testing.T) {
+    tests := []struct {
+        name           string
+        fy             *fakeYahoo
+        wantErr        error
+        wantHandshakes int
+    }{
+        {"handshake on first use", &fakeYahoo{}, nil, 1},
+        {"expired crumb is renewed", &fakeYahoo{expireNext: true}, nil, 2},
+        {"refused after a fresh crumb", &fakeYahoo{refuse: true}, errYahooUnauthorized, 2},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            useFakeYahoo(t, tt.fy)
+            quotes, err := FetchQuotes([]string{"AAPL", "MSFT"})
+            if !errors.Is(err, tt.wantErr) {
+                t.Fatalf("FetchQuotes error = %v, want %v", err, tt.wantErr)
+            }
+            if tt.wantErr == nil && (len(quotes) != 2 || quotes["AAPL"] == nil || quotes["AAPL"].Price != 187.5) {
+                t.Errorf("FetchQuotes = %+v, want AAPL and MSFT at 187.5", quotes)
+            }
+            if tt.fy.handshakes != tt.wantHandshakes {
+                t.Errorf("handshakes = %d, want %d", tt.fy.handshakes, tt.wantHandshakes)
+            }
+        })
+    }
+}
+
+func TestCollectBatchFallsBackOn401(t *testing.T) {
+    fy := &fakeYahoo{refuse: true}
+    useFakeYahoo(t, fy)
+    fp := newTestProcessor(t, map[string]string{"SYMBOLS": "AAPL", "EVENT_CALENDAR_TTL": "0"})
+
+    fp.collectBatch([]string{"AAPL"})
+    if fy.charts != 1 {
+        t.Errorf("chart API asked %d times, want 1 fallback fetch", fy.charts)
+    }
+    if sd, ok := fp.dataStore.Latest("AAPL"); !ok || sd.Price != 190 {
+        t.Errorf("latest tick = %+v, %v; want the fallback fetch at 190", sd, ok)
+    }
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+yahooQuoteSummaryAPI is Yahoo's per-symbol fundamentals endpoint; the modules
+query parameter selects which sections are returned.
+*/
+const yahooQuoteSummaryAPI = "https://query2.finance.yahoo.com/v10/finance/quoteSummary/"
+
+/*
+defaultSummaryModules are requested when the caller does not select any.
+*/
+var defaultSummaryModules = []string{"financialData", "defaultKeyStatistics", "summaryDetail"}
+
+/*
+knownSummaryModules lists the quoteSummary modules callers may request.
+*/
+var knownSummaryModules = map[string]bool{
+    "assetProfile":         true,
+    "calendarEvents":       true,
+    "defaultKeyStatistics": true,
+    "earnings":             true,
+    "financialData":        true,
+    "price":                true,
+    "recommendationTrend":  true,
+    "summaryDetail":        true,
+}
+
+/*
+cachedModule is one module payload and the time it was fetched.
+*/
+type cachedModule struct {
+    data      json.RawMessage
+    fetchedAt time.Time
+}
+
+/*
+QuoteSummaryFetcher retrieves quoteSummary modules and caches each
+(symbol, module) pair for ttl, so repeated requests only fetch the modules
+that are missing or stale. Stale entries are dropped whenever new ones are
+stored, so symbols no longer asked for do not stay cached.
+*/
+type QuoteSummaryFetcher struct {
+    mu      sync.Mutex
+    ttl     time.Duration
+    cache   map[string]map[string]cachedModule
+    latency *DependencyMetrics
+}
+
+/*
+NewQuoteSummaryFetcherFromEnv caches modules for QUOTE_SUMMARY_TTL (default 15m).
+*/
+func NewQuoteSummaryFetcherFromEnv(latency *DependencyMetrics) *QuoteSummaryFetcher {
+    return &QuoteSummaryFetcher{
+        ttl:     envDuration("QUOTE_SUMMARY_TTL", 15*time.Minute),
+        cache:   make(map[string]map[string]cachedModule),
+        latency: latency,
+    }
+}
+
+/*
+Fetch returns the requested modules for symbol, serving fresh entries from the
+cache and requesting only the remainder from Yahoo.
+*/
+func (qf *QuoteSummaryFetcher) Fetch(symbol string, modules []string) (map[string]json.RawMessage, error) {
+    out := make(map[string]json.RawMessage, len(modules))
+    var missing []string
+
+    now := time.Now()
+    qf.mu.Lock()
+    for _, m := range modules {
+        if c, ok := qf.cache[symbol][m]; ok && now.Sub(c.fetchedAt) < qf.ttl {
+            out[m] = c.data
+        } else {
+            missing = append(missing, m)
+        }
+    }
+    qf.mu.Unlock()
+    if len(missing) == 0 {
+        return out, nil
+    }
+
+    start := time.Now()
+    fetched, err := fetchQuoteSummary(symbol, missing)
+    qf.latency.Observe(depYahoo, time.Since(start), err)
+    if err != nil {
+        return nil, err
+    }
+
+    qf.mu.Lock()
+    qf.expire(now)
+    if qf.cache[symbol] == nil {
+        qf.cache[symbol] = make(map[string]cachedModule)
+    }
+    for m, data := range fetched {
+        qf.cache[symbol][m] = cachedModule{data: data, fetchedAt: now}
+        out[m] = data
+    }
+    qf.mu.Unlock()
+    return out, nil
+}
+
+/*
+expire drops the modules fetched ttl or longer before now, and symbols left
+with none. Callers must hold qf.mu.
+*/
+func (qf *QuoteSummaryFetcher) expire(now time.Time) {
+    for sym, modules := range qf.cache {
+        for m, c := range modules {
+            if now.Sub(c.fetchedAt) >= qf.ttl {
+                delete(modules, m)
+            }
+        }
+        if len(modules) == 0 {
+            delete(qf.cache, sym)
+        }
+    }
+}
+
+/*
+Purge drops every cached module.
+*/
+func (qf *QuoteSummaryFetcher) Purge() {
+    qf.mu.Lock()
+    qf.cache = make(map[string]map[string]cachedModule)
+    qf.mu.Unlock()
+}
+
+/*
+fetchQuoteSummary performs one quoteSummary request for the given modules
+through the shared Yahoo session, since the endpoint needs a cookie and crumb.
+*/
+func fetchQuoteSummary(symbol string, modules []string) (map[string]json.RawMessage, error) {
+    resp, err := yahooSession.Get(yahooQuoteSummaryAPI + url.PathEscape(symbol) + "?modules=" + url.QueryEscape(strings.Join(modules, ",")))
+    if err != nil {
+        return nil, fmt.Errorf("quoteSummary: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("quoteSummary returned %s", resp.Status)
+    }
+
+    var body struct {
+        QuoteSummary struct {
+            Result []map[string]json.RawMessage `json:"result"`
+            Error  *struct {
+                Code        string `json:"code"`
+                Description string `json:"description"`
+            } `json:"error"`
+        } `json:"quoteSummary"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        return nil, err
+    }
+    if e := body.QuoteSummary.Error; e != nil {
+        return nil, fmt.Errorf("quoteSummary error %s: %s", e.Code, e.Description)
+    }
+    if len(body.QuoteSummary.Result) == 0 {
+        return nil, fmt.Errorf("quoteSummary returned no result for %s", symbol)
+    }
+    return body.QuoteSummary.Result[0], nil
+}
+
+/*
+handleGetSummary exposes GET /api/summary/{symbol}?modules=a,b returning the
+selected quoteSummary modules keyed by module name. Only tracked symbols are
+looked up, which keeps the cache to the watchlist.
+*/
+func (fp *FinancialProcessor) handleGetSummary(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    if !slices.Contains(fp.trackedSymbols(), sym) {
+        http.Error(w, sym+" is not tracked", http.StatusNotFound)
+        return
+    }
+    modules := defaultSummaryModules
+    if q := r.URL.Query().Get("modules"); q != "" {
+        modules = nil
+        for _, m := range strings.Split(q, ",") {
+            m = strings.TrimSpace(m)
+            if !knownSummaryModules[m] {
+                known := make([]string, 0, len(knownSummaryModules))
+                for k := range knownSummaryModules {
+                    known = append(known, k)
+                }
+                sort.Strings(known)
+                http.Error(w, fmt.Sprintf("unknown module %q; supported: %s", m, strings.Join(known, ", ")),
+                    http.StatusBadRequest)
+                return
+            }
+            modules = append(modules, m)
+        }
+    }
+
+    data, err := fp.summary.Fetch(sym, modules)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadGateway)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleGetSummary(t *testing.T) {
+    fy := &fakeYahoo{}
+    useFakeYahoo(t, fy)
+    r := newRouter(newTestProcessor(t, map[string]string{"SYMBOLS": "AAPL"}))
+
+    tests := []struct {
+        name    string
+        path    string
+        want    int
+        fetches int
+    }{
+        {"untracked symbol", "/api/summary/ZZZZ", http.StatusNotFound, 0},
+        {"tracked symbol", "/api/summary/AAPL?modules=price", http.StatusOK, 1},
+        {"served from cache", "/api/summary/AAPL?modules=price", http.StatusOK, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            r.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+            if rec.Code != tt.want {
+                t.Fatalf("GET %s = %d %s, want %d", tt.path, rec.Code, rec.Body, tt.want)
+            }
+            if fy.summaries != tt.fetches {
+                t.Errorf("quoteSummary requests = %d, want %d", fy.summaries, tt.fetches)
+            }
+            if tt.want != http.StatusOK {
+                return
+            }
+            var body map[string]map[string]string
+            if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["price"]["symbol"] != "AAPL" {
+                t.Errorf("body = %v, %v; want the price module for AAPL", body, err)
+            }
+        })
+    }
+}
+
+func TestQuoteSummaryCacheExpires(t *testing.T) {
+    now := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+    qf := &QuoteSummaryFetcher{ttl: 15 * time.Minute, cache: map[string]map[string]cachedModule{
+        "AAPL": {
+            "price":         {data: json.RawMessage(`{}`), fetchedAt: now.Add(-time.Minute)},
+            "summaryDetail": {data: json.RawMessage(`{}`), fetchedAt: now.Add(-time.Hour)},
+        },
+        "GONE": {
+            "price": {data: json.RawMessage(`{}`), fetchedAt: now.Add(-15 * time.Minute)},
+        },
+    }}
+    qf.expire(now)
+    if _, ok := qf.cache["GONE"]; ok {
+        t.Error("symbol with only stale modules still cached")
+    }
+    if got := len(qf.cache["AAPL"]); got != 1 {
+        t.Errorf("AAPL keeps %d modules, want only the fresh one", got)
+    }
+    if _, ok := qf.cache["AAPL"]["price"]; !ok {
+        t.Error("fresh module dropped")
+    }
+}
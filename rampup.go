@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+RampLimiter spaces out upstream fetches during the first minutes after
+startup, when every loop would otherwise fire at once. After the ramp window
+it lets every call through immediately.
+*/
+type RampLimiter struct {
+    mu    sync.Mutex
+    gap   time.Duration
+    until time.Time
+    next  time.Time
+}
+
+/*
+NewRampLimiterFromEnv allows STARTUP_RAMP_RATE fetches per second (default 2)
+for STARTUP_RAMP (default 1m) after startup. Either set to 0 disables the limit.
+*/
+func NewRampLimiterFromEnv() *RampLimiter {
+    rl := &RampLimiter{}
+    rate := envInt("STARTUP_RAMP_RATE", 2)
+    if ramp := envDuration("STARTUP_RAMP", time.Minute); ramp > 0 && rate > 0 {
+        rl.gap = time.Second / time.Duration(rate)
+        rl.until = time.Now().Add(ramp)
+    }
+    return rl
+}
+
+/*
+Wait blocks until the caller may issue its next fetch.
+*/
+func (rl *RampLimiter) Wait() {
+    rl.mu.Lock()
+    now := time.Now()
+    if rl.gap == 0 || now.After(rl.until) {
+        rl.mu.Unlock()
+        return
+    }
+    slot := rl.next
+    if slot.Before(now) {
+        slot = now
+    }
+    rl.next = slot.Add(rl.gap)
+    rl.mu.Unlock()
+    time.Sleep(time.Until(slot))
+}
+
+/*
+staggerOffsets spreads n loop start times evenly over spread, with up to one
+slot of random jitter each so restarts of many instances do not line up.
+*/
+func staggerOffsets(n int, spread time.Duration) []time.Duration {
+    out := make([]time.Duration, n)
+    if n == 0 || spread <= 0 {
+        return out
+    }
+    slot := spread / time.Duration(n)
+    for i := range out {
+        out[i] = time.Duration(i) * slot
+        if slot > 0 {
+            out[i] += time.Duration(rand.Int63n(int64(slot)))
+        }
+    }
+    return out
+}
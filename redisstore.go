@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+redisStore is the Store for replicas sharing one Redis, opened with
+STORAGE_DRIVER=redis and a redis:// STORAGE_DSN. Each symbol's ticks are a
+sorted set of their timestamps, zero-padded to 19 digits and all scored 0 so
+they sort and range lexicographically at full nanosecond precision, next to
+a hash of each timestamp's price, volume and other quote fields. Bars and daily accuracy are kept
+the same way per resolution and per symbol. Every key of a symbol shares the
+hash tag {SYMBOL}, so one Lua script can update them atomically on Redis
+Cluster too, and all keys are prefixed with the NAMESPACE.
+*/
+type redisStore struct {
+    client *redis.Client
+    prefix string
+}
+
+/*
+redisSaveTick stores a tick unless its timestamp is already present, and
+adds it to its bar at every resolution like addToBars.
+
+KEYS: ticks, tick data, then a bar index and bar data per resolution.
+ARGV: timestamp, price, volume, the tick data value (see redisTickValue),
+then each bar's start.
+*/
+var redisSaveTick = redis.NewScript(`
+local ts, price, volume = ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3])
+if redis.call('ZADD', KEYS[1], 'NX', 0, ts) == 0 then
+    return 0
+end
+redis.call('HSET', KEYS[2], ts, ARGV[4])
+local traded = 0
+local prev = redis.call('ZREVRANGEBYLEX', KEYS[1], '(' .. ts, '-', 'LIMIT', 0, 1)
+if #prev > 0 then
+    local pv = tonumber(string.match(redis.call('HGET', KEYS[2], prev[1]), '^%S+ (%S+)'))
+    if volume < pv then traded = volume else traded = volume - pv end
+end
+for i = 3, #KEYS, 2 do
+    local start = ARGV[5 + (i - 3) / 2]
+    redis.call('ZADD', KEYS[i], 'NX', 0, start)
+    local raw = redis.call('HGET', KEYS[i + 1], start)
+    local b
+    if raw then
+        b = cjson.decode(raw)
+        if ts < b.open_ts then b.open, b.open_ts = price, ts end
+        if ts > b.close_ts then b.close, b.close_ts = price, ts end
+        if price > b.high then b.high = price end
+        if price < b.low then b.low = price end
+        b.volume = b.volume + traded
+        b.ticks = b.ticks + 1
+    else
+        b = {open = price, high = price, low = price, close = price, volume = traded, ticks = 1, open_ts = ts, close_ts = ts}
+    end
+    redis.call('HSET', KEYS[i + 1], start, cjson.encode(b))
+end
+return 1
+`)
+
+/*
+redisBar is a bar as redisSaveTick stores it. Timestamps are the padded
+strings the script compares.
+*/
+type redisBar struct {
+    Open    float64 `json:"open"`
+    High    float64 `json:"high"`
+    Low     float64 `json:"low"`
+    Close   float64 `json:"close"`
+    Volume  float64 `json:"volume"`
+    Ticks   int     `json:"ticks"`
+    OpenTS  string  `json:"open_ts"`
+    CloseTS string  `json:"close_ts"`
+}
+
+/*
+NewRedisStore connects to the Redis at dsn, a redis:// or rediss:// URL.
+*/
+func NewRedisStore(dsn string) (Store, error) {
+    opts, err := redis.ParseURL(dsn)
+    if err != nil {
+        return nil, err
+    }
+    client := redis.NewClient(opts)
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := client.Ping(ctx).Err(); err != nil {
+        client.Close()
+        return nil, err
+    }
+    return &redisStore{client: client, prefix: namespacedKey("forecaster", ":") + ":"}, nil
+}
+
+/*
+key names one of symbol's keys, e.g. "forecaster:{AAPL}:ticks".
+*/
+func (s *redisStore) key(symbol, name string) string {
+    return s.prefix + "{" + symbol + "}:" + name
+}
+
+/*
+lexTime formats nanoseconds as a sorted set member.
+*/
+func lexTime(ns int64) string {
+    return fmt.Sprintf("%019d", ns)
+}
+
+/*
+lexBounds converts a since/until range, where zero is open, to inclusive
+ZRANGEBYLEX bounds.
+*/
+func lexBounds(since, until time.Time) (lo, hi string) {
+    a, b := nanoBounds(since, until)
+    return "[" + lexTime(a), "[" + lexTime(b)
+}
+
+func (s *redisStore) SaveTick(sd StockData) error {
+    ts := sd.Timestamp.UnixNano()
+    keys := []string{s.key(sd.Symbol, "ticks"), s.key(sd.Symbol, "tickdata")}
+    args := []interface{}{lexTime(ts), strconv.FormatFloat(sd.Price, 'g', -1, 64), strconv.FormatInt(sd.Volume, 10), redisTickValue(sd)}
+    for _, name := range barResolutions {
+        keys = append(keys, s.key(sd.Symbol, "bars:"+name), s.key(sd.Symbol, "bardata:"+name))
+        args = append(args, lexTime(sd.Timestamp.UTC().Truncate(candleIntervals[name]).UnixNano()))
+    }
+    ctx := context.Background()
+    added, err := redisSaveTick.Run(ctx, s.client, keys, args...).Int()
+    if err != nil || added == 0 {
+        return err
+    }
+    return s.client.SAdd(ctx, s.prefix+"symbols", sd.Symbol).Err()
+}
+
+func (s *redisStore) Range(symbol string, since, until time.Time, limit int) ([]StockData, error) {
+    lo, hi := lexBounds(since, until)
+    ctx := context.Background()
+    ts, err := s.client.ZRangeByLex(ctx, s.key(symbol, "ticks"), &redis.ZRangeBy{Min: lo, Max: hi, Count: redisLimit(limit)}).Result()
+    if err != nil {
+        return nil, err
+    }
+    return s.ticks(ctx, symbol, ts)
+}
+
+func (s *redisStore) RangeDesc(symbol string, since, until time.Time, limit int) ([]StockData, error) {
+    lo, hi := lexBounds(since, until)
+    ctx := context.Background()
+    ts, err := s.client.ZRevRangeByLex(ctx, s.key(symbol, "ticks"), &redis.ZRangeBy{Min: lo, Max: hi, Count: redisLimit(limit)}).Result()
+    if err != nil {
+        return nil, err
+    }
+    return s.ticks(ctx, symbol, ts)
+}
+
+func (s *redisStore) Recent(symbol string, n int) ([]StockData, error) {
+    ctx := context.Background()
+    ts, err := s.client.ZRange(ctx, s.key(symbol, "ticks"), int64(-n), -1).Result()
+    if err != nil {
+        return nil, err
+    }
+    return s.ticks(ctx, symbol, ts)
+}
+
+/*
+redisLimit converts a Store limit, where 0 is none, to a ZRANGEBYLEX count,
+where -1 is none.
+*/
+func redisLimit(limit int) int64 {
+    if limit <= 0 {
+        return -1
+    }
+    return int64(limit)
+}
+
+/*
+redisTickValue is how a tick is kept in the tick data hash: its price,
+volume, open, high, low and previous close, then its earnings and
+ex-dividend dates in Unix nanoseconds, separated by spaces. Unset values
+are 0. Ticks stored before the other fields were kept hold only price and
+volume.
+*/
+func redisTickValue(sd StockData) string {
+    f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+    t := func(v *time.Time) int64 {
+        if v == nil {
+            return 0
+        }
+        return v.UnixNano()
+    }
+    return fmt.Sprintf("%s %d %s %s %s %s %d %d", f(sd.Price), sd.Volume, f(sd.Open), f(sd.High), f(sd.Low), f(sd.PreviousClose),
+        t(sd.EarningsDate), t(sd.ExDividendDate))
+}
+
+/*
+parseRedisTick fills sd from a redisTickValue.
+*/
+func parseRedisTick(raw string, sd *StockData) error {
+    fields := strings.Fields(raw)
+    if len(fields) < 2 {
+        return fmt.Errorf("malformed tick data %q", raw)
+    }
+    var err error
+    if sd.Price, err = strconv.ParseFloat(fields[0], 64); err != nil {
+        return err
+    }
+    if sd.Volume, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+        return err
+    }
+    if len(fields) < 8 {
+        return nil
+    }
+    for i, p := range []*float64{&sd.Open, &sd.High, &sd.Low, &sd.PreviousClose} {
+        if *p, err = strconv.ParseFloat(fields[2+i], 64); err != nil {
+            return err
+        }
+    }
+    for i, p := range []**time.Time{&sd.EarningsDate, &sd.ExDividendDate} {
+        ns, err := strconv.ParseInt(fields[6+i], 10, 64)
+        if err != nil {
+            return err
+        }
+        if ns != 0 {
+            t := time.Unix(0, ns).UTC()
+            *p = &t
+        }
+    }
+    return nil
+}
+
+/*
+ticks loads symbol's ticks at the sorted set members ts, in their order.
+*/
+func (s *redisStore) ticks(ctx context.Context, symbol string, ts []string) ([]StockData, error) {
+    if len(ts) == 0 {
+        return nil, nil
+    }
+    vals, err := s.client.HMGet(ctx, s.key(symbol, "tickdata"), ts...).Result()
+    if err != nil {
+        return nil, err
+    }
+    out := make([]StockData, 0, len(ts))
+    for i, v := range vals {
+        raw, ok := v.(string)
+        if !ok {
+            // Trimmed between the two reads.
+            continue
+        }
+        ns, err := strconv.ParseInt(ts[i], 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("tick %q of %s: %w", ts[i], symbol, err)
+        }
+        sd := StockData{Symbol: symbol, Timestamp: time.Unix(0, ns).UTC(), AssetClass: assetClassOf(symbol)}
+        if err := parseRedisTick(raw, &sd); err != nil {
+            return nil, fmt.Errorf("tick %q of %s: %w", ts[i], symbol, err)
+        }
+        out = append(out, sd)
+    }
+    return out, nil
+}
+
+func (s *redisStore) Count(symbol string) (int, error) {
+    n, err := s.client.ZCard(context.Background(), s.key(symbol, "ticks")).Result()
+    return int(n), err
+}
+
+func (s *redisStore) Symbols() ([]string, error) {
+    out, err := s.client.SMembers(context.Background(), s.prefix+"symbols").Result()
+    sort.Strings(out)
+    return out, err
+}
+
+func (s *redisStore) Bars(symbol, resolution string, since, until time.Time) ([]Candle, error) {
+    lo, hi := lexBounds(since, until)
+    ctx := context.Background()
+    starts, err := s.client.ZRangeByLex(ctx, s.key(symbol, "bars:"+resolution), &redis.ZRangeBy{Min: lo, Max: hi}).Result()
+    if err != nil || len(starts) == 0 {
+        return []Candle{}, err
+    }
+    vals, err := s.client.HMGet(ctx, s.key(symbol, "bardata:"+resolution), starts...).Result()
+    if err != nil {
+        return nil, err
+    }
+    out := []Candle{}
+    for i, v := range vals {
+        raw, ok := v.(string)
+        if !ok {
+            continue
+        }
+        var b redisBar
+        if err := json.Unmarshal([]byte(raw), &b); err != nil {
+            return nil, fmt.Errorf("bar %s of %s: %w", starts[i], symbol, err)
+        }
+        ns, err := strconv.ParseInt(starts[i], 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("bar %q of %s: %w", starts[i], symbol, err)
+        }
+        out = append(out, Candle{
+            Timestamp: time.Unix(0, ns).UTC(),
+            Open:      b.Open,
+            High:      b.High,
+            Low:       b.Low,
+            Close:     b.Close,
+            Volume:    int64(b.Volume),
+            Ticks:     b.Ticks,
+        })
+    }
+    return out, nil
+}
+
+func (s *redisStore) SaveAccuracy(rec AccuracyRecord) error {
+    if rec.RealizedAt == nil {
+        return nil
+    }
+    var t accuracyTotals
+    t.add(rec)
+    hits := int64(0)
+    if t.hits > 0 {
+        hits = 1
+    }
+    day := lexTime(rec.RealizedAt.UTC().Truncate(24 * time.Hour).UnixNano())
+    member := day + "|" + rec.Horizon
+    data := s.key(rec.Symbol, "accuracy:"+member)
+    ctx := context.Background()
+    _, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+        p.ZAdd(ctx, s.key(rec.Symbol, "accuracy"), redis.Z{Member: member})
+        p.HIncrBy(ctx, data, "samples", 1)
+        p.HIncrByFloat(ctx, data, "abs_error", t.abs)
+        p.HIncrByFloat(ctx, data, "squared_error", t.sq)
+        p.HIncrByFloat(ctx, data, "baseline_abs_error", t.base)
+        p.HIncrBy(ctx, data, "direction_hits", hits)
+        return nil
+    })
+    return err
+}
+
+func (s *redisStore) DailyAccuracy(symbol string, since, until time.Time) ([]DailyAccuracy, error) {
+    lo, hi := lexBounds(since, until)
+    ctx := context.Background()
+    // Members are "<day>|<horizon>", so the upper bound must admit any horizon.
+    members, err := s.client.ZRangeByLex(ctx, s.key(symbol, "accuracy"), &redis.ZRangeBy{Min: lo, Max: hi + "|\xff"}).Result()
+    if err != nil {
+        return nil, err
+    }
+    out := []DailyAccuracy{}
+    for _, m := range members {
+        day, horizon, _ := strings.Cut(m, "|")
+        f, err := s.client.HGetAll(ctx, s.key(symbol, "accuracy:"+m)).Result()
+        if err != nil {
+            return nil, err
+        }
+        ns, _ := strconv.ParseInt(day, 10, 64)
+        var t accuracyTotals
+        t.samples, _ = strconv.Atoi(f["samples"])
+        t.abs, _ = strconv.ParseFloat(f["abs_error"], 64)
+        t.sq, _ = strconv.ParseFloat(f["squared_error"], 64)
+        t.base, _ = strconv.ParseFloat(f["baseline_abs_error"], 64)
+        t.hits, _ = strconv.Atoi(f["direction_hits"])
+        rep := t.report(horizon)
+        out = append(out, DailyAccuracy{
+            Day:                        time.Unix(0, ns).UTC(),
+            Horizon:                    horizon,
+            Samples:                    rep.Samples,
+            MAE:                        rep.MAE,
+            RMSE:                       rep.RMSE,
+            DirectionalAccuracyPercent: rep.DirectionalAccuracyPercent,
+            BaselineMAE:                rep.BaselineMAE,
+            SkillPercent:               rep.SkillPercent,
+        })
+    }
+    return out, nil
+}
+
+/*
+probeWrite sets and deletes a probe key, which a read-only replica refuses.
+*/
+func (s *redisStore) probeWrite() error {
+    ctx := context.Background()
+    if err := s.client.Set(ctx, s.prefix+"probe", "1", time.Minute).Err(); err != nil {
+        return err
+    }
+    return s.client.Del(ctx, s.prefix+"probe").Err()
+}
+
+func (s *redisStore) Close() error {
+    return s.client.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+/*
+ReloadResult reports what a configuration reload changed. Changed lists the
+settings applied in place; RestartRequired lists settings that differ from
+the running ones but only take effect on a restart.
+*/
+type ReloadResult struct {
+    Changed         []string `json:"changed"`
+    SymbolsAdded    []string `json:"symbols_added"`
+    SymbolsRemoved  []string `json:"symbols_removed"`
+    RestartRequired []string `json:"restart_required"`
+}
+
+/*
+currentConfig returns the configuration in effect, which a reload may
+replace.
+*/
+func (fp *FinancialProcessor) currentConfig() Config {
+    fp.cfgMu.RLock()
+    defer fp.cfgMu.RUnlock()
+    return fp.config
+}
+
+/*
+reloadConfig reads the configuration again, as at startup, and applies the
+collection interval, market-closed behaviour, prediction threshold and
+symbol list without restarting anything: queued pipelines are brought
+forward when the new interval makes their next fetch due sooner and
+otherwise pick it up when they next schedule one, and symbols added to or removed from the
+configured list since the last load are started or stopped like through
+the symbols API, leaving symbols added or removed through the API alone.
+An invalid configuration is rejected as a whole and nothing changes.
+*/
+func (fp *FinancialProcessor) reloadConfig() (ReloadResult, error) {
+    fp.reloadMu.Lock()
+    defer fp.reloadMu.Unlock()
+    res := ReloadResult{Changed: []string{}, SymbolsAdded: []string{}, SymbolsRemoved: []string{}, RestartRequired: []string{}}
+    next, err := LoadConfig()
+    if err != nil {
+        return res, err
+    }
+    fp.cfgMu.Lock()
+    prev := fp.config
+    applied := prev
+    applied.Interval = next.Interval
+    applied.MarketClosed = next.MarketClosed
+    applied.ClosedInterval = next.ClosedInterval
+    applied.PredictionThreshold = next.PredictionThreshold
+    applied.Symbols = next.Symbols
+    fp.config = applied
+    fp.cfgMu.Unlock()
+
+    if prev.Interval != next.Interval {
+        res.Changed = append(res.Changed, "interval")
+    }
+    if prev.MarketClosed != next.MarketClosed {
+        res.Changed = append(res.Changed, "market_closed")
+    }
+    if prev.ClosedInterval != next.ClosedInterval {
+        res.Changed = append(res.Changed, "market_closed_interval")
+    }
+    if prev.PredictionThreshold != next.PredictionThreshold {
+        res.Changed = append(res.Changed, "prediction_threshold")
+    }
+    if prev.MaxHistory != next.MaxHistory {
+        res.RestartRequired = append(res.RestartRequired, "max_history")
+    }
+    if !reflect.DeepEqual(prev.Calendars, next.Calendars) {
+        res.RestartRequired = append(res.RestartRequired, "calendars")
+    }
+    if !reflect.DeepEqual(prev.Watchlists, next.Watchlists) {
+        res.RestartRequired = append(res.RestartRequired, "watchlists")
+    }
+    if prev.RetentionTiers != next.RetentionTiers {
+        res.RestartRequired = append(res.RestartRequired, "retention_tiers")
+    }
+    if !reflect.DeepEqual(prev.Features, next.Features) {
+        res.RestartRequired = append(res.RestartRequired, "features")
+    }
+
+    was := make(map[string]bool)
+    for _, s := range prev.Symbols {
+        was[s] = true
+    }
+    now := make(map[string]bool)
+    for _, s := range next.Symbols {
+        now[s] = true
+        if !was[s] && fp.addSymbol(s) {
+            res.SymbolsAdded = append(res.SymbolsAdded, s)
+        }
+    }
+    for _, s := range prev.Symbols {
+        if !now[s] && fp.removeSymbol(s) {
+            res.SymbolsRemoved = append(res.SymbolsRemoved, s)
+        }
+    }
+    if len(res.Changed) > 0 {
+        fp.pool.Reschedule(func(p *symbolPipeline) time.Time {
+            last := fp.clock.Now()
+            if ns := p.times.last.Load(); ns != 0 {
+                last = time.Unix(0, ns)
+            }
+            return last.Add(fp.collectionInterval(p.symbol))
+        })
+        fp.publishConfig("", ConfigChange{Kind: "config_reloaded", Changed: res.Changed})
+    }
+    slog.Info("configuration reloaded", "changed", res.Changed, "symbols_added", res.SymbolsAdded,
+        "symbols_removed", res.SymbolsRemoved, "restart_required", res.RestartRequired)
+    return res, nil
+}
+
+/*
+reloadOnSIGHUP reloads the configuration whenever the process receives
+SIGHUP.
+*/
+func (fp *FinancialProcessor) reloadOnSIGHUP() {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    for range hup {
+        if _, err := fp.reloadConfig(); err != nil {
+            slog.Error("configuration reload failed; keeping the running configuration", "err", err)
+        }
+    }
+}
+
+/*
+handleReload exposes POST /api/admin/reload, which reloads the configuration
+like SIGHUP and returns the ReloadResult, or 422 with the error when the
+new configuration is invalid.
+*/
+func (fp *FinancialProcessor) handleReload(w http.ResponseWriter, r *http.Request) {
+    res, err := fp.reloadConfig()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+loadTicks reads StockData snapshots from a file containing either a JSON array
+(the shape returned by /api/data/{symbol}) or newline-delimited JSON objects.
+The result is sorted by timestamp.
+*/
+func loadTicks(path string) ([]StockData, error) {
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var ticks []StockData
+    trimmed := bytes.TrimSpace(raw)
+    if len(trimmed) > 0 && trimmed[0] == '[' {
+        if err := json.Unmarshal(trimmed, &ticks); err != nil {
+            return nil, fmt.Errorf("parse %s: %w", path, err)
+        }
+    } else {
+        dec := json.NewDecoder(bytes.NewReader(trimmed))
+        for {
+            var sd StockData
+            if err := dec.Decode(&sd); err == io.EOF {
+                break
+            } else if err != nil {
+                return nil, fmt.Errorf("parse %s: %w", path, err)
+            }
+            ticks = append(ticks, sd)
+        }
+    }
+
+    sort.SliceStable(ticks, func(i, j int) bool {
+        return ticks[i].Timestamp.Before(ticks[j].Timestamp)
+    })
+    return ticks, nil
+}
+
+/*
+runReplay implements the `replay` subcommand. It feeds stored historical ticks
+through recordTick, the same path used by live collection, so predictions and
+everything downstream of them can be exercised while markets are closed.
+Ticks are paced by their original spacing divided by -speed; a speed of 0
+replays as fast as possible. The processor runs on a virtual clock that is
+moved to each tick's timestamp before it is recorded, so everything that reads
+the time sees the market time being replayed rather than the wall clock.
+The replay is isolated from the live deployment by isolateReplay.
+*/
+func runReplay(args []string) error {
+    fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+    file := fs.String("file", "", "JSON or JSON-lines file of StockData ticks to replay")
+    speed := fs.Float64("speed", 60, "playback speed multiplier (0 = no pacing)")
+    maxGap := fs.Duration("max-gap", 5*time.Second, "cap on wall-clock wait between ticks, e.g. across weekends")
+    serve := fs.Bool("serve", true, "serve the HTTP API while replaying")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if *file == "" {
+        return errors.New("-file is required")
+    }
+    if *speed < 0 {
+        return errors.New("-speed must not be negative")
+    }
+
+    ticks, err := loadTicks(*file)
+    if err != nil {
+        return err
+    }
+    if len(ticks) == 0 {
+        return fmt.Errorf("no ticks in %s", *file)
+    }
+
+    seen := make(map[string]bool)
+    var symbols []string
+    for _, t := range ticks {
+        if !seen[t.Symbol] {
+            seen[t.Symbol] = true
+            symbols = append(symbols, t.Symbol)
+        }
+    }
+    cfg, err := LoadConfig()
+    if err != nil {
+        return err
+    }
+    cfg.Symbols = symbols
+    fp := NewFinancialProcessor(cfg)
+    isolateReplay(fp)
+    clock := NewVirtualClock(ticks[0].Timestamp)
+    fp.clock = clock
+
+    if fp.mlReady != nil {
+        fp.mlReady.Run()
+    }
+
+    if *serve {
+        port := listenPort()
+        go func() {
+            slog.Info("replay API listening", "port", port)
+            if err := http.ListenAndServe(":"+port, newRouter(fp)); err != nil {
+                slog.Error("replay server error", "err", err)
+            }
+        }()
+    }
+
+    slog.Info("replaying", "ticks", len(ticks), "symbols", len(symbols), "file", *file, "speed", *speed)
+    for i, t := range ticks {
+        if i > 0 && *speed > 0 {
+            wait := time.Duration(float64(t.Timestamp.Sub(ticks[i-1].Timestamp)) / *speed)
+            if wait > *maxGap {
+                wait = *maxGap
+            }
+            if wait > 0 {
+                time.Sleep(wait)
+            }
+        }
+        clock.Set(t.Timestamp)
+        fp.recordTick(t)
+    }
+    fp.pending.Wait()
+    slog.Info("replay finished", "ticks", len(ticks))
+    return nil
+}
+
+/*
+isolateReplay keeps fp, built for a replay from the live configuration, from
+touching the live deployment: replayed ticks are not part of the forecast
+record, nothing it changes, including symbols added or removed through the
+HTTP API, is written back to the files it was loaded from, alerts are
+recorded in memory but never sent, and prediction notifications and trading
+signals are not produced at all.
+*/
+func isolateReplay(fp *FinancialProcessor) {
+    fp.ledger = nil
+    fp.archive = nil
+    fp.tradingView = nil
+    fp.notifier = nil
+    fp.alerts = newSilentAlertDispatcher()
+    fp.alertRules.path = ""
+    fp.accuracy.path = ""
+    fp.residuals.exportTo = ""
+    fp.positions.path = ""
+    fp.annotations.path = ""
+    fp.settings.path = ""
+    fp.digests.path = ""
+    fp.delisting.path = ""
+    fp.flags.path = ""
+    fp.symbolsFile = ""
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayLeavesLiveStateAlone(t *testing.T) {
+    dir := t.TempDir()
+    rulesFile := filepath.Join(dir, "rules.json")
+    accuracyFile := filepath.Join(dir, "accuracy.json")
+    historyFile := filepath.Join(dir, "alerts.jsonl")
+    symbolsFile := filepath.Join(dir, "symbols.json")
+    fp := newTestProcessor(t, map[string]string{
+        "SYMBOLS":              "AAPL",
+        "ALERT_RULES_FILE":     rulesFile,
+        "ACCURACY_FILE":        accuracyFile,
+        "ALERT_HISTORY_FILE":   historyFile,
+        "SYMBOLS_FILE":         symbolsFile,
+        "OPERATOR_WEBHOOK_URL": "http://127.0.0.1:1/hook",
+    })
+    isolateReplay(fp)
+
+    fp.alerts.recipients.recipients["ops"] = Recipient{User: "ops", WebhookURL: "http://127.0.0.1:1/hook"}
+    if rec := fp.alerts.Fire("price_above", "AAPL", 200, "AAPL above 200"); rec.DeliveryStatus != deliverySkipped {
+        t.Errorf("Fire recorded %q, want %q", rec.DeliveryStatus, deliverySkipped)
+    }
+    if rec := fp.alerts.FireOperator("scrape_failures", 1, "failing"); rec.DeliveryStatus != deliveryNoChannel {
+        t.Errorf("FireOperator recorded %q, want %q", rec.DeliveryStatus, deliveryNoChannel)
+    }
+    if got := len(fp.alerts.history.records); got != 2 {
+        t.Errorf("replay alert history holds %d records, want 2", got)
+    }
+
+    at := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+    fp.accuracy.Track("AAPL", horizonNext, 100, 101, at, at)
+    fp.alertRules.Flush()
+    fp.accuracy.Flush()
+    if !fp.addSymbol("MSFT") || !fp.removeSymbol("AAPL") {
+        t.Fatal("changing the replay watchlist failed")
+    }
+    for _, path := range []string{rulesFile, accuracyFile, historyFile, symbolsFile} {
+        if _, err := os.Stat(path); !os.IsNotExist(err) {
+            t.Errorf("replay wrote %s (stat err %v)", filepath.Base(path), err)
+        }
+    }
+}
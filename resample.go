@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+maxResampleBars bounds how many bars one resample request may produce.
+*/
+const maxResampleBars = 100000
+
+/*
+ResampledBar is one evenly spaced point of a resampled series. Price is the
+last tick in the bar; bars without a tick carry the previous price forward
+(Filled is then true) or are null, depending on the fill policy.
+*/
+type ResampledBar struct {
+    Timestamp time.Time `json:"timestamp"`
+    Price     *float64  `json:"price"`
+    Volume    *int64    `json:"volume"`
+    Ticks     int       `json:"ticks"`
+    Filled    bool      `json:"filled,omitempty"`
+}
+
+/*
+resample buckets data, which must be oldest first, into bars of width
+interval aligned to multiples of interval, from the first tick's bar through
+the last tick's bar. With ffill true empty bars repeat the previous bar's
+price and volume; otherwise they are left null.
+*/
+func resample(data []StockData, interval time.Duration, ffill bool) []ResampledBar {
+    if len(data) == 0 {
+        return []ResampledBar{}
+    }
+    first := data[0].Timestamp.Truncate(interval)
+    last := data[len(data)-1].Timestamp.Truncate(interval)
+    bars := make([]ResampledBar, int(last.Sub(first)/interval)+1)
+    for i := range bars {
+        bars[i].Timestamp = first.Add(time.Duration(i) * interval)
+    }
+    for _, d := range data {
+        b := &bars[int(d.Timestamp.Truncate(interval).Sub(first)/interval)]
+        price, volume := d.Price, d.Volume
+        b.Price, b.Volume = &price, &volume
+        b.Ticks++
+    }
+    if ffill {
+        for i := 1; i < len(bars); i++ {
+            if bars[i].Ticks == 0 {
+                bars[i].Price, bars[i].Volume = bars[i-1].Price, bars[i-1].Volume
+                bars[i].Filled = true
+            }
+        }
+    }
+    return bars
+}
+
+/*
+handleResample exposes GET /api/resample/{symbol}?interval=1m&fill=ffill|null,
+returning the symbol's history as an evenly spaced series. interval defaults
+to 1m (minimum 1s) and fill to ffill.
+*/
+func (fp *FinancialProcessor) handleResample(w http.ResponseWriter, r *http.Request) {
+    sym := mux.Vars(r)["symbol"]
+    qs := r.URL.Query()
+    interval := time.Minute
+    if v := qs.Get("interval"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d < time.Second {
+            http.Error(w, "interval must be a duration of at least 1s", http.StatusBadRequest)
+            return
+        }
+        interval = d
+    }
+    var ffill bool
+    switch qs.Get("fill") {
+    case "", "ffill":
+        ffill = true
+    case "null":
+    default:
+        http.Error(w, "fill must be ffill or null", http.StatusBadRequest)
+        return
+    }
+    data := fp.dataStore.Window(sym, 0)
+    if len(data) == 0 {
+        http.Error(w, "no data", http.StatusNotFound)
+        return
+    }
+    sort.SliceStable(data, func(i, j int) bool { return data[i].Timestamp.Before(data[j].Timestamp) })
+    span := data[len(data)-1].Timestamp.Truncate(interval).Sub(data[0].Timestamp.Truncate(interval))
+    if span/interval >= maxResampleBars {
+        http.Error(w, "interval too small for the stored history", http.StatusBadRequest)
+        return
+    }
+    streamJSONArray(w, resample(data, interval, ffill))
+}
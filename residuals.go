@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ResidualRecord ties one prediction to the outcome that followed it: the exact
+input window sent to the ML service, the features the model derives from its
+last row, the predicted price, and the price observed on the next tick.
+*/
+type ResidualRecord struct {
+    Symbol         string             `json:"symbol"`
+    Data           []StockData        `json:"data"`
+    Features       map[string]float64 `json:"features"`
+    PredictedPrice float64            `json:"predicted_price"`
+    ActualPrice    float64            `json:"actual_price"`
+    Residual       float64            `json:"residual"`
+    PredictedAt    time.Time          `json:"predicted_at"`
+    RealizedAt     time.Time          `json:"realized_at"`
+}
+
+/*
+ResidualTracker holds the latest unresolved prediction per symbol and a bounded
+history of resolved records. When RESIDUAL_EXPORT_FILE is set every resolved
+record is also appended to that file as a JSON line.
+*/
+type ResidualTracker struct {
+    mu       sync.Mutex
+    pending  map[string]*ResidualRecord
+    records  []ResidualRecord
+    max      int
+    exportTo string
+}
+
+/*
+NewResidualTrackerFromEnv keeps up to RESIDUAL_HISTORY (default 5000) resolved records.
+*/
+func NewResidualTrackerFromEnv() *ResidualTracker {
+    return &ResidualTracker{
+        pending:  make(map[string]*ResidualRecord),
+        max:      envInt("RESIDUAL_HISTORY", 5000),
+        exportTo: os.Getenv("RESIDUAL_EXPORT_FILE"),
+    }
+}
+
+/*
+Track registers a prediction made from data so it can be resolved by the next tick.
+A newer prediction for the same symbol replaces an unresolved one.
+*/
+func (rt *ResidualTracker) Track(symbol string, data []StockData, predicted float64, at time.Time) {
+    window := append([]StockData(nil), data...)
+    rt.mu.Lock()
+    rt.pending[symbol] = &ResidualRecord{
+        Symbol:         symbol,
+        Data:           window,
+        Features:       modelFeatures(window),
+        PredictedPrice: predicted,
+        PredictedAt:    at,
+    }
+    rt.mu.Unlock()
+}
+
+/*
+Resolve completes the pending record for sd.Symbol when sd is newer than the
+prediction's input window.
+*/
+func (rt *ResidualTracker) Resolve(sd StockData) {
+    rt.mu.Lock()
+    rec, ok := rt.pending[sd.Symbol]
+    if !ok || len(rec.Data) == 0 || !sd.Timestamp.After(rec.Data[len(rec.Data)-1].Timestamp) {
+        rt.mu.Unlock()
+        return
+    }
+    delete(rt.pending, sd.Symbol)
+    rec.ActualPrice = sd.Price
+    rec.Residual = sd.Price - rec.PredictedPrice
+    rec.RealizedAt = sd.Timestamp
+    rt.records = append(rt.records, *rec)
+    if len(rt.records) > rt.max {
+        rt.records = rt.records[len(rt.records)-rt.max:]
+    }
+    rt.mu.Unlock()
+
+    if rt.exportTo != "" {
+        if err := appendJSONLine(rt.exportTo, rec); err != nil {
+            slog.Error("residual export error", "err", err)
+        }
+    }
+}
+
+/*
+Records returns resolved records, optionally restricted to one symbol.
+*/
+func (rt *ResidualTracker) Records(symbol string) []ResidualRecord {
+    rt.mu.Lock()
+    defer rt.mu.Unlock()
+    out := make([]ResidualRecord, 0, len(rt.records))
+    for _, r := range rt.records {
+        if symbol == "" || r.Symbol == symbol {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+/*
+modelFeatures reproduces the features ml_service.py computes for the last row
+of a window: price, volume, SMA_5, and price_change_1d.
+*/
+func modelFeatures(data []StockData) map[string]float64 {
+    n := len(data)
+    if n == 0 {
+        return nil
+    }
+    last := data[n-1]
+    f := map[string]float64{
+        "price":  last.Price,
+        "volume": float64(last.Volume),
+    }
+    if n >= 5 {
+        sum := 0.0
+        for _, d := range data[n-5:] {
+            sum += d.Price
+        }
+        f["SMA_5"] = sum / 5
+    }
+    if n >= 2 && data[n-2].Price != 0 {
+        f["price_change_1d"] = last.Price/data[n-2].Price - 1
+    }
+    return f
+}
+
+/*
+appendJSONLine appends v to path as a single line of JSON.
+*/
+func appendJSONLine(path string, v interface{}) error {
+    line, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    _, err = f.Write(append(line, '\n'))
+    return err
+}
+
+/*
+handleExportResiduals exposes GET /api/export/residuals, emitting resolved
+(features, prediction, outcome) records as JSON lines by default or as a JSON
+array with ?format=json. ?symbol restricts the export to one symbol. The JSON
+lines output can be posted directly to the ML service's /retrain endpoint.
+*/
+func (fp *FinancialProcessor) handleExportResiduals(w http.ResponseWriter, r *http.Request) {
+    records := fp.residuals.Records(r.URL.Query().Get("symbol"))
+    switch r.URL.Query().Get("format") {
+    case "", "jsonl":
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        streamJSONLines(w, records)
+    case "json":
+        streamJSONArray(w, records)
+    default:
+        http.Error(w, "format must be jsonl or json", http.StatusBadRequest)
+    }
+}
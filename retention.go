@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+RetentionTier keeps ticks at Resolution (0 for raw ticks) for Keep, measured
+back from a symbol's newest tick. Label is the resolution as configured.
+*/
+type RetentionTier struct {
+    Label      string
+    Resolution time.Duration
+    Keep       time.Duration
+}
+
+/*
+parseRetentionTiers reads a policy such as "raw:1h,5m:24h,1h:720h": raw
+ticks for the last hour, 5-minute bars for the last day and hourly bars for
+the last 30 days. The first tier must be raw, and each coarser resolution
+must be a multiple of the one before and be kept longer.
+*/
+func parseRetentionTiers(spec string) ([]RetentionTier, error) {
+    var tiers []RetentionTier
+    for _, part := range strings.Split(spec, ",") {
+        label, keep, ok := strings.Cut(strings.TrimSpace(part), ":")
+        if !ok {
+            return nil, fmt.Errorf("retention tier %q is not resolution:duration", part)
+        }
+        t := RetentionTier{Label: label}
+        var err error
+        if t.Keep, err = time.ParseDuration(keep); err != nil || t.Keep <= 0 {
+            return nil, fmt.Errorf("retention tier %q: invalid duration %q", part, keep)
+        }
+        if label != "raw" {
+            if t.Resolution, err = time.ParseDuration(label); err != nil || t.Resolution < time.Second {
+                return nil, fmt.Errorf("retention tier %q: resolution must be raw or a duration of at least 1s", part)
+            }
+        }
+        if n := len(tiers); n == 0 && t.Resolution != 0 {
+            return nil, fmt.Errorf("the first retention tier must be raw, got %q", label)
+        } else if n > 0 {
+            prev := tiers[n-1]
+            switch {
+            case t.Resolution == 0:
+                return nil, fmt.Errorf("only the first retention tier can be raw")
+            case t.Resolution <= prev.Resolution || prev.Resolution > 0 && t.Resolution%prev.Resolution != 0:
+                return nil, fmt.Errorf("retention tier %s must be a multiple of %s", label, prev.Label)
+            case t.Keep <= prev.Keep:
+                return nil, fmt.Errorf("retention tier %s must be kept longer than %s", label, prev.Label)
+            }
+        }
+        tiers = append(tiers, t)
+    }
+    return tiers, nil
+}
+
+/*
+tieredSeries is the tick TimeSeriesStore used with a retention policy. Every
+tick is appended to the raw tier and folded into the open bar of each
+coarser tier as it arrives, so bars never need recomputing. A bar's price is
+its last tick's, open, high and low span its ticks' prices, and volume is
+the last tick's, as quotes carry the day's cumulative volume. Reads return
+each tier's bars from before the next finer tier begins, followed by the raw
+ticks, oldest first. The raw tier also holds at most capacity ticks.
+*/
+type tieredSeries struct {
+    mu       sync.RWMutex
+    tiers    []RetentionTier
+    capacity int
+    series   map[string]*tieredKey
+}
+
+/*
+tieredKey is one symbol's raw ticks and bars per coarser tier, oldest first;
+the last bar of each tier may still be receiving ticks.
+*/
+type tieredKey struct {
+    raw  []StockData
+    bars [][]StockData
+}
+
+/*
+NewTieredSeries creates a store keeping ticks by tiers, whose first tier
+must be raw.
+*/
+func NewTieredSeries(tiers []RetentionTier, capacity int) TimeSeriesStore[StockData] {
+    return &tieredSeries{tiers: tiers, capacity: capacity, series: make(map[string]*tieredKey)}
+}
+
+/*
+cutoff returns the oldest time tier i keeps given the newest tick at latest,
+aligned down to the next tier's resolution so tiers meet without a gap.
+*/
+func (ts *tieredSeries) cutoff(i int, latest time.Time) time.Time {
+    c := latest.Add(-ts.tiers[i].Keep)
+    if i+1 < len(ts.tiers) {
+        c = c.Truncate(ts.tiers[i+1].Resolution)
+    }
+    return c
+}
+
+func (ts *tieredSeries) Append(key string, v StockData) int {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    k := ts.series[key]
+    if k == nil {
+        k = &tieredKey{bars: make([][]StockData, len(ts.tiers)-1)}
+        ts.series[key] = k
+    }
+    k.raw = append(k.raw, v)
+    if n := len(k.raw); n > 1 && v.Timestamp.Before(k.raw[n-2].Timestamp) {
+        sort.SliceStable(k.raw, func(i, j int) bool { return k.raw[i].Timestamp.Before(k.raw[j].Timestamp) })
+    }
+    latest := k.raw[len(k.raw)-1].Timestamp
+    cut := ts.cutoff(0, latest)
+    drop := sort.Search(len(k.raw), func(i int) bool { return !k.raw[i].Timestamp.Before(cut) })
+    if ts.capacity > 0 && len(k.raw)-drop > ts.capacity {
+        drop = len(k.raw) - ts.capacity
+    }
+    if drop > 0 {
+        k.raw = append([]StockData(nil), k.raw[drop:]...)
+    }
+    for i := range k.bars {
+        tier := ts.tiers[i+1]
+        k.bars[i] = foldBar(k.bars[i], v, tier)
+        cut := ts.cutoff(i+1, latest)
+        bars := k.bars[i]
+        drop := sort.Search(len(bars), func(j int) bool { return !bars[j].Timestamp.Before(cut) })
+        if drop > 0 {
+            k.bars[i] = append([]StockData(nil), bars[drop:]...)
+        }
+    }
+    return ts.count(k)
+}
+
+/*
+foldBar adds v to the bar of bars covering it, starting a new bar when v is
+past the last one. A tick older than every bar is dropped.
+*/
+func foldBar(bars []StockData, v StockData, tier RetentionTier) []StockData {
+    start := v.Timestamp.Truncate(tier.Resolution)
+    i := sort.Search(len(bars), func(i int) bool { return !bars[i].Timestamp.Before(start) })
+    if i == len(bars) {
+        return append(bars, StockData{
+            Symbol:        v.Symbol,
+            Price:         v.Price,
+            Volume:        v.Volume,
+            Timestamp:     start,
+            AssetClass:    v.AssetClass,
+            Open:          v.Price,
+            High:          v.Price,
+            Low:           v.Price,
+            PreviousClose: v.PreviousClose,
+            Resolution:    tier.Label,
+        })
+    }
+    b := &bars[i]
+    if !b.Timestamp.Equal(start) {
+        return bars
+    }
+    if v.Price > b.High {
+        b.High = v.Price
+    }
+    if v.Price < b.Low {
+        b.Low = v.Price
+    }
+    if i == len(bars)-1 {
+        b.Price, b.Volume = v.Price, v.Volume
+    }
+    return bars
+}
+
+/*
+served returns how many bars of each coarser tier of k are served: those
+ending before the next finer tier begins. Callers must hold ts.mu.
+*/
+func (ts *tieredSeries) served(k *tieredKey) []int {
+    counts := make([]int, len(k.bars))
+    if len(k.raw) == 0 {
+        return counts
+    }
+    boundary := k.raw[0].Timestamp
+    for i, bars := range k.bars {
+        res := ts.tiers[i+1].Resolution
+        counts[i] = sort.Search(len(bars), func(j int) bool { return bars[j].Timestamp.Add(res).After(boundary) })
+        if len(bars) > 0 && bars[0].Timestamp.Before(boundary) {
+            boundary = bars[0].Timestamp
+        }
+    }
+    return counts
+}
+
+/*
+view lists k's served bars and raw ticks, oldest first. Callers must hold ts.mu.
+*/
+func (ts *tieredSeries) view(k *tieredKey) []StockData {
+    counts := ts.served(k)
+    n := len(k.raw)
+    for _, c := range counts {
+        n += c
+    }
+    out := make([]StockData, 0, n)
+    for i := len(k.bars) - 1; i >= 0; i-- {
+        out = append(out, k.bars[i][:counts[i]]...)
+    }
+    return append(out, k.raw...)
+}
+
+/*
+count returns the length of k's view. Callers must hold ts.mu.
+*/
+func (ts *tieredSeries) count(k *tieredKey) int {
+    n := len(k.raw)
+    for _, c := range ts.served(k) {
+        n += c
+    }
+    return n
+}
+
+func (ts *tieredSeries) Window(key string, n int) []StockData {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    k := ts.series[key]
+    if k == nil {
+        return []StockData{}
+    }
+    out := ts.view(k)
+    if n > 0 && n < len(out) {
+        out = out[len(out)-n:]
+    }
+    return out
+}
+
+/*
+RawWindow returns a copy of the last n raw ticks for key, or all of them
+when n <= 0.
+*/
+func (ts *tieredSeries) RawWindow(key string, n int) []StockData {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    k := ts.series[key]
+    if k == nil {
+        return []StockData{}
+    }
+    raw := k.raw
+    if n > 0 && n < len(raw) {
+        raw = raw[len(raw)-n:]
+    }
+    return append([]StockData(nil), raw...)
+}
+
+/*
+rawWindower is implemented by tick stores that serve aggregated bars
+alongside raw ticks.
+*/
+type rawWindower interface {
+    RawWindow(key string, n int) []StockData
+}
+
+/*
+rawTicks returns symbol's retained raw ticks, leaving out any aggregated
+bars, for consumers such as the ML service that expect evenly collected
+ticks.
+*/
+func (fp *FinancialProcessor) rawTicks(symbol string) []StockData {
+    if rw, ok := fp.dataStore.(rawWindower); ok {
+        return rw.RawWindow(symbol, 0)
+    }
+    return fp.dataStore.Window(symbol, 0)
+}
+
+func (ts *tieredSeries) Latest(key string) (StockData, bool) {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    k := ts.series[key]
+    if k == nil || len(k.raw) == 0 {
+        return StockData{}, false
+    }
+    return k.raw[len(k.raw)-1], true
+}
+
+func (ts *tieredSeries) Len(key string) int {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    k := ts.series[key]
+    if k == nil {
+        return 0
+    }
+    return ts.count(k)
+}
+
+func (ts *tieredSeries) Keys() []string {
+    ts.mu.RLock()
+    defer ts.mu.RUnlock()
+    keys := make([]string, 0, len(ts.series))
+    for k, v := range ts.series {
+        if len(v.raw) > 0 {
+            keys = append(keys, k)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+/*
+SetCapacity changes how many raw ticks each key retains, trimming longer
+series straight away; bars are unaffected. 0 or less means unbounded.
+*/
+func (ts *tieredSeries) SetCapacity(capacity int) {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    ts.capacity = capacity
+    if capacity <= 0 {
+        return
+    }
+    for _, k := range ts.series {
+        if len(k.raw) > capacity {
+            k.raw = append([]StockData(nil), k.raw[len(k.raw)-capacity:]...)
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+backgroundJob is queued work together with its enqueue time.
+*/
+type backgroundJob struct {
+    fn       func()
+    queuedAt time.Time
+}
+
+/*
+Scheduler gives interactive API requests priority over background work such
+as predictions. API requests never wait; background jobs run on a bounded
+pool that shrinks to a minimum while any API request is in flight, so they
+yield CPU and upstream capacity to clients.
+*/
+type Scheduler struct {
+    mu          sync.Mutex
+    max         int
+    yield       int
+    interactive int
+    running     int
+    queue       []backgroundJob
+    completed   int64
+    waitTotal   time.Duration
+}
+
+/*
+NewSchedulerFromEnv creates the scheduler. SCHEDULER_BACKGROUND_MAX (default
+GOMAXPROCS) bounds concurrent background jobs and SCHEDULER_BACKGROUND_YIELD
+(default 1) is the limit while API requests are being served.
+*/
+func NewSchedulerFromEnv() *Scheduler {
+    s := &Scheduler{
+        max:   envInt("SCHEDULER_BACKGROUND_MAX", runtime.GOMAXPROCS(0)),
+        yield: envInt("SCHEDULER_BACKGROUND_YIELD", 1),
+    }
+    if s.max < 1 {
+        s.max = 1
+    }
+    if s.yield < 1 || s.yield > s.max {
+        s.yield = s.max
+    }
+    return s
+}
+
+/*
+Background queues fn to run once a background slot is available.
+*/
+func (s *Scheduler) Background(fn func()) {
+    s.mu.Lock()
+    s.queue = append(s.queue, backgroundJob{fn: fn, queuedAt: time.Now()})
+    s.dispatchLocked()
+    s.mu.Unlock()
+}
+
+/*
+dispatchLocked starts queued jobs up to the current limit. Callers must hold s.mu.
+*/
+func (s *Scheduler) dispatchLocked() {
+    limit := s.max
+    if s.interactive > 0 {
+        limit = s.yield
+    }
+    for s.running < limit && len(s.queue) > 0 {
+        job := s.queue[0]
+        s.queue = s.queue[1:]
+        s.running++
+        s.waitTotal += time.Since(job.queuedAt)
+        go s.run(job)
+    }
+}
+
+/*
+run executes one job and frees its slot.
+*/
+func (s *Scheduler) run(job backgroundJob) {
+    defer func() {
+        s.mu.Lock()
+        s.running--
+        s.completed++
+        s.dispatchLocked()
+        s.mu.Unlock()
+    }()
+    job.fn()
+}
+
+/*
+Middleware marks requests as interactive for as long as they are being served.
+Long-lived profiling, WebSocket and event stream connections are left out.
+*/
+func (s *Scheduler) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasPrefix(r.URL.Path, "/debug/pprof") || r.URL.Path == "/api/admin/profile" || r.URL.Path == "/ws" ||
+            r.URL.Path == "/api/stream" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        s.mu.Lock()
+        s.interactive++
+        s.mu.Unlock()
+        defer func() {
+            s.mu.Lock()
+            s.interactive--
+            s.dispatchLocked()
+            s.mu.Unlock()
+        }()
+        next.ServeHTTP(w, r)
+    })
+}
+
+/*
+writeMetrics appends the scheduler gauges and counters in Prometheus text format.
+*/
+func (s *Scheduler) writeMetrics(sb *strings.Builder) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    sb.WriteString("# HELP scheduler_queue_depth Background jobs waiting for a slot.\n")
+    sb.WriteString("# TYPE scheduler_queue_depth gauge\n")
+    fmt.Fprintf(sb, "scheduler_queue_depth %d\n", len(s.queue))
+    sb.WriteString("# HELP scheduler_running Work currently executing by class.\n")
+    sb.WriteString("# TYPE scheduler_running gauge\n")
+    fmt.Fprintf(sb, "scheduler_running{class=\"interactive\"} %d\n", s.interactive)
+    fmt.Fprintf(sb, "scheduler_running{class=\"background\"} %d\n", s.running)
+    sb.WriteString("# HELP scheduler_background_wait_seconds Time background jobs spent queued.\n")
+    sb.WriteString("# TYPE scheduler_background_wait_seconds summary\n")
+    fmt.Fprintf(sb, "scheduler_background_wait_seconds_sum %g\n", s.waitTotal.Seconds())
+    fmt.Fprintf(sb, "scheduler_background_wait_seconds_count %d\n", s.completed+int64(s.running))
+}
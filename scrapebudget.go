@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+ScrapeBudget tracks the success rate of the last SCRAPE_BUDGET_WINDOW
+fetches (default 200) per provider. Once a provider has at least
+SCRAPE_BUDGET_MIN_SAMPLES fetches (default 20) and its success rate falls
+below SCRAPE_BUDGET_PERCENT (default 95, 0 disables), an operator alert is
+fired, and another once it recovers, so a provider that quietly degrades is
+noticed before forecasts go stale.
+*/
+type ScrapeBudget struct {
+    budget     float64
+    window     int
+    minSamples int
+    mu         sync.Mutex
+    series     map[string]*sloSeries
+    breaching  map[string]bool
+}
+
+/*
+ScrapeBudgetReport is one provider's recent success rate against the budget.
+*/
+type ScrapeBudgetReport struct {
+    Provider       string  `json:"provider"`
+    Fetches        int     `json:"fetches"`
+    Succeeded      int     `json:"succeeded"`
+    SuccessPercent float64 `json:"success_percent"`
+    BudgetPercent  float64 `json:"budget_percent"`
+    Breaching      bool    `json:"breaching"`
+}
+
+/*
+NewScrapeBudgetFromEnv creates the tracker.
+*/
+func NewScrapeBudgetFromEnv() *ScrapeBudget {
+    sb := &ScrapeBudget{
+        budget:     envFloat("SCRAPE_BUDGET_PERCENT", 95),
+        window:     envInt("SCRAPE_BUDGET_WINDOW", 200),
+        minSamples: envInt("SCRAPE_BUDGET_MIN_SAMPLES", 20),
+        series:     make(map[string]*sloSeries),
+        breaching:  make(map[string]bool),
+    }
+    if sb.window < 1 {
+        log.Fatalf("SCRAPE_BUDGET_WINDOW must be at least 1, got %d", sb.window)
+    }
+    return sb
+}
+
+/*
+Observe records one fetch through provider and returns its report when the
+provider has just crossed the budget in either direction.
+*/
+func (sb *ScrapeBudget) Observe(provider string, ok bool) (ScrapeBudgetReport, bool) {
+    sb.mu.Lock()
+    defer sb.mu.Unlock()
+    s, found := sb.series[provider]
+    if !found {
+        s = &sloSeries{}
+        sb.series[provider] = s
+    }
+    s.record(ok, sb.window)
+    rep := sb.report(provider, s)
+    if rep.Breaching == sb.breaching[provider] {
+        return rep, false
+    }
+    sb.breaching[provider] = rep.Breaching
+    return rep, true
+}
+
+/*
+report summarizes s, provider's series. Callers must hold sb.mu.
+*/
+func (sb *ScrapeBudget) report(provider string, s *sloSeries) ScrapeBudgetReport {
+    rep := ScrapeBudgetReport{
+        Provider:       provider,
+        Fetches:        len(s.outcomes),
+        Succeeded:      s.met,
+        SuccessPercent: 100,
+        BudgetPercent:  sb.budget,
+    }
+    if rep.Fetches > 0 {
+        rep.SuccessPercent = 100 * float64(s.met) / float64(rep.Fetches)
+    }
+    rep.Breaching = rep.Fetches >= sb.minSamples && rep.SuccessPercent < sb.budget
+    return rep
+}
+
+/*
+Report returns every provider's success rate, ordered by provider.
+*/
+func (sb *ScrapeBudget) Report() []ScrapeBudgetReport {
+    sb.mu.Lock()
+    defer sb.mu.Unlock()
+    out := make([]ScrapeBudgetReport, 0, len(sb.series))
+    for provider, s := range sb.series {
+        out = append(out, sb.report(provider, s))
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Provider < out[j].Provider })
+    return out
+}
+
+/*
+writeMetrics appends each provider's success rate in Prometheus text format.
+*/
+func (sb *ScrapeBudget) writeMetrics(b *strings.Builder) {
+    b.WriteString("# HELP scrape_success_percent Recent fetches that succeeded, by provider.\n")
+    b.WriteString("# TYPE scrape_success_percent gauge\n")
+    for _, rep := range sb.Report() {
+        fmt.Fprintf(b, "scrape_success_percent{provider=%q} %g\n", rep.Provider, rep.SuccessPercent)
+    }
+}
+
+/*
+scrapeProvider names the provider symbol is fetched through: its
+SYMBOL_SOURCES provider, "quote-api" in batched mode, or "yahoo".
+*/
+func (fp *FinancialProcessor) scrapeProvider(symbol string) string {
+    if src, ok := fp.sources[symbol]; ok {
+        return src.Provider
+    }
+    if envInt("QUOTE_BATCH_SIZE", 0) > 0 {
+        return "quote-api"
+    }
+    return "yahoo"
+}
+
+/*
+observeScrape counts a fetch of symbol against its provider's budget and
+alerts the operator when the provider crosses it.
+*/
+func (fp *FinancialProcessor) observeScrape(symbol string, err error) {
+    rep, crossed := fp.scrapes.Observe(fp.scrapeProvider(symbol), err == nil)
+    if !crossed {
+        return
+    }
+    if rep.Breaching {
+        msg := fmt.Sprintf("%s scrape success rate %.1f%% over the last %d fetches is below the %.1f%% budget",
+            rep.Provider, rep.SuccessPercent, rep.Fetches, rep.BudgetPercent)
+        if err != nil {
+            msg += fmt.Sprintf(" (%s: %v)", symbol, err)
+        }
+        slog.Warn("scrape budget breached", "source", rep.Provider, "success_percent", rep.SuccessPercent, "fetches", rep.Fetches)
+        fp.alerts.FireOperator("scrape_budget", rep.SuccessPercent, msg)
+        return
+    }
+    msg := fmt.Sprintf("%s scrape success rate recovered to %.1f%% (budget %.1f%%)", rep.Provider, rep.SuccessPercent, rep.BudgetPercent)
+    slog.Info("scrape budget recovered", "source", rep.Provider, "success_percent", rep.SuccessPercent)
+    fp.alerts.FireOperator("scrape_budget_recovered", rep.SuccessPercent, msg)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ScrapePool runs the per-symbol pipelines on SCRAPE_WORKERS workers (default
+8) rather than a goroutine and timer per symbol. One scheduler keeps every
+pipeline in a queue ordered by when its next fetch is due and hands due
+pipelines to free workers, at most SCRAPE_RATE per second across all
+symbols (default 20, 0 lifts the limit), so hundreds of symbols make a
+steady stream of requests instead of uncoordinated bursts. Pipelines that
+fell due while the workers were busy are served in the order they fell due,
+ties going to the one fetched least recently, so a backlog is worked off
+fairly instead of some symbols starving others; a pipeline is queued again
+only once its fetch has finished, so no symbol is fetched twice at once.
+*/
+type ScrapePool struct {
+    fp         *FinancialProcessor
+    workers    int
+    gap        time.Duration
+    mu         sync.Mutex
+    queue      pipelineQueue
+    running    map[*symbolPipeline]bool
+    order      int64
+    wake       chan struct{}
+    jobs       chan *symbolPipeline
+    started    sync.Once
+    dispatched atomic.Int64
+}
+
+/*
+NewScrapePoolFromEnv creates the pool for fp. Its goroutines start with the
+first pipeline added.
+*/
+func NewScrapePoolFromEnv(fp *FinancialProcessor) *ScrapePool {
+    sp := &ScrapePool{
+        fp:      fp,
+        workers: envInt("SCRAPE_WORKERS", 8),
+        running: make(map[*symbolPipeline]bool),
+        wake:    make(chan struct{}, 1),
+        jobs:    make(chan *symbolPipeline),
+    }
+    if sp.workers < 1 {
+        log.Fatalf("SCRAPE_WORKERS must be at least 1, got %d", sp.workers)
+    }
+    rate := envFloat("SCRAPE_RATE", 20)
+    if rate < 0 {
+        log.Fatalf("SCRAPE_RATE must not be negative, got %g", rate)
+    }
+    if rate > 0 {
+        sp.gap = time.Duration(float64(time.Second) / rate)
+    }
+    return sp
+}
+
+/*
+pipelineQueue is a heap of pipelines ordered by due time, then by when they
+were last dispatched.
+*/
+type pipelineQueue []*symbolPipeline
+
+func (q pipelineQueue) Len() int { return len(q) }
+
+func (q pipelineQueue) Less(i, j int) bool {
+    if !q[i].due.Equal(q[j].due) {
+        return q[i].due.Before(q[j].due)
+    }
+    return q[i].order < q[j].order
+}
+
+func (q pipelineQueue) Swap(i, j int) {
+    q[i], q[j] = q[j], q[i]
+    q[i].index, q[j].index = i, j
+}
+
+func (q *pipelineQueue) Push(x interface{}) {
+    p := x.(*symbolPipeline)
+    p.index = len(*q)
+    *q = append(*q, p)
+}
+
+func (q *pipelineQueue) Pop() interface{} {
+    old := *q
+    p := old[len(old)-1]
+    old[len(old)-1] = nil
+    p.index = -1
+    *q = old[:len(old)-1]
+    return p
+}
+
+/*
+Add queues p for its first fetch after p.delay.
+*/
+func (sp *ScrapePool) Add(p *symbolPipeline) {
+    sp.started.Do(func() {
+        go sp.schedule()
+        for i := 0; i < sp.workers; i++ {
+            go sp.work()
+        }
+    })
+    due := sp.fp.clock.Now().Add(p.delay)
+    p.times.next.Store(due.UnixNano())
+    sp.mu.Lock()
+    p.due = due
+    heap.Push(&sp.queue, p)
+    sp.mu.Unlock()
+    sp.notify()
+}
+
+/*
+Stop halts p. A queued pipeline is dropped at once; one being fetched is
+dropped when its fetch finishes, whose result is then discarded.
+*/
+func (sp *ScrapePool) Stop(p *symbolPipeline) {
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    p.halt()
+    if p.index >= 0 {
+        heap.Remove(&sp.queue, p.index)
+        close(p.done)
+    }
+}
+
+/*
+Reschedule moves each queued pipeline's next fetch forward to due(p) when
+that is sooner, so a shortened interval applies without waiting out the
+longer one already scheduled.
+*/
+func (sp *ScrapePool) Reschedule(due func(p *symbolPipeline) time.Time) {
+    sp.mu.Lock()
+    queued := append([]*symbolPipeline(nil), sp.queue...)
+    sp.mu.Unlock()
+    // due may take other locks, so it is evaluated outside sp.mu.
+    sooner := make(map[*symbolPipeline]time.Time)
+    for _, p := range queued {
+        sooner[p] = due(p)
+    }
+    sp.mu.Lock()
+    for _, p := range sp.queue {
+        if d, ok := sooner[p]; ok && d.Before(p.due) {
+            p.due = d
+            p.times.next.Store(d.UnixNano())
+        }
+    }
+    heap.Init(&sp.queue)
+    sp.mu.Unlock()
+    sp.notify()
+}
+
+/*
+notify wakes the scheduler to look at the queue again.
+*/
+func (sp *ScrapePool) notify() {
+    select {
+    case sp.wake <- struct{}{}:
+    default:
+    }
+}
+
+/*
+schedule hands due pipelines to the workers, spacing dispatches by the rate
+limit. When idle mode ends every queued pipeline becomes due at once.
+*/
+func (sp *ScrapePool) schedule() {
+    var slot time.Time
+    for {
+        now := sp.fp.clock.Now()
+        sp.mu.Lock()
+        wait := time.Hour
+        var p *symbolPipeline
+        if len(sp.queue) > 0 {
+            due := sp.queue[0].due
+            if due.Before(slot) {
+                due = slot
+            }
+            if wait = due.Sub(now); wait <= 0 {
+                p = heap.Pop(&sp.queue).(*symbolPipeline)
+                sp.order++
+                p.order = sp.order
+                sp.running[p] = true
+            }
+        }
+        sp.mu.Unlock()
+        if p == nil {
+            select {
+            case <-sp.wake:
+            case <-sp.fp.idle.Wake():
+                sp.hurry(sp.fp.clock.Now())
+            case <-sp.fp.clock.After(wait):
+            }
+            continue
+        }
+        if sp.gap > 0 {
+            if slot.Before(now) {
+                slot = now
+            }
+            slot = slot.Add(sp.gap)
+        }
+        sp.jobs <- p
+        sp.dispatched.Add(1)
+    }
+}
+
+/*
+hurry makes every queued pipeline due at now.
+*/
+func (sp *ScrapePool) hurry(now time.Time) {
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    for _, p := range sp.queue {
+        if p.due.After(now) {
+            p.due = now
+        }
+    }
+    heap.Init(&sp.queue)
+}
+
+/*
+work runs dispatched pipelines until the process exits.
+*/
+func (sp *ScrapePool) work() {
+    for p := range sp.jobs {
+        next, ok := sp.fp.collectOnce(p)
+        sp.finish(p, next, ok)
+    }
+}
+
+/*
+finish queues p again for next, or drops it when it was stopped or ok is
+false.
+*/
+func (sp *ScrapePool) finish(p *symbolPipeline, next time.Time, ok bool) {
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    delete(sp.running, p)
+    if !ok || p.stopped() {
+        close(p.done)
+        return
+    }
+    p.due = next
+    heap.Push(&sp.queue, p)
+    sp.notify()
+}
+
+/*
+writeMetrics appends pool occupancy and backlog in Prometheus text format.
+*/
+func (sp *ScrapePool) writeMetrics(sb *strings.Builder) {
+    now := sp.fp.clock.Now()
+    sp.mu.Lock()
+    busy, queued := len(sp.running), len(sp.queue)
+    var lag time.Duration
+    for _, p := range sp.queue {
+        if d := now.Sub(p.due); d > lag {
+            lag = d
+        }
+    }
+    sp.mu.Unlock()
+    sb.WriteString("# HELP scrape_pool_workers_busy Scrape workers fetching, out of scrape_pool_workers.\n")
+    sb.WriteString("# TYPE scrape_pool_workers_busy gauge\n")
+    fmt.Fprintf(sb, "scrape_pool_workers_busy %d\n", busy)
+    sb.WriteString("# HELP scrape_pool_workers Scrape workers in the pool.\n")
+    sb.WriteString("# TYPE scrape_pool_workers gauge\n")
+    fmt.Fprintf(sb, "scrape_pool_workers %d\n", sp.workers)
+    sb.WriteString("# HELP scrape_pool_queued Pipelines waiting for their next fetch.\n")
+    sb.WriteString("# TYPE scrape_pool_queued gauge\n")
+    fmt.Fprintf(sb, "scrape_pool_queued %d\n", queued)
+    sb.WriteString("# HELP scrape_pool_lag_seconds How long the most overdue queued pipeline has been due.\n")
+    sb.WriteString("# TYPE scrape_pool_lag_seconds gauge\n")
+    fmt.Fprintf(sb, "scrape_pool_lag_seconds %g\n", lag.Seconds())
+    sb.WriteString("# HELP scrape_pool_dispatched_total Fetches handed to the scrape workers.\n")
+    sb.WriteString("# TYPE scrape_pool_dispatched_total counter\n")
+    fmt.Fprintf(sb, "scrape_pool_dispatched_total %d\n", sp.dispatched.Load())
+}
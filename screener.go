@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ScreenerRow is one symbol's screenable metrics. Metrics that cannot be computed
+yet (no prediction, too little history for RSI) are omitted.
+*/
+type ScreenerRow struct {
+    Symbol              string      `json:"symbol"`
+    Price               float64     `json:"price,omitempty"`
+    Volume              float64     `json:"volume,omitempty"`
+    ChangePerc          *float64    `json:"change_percent,omitempty"`
+    RSI                 *float64    `json:"rsi,omitempty"`
+    PredictedPrice      *float64    `json:"predicted_price,omitempty"`
+    PredictedChangePerc *float64    `json:"predicted_change_percent,omitempty"`
+    Status              EntryStatus `json:"status"`
+}
+
+/*
+screenerFields lists the metric names accepted in filter and sort expressions.
+*/
+var screenerFields = map[string]bool{
+    "price": true, "volume": true, "change_percent": true, "rsi": true,
+    "predicted_price": true, "predicted_change_percent": true,
+}
+
+/*
+metric returns the named field and whether it is available.
+*/
+func (row ScreenerRow) metric(name string) (float64, bool) {
+    opt := func(p *float64) (float64, bool) {
+        if p == nil {
+            return 0, false
+        }
+        return *p, true
+    }
+    switch name {
+    case "price":
+        return row.Price, true
+    case "volume":
+        return row.Volume, true
+    case "change_percent":
+        return opt(row.ChangePerc)
+    case "rsi":
+        return opt(row.RSI)
+    case "predicted_price":
+        return opt(row.PredictedPrice)
+    case "predicted_change_percent":
+        return opt(row.PredictedChangePerc)
+    }
+    return 0, false
+}
+
+/*
+screenFilter is a parsed "field op value" comparison.
+*/
+type screenFilter struct {
+    field string
+    op    string
+    value float64
+}
+
+/*
+screenSort is a parsed "field [asc|desc]" ordering key.
+*/
+type screenSort struct {
+    field string
+    desc  bool
+}
+
+/*
+parseScreenFilter parses expressions such as "price > 100" or "rsi<30".
+Supported operators are >, >=, <, <=, == and !=.
+*/
+func parseScreenFilter(expr string) (screenFilter, error) {
+    for _, op := range []string{">=", "<=", "==", "!=", ">", "<"} {
+        field, value, ok := strings.Cut(expr, op)
+        if !ok {
+            continue
+        }
+        field = strings.TrimSpace(field)
+        if !screenerFields[field] {
+            return screenFilter{}, fmt.Errorf("unknown field %q", field)
+        }
+        v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+        if err != nil {
+            return screenFilter{}, fmt.Errorf("invalid value in %q", expr)
+        }
+        return screenFilter{field: field, op: op, value: v}, nil
+    }
+    return screenFilter{}, fmt.Errorf("no comparison operator in %q", expr)
+}
+
+/*
+match reports whether row satisfies the filter. Rows missing the field never match.
+*/
+func (f screenFilter) match(row ScreenerRow) bool {
+    v, ok := row.metric(f.field)
+    if !ok {
+        return false
+    }
+    switch f.op {
+    case ">":
+        return v > f.value
+    case ">=":
+        return v >= f.value
+    case "<":
+        return v < f.value
+    case "<=":
+        return v <= f.value
+    case "==":
+        return v == f.value
+    case "!=":
+        return v != f.value
+    }
+    return false
+}
+
+/*
+parseScreenSort parses "field", "field asc" or "field desc".
+*/
+func parseScreenSort(expr string) (screenSort, error) {
+    parts := strings.Fields(expr)
+    if len(parts) == 0 || len(parts) > 2 || !screenerFields[parts[0]] {
+        return screenSort{}, fmt.Errorf("invalid sort %q", expr)
+    }
+    s := screenSort{field: parts[0]}
+    if len(parts) == 2 {
+        switch strings.ToLower(parts[1]) {
+        case "asc":
+        case "desc":
+            s.desc = true
+        default:
+            return screenSort{}, fmt.Errorf("invalid sort direction in %q", expr)
+        }
+    }
+    return s, nil
+}
+
+/*
+screenerRows builds a row for every symbol with collected data.
+*/
+func (fp *FinancialProcessor) screenerRows() []ScreenerRow {
+    var rows []ScreenerRow
+    for _, sym := range fp.dataStore.Keys() {
+        data := fp.dataStore.Window(sym, 0)
+        if len(data) == 0 {
+            continue
+        }
+        last := data[len(data)-1]
+        row := ScreenerRow{Symbol: sym, Price: last.Price, Volume: float64(last.Volume)}
+        if first := data[0].Price; first != 0 && len(data) > 1 {
+            c := (last.Price - first) / first * 100
+            row.ChangePerc = &c
+        }
+        prices := make([]float64, len(data))
+        for i, d := range data {
+            prices[i] = d.Price
+        }
+        if v, ok := rsi(prices, 14); ok {
+            row.RSI = &v
+        }
+        if p, ok := fp.predictions.Latest(sym); ok {
+            pp, pc := p.PredictedPrice, p.PredictedChangePerc
+            row.PredictedPrice, row.PredictedChangePerc = &pp, &pc
+        }
+        row.Status = fp.entryStatus(sym, &last.Timestamp)
+        rows = append(rows, row)
+    }
+    return rows
+}
+
+/*
+splitExprs collects comma-separated expressions from every value of a
+repeated query parameter.
+*/
+func splitExprs(values []string) []string {
+    var out []string
+    for _, v := range values {
+        for _, e := range strings.Split(v, ",") {
+            if e = strings.TrimSpace(e); e != "" {
+                out = append(out, e)
+            }
+        }
+    }
+    return out
+}
+
+/*
+handleScreener exposes GET /api/screener?filter=price>100,rsi<30&sort=predicted_change_percent desc,volume desc&limit=20.
+All filters must match; sort keys are applied in order, with rows missing a
+sort field placed last.
+*/
+func (fp *FinancialProcessor) handleScreener(w http.ResponseWriter, r *http.Request) {
+    qs := r.URL.Query()
+    var filters []screenFilter
+    for _, e := range splitExprs(qs["filter"]) {
+        f, err := parseScreenFilter(e)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        filters = append(filters, f)
+    }
+    var sorts []screenSort
+    for _, e := range splitExprs(qs["sort"]) {
+        s, err := parseScreenSort(e)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        sorts = append(sorts, s)
+    }
+    limit := 0
+    if v := qs.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 {
+            http.Error(w, "invalid limit", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+
+    out := []ScreenerRow{}
+rows:
+    for _, row := range fp.screenerRows() {
+        for _, f := range filters {
+            if !f.match(row) {
+                continue rows
+            }
+        }
+        out = append(out, row)
+    }
+    sort.SliceStable(out, func(i, j int) bool {
+        for _, s := range sorts {
+            a, aok := out[i].metric(s.field)
+            b, bok := out[j].metric(s.field)
+            if aok != bok {
+                return aok
+            }
+            if !aok || a == b {
+                continue
+            }
+            if s.desc {
+                return a > b
+            }
+            return a < b
+        }
+        return out[i].Symbol < out[j].Symbol
+    })
+    if limit > 0 && len(out) > limit {
+        out = out[:limit]
+    }
+    // Tracked symbols without data could not be evaluated; list them after
+    // the matches so their failure is visible rather than silent.
+    for _, sym := range fp.trackedSymbols() {
+        if fp.dataStore.Len(sym) == 0 {
+            out = append(out, ScreenerRow{Symbol: sym, Status: fp.entryStatus(sym, nil)})
+        }
+    }
+    json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+ShardRing splits the tracked symbols between collector instances so each
+scrapes a disjoint subset. SHARD_MODE picks how:
+
+  - static: SHARD_COUNT instances each started with their own SHARD_INDEX
+    (0 to SHARD_COUNT-1) own the symbols whose hash falls in their range.
+    Nothing is coordinated, so every index must be running.
+  - redis: instances register in Redis (SHARD_REDIS_URL, or STORAGE_DSN with
+    STORAGE_DRIVER=redis) with a heartbeat every third of SHARD_TTL (default
+    15s), and each symbol belongs to one live member by rendezvous hashing.
+    When an instance joins or its heartbeat lapses the others pick up or
+    hand over only the symbols whose owner changed.
+
+Every instance keeps tracking all symbols, so the symbols API and reloads
+work anywhere; only fetching, and with it storing and predicting, is
+limited to owned symbols.
+*/
+type ShardRing struct {
+    fp         *FinancialProcessor
+    mode       string
+    index      int
+    count      int
+    client     *redis.Client
+    key        string
+    id         string
+    ttl        time.Duration
+    mu         sync.RWMutex
+    members    []string
+    rebalances atomic.Int64
+}
+
+/*
+NewShardRingFromEnv returns the ring for fp, or nil when SHARD_MODE is unset.
+*/
+func NewShardRingFromEnv(fp *FinancialProcessor) *ShardRing {
+    sr := &ShardRing{fp: fp, mode: os.Getenv("SHARD_MODE")}
+    switch sr.mode {
+    case "":
+        return nil
+    case "static":
+        sr.count = envInt("SHARD_COUNT", 0)
+        sr.index = envInt("SHARD_INDEX", -1)
+        if sr.count < 1 || sr.index < 0 || sr.index >= sr.count {
+            log.Fatalf("SHARD_MODE=static needs SHARD_COUNT >= 1 and SHARD_INDEX in [0, SHARD_COUNT), got %d and %d", sr.count, sr.index)
+        }
+    case "redis":
+        url := os.Getenv("SHARD_REDIS_URL")
+        if url == "" && os.Getenv("STORAGE_DRIVER") == "redis" {
+            url = os.Getenv("STORAGE_DSN")
+        }
+        opts, err := redis.ParseURL(url)
+        if err != nil {
+            log.Fatalf("SHARD_MODE=redis needs SHARD_REDIS_URL (or Redis storage): %v", err)
+        }
+        host, _ := os.Hostname()
+        sr.client = redis.NewClient(opts)
+        sr.key = namespacedKey("forecaster", ":") + ":shards"
+        sr.id = fmt.Sprintf("%s:%d", host, os.Getpid())
+        sr.ttl = envDuration("SHARD_TTL", 15*time.Second)
+        if sr.ttl < time.Second {
+            log.Fatalf("SHARD_TTL must be at least 1s, got %s", sr.ttl)
+        }
+    default:
+        log.Fatalf("SHARD_MODE must be static or redis, got %q", sr.mode)
+    }
+    return sr
+}
+
+/*
+Owns reports whether this instance collects symbol. Without sharding it
+owns everything.
+*/
+func (sr *ShardRing) Owns(symbol string) bool {
+    if sr == nil {
+        return true
+    }
+    if sr.mode == "static" {
+        return int(shardHash(symbol)%uint64(sr.count)) == sr.index
+    }
+    sr.mu.RLock()
+    defer sr.mu.RUnlock()
+    return rendezvousOwner(sr.members, symbol) == sr.id
+}
+
+/*
+shardHash is the FNV-1a hash of s.
+*/
+func shardHash(s string) uint64 {
+    h := fnv.New64a()
+    h.Write([]byte(s))
+    return h.Sum64()
+}
+
+/*
+rendezvousOwner returns the member with the highest hash paired with
+symbol, or "" when there are no members.
+*/
+func rendezvousOwner(members []string, symbol string) string {
+    best, owner := uint64(0), ""
+    for _, m := range members {
+        if h := shardHash(m + "|" + symbol); owner == "" || h > best {
+            best, owner = h, m
+        }
+    }
+    return owner
+}
+
+/*
+Join registers this instance before collection starts, so Owns is right
+from the first fetch, and keeps its heartbeat going. Once heartbeats have
+failed for a whole SHARD_TTL the others will have taken its symbols over,
+so it stops collecting until it can register again. It does nothing
+without Redis coordination. Registration failing at startup is fatal.
+*/
+func (sr *ShardRing) Join() {
+    if sr == nil || sr.mode != "redis" {
+        return
+    }
+    if err := sr.heartbeat(); err != nil {
+        log.Fatalf("joining the shard ring: %v", err)
+    }
+    slog.Info("joined shard ring", "instance", sr.id, "members", len(sr.Members()))
+    go func() {
+        ok := time.Now()
+        for {
+            time.Sleep(sr.ttl / 3)
+            err := sr.heartbeat()
+            if err == nil {
+                ok = time.Now()
+                continue
+            }
+            slog.Warn("shard heartbeat failed", "err", err)
+            if time.Since(ok) >= sr.ttl && len(sr.Members()) > 0 {
+                slog.Warn("shard membership lapsed; pausing collection until it is renewed")
+                sr.mu.Lock()
+                sr.members = nil
+                sr.mu.Unlock()
+                sr.fp.rebalanceShards()
+            }
+        }
+    }()
+}
+
+/*
+heartbeat renews this instance's membership, drops members whose heartbeat
+has lapsed, and rebalances the pipelines when the membership changed.
+*/
+func (sr *ShardRing) heartbeat() error {
+    ctx := context.Background()
+    now := time.Now()
+    pipe := sr.client.TxPipeline()
+    pipe.ZAdd(ctx, sr.key, redis.Z{Score: float64(now.Add(sr.ttl).UnixMilli()), Member: sr.id})
+    pipe.ZRemRangeByScore(ctx, sr.key, "-inf", "("+strconv.FormatInt(now.UnixMilli(), 10))
+    members := pipe.ZRange(ctx, sr.key, 0, -1)
+    if _, err := pipe.Exec(ctx); err != nil {
+        return err
+    }
+    live := members.Val()
+    sort.Strings(live)
+    sr.mu.Lock()
+    changed := strings.Join(live, ",") != strings.Join(sr.members, ",")
+    sr.members = live
+    sr.mu.Unlock()
+    if changed {
+        slog.Info("shard membership changed", "members", live)
+        sr.fp.rebalanceShards()
+    }
+    return nil
+}
+
+/*
+Members returns the live instances, or nil without Redis coordination.
+*/
+func (sr *ShardRing) Members() []string {
+    sr.mu.RLock()
+    defer sr.mu.RUnlock()
+    return append([]string(nil), sr.members...)
+}
+
+/*
+rebalanceShards starts a pipeline for every owned symbol that lacks one and
+stops those of symbols this instance no longer owns. Under batched
+collection only custom-source symbols have pipelines; the batch loop checks
+ownership on every pass.
+*/
+func (fp *FinancialProcessor) rebalanceShards() {
+    fp.mutex.RLock()
+    collecting := fp.collecting
+    fp.mutex.RUnlock()
+    if !collecting {
+        return
+    }
+    fp.shards.rebalances.Add(1)
+    batched := envInt("QUOTE_BATCH_SIZE", 0) > 0
+    for _, sym := range fp.trackedSymbols() {
+        fp.mutex.RLock()
+        _, running := fp.pipelines[sym]
+        fp.mutex.RUnlock()
+        _, custom := fp.sources[sym]
+        switch owned := fp.shards.Owns(sym); {
+        case owned && !running && (custom || !batched) && !fp.delisting.Inactive(sym):
+            fp.startPipeline(sym, 0, 0)
+            slog.Info("symbol taken over from another shard", "symbol", sym)
+        case !owned && running:
+            fp.stopPipeline(sym)
+            slog.Info("symbol handed over to another shard", "symbol", sym)
+        }
+    }
+}
+
+/*
+handleShards exposes GET /api/admin/shards: the sharding mode, this
+instance's place in it and the tracked symbols it collects.
+*/
+func (sr *ShardRing) handleShards(w http.ResponseWriter, r *http.Request) {
+    resp := map[string]interface{}{"mode": "off"}
+    if sr != nil {
+        owned := []string{}
+        for _, sym := range sr.fp.trackedSymbols() {
+            if sr.Owns(sym) {
+                owned = append(owned, sym)
+            }
+        }
+        sort.Strings(owned)
+        resp = map[string]interface{}{"mode": sr.mode, "owned": owned}
+        if sr.mode == "static" {
+            resp["index"], resp["count"] = sr.index, sr.count
+        } else {
+            resp["instance"], resp["members"] = sr.id, sr.Members()
+        }
+    }
+    json.NewEncoder(w).Encode(resp)
+}
+
+/*
+writeMetrics appends the ring's size, this instance's owned symbols and
+rebalances in Prometheus text format. A nil ShardRing writes nothing.
+*/
+func (sr *ShardRing) writeMetrics(sb *strings.Builder) {
+    if sr == nil {
+        return
+    }
+    members := sr.count
+    if sr.mode == "redis" {
+        members = len(sr.Members())
+    }
+    owned := 0
+    for _, sym := range sr.fp.trackedSymbols() {
+        if sr.Owns(sym) {
+            owned++
+        }
+    }
+    sb.WriteString("# HELP shard_members Collector instances sharing the symbols.\n")
+    sb.WriteString("# TYPE shard_members gauge\n")
+    fmt.Fprintf(sb, "shard_members %d\n", members)
+    sb.WriteString("# HELP shard_owned_symbols Tracked symbols this instance collects.\n")
+    sb.WriteString("# TYPE shard_owned_symbols gauge\n")
+    fmt.Fprintf(sb, "shard_owned_symbols %d\n", owned)
+    sb.WriteString("# HELP shard_rebalances_total Membership changes after which pipelines were rebalanced.\n")
+    sb.WriteString("# TYPE shard_rebalances_total counter\n")
+    fmt.Fprintf(sb, "shard_rebalances_total %d\n", sr.rebalances.Load())
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+SnapshotCache keeps the serialized JSON body of hot read-only endpoints so
+that many dashboards polling them share one serialization instead of each
+marshaling its own. A body is rebuilt on the next request once an event
+(a tick, prediction, alert, configuration change or imported history) has
+been published since it was built, or once it is older than
+SNAPSHOT_MAX_AGE (default 1s), which bounds how stale time-dependent fields
+such as uptime can get. SNAPSHOT_MAX_AGE=0 builds every response afresh.
+*/
+type SnapshotCache struct {
+    maxAge  time.Duration
+    version func() int64
+    mu      sync.Mutex
+    entries map[string]*jsonSnapshot
+    hits    atomic.Int64
+    builds  atomic.Int64
+}
+
+/*
+jsonSnapshot is one endpoint's serialized body and the event sequence
+number it was built at. mu is held while building, so concurrent requests
+wait for one build instead of each doing their own.
+*/
+type jsonSnapshot struct {
+    mu      sync.Mutex
+    version int64
+    builtAt time.Time
+    body    []byte
+}
+
+/*
+NewSnapshotCacheFromEnv creates a cache whose bodies go stale whenever
+version changes.
+*/
+func NewSnapshotCacheFromEnv(version func() int64) *SnapshotCache {
+    return &SnapshotCache{
+        maxAge:  envDuration("SNAPSHOT_MAX_AGE", time.Second),
+        version: version,
+        entries: make(map[string]*jsonSnapshot),
+    }
+}
+
+/*
+get returns key's body, building it with build when missing or stale. ok is
+false when build has nothing to serve; that outcome is not cached.
+*/
+func (sc *SnapshotCache) get(key string, build func() (interface{}, bool)) ([]byte, bool) {
+    if sc.maxAge <= 0 {
+        return sc.build(build)
+    }
+    now := time.Now()
+    sc.mu.Lock()
+    snap, found := sc.entries[key]
+    if !found {
+        for k, s := range sc.entries {
+            if s.mu.TryLock() {
+                if now.Sub(s.builtAt) > sc.maxAge {
+                    delete(sc.entries, k)
+                }
+                s.mu.Unlock()
+            }
+        }
+        snap = &jsonSnapshot{}
+        sc.entries[key] = snap
+    }
+    sc.mu.Unlock()
+
+    snap.mu.Lock()
+    defer snap.mu.Unlock()
+    version := sc.version()
+    if snap.body != nil && snap.version == version && time.Since(snap.builtAt) < sc.maxAge {
+        sc.hits.Add(1)
+        return snap.body, true
+    }
+    body, ok := sc.build(build)
+    snap.body, snap.version, snap.builtAt = nil, version, time.Now()
+    if ok {
+        snap.body = body
+    }
+    return body, ok
+}
+
+/*
+build serializes what build returns the way json.Encoder would.
+*/
+func (sc *SnapshotCache) build(build func() (interface{}, bool)) ([]byte, bool) {
+    v, ok := build()
+    if !ok {
+        return nil, false
+    }
+    sc.builds.Add(1)
+    body, err := json.Marshal(v)
+    if err != nil {
+        slog.Error("snapshot encoding failed", "err", err)
+        return nil, false
+    }
+    return append(body, '\n'), true
+}
+
+/*
+Serve writes key's body to w as JSON, reporting false without writing
+anything when build has nothing to serve.
+*/
+func (sc *SnapshotCache) Serve(w http.ResponseWriter, key string, build func() (interface{}, bool)) bool {
+    body, ok := sc.get(key, build)
+    if !ok {
+        return false
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(body)
+    return true
+}
+
+/*
+writeMetrics appends snapshot reuse counters in Prometheus text format.
+*/
+func (sc *SnapshotCache) writeMetrics(sb *strings.Builder) {
+    sb.WriteString("# HELP snapshot_hits_total Responses served from a cached JSON snapshot.\n")
+    sb.WriteString("# TYPE snapshot_hits_total counter\n")
+    fmt.Fprintf(sb, "snapshot_hits_total %d\n", sc.hits.Load())
+    sb.WriteString("# HELP snapshot_builds_total JSON snapshots serialized.\n")
+    sb.WriteString("# TYPE snapshot_builds_total counter\n")
+    fmt.Fprintf(sb, "snapshot_builds_total %d\n", sc.builds.Load())
+}
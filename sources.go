@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+/*
+FetchFunc retrieves one snapshot for a symbol from a data source.
+*/
+type FetchFunc func(symbol string) (*StockData, error)
+
+/*
+SourceFactory builds a FetchFunc from the provider argument configured for a
+symbol, e.g. the URL template of the json provider.
+*/
+type SourceFactory func(arg string) (FetchFunc, error)
+
+/*
+SymbolSource is a per-symbol override of where quotes come from. Listed
+marks a symbol a source plugin listed itself rather than one configured.
+*/
+type SymbolSource struct {
+    Provider string
+    Arg      string
+    Fetch    FetchFunc
+    Listed   bool
+}
+
+var (
+    sourceMu        sync.RWMutex
+    sourceProviders = map[string]SourceFactory{
+        "quote-api": quoteAPISource,
+        "chart":     chartAPISource,
+        "json":      jsonURLSource,
+        "html":      htmlURLSource,
+        "exec":      execSource,
+    }
+)
+
+/*
+RegisterSourceProvider makes a provider available to SYMBOL_SOURCES under name.
+*/
+func RegisterSourceProvider(name string, factory SourceFactory) {
+    sourceMu.Lock()
+    sourceProviders[name] = factory
+    sourceMu.Unlock()
+}
+
+/*
+loadSymbolSourcesFromEnv parses SYMBOL_SOURCES, a semicolon-separated list of
+SYMBOL=provider[:arg] entries such as
+"OTCX=json:https://otc.example.com/api/{symbol}#price=data.last&volume=data.vol;BRK-A=quote-api",
+then adds the symbols listed by SOURCE_PLUGINS. Symbols without an entry
+keep the default Yahoo page scraper.
+*/
+func loadSymbolSourcesFromEnv() (map[string]*SymbolSource, error) {
+    out := make(map[string]*SymbolSource)
+    for _, entry := range strings.Split(os.Getenv("SYMBOL_SOURCES"), ";") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        sym, spec, ok := strings.Cut(entry, "=")
+        if !ok || strings.TrimSpace(sym) == "" {
+            return nil, fmt.Errorf("SYMBOL_SOURCES: invalid entry %q", entry)
+        }
+        provider, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+        sourceMu.RLock()
+        factory, ok := sourceProviders[provider]
+        sourceMu.RUnlock()
+        if !ok {
+            return nil, fmt.Errorf("SYMBOL_SOURCES: unknown provider %q for %s", provider, sym)
+        }
+        fetch, err := factory(arg)
+        if err != nil {
+            return nil, fmt.Errorf("SYMBOL_SOURCES: %s: %w", sym, err)
+        }
+        out[strings.TrimSpace(sym)] = &SymbolSource{Provider: provider, Arg: arg, Fetch: fetch}
+    }
+    return out, loadPluginUniverses(out)
+}
+
+/*
+quoteAPISource fetches the symbol alone through the Yahoo quote API.
+*/
+func quoteAPISource(string) (FetchFunc, error) {
+    return func(symbol string) (*StockData, error) {
+        quotes, err := FetchQuotes([]string{symbol})
+        if err != nil {
+            return nil, err
+        }
+        sd, ok := quotes[symbol]
+        if !ok {
+            return nil, fmt.Errorf("quote API returned no result for %s", symbol)
+        }
+        return sd, nil
+    }, nil
+}
+
+/*
+splitSourceURL separates a URL template from its "#price=...&volume=..."
+field mapping, filling in defaults for missing fields.
+*/
+func splitSourceURL(arg, defPrice, defVolume string) (tmpl, price, volume string, err error) {
+    tmpl, frag, _ := strings.Cut(arg, "#")
+    if tmpl == "" {
+        return "", "", "", fmt.Errorf("missing URL")
+    }
+    q, err := url.ParseQuery(frag)
+    if err != nil {
+        return "", "", "", err
+    }
+    price, volume = q.Get("price"), q.Get("volume")
+    if price == "" {
+        price = defPrice
+    }
+    if volume == "" {
+        volume = defVolume
+    }
+    return tmpl, price, volume, nil
+}
+
+/*
+sourceURL substitutes the symbol into a URL template.
+*/
+func sourceURL(tmpl, symbol string) string {
+    return strings.ReplaceAll(tmpl, "{symbol}", url.PathEscape(symbol))
+}
+
+/*
+jsonURLSource fetches a JSON document and reads price and volume from dotted
+paths (default "price" and "volume"); numeric path segments index arrays.
+*/
+func jsonURLSource(arg string) (FetchFunc, error) {
+    tmpl, pricePath, volumePath, err := splitSourceURL(arg, "price", "volume")
+    if err != nil {
+        return nil, err
+    }
+    client := egressClient(egressSource, 15*time.Second)
+    return func(symbol string) (*StockData, error) {
+        req, err := http.NewRequest("GET", sourceURL(tmpl, symbol), nil)
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("User-Agent", "Mozilla/5.0")
+        resp, err := client.Do(req)
+        if err != nil {
+            return nil, err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            return nil, fmt.Errorf("source returned %s", resp.Status)
+        }
+        var doc interface{}
+        if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+            return nil, err
+        }
+        price, ok := jsonNumber(doc, pricePath)
+        if !ok {
+            return nil, fmt.Errorf("no numeric %q in response", pricePath)
+        }
+        volume, _ := jsonNumber(doc, volumePath)
+        return &StockData{Symbol: symbol, Price: price, Volume: int64(volume)}, nil
+    }, nil
+}
+
+/*
+jsonNumber walks a dotted path through decoded JSON and returns the number
+(or numeric string) found there.
+*/
+func jsonNumber(v interface{}, path string) (float64, bool) {
+    for _, key := range strings.Split(path, ".") {
+        switch node := v.(type) {
+        case map[string]interface{}:
+            v = node[key]
+        case []interface{}:
+            i, err := strconv.Atoi(key)
+            if err != nil || i < 0 || i >= len(node) {
+                return 0, false
+            }
+            v = node[i]
+        default:
+            return 0, false
+        }
+    }
+    switch n := v.(type) {
+    case float64:
+        return n, true
+    case string:
+        f, err := strconv.ParseFloat(CleanNumberString(n), 64)
+        return f, err == nil
+    }
+    return 0, false
+}
+
+/*
+htmlURLSource scrapes an arbitrary page, reading price and volume from the
+first elements matching the configured CSS selectors.
+*/
+func htmlURLSource(arg string) (FetchFunc, error) {
+    tmpl, priceSel, volumeSel, err := splitSourceURL(arg, "", "")
+    if err != nil {
+        return nil, err
+    }
+    if priceSel == "" {
+        return nil, fmt.Errorf("html provider needs a price selector, e.g. #price=span.last")
+    }
+    return func(symbol string) (*StockData, error) {
+        sd := &StockData{Symbol: symbol}
+        found := false
+        c := colly.NewCollector(colly.UserAgent("Mozilla/5.0"))
+        c.WithTransport(egressTransport(egressSource))
+        c.SetRequestTimeout(15 * time.Second)
+        c.OnHTML(priceSel, func(e *colly.HTMLElement) {
+            if v, err := strconv.ParseFloat(CleanNumberString(e.Text), 64); err == nil && !found {
+                sd.Price, found = v, true
+            }
+        })
+        if volumeSel != "" {
+            c.OnHTML(volumeSel, func(e *colly.HTMLElement) {
+                if v, err := strconv.ParseInt(CleanNumberString(e.Text), 10, 64); err == nil && sd.Volume == 0 {
+                    sd.Volume = v
+                }
+            })
+        }
+        if err := c.Visit(sourceURL(tmpl, symbol)); err != nil {
+            return nil, err
+        }
+        c.Wait()
+        if !found {
+            return nil, fmt.Errorf("no price matched %q", priceSel)
+        }
+        return sd, nil
+    }, nil
+}
+
+/*
+sourceOverrides returns the symbols with a configured source, sorted.
+*/
+func (fp *FinancialProcessor) sourceOverrides() []string {
+    var out []string
+    for sym := range fp.sources {
+        out = append(out, sym)
+    }
+    sort.Strings(out)
+    return out
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+sseHeartbeat is how often an open stream gets a comment line, so proxies
+that close silent connections keep it open.
+*/
+const sseHeartbeat = 15 * time.Second
+
+/*
+sseEventTypes are the event types GET /api/stream can carry.
+*/
+var sseEventTypes = map[string]bool{"tick": true, "prediction": true, "alert": true, "history": true}
+
+/*
+handleStream exposes GET /api/stream, a Server-Sent Events feed of the same
+events /ws pushes, for clients that cannot open WebSockets, such as those
+behind proxies that only pass plain HTTP. Each event is sent with its
+sequence number as id, its type as event name and the Event as JSON data.
+?types picks event types (tick and prediction by default; alert and history
+are also available) and ?symbols=AAPL,MSFT picks symbols (all by default).
+A client reconnecting with Last-Event-ID first receives the retained events
+it missed, from the oldest retained when some have been discarded.
+*/
+func (fp *FinancialProcessor) handleStream(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+    qs := r.URL.Query()
+    types := map[string]bool{"tick": true, "prediction": true}
+    if q := qs.Get("types"); q != "" {
+        types = make(map[string]bool)
+        for _, t := range strings.Split(q, ",") {
+            if t = strings.TrimSpace(t); !sseEventTypes[t] {
+                http.Error(w, "types must be among tick, prediction, alert and history", http.StatusBadRequest)
+                return
+            }
+            types[t] = true
+        }
+    }
+    subs := &wsSubscriptions{all: true, symbols: make(map[string]bool)}
+    if q := qs.Get("symbols"); q != "" {
+        subs.all = false
+        subs.update("subscribe", strings.Split(q, ","))
+    }
+
+    // Subscribe before reading the backlog so nothing falls between them.
+    events, unsubscribe := fp.events.Subscribe(256)
+    defer unsubscribe()
+    var backlog []Event
+    if id := r.Header.Get("Last-Event-ID"); id != "" {
+        if cursor, err := strconv.ParseInt(id, 10, 64); err == nil {
+            if backlog, _, ok = fp.events.Since(cursor, 0); !ok {
+                backlog, _, _ = fp.events.Since(-1, 0)
+            }
+        }
+    }
+
+    h := w.Header()
+    h.Set("Content-Type", "text/event-stream")
+    h.Set("Cache-Control", "no-cache")
+    h.Set("X-Accel-Buffering", "no")
+    io.WriteString(w, "retry: 5000\n\n")
+    var last int64
+    send := func(ev Event) error {
+        if ev.Seq <= last {
+            return nil
+        }
+        last = ev.Seq
+        if !types[ev.Type] || !subs.wants(ev.Symbol) {
+            return nil
+        }
+        raw, err := json.Marshal(ev)
+        if err != nil {
+            return err
+        }
+        _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, raw)
+        return err
+    }
+    for _, ev := range backlog {
+        if send(ev) != nil {
+            return
+        }
+    }
+    flusher.Flush()
+
+    heartbeat := time.NewTicker(sseHeartbeat)
+    defer heartbeat.Stop()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case ev, ok := <-events:
+            if !ok || send(ev) != nil {
+                return
+            }
+        case <-heartbeat.C:
+            // An open stream counts as a client being active.
+            fp.idle.Touch()
+            if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+                return
+            }
+        }
+        flusher.Flush()
+    }
+}
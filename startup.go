@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+StartupProblem is one thing found wrong before starting: which check found
+it, what it concerns (a symbol, URL, path or port), the error and a hint on
+fixing it. Problems of severity "error" stop startup; "warning" ones are
+only logged.
+*/
+type StartupProblem struct {
+    Check    string `json:"check"`
+    Subject  string `json:"subject,omitempty"`
+    Severity string `json:"severity"`
+    Error    string `json:"error"`
+    Hint     string `json:"hint,omitempty"`
+}
+
+/*
+StartupReport lists every problem the startup checks found.
+*/
+type StartupReport struct {
+    Mode     string           `json:"mode"`
+    Checks   []string         `json:"checks"`
+    Problems []StartupProblem `json:"problems"`
+    mu       sync.Mutex
+}
+
+/*
+add records a problem; it is safe for concurrent checks.
+*/
+func (sr *StartupReport) add(check, subject, severity string, err error, hint string) {
+    sr.mu.Lock()
+    defer sr.mu.Unlock()
+    sr.Problems = append(sr.Problems, StartupProblem{check, subject, severity, err.Error(), hint})
+}
+
+/*
+Failed reports whether any problem is an error.
+*/
+func (sr *StartupReport) Failed() bool {
+    for _, p := range sr.Problems {
+        if p.Severity == "error" {
+            return true
+        }
+    }
+    return false
+}
+
+/*
+startupChecks are the checks STARTUP_CHECKS may name.
+*/
+var startupChecks = []string{"symbols", "ml", "storage", "port"}
+
+/*
+checkStartup validates the whole setup for mode before anything starts and
+reports every problem found rather than the first. The configuration is
+always checked (cfgErr is LoadConfig's error); STARTUP_CHECKS (default all
+of symbols, ml, storage and port; "none" for none) selects the others:
+
+  - symbols: every configured symbol is looked up once through its source;
+    one the provider does not know is an error, and a lookup that fails
+    otherwise is a warning, since collection retries it anyway.
+  - ml: the default ML service and every ML route answer over HTTP at all,
+    retried until STARTUP_CHECK_TIMEOUT (default 30s) so services started
+    alongside this one have time to come up.
+  - storage: STORAGE_DSN can be opened and, when this process collects,
+    written, and the directory of SYMBOLS_FILE is writable.
+  - port: PORT is free to listen on.
+
+symbols and ml only apply to modes that collect, and are skipped while the
+configuration is invalid or fp is nil.
+*/
+func checkStartup(mode string, fp *FinancialProcessor, cfgErr error) *StartupReport {
+    sr := &StartupReport{Mode: mode, Checks: []string{"config"}, Problems: []StartupProblem{}}
+    if cfgErr != nil {
+        sr.add("config", os.Getenv("CONFIG_FILE"), "error", cfgErr, "fix the setting named in the error in CONFIG_FILE or the environment")
+    }
+    enabled := make(map[string]bool)
+    switch v := strings.TrimSpace(os.Getenv("STARTUP_CHECKS")); v {
+    case "":
+        for _, c := range startupChecks {
+            enabled[c] = true
+        }
+    case "none":
+    default:
+        for _, c := range strings.Split(v, ",") {
+            enabled[strings.TrimSpace(c)] = true
+        }
+    }
+    collects := mode != modeAPI
+    var wg sync.WaitGroup
+    run := func(name string, applies bool, check func(*StartupReport)) {
+        if !enabled[name] || !applies {
+            return
+        }
+        sr.Checks = append(sr.Checks, name)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            check(sr)
+        }()
+    }
+    run("symbols", collects && fp != nil, fp.checkSymbolsResolve)
+    run("ml", collects && fp != nil, fp.checkMLReachable)
+    run("storage", true, func(sr *StartupReport) { checkStorageWritable(sr, collects) })
+    run("port", true, checkPortFree)
+    wg.Wait()
+    return sr
+}
+
+/*
+checkSymbolsResolve looks each configured symbol up through its source or
+Yahoo collector, a few at a time.
+*/
+func (fp *FinancialProcessor) checkSymbolsResolve(sr *StartupReport) {
+    sem := make(chan struct{}, 8)
+    var wg sync.WaitGroup
+    for _, sym := range fp.trackedSymbols() {
+        if fp.delisting.Inactive(sym) {
+            continue
+        }
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(sym string) {
+            defer func() { <-sem; wg.Done() }()
+            var err error
+            if src, ok := fp.sources[sym]; ok {
+                _, err = src.Fetch(sym)
+            } else {
+                _, err = fp.collectorFor(sym).FetchStockData(sym)
+            }
+            switch err.(type) {
+            case nil:
+            case *QuoteMissingError:
+                sr.add("symbols", sym, "error", err, "check the ticker's spelling and exchange suffix, or remove it from SYMBOLS")
+            default:
+                sr.add("symbols", sym, "warning", err, "the quote provider could not be reached; collection will keep retrying")
+            }
+        }(sym)
+    }
+    wg.Wait()
+}
+
+/*
+checkMLReachable waits up to STARTUP_CHECK_TIMEOUT for the default ML service
+and each ML route to answer.
+*/
+func (fp *FinancialProcessor) checkMLReachable(sr *StartupReport) {
+    urls := []string{mlServiceURL("/ready")}
+    for _, rt := range fp.mlRoutes.Routes() {
+        urls = append(urls, rt.BaseURL+"/ready")
+    }
+    deadline := time.Now().Add(envDuration("STARTUP_CHECK_TIMEOUT", 30*time.Second))
+    var wg sync.WaitGroup
+    for _, u := range urls {
+        wg.Add(1)
+        go func(u string) {
+            defer wg.Done()
+            for {
+                resp, err := fp.ml.Get(u)
+                if err == nil {
+                    // Any answer will do; the handshake waits out warm-up.
+                    resp.Body.Close()
+                    return
+                }
+                if time.Now().After(deadline) {
+                    sr.add("ml", u, "error", err, "start the ML service or point ML_SERVICE_HOST, ML_PORT and ML_ROUTES at it")
+                    return
+                }
+                time.Sleep(time.Second)
+            }
+        }(u)
+    }
+    wg.Wait()
+}
+
+/*
+writeProber is implemented by Stores that can check they accept writes
+without changing anything.
+*/
+type writeProber interface {
+    probeWrite() error
+}
+
+/*
+checkStorageWritable opens the configured storage, probes it for writes
+when write is set, and checks that SYMBOLS_FILE could be saved.
+*/
+func checkStorageWritable(sr *StartupReport, write bool) {
+    if os.Getenv("STORAGE_DSN") != "" {
+        driver := os.Getenv("STORAGE_DRIVER")
+        if driver == "" {
+            driver = "sqlite"
+        }
+        store, err := NewStoreFromEnv()
+        switch {
+        case err != nil:
+            sr.add("storage", driver, "error", err, "check STORAGE_DRIVER and STORAGE_DSN, and that the database is running")
+        case write:
+            if wp, ok := store.(writeProber); ok {
+                if err := wp.probeWrite(); err != nil {
+                    sr.add("storage", driver, "error", err, "collectors need write access: check the database user's grants or the file's permissions")
+                }
+            }
+        }
+        if store != nil {
+            store.Close()
+        }
+    }
+    if path := os.Getenv("SYMBOLS_FILE"); path != "" {
+        dir := filepath.Dir(path)
+        f, err := os.CreateTemp(dir, ".symbols-probe-*")
+        if err != nil {
+            sr.add("storage", dir, "error", err, "SYMBOLS_FILE must be in a writable directory")
+            return
+        }
+        f.Close()
+        os.Remove(f.Name())
+    }
+}
+
+/*
+checkPortFree makes sure PORT can be listened on.
+*/
+func checkPortFree(sr *StartupReport) {
+    port := listenPort()
+    l, err := net.Listen("tcp", ":"+port)
+    if err != nil {
+        sr.add("port", port, "error", err, "stop whatever holds the port or set PORT to a free one")
+        return
+    }
+    l.Close()
+}
+
+/*
+mustPassStartupChecks runs checkStartup, logs every problem with its check,
+subject and hint, and exits when any is an error.
+*/
+func mustPassStartupChecks(mode string, fp *FinancialProcessor, cfgErr error) {
+    sr := checkStartup(mode, fp, cfgErr)
+    for _, p := range sr.Problems {
+        level := slog.LevelWarn
+        if p.Severity == "error" {
+            level = slog.LevelError
+        }
+        slog.Log(context.Background(), level, "startup check failed", "check", p.Check, "subject", p.Subject, "err", p.Error, "hint", p.Hint)
+    }
+    if sr.Failed() {
+        log.Fatalf("startup validation found %d problem(s); nothing was started", len(sr.Problems))
+    }
+}
+
+/*
+runValidate implements the `validate` subcommand, which runs the startup
+checks for the mode given as for the service and prints the report as JSON,
+failing when it has errors.
+*/
+func runValidate(args []string) error {
+    mode, err := parseRunMode(args)
+    if err != nil {
+        return err
+    }
+    cfg, cfgErr := LoadConfig()
+    var fp *FinancialProcessor
+    if cfgErr == nil {
+        cfg.Symbols = loadSymbolsFromEnv(cfg.Symbols)
+        fp = NewFinancialProcessor(cfg)
+    }
+    sr := checkStartup(mode, fp, cfgErr)
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetIndent("", "  ")
+    enc.Encode(sr)
+    if sr.Failed() {
+        return fmt.Errorf("%d problem(s) found", len(sr.Problems))
+    }
+    return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+/*
+StatusReport is the operational summary served at /api/status.
+*/
+type StatusReport struct {
+    StartedAt     time.Time                  `json:"started_at"`
+    UptimeSeconds float64                    `json:"uptime_seconds"`
+    Symbols       []string                   `json:"symbols"`
+    Dependencies  map[string]LatencySnapshot `json:"dependencies"`
+    SLOBreaches   []SLOBreach                `json:"slo_breaches"`
+    Idle          bool                       `json:"idle"`
+    Incidents     []WatchdogIncident         `json:"watchdog_incidents"`
+    Inactive      []InactiveSymbol           `json:"inactive_symbols"`
+    Quarantined   []QuarantinedSymbol        `json:"quarantined_symbols"`
+    MLHandshake   []MLReadiness              `json:"ml_handshake"`
+    Degradation   *DegradationState          `json:"degradation,omitempty"`
+    ScrapeBudget  []ScrapeBudgetReport       `json:"scrape_budget"`
+}
+
+/*
+handleStatus exposes an HTTP GET endpoint reporting uptime, tracked symbols,
+per-dependency latency percentiles, any SLO breaches, whether the service is
+idle, loops the watchdog has restarted, symbols deactivated as delisted,
+symbols quarantined after repeated fetch failures, the outcome of the
+startup handshake with each ML service, how much load is being shed under
+memory pressure, and each provider's recent scrape success rate against its
+budget. The report is served from a snapshot.
+*/
+func (fp *FinancialProcessor) handleStatus(w http.ResponseWriter, r *http.Request) {
+    fp.snapshots.Serve(w, "status", func() (interface{}, bool) { return fp.statusReport(), true })
+}
+
+/*
+statusReport gathers the current StatusReport.
+*/
+func (fp *FinancialProcessor) statusReport() StatusReport {
+    symbols := fp.trackedSymbols()
+    return StatusReport{
+        StartedAt:     fp.startedAt,
+        UptimeSeconds: time.Since(fp.startedAt).Seconds(),
+        Symbols:       symbols,
+        Dependencies:  fp.latency.Snapshots(),
+        SLOBreaches:   fp.latency.Breaches(),
+        Idle:          fp.idle.Idle(),
+        Incidents:     fp.watchdog.Incidents(),
+        Inactive:      fp.delisting.List(),
+        Quarantined:   fp.quarantine.List(),
+        MLHandshake:   fp.mlReady.Snapshot(),
+        Degradation:   fp.degradation(),
+        ScrapeBudget:  fp.scrapes.Report(),
+    }
+}
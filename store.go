@@ -0,0 +1,541 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+Store persists collected ticks so history survives restarts and can be
+queried by time range, and maintains aggregates of them as they are stored.
+Implementations exist for SQLite and PostgreSQL, both over the tables
+created by the migrations, and for Redis, shared by replicas.
+*/
+type Store interface {
+    // SaveTick stores sd and adds it to its bars; storing the same symbol and
+    // timestamp twice is a no-op.
+    SaveTick(sd StockData) error
+    // Range returns symbol's ticks with since <= timestamp <= until, oldest
+    // first, at most limit of them when limit > 0. Zero bounds are open.
+    Range(symbol string, since, until time.Time, limit int) ([]StockData, error)
+    // RangeDesc is Range newest first: the limit newest ticks in the range.
+    RangeDesc(symbol string, since, until time.Time, limit int) ([]StockData, error)
+    // Recent returns symbol's last n ticks, oldest first.
+    Recent(symbol string, n int) ([]StockData, error)
+    // Count returns how many ticks are stored for symbol.
+    Count(symbol string) (int, error)
+    // Symbols returns every symbol with stored ticks, sorted.
+    Symbols() ([]string, error)
+    // Bars returns symbol's bars at resolution ("5m", "1h" or "1d") that
+    // start between since and until, oldest first. Zero bounds are open.
+    Bars(symbol, resolution string, since, until time.Time) ([]Candle, error)
+    // SaveAccuracy adds a resolved prediction to its day's accuracy totals.
+    SaveAccuracy(rec AccuracyRecord) error
+    // DailyAccuracy returns symbol's accuracy per horizon and day for days
+    // starting between since and until, oldest first.
+    DailyAccuracy(symbol string, since, until time.Time) ([]DailyAccuracy, error)
+    Close() error
+}
+
+/*
+sqlStore is the database/sql Store. driver ("sqlite" or "postgres") selects
+the placeholder syntax; the SQL is otherwise shared. columns are the tick
+columns read, tickColumns unless the schema predates them.
+*/
+type sqlStore struct {
+    db      *sql.DB
+    driver  string
+    columns string
+}
+
+/*
+NewSQLiteStore opens a SQLite-backed Store at dsn (a file path or URI).
+*/
+func NewSQLiteStore(dsn string) (Store, error) {
+    return openSQLStore("sqlite3", "sqlite", dsn)
+}
+
+/*
+NewPostgresStore opens a PostgreSQL-backed Store using a lib/pq dsn.
+*/
+func NewPostgresStore(dsn string) (Store, error) {
+    return openSQLStore("postgres", "postgres", dsn)
+}
+
+/*
+openSQLStore opens and pings the database. A stock_data table without the
+columns added by migration 3, such as an archive attached as cold storage,
+is read with those fields left zero.
+*/
+func openSQLStore(sqlDriver, driver, dsn string) (Store, error) {
+    db, err := sql.Open(sqlDriver, dsn)
+    if err != nil {
+        return nil, err
+    }
+    if err := db.Ping(); err != nil {
+        db.Close()
+        return nil, err
+    }
+    s := &sqlStore{db: db, driver: driver, columns: tickColumns}
+    rows, err := db.Query(`SELECT ` + tickColumns + ` FROM stock_data WHERE 1 = 0`)
+    if err != nil {
+        s.columns = legacyTickColumns
+    } else {
+        rows.Close()
+    }
+    return s, nil
+}
+
+/*
+NewStoreFromEnv opens the Store configured by STORAGE_DRIVER and STORAGE_DSN,
+or returns nil when no DSN is set.
+*/
+func NewStoreFromEnv() (Store, error) {
+    return openStoreFromEnv("STORAGE")
+}
+
+/*
+NewColdStoreFromEnv opens the archival Store configured by
+COLD_STORAGE_DRIVER and COLD_STORAGE_DSN, or returns nil when no DSN is set.
+It is only read from, for history older than the hot tier holds.
+*/
+func NewColdStoreFromEnv() (Store, error) {
+    return openStoreFromEnv("COLD_STORAGE")
+}
+
+/*
+openStoreFromEnv opens the Store configured by <prefix>_DRIVER and <prefix>_DSN.
+*/
+func openStoreFromEnv(prefix string) (Store, error) {
+    dsn := os.Getenv(prefix + "_DSN")
+    if dsn == "" {
+        return nil, nil
+    }
+    switch driver := os.Getenv(prefix + "_DRIVER"); driver {
+    case "", "sqlite":
+        return NewSQLiteStore(dsn)
+    case "postgres":
+        return NewPostgresStore(dsn)
+    case "redis":
+        return NewRedisStore(dsn)
+    default:
+        return nil, fmt.Errorf("unsupported %s_DRIVER %q", prefix, driver)
+    }
+}
+
+func (s *sqlStore) SaveTick(sd StockData) error {
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    res, err := tx.Exec(rebind(s.driver,
+        `INSERT INTO stock_data (symbol, price, volume, ts, open, high, low, previous_close, earnings_date, ex_dividend_date)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`),
+        sd.Symbol, sd.Price, sd.Volume, sd.Timestamp.UnixNano(),
+        nullFloat(sd.Open), nullFloat(sd.High), nullFloat(sd.Low), nullFloat(sd.PreviousClose),
+        nullTime(sd.EarningsDate), nullTime(sd.ExDividendDate))
+    if err != nil {
+        return err
+    }
+    if n, err := res.RowsAffected(); err != nil || n == 0 {
+        return err
+    }
+    if err := s.addToBars(tx, sd); err != nil {
+        return fmt.Errorf("updating bars: %w", err)
+    }
+    return tx.Commit()
+}
+
+func (s *sqlStore) Range(symbol string, since, until time.Time, limit int) ([]StockData, error) {
+    lo, hi := nanoBounds(since, until)
+    q := `SELECT ` + s.columns + ` FROM stock_data WHERE symbol = ? AND ts >= ? AND ts <= ? ORDER BY ts`
+    args := []interface{}{symbol, lo, hi}
+    if limit > 0 {
+        q += ` LIMIT ?`
+        args = append(args, limit)
+    }
+    return s.query(q, args...)
+}
+
+func (s *sqlStore) RangeDesc(symbol string, since, until time.Time, limit int) ([]StockData, error) {
+    lo, hi := nanoBounds(since, until)
+    q := `SELECT ` + s.columns + ` FROM stock_data WHERE symbol = ? AND ts >= ? AND ts <= ? ORDER BY ts DESC`
+    args := []interface{}{symbol, lo, hi}
+    if limit > 0 {
+        q += ` LIMIT ?`
+        args = append(args, limit)
+    }
+    return s.query(q, args...)
+}
+
+func (s *sqlStore) Recent(symbol string, n int) ([]StockData, error) {
+    out, err := s.query(`SELECT `+s.columns+` FROM stock_data WHERE symbol = ? ORDER BY ts DESC LIMIT ?`, symbol, n)
+    for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+        out[i], out[j] = out[j], out[i]
+    }
+    return out, err
+}
+
+/*
+tickColumns are the stock_data columns query scans, in order, and
+legacyTickColumns stand in for them before migration 3.
+*/
+const (
+    tickColumns       = `symbol, price, volume, ts, open, high, low, previous_close, earnings_date, ex_dividend_date`
+    legacyTickColumns = `symbol, price, volume, ts, NULL, NULL, NULL, NULL, NULL, NULL`
+)
+
+/*
+query runs a tick query selecting s.columns and scans its rows. Columns
+left NULL, by ticks stored before they existed or without the value, leave
+the field zero.
+*/
+func (s *sqlStore) query(q string, args ...interface{}) ([]StockData, error) {
+    rows, err := s.db.Query(rebind(s.driver, q), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []StockData
+    for rows.Next() {
+        var sd StockData
+        var ts int64
+        var open, high, low, prev sql.NullFloat64
+        var earnings, exDividend sql.NullInt64
+        if err := rows.Scan(&sd.Symbol, &sd.Price, &sd.Volume, &ts, &open, &high, &low, &prev, &earnings, &exDividend); err != nil {
+            return nil, err
+        }
+        sd.Timestamp = time.Unix(0, ts).UTC()
+        sd.AssetClass = assetClassOf(sd.Symbol)
+        sd.Open, sd.High, sd.Low, sd.PreviousClose = open.Float64, high.Float64, low.Float64, prev.Float64
+        sd.EarningsDate, sd.ExDividendDate = timeFromNull(earnings), timeFromNull(exDividend)
+        out = append(out, sd)
+    }
+    return out, rows.Err()
+}
+
+/*
+nullFloat stores an unset (zero) price as NULL.
+*/
+func nullFloat(f float64) interface{} {
+    if f == 0 {
+        return nil
+    }
+    return f
+}
+
+/*
+nullTime stores a date as Unix nanoseconds, or NULL when there is none.
+*/
+func nullTime(t *time.Time) interface{} {
+    if t == nil {
+        return nil
+    }
+    return t.UnixNano()
+}
+
+/*
+timeFromNull is the inverse of nullTime.
+*/
+func timeFromNull(n sql.NullInt64) *time.Time {
+    if !n.Valid {
+        return nil
+    }
+    t := time.Unix(0, n.Int64).UTC()
+    return &t
+}
+
+func (s *sqlStore) Count(symbol string) (int, error) {
+    var n int
+    err := s.db.QueryRow(rebind(s.driver, `SELECT COUNT(*) FROM stock_data WHERE symbol = ?`), symbol).Scan(&n)
+    return n, err
+}
+
+func (s *sqlStore) Symbols() ([]string, error) {
+    rows, err := s.db.Query(`SELECT DISTINCT symbol FROM stock_data ORDER BY symbol`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []string
+    for rows.Next() {
+        var sym string
+        if err := rows.Scan(&sym); err != nil {
+            return nil, err
+        }
+        out = append(out, sym)
+    }
+    return out, rows.Err()
+}
+
+/*
+probeWrite runs a delete that matches nothing, which a read-only database or
+role still refuses, and rolls it back.
+*/
+func (s *sqlStore) probeWrite() error {
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    _, err = tx.Exec(`DELETE FROM stock_data WHERE 1 = 0`)
+    return err
+}
+
+func (s *sqlStore) Close() error {
+    return s.db.Close()
+}
+
+/*
+persistentSeries is the tick TimeSeriesStore used when a Store is configured:
+reads come from the in-memory window, and every appended tick is also written
+to the Store. It is seeded from the Store so history survives restarts.
+*/
+type persistentSeries struct {
+    TimeSeriesStore[StockData]
+    store Store
+}
+
+/*
+NewPersistentSeries wraps mem, first warming it with each of symbols' most
+recent capacity ticks from store, leaving out those older than since returns
+for the symbol (a zero time means no age limit). Older history stays in
+storage, so startup time and memory do not grow with it.
+*/
+func NewPersistentSeries(mem TimeSeriesStore[StockData], store Store, symbols []string, capacity int, since func(symbol string) time.Time) TimeSeriesStore[StockData] {
+    start := time.Now()
+    total := 0
+    for _, sym := range symbols {
+        ticks, err := store.Recent(sym, capacity)
+        if err != nil {
+            slog.Error("loading stored history failed", "symbol", sym, "err", err)
+            continue
+        }
+        oldest := since(sym)
+        i := sort.Search(len(ticks), func(i int) bool { return !ticks[i].Timestamp.Before(oldest) })
+        for _, sd := range ticks[i:] {
+            mem.Append(sym, sd)
+        }
+        if n := len(ticks) - i; n > 0 {
+            slog.Info("restored ticks from storage", "symbol", sym, "ticks", n)
+            total += n
+        }
+    }
+    slog.Info("warm cache loaded", "ticks", total, "symbols", len(symbols), "latency", time.Since(start).Round(time.Millisecond))
+    return &persistentSeries{TimeSeriesStore: mem, store: store}
+}
+
+/*
+SetCapacity resizes the in-memory window; stored ticks are unaffected.
+*/
+func (ps *persistentSeries) SetCapacity(capacity int) {
+    if cs, ok := ps.TimeSeriesStore.(capacitySetter); ok {
+        cs.SetCapacity(capacity)
+    }
+}
+
+/*
+RawWindow reads raw ticks from the in-memory window when it keeps bars too.
+*/
+func (ps *persistentSeries) RawWindow(key string, n int) []StockData {
+    if rw, ok := ps.TimeSeriesStore.(rawWindower); ok {
+        return rw.RawWindow(key, n)
+    }
+    return ps.TimeSeriesStore.Window(key, n)
+}
+
+func (ps *persistentSeries) Append(key string, v StockData) int {
+    if err := ps.store.SaveTick(v); err != nil {
+        slog.Error("storing tick failed", "symbol", key, "err", err)
+    }
+    return ps.TimeSeriesStore.Append(key, v)
+}
+
+/*
+storeSeries reads ticks straight from a Store, for API-only processes whose
+history is written by a separate collector. Like the in-memory store it
+exposes at most capacity ticks per symbol. Store errors are logged and read
+as empty results.
+*/
+type storeSeries struct {
+    store    Store
+    capacity int
+}
+
+/*
+NewStoreSeries returns a read-through tick store over store.
+*/
+func NewStoreSeries(store Store, capacity int) TimeSeriesStore[StockData] {
+    return &storeSeries{store: store, capacity: capacity}
+}
+
+func (ss *storeSeries) Append(key string, v StockData) int {
+    if err := ss.store.SaveTick(v); err != nil {
+        slog.Error("storing tick failed", "symbol", key, "err", err)
+    }
+    return ss.Len(key)
+}
+
+func (ss *storeSeries) Window(key string, n int) []StockData {
+    if n <= 0 || n > ss.capacity {
+        n = ss.capacity
+    }
+    out, err := ss.store.Recent(key, n)
+    if err != nil {
+        slog.Error("reading ticks failed", "symbol", key, "err", err)
+    }
+    return out
+}
+
+func (ss *storeSeries) Latest(key string) (StockData, bool) {
+    w := ss.Window(key, 1)
+    if len(w) == 0 {
+        return StockData{}, false
+    }
+    return w[0], true
+}
+
+func (ss *storeSeries) Len(key string) int {
+    n, err := ss.store.Count(key)
+    if err != nil {
+        slog.Error("counting ticks failed", "symbol", key, "err", err)
+    }
+    if n > ss.capacity {
+        n = ss.capacity
+    }
+    return n
+}
+
+func (ss *storeSeries) Keys() []string {
+    keys, err := ss.store.Symbols()
+    if err != nil {
+        slog.Error("listing tick symbols failed", "err", err)
+    }
+    return keys
+}
+
+/*
+tickRange returns symbol's ticks between since and until as one series. Ticks
+come from the hot tier (the Store when one is configured, otherwise the
+in-memory window), and, when cold storage is configured and the range starts
+before the hot tier's first tick, from cold storage for the part before it.
+*/
+func (fp *FinancialProcessor) tickRange(symbol string, since, until time.Time, limit int) ([]StockData, error) {
+    hot, err := fp.hotRange(symbol, since, until, limit)
+    if err != nil || fp.cold == nil {
+        return hot, err
+    }
+    coldUntil := until
+    if first, ok := fp.hotStart(symbol); ok {
+        if !since.IsZero() && !since.Before(first) {
+            return hot, nil
+        }
+        if coldUntil.IsZero() || !coldUntil.Before(first) {
+            coldUntil = first.Add(-time.Nanosecond)
+        }
+    }
+    cold, err := fp.cold.Range(symbol, since, coldUntil, limit)
+    if err != nil {
+        return nil, fmt.Errorf("reading cold storage: %w", err)
+    }
+    out := append(cold, hot...)
+    if limit > 0 && len(out) > limit {
+        out = out[:limit]
+    }
+    return out, nil
+}
+
+/*
+tickRangeDesc is tickRange newest first: up to limit of symbol's newest
+ticks between since and until from the hot tier, continued from cold storage
+when the hot tier runs out before since.
+*/
+func (fp *FinancialProcessor) tickRangeDesc(symbol string, since, until time.Time, limit int) ([]StockData, error) {
+    var hot []StockData
+    if fp.store != nil {
+        ticks, err := fp.store.RangeDesc(symbol, since, until, limit)
+        if err != nil {
+            return nil, fmt.Errorf("reading stored history: %w", err)
+        }
+        hot = ticks
+    } else {
+        w := fp.dataStore.Window(symbol, 0)
+        for i := len(w) - 1; i >= 0 && (limit <= 0 || len(hot) < limit); i-- {
+            if !since.IsZero() && w[i].Timestamp.Before(since) {
+                break
+            }
+            if until.IsZero() || !w[i].Timestamp.After(until) {
+                hot = append(hot, w[i])
+            }
+        }
+    }
+    if fp.cold == nil || limit > 0 && len(hot) >= limit {
+        return hot, nil
+    }
+    coldUntil := until
+    if first, ok := fp.hotStart(symbol); ok {
+        if !since.IsZero() && !since.Before(first) {
+            return hot, nil
+        }
+        if coldUntil.IsZero() || !coldUntil.Before(first) {
+            coldUntil = first.Add(-time.Nanosecond)
+        }
+    }
+    rest := 0
+    if limit > 0 {
+        rest = limit - len(hot)
+    }
+    cold, err := fp.cold.RangeDesc(symbol, since, coldUntil, rest)
+    if err != nil {
+        return nil, fmt.Errorf("reading cold storage: %w", err)
+    }
+    return append(hot, cold...), nil
+}
+
+/*
+hotStart returns the timestamp of symbol's oldest tick in the hot tier.
+*/
+func (fp *FinancialProcessor) hotStart(symbol string) (time.Time, bool) {
+    if fp.store != nil {
+        ticks, err := fp.store.Range(symbol, time.Time{}, time.Time{}, 1)
+        if err != nil || len(ticks) == 0 {
+            return time.Time{}, false
+        }
+        return ticks[0].Timestamp, true
+    }
+    w := fp.dataStore.Window(symbol, 0)
+    if len(w) == 0 {
+        return time.Time{}, false
+    }
+    return w[0].Timestamp, true
+}
+
+/*
+hotRange returns symbol's ticks between since and until from the Store when
+one is configured, otherwise from the in-memory window.
+*/
+func (fp *FinancialProcessor) hotRange(symbol string, since, until time.Time, limit int) ([]StockData, error) {
+    if fp.store != nil {
+        ticks, err := fp.store.Range(symbol, since, until, limit)
+        if err != nil {
+            return nil, fmt.Errorf("reading stored history: %w", err)
+        }
+        return ticks, nil
+    }
+    var out []StockData
+    for _, sd := range fp.dataStore.Window(symbol, 0) {
+        if !since.IsZero() && sd.Timestamp.Before(since) || !until.IsZero() && sd.Timestamp.After(until) {
+            continue
+        }
+        out = append(out, sd)
+        if limit > 0 && len(out) >= limit {
+            break
+        }
+    }
+    return out, nil
+}
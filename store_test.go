@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+/*
+openTestStores returns a migrated Store for every driver that can run here:
+SQLite in a temporary file, Redis on an in-process server, and PostgreSQL
+when TEST_POSTGRES_DSN points at a scratch database.
+*/
+func openTestStores(t *testing.T) map[string]Store {
+    t.Helper()
+    stores := make(map[string]Store)
+    migrated := func(sqlDriver, driver, dsn string) Store {
+        db, err := sql.Open(sqlDriver, dsn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer db.Close()
+        m, err := NewMigrator(db, driver)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := m.Up(0); err != nil {
+            t.Fatal(err)
+        }
+        st, err := openSQLStore(sqlDriver, driver, dsn)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return st
+    }
+    stores["sqlite"] = migrated("sqlite3", "sqlite", filepath.Join(t.TempDir(), "ticks.db"))
+    if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+        stores["postgres"] = migrated("postgres", "postgres", dsn)
+    }
+    mr := miniredis.RunT(t)
+    st, err := NewRedisStore("redis://" + mr.Addr())
+    if err != nil {
+        t.Fatal(err)
+    }
+    stores["redis"] = st
+    for _, st := range stores {
+        t.Cleanup(func() { st.Close() })
+    }
+    return stores
+}
+
+func TestStoreTickRoundTrip(t *testing.T) {
+    at := time.Date(2025, 1, 10, 15, 30, 0, 123456789, time.UTC)
+    earnings := time.Date(2025, 1, 30, 0, 0, 0, 0, time.UTC)
+    exDividend := time.Date(2025, 2, 7, 0, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name string
+        tick StockData
+    }{
+        {"price and volume only", StockData{Symbol: "BTC-USD", Price: 42000.5, Volume: 120}},
+        {"every field", StockData{
+            Symbol:         "AAPL",
+            Price:          187.25,
+            Volume:         51234567,
+            Open:           185.1,
+            High:           188,
+            Low:            184.75,
+            PreviousClose:  185.6,
+            EarningsDate:   &earnings,
+            ExDividendDate: &exDividend,
+        }},
+    }
+    for driver, st := range openTestStores(t) {
+        for _, tt := range tests {
+            t.Run(driver+"/"+tt.name, func(t *testing.T) {
+                want := tt.tick
+                want.Timestamp = at
+                want.AssetClass = assetClassOf(want.Symbol)
+                if err := st.SaveTick(want); err != nil {
+                    t.Fatalf("SaveTick: %v", err)
+                }
+                ranged, err := st.Range(want.Symbol, time.Time{}, time.Time{}, 0)
+                if err != nil {
+                    t.Fatalf("Range: %v", err)
+                }
+                recent, err := st.Recent(want.Symbol, 1)
+                if err != nil {
+                    t.Fatalf("Recent: %v", err)
+                }
+                for name, got := range map[string][]StockData{"Range": ranged, "Recent": recent} {
+                    if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+                        t.Errorf("%s = %+v, want [%+v]", name, got, want)
+                    }
+                }
+            })
+        }
+    }
+}
+
+func TestStoreBarsTradedVolume(t *testing.T) {
+    start := time.Date(2025, 1, 10, 15, 0, 0, 0, time.UTC)
+    for driver, st := range openTestStores(t) {
+        t.Run(driver, func(t *testing.T) {
+            for i, vol := range []int64{1000, 1400, 1450} {
+                sd := StockData{Symbol: "MSFT", Price: 400 + float64(i), Volume: vol, Open: 399, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+                if err := st.SaveTick(sd); err != nil {
+                    t.Fatalf("SaveTick: %v", err)
+                }
+            }
+            bars, err := st.Bars("MSFT", "5m", time.Time{}, time.Time{})
+            if err != nil {
+                t.Fatalf("Bars: %v", err)
+            }
+            if len(bars) != 1 || bars[0].Volume != 450 || bars[0].Open != 400 || bars[0].Close != 402 || bars[0].Ticks != 3 {
+                t.Errorf("Bars = %+v, want one bar opening at 400, closing at 402 with volume 450 over 3 ticks", bars)
+            }
+        })
+    }
+}
+
+func TestSQLStoreReadsLegacySchema(t *testing.T) {
+    dsn := filepath.Join(t.TempDir(), "archive.db")
+    db, err := sql.Open("sqlite3", dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+    m, err := NewMigrator(db, "sqlite")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := m.Up(2); err != nil {
+        t.Fatal(err)
+    }
+    at := time.Date(2024, 6, 3, 14, 0, 0, 0, time.UTC)
+    if _, err := db.Exec(`INSERT INTO stock_data (symbol, price, volume, ts) VALUES ('IBM', 170.5, 900, ?)`, at.UnixNano()); err != nil {
+        t.Fatal(err)
+    }
+    st, err := NewSQLiteStore(dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer st.Close()
+    got, err := st.Range("IBM", time.Time{}, time.Time{}, 0)
+    want := []StockData{{Symbol: "IBM", Price: 170.5, Volume: 900, Timestamp: at, AssetClass: assetClassOf("IBM")}}
+    if err != nil || !reflect.DeepEqual(got, want) {
+        t.Errorf("Range = %+v, %v; want %+v", got, err, want)
+    }
+}
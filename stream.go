@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+)
+
+/*
+streamFlushEvery is how many elements are written between flushes to the client.
+*/
+const streamFlushEvery = 256
+
+/*
+streamJSONArray writes items as a JSON array one element at a time through a
+small buffer, flushing to the client periodically, so large responses are
+never materialized in memory as a whole. The output matches
+json.NewEncoder(w).Encode(items), except that a nil slice is written as [].
+*/
+func streamJSONArray[T any](w http.ResponseWriter, items []T) error {
+    if w.Header().Get("Content-Type") == "" {
+        w.Header().Set("Content-Type", "application/json")
+    }
+    bw := bufio.NewWriterSize(w, 32*1024)
+    flusher, _ := w.(http.Flusher)
+    bw.WriteByte('[')
+    for i, item := range items {
+        if i > 0 {
+            bw.WriteByte(',')
+        }
+        raw, err := json.Marshal(item)
+        if err != nil {
+            return err
+        }
+        bw.Write(raw)
+        if (i+1)%streamFlushEvery == 0 {
+            if err := bw.Flush(); err != nil {
+                return err
+            }
+            if flusher != nil {
+                flusher.Flush()
+            }
+        }
+    }
+    bw.WriteString("]\n")
+    return bw.Flush()
+}
+
+/*
+streamJSONLines writes items as newline-delimited JSON with the same
+periodic flushing as streamJSONArray.
+*/
+func streamJSONLines[T any](w http.ResponseWriter, items []T) error {
+    bw := bufio.NewWriterSize(w, 32*1024)
+    flusher, _ := w.(http.Flusher)
+    enc := json.NewEncoder(bw)
+    for i, item := range items {
+        if err := enc.Encode(item); err != nil {
+            return err
+        }
+        if (i+1)%streamFlushEvery == 0 {
+            if err := bw.Flush(); err != nil {
+                return err
+            }
+            if flusher != nil {
+                flusher.Flush()
+            }
+        }
+    }
+    return bw.Flush()
+}
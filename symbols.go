@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+symbolPattern accepts Yahoo-style tickers such as BRK-B, BTC-USD, ^GSPC and EURUSD=X.
+*/
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9^][A-Z0-9.\-=^]{0,19}$`)
+
+/*
+loadSymbolsFromEnv returns the symbols saved in SYMBOLS_FILE, or defaults
+when the file is unset or has not been written yet.
+*/
+func loadSymbolsFromEnv(defaults []string) []string {
+    path := os.Getenv("SYMBOLS_FILE")
+    if path == "" {
+        return defaults
+    }
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            slog.Warn("symbols file unavailable, using defaults", "err", err)
+        }
+        return defaults
+    }
+    if raw, err = openAtRest(raw); err != nil {
+        log.Fatalf("reading %s: %v", path, err)
+    }
+    var symbols []string
+    if err := json.Unmarshal(raw, &symbols); err != nil {
+        slog.Warn("ignoring unreadable file", "path", path, "err", err)
+        return defaults
+    }
+    return symbols
+}
+
+/*
+saveSymbols writes the tracked symbols to fp.symbolsFile, SYMBOLS_FILE, when
+it is set. Callers must hold fp.mutex.
+*/
+func (fp *FinancialProcessor) saveSymbols() {
+    path := fp.symbolsFile
+    if path == "" {
+        return
+    }
+    raw, err := json.MarshalIndent(fp.symbols, "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = writeFileAtomic(path, raw)
+    }
+    if err != nil {
+        slog.Error("saving symbols failed", "err", err)
+    }
+}
+
+/*
+trackedSymbols returns a copy of the tracked symbols.
+*/
+func (fp *FinancialProcessor) trackedSymbols() []string {
+    fp.mutex.RLock()
+    defer fp.mutex.RUnlock()
+    return append([]string(nil), fp.symbols...)
+}
+
+/*
+addSymbol starts tracking symbol, reporting false if it already was. Once the
+processor is collecting, the symbol gets its own loop, or is picked up by the
+batched loop on its next pass.
+*/
+func (fp *FinancialProcessor) addSymbol(symbol string) bool {
+    fp.mutex.Lock()
+    for _, s := range fp.symbols {
+        if s == symbol {
+            fp.mutex.Unlock()
+            return false
+        }
+    }
+    fp.symbols = append(fp.symbols, symbol)
+    fp.saveSymbols()
+    collecting := fp.collecting
+    fp.mutex.Unlock()
+
+    if _, custom := fp.sources[symbol]; collecting && fp.shards.Owns(symbol) && (custom || envInt("QUOTE_BATCH_SIZE", 0) <= 0) {
+        fp.startPipeline(symbol, 0, 0)
+    }
+    fp.publishConfig(symbol, ConfigChange{Kind: "symbol_added"})
+    slog.Info("symbol tracked", "symbol", symbol)
+    return true
+}
+
+/*
+removeSymbol stops tracking symbol and stops its pipeline, reporting
+false if it was not tracked. Its stored history is kept.
+*/
+func (fp *FinancialProcessor) removeSymbol(symbol string) bool {
+    fp.mutex.Lock()
+    idx := -1
+    for i, s := range fp.symbols {
+        if s == symbol {
+            idx = i
+        }
+    }
+    if idx < 0 {
+        fp.mutex.Unlock()
+        return false
+    }
+    fp.symbols = append(fp.symbols[:idx:idx], fp.symbols[idx+1:]...)
+    fp.saveSymbols()
+    p, ok := fp.pipelines[symbol]
+    delete(fp.pipelines, symbol)
+    delete(fp.collectors, symbol)
+    fp.mutex.Unlock()
+
+    if ok {
+        fp.pool.Stop(p)
+    }
+    fp.quarantine.Forget(symbol)
+    fp.publishConfig(symbol, ConfigChange{Kind: "symbol_removed"})
+    slog.Info("symbol no longer tracked", "symbol", symbol)
+    return true
+}
+
+/*
+handleListSymbols exposes GET /api/symbols.
+*/
+func (fp *FinancialProcessor) handleListSymbols(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(fp.trackedSymbols())
+}
+
+/*
+handleAddSymbol exposes POST /api/symbols, which starts tracking the symbol
+in a JSON body such as {"symbol": "NVDA"}.
+*/
+func (fp *FinancialProcessor) handleAddSymbol(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Symbol string `json:"symbol"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    sym := strings.ToUpper(strings.TrimSpace(req.Symbol))
+    if !symbolPattern.MatchString(sym) {
+        http.Error(w, "invalid symbol", http.StatusBadRequest)
+        return
+    }
+    if fp.delisting.Inactive(sym) {
+        http.Error(w, sym+" is inactive; reactivate it through the admin API", http.StatusConflict)
+        return
+    }
+    if !fp.addSymbol(sym) {
+        http.Error(w, sym+" is already tracked", http.StatusConflict)
+        return
+    }
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]string{"symbol": sym})
+}
+
+/*
+handleRemoveSymbol exposes DELETE /api/symbols/{symbol}.
+*/
+func (fp *FinancialProcessor) handleRemoveSymbol(w http.ResponseWriter, r *http.Request) {
+    sym := strings.ToUpper(mux.Vars(r)["symbol"])
+    if !fp.removeSymbol(sym) {
+        http.Error(w, sym+" is not tracked", http.StatusNotFound)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+SymbolSettings overrides the configured defaults for one symbol:
+IntervalSeconds replaces the collection interval and PredictionThreshold the
+predicted move below which predictions are not acted on. Tags are free-form
+labels for operators. LowPriority symbols are the first to stop being
+collected under memory pressure. WarmCacheDays replaces WARM_CACHE_DAYS, how
+many days of stored history are loaded into memory at startup.
+*/
+type SymbolSettings struct {
+    IntervalSeconds     int      `json:"interval_seconds,omitempty"`
+    PredictionThreshold *float64 `json:"prediction_threshold,omitempty"`
+    Tags                []string `json:"tags,omitempty"`
+    LowPriority         bool     `json:"low_priority,omitempty"`
+    WarmCacheDays       int      `json:"warm_cache_days,omitempty"`
+}
+
+/*
+SymbolSettingsChange is one entry of a bulk settings update. Omitted fields
+are left unchanged; an interval of 0 restores the default, and Reset clears
+every override before the other fields are applied.
+*/
+type SymbolSettingsChange struct {
+    Symbol              string    `json:"symbol"`
+    Reset               bool      `json:"reset"`
+    IntervalSeconds     *int      `json:"interval_seconds"`
+    PredictionThreshold *float64  `json:"prediction_threshold"`
+    Tags                *[]string `json:"tags"`
+    LowPriority         *bool     `json:"low_priority"`
+    WarmCacheDays       *int      `json:"warm_cache_days"`
+}
+
+/*
+SettingsError reports why the change at Index was rejected.
+*/
+type SettingsError struct {
+    Index  int    `json:"index"`
+    Symbol string `json:"symbol"`
+    Error  string `json:"error"`
+}
+
+/*
+SymbolSettingsBook holds per-symbol settings, saved to SYMBOL_SETTINGS_FILE
+when it is set.
+*/
+type SymbolSettingsBook struct {
+    mu       sync.RWMutex
+    settings map[string]SymbolSettings
+    path     string
+}
+
+/*
+NewSymbolSettingsBookFromEnv loads the settings saved in SYMBOL_SETTINGS_FILE, if any.
+*/
+func NewSymbolSettingsBookFromEnv() *SymbolSettingsBook {
+    sb := &SymbolSettingsBook{settings: make(map[string]SymbolSettings), path: os.Getenv("SYMBOL_SETTINGS_FILE")}
+    if sb.path == "" {
+        return sb
+    }
+    raw, err := os.ReadFile(sb.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            slog.Error("symbol settings unavailable", "err", err)
+        }
+        return sb
+    }
+    if raw, err = openAtRest(raw); err != nil {
+        log.Fatalf("reading %s: %v", sb.path, err)
+    }
+    if err := json.Unmarshal(raw, &sb.settings); err != nil {
+        slog.Warn("ignoring unreadable file", "path", sb.path, "err", err)
+        sb.settings = make(map[string]SymbolSettings)
+    }
+    return sb
+}
+
+/*
+Get returns symbol's settings; the zero value means all defaults.
+*/
+func (sb *SymbolSettingsBook) Get(symbol string) SymbolSettings {
+    sb.mu.RLock()
+    defer sb.mu.RUnlock()
+    return sb.settings[symbol]
+}
+
+/*
+All returns a copy of every symbol's settings.
+*/
+func (sb *SymbolSettingsBook) All() map[string]SymbolSettings {
+    sb.mu.RLock()
+    defer sb.mu.RUnlock()
+    out := make(map[string]SymbolSettings, len(sb.settings))
+    for sym, s := range sb.settings {
+        out[sym] = s
+    }
+    return out
+}
+
+/*
+Apply validates every change against the tracked symbols and applies them
+all, or, if any is invalid, none of them and returns why.
+*/
+func (sb *SymbolSettingsBook) Apply(changes []SymbolSettingsChange, tracked []string) []SettingsError {
+    known := make(map[string]bool, len(tracked))
+    for _, s := range tracked {
+        known[s] = true
+    }
+    var errs []SettingsError
+    seen := make(map[string]bool)
+    for i, c := range changes {
+        var msg string
+        switch {
+        case !known[c.Symbol]:
+            msg = "symbol is not tracked"
+        case seen[c.Symbol]:
+            msg = "symbol appears more than once"
+        case c.IntervalSeconds != nil && *c.IntervalSeconds < 0:
+            msg = "interval_seconds must be positive, or 0 for the default"
+        case c.PredictionThreshold != nil && *c.PredictionThreshold < 0:
+            msg = "prediction_threshold must not be negative"
+        case c.WarmCacheDays != nil && *c.WarmCacheDays < 0:
+            msg = "warm_cache_days must be positive, or 0 for the default"
+        }
+        seen[c.Symbol] = true
+        if msg != "" {
+            errs = append(errs, SettingsError{Index: i, Symbol: c.Symbol, Error: msg})
+        }
+    }
+    if len(errs) > 0 {
+        return errs
+    }
+
+    sb.mu.Lock()
+    defer sb.mu.Unlock()
+    for _, c := range changes {
+        s := sb.settings[c.Symbol]
+        if c.Reset {
+            s = SymbolSettings{}
+        }
+        if c.IntervalSeconds != nil {
+            s.IntervalSeconds = *c.IntervalSeconds
+        }
+        if c.PredictionThreshold != nil {
+            v := *c.PredictionThreshold
+            s.PredictionThreshold = &v
+        }
+        if c.Tags != nil {
+            s.Tags = append([]string(nil), (*c.Tags)...)
+            sort.Strings(s.Tags)
+        }
+        if c.LowPriority != nil {
+            s.LowPriority = *c.LowPriority
+        }
+        if c.WarmCacheDays != nil {
+            s.WarmCacheDays = *c.WarmCacheDays
+        }
+        if s.IntervalSeconds == 0 && s.PredictionThreshold == nil && len(s.Tags) == 0 && !s.LowPriority && s.WarmCacheDays == 0 {
+            delete(sb.settings, c.Symbol)
+        } else {
+            sb.settings[c.Symbol] = s
+        }
+    }
+    sb.save()
+    return nil
+}
+
+/*
+save writes the settings to the configured file. Callers must hold sb.mu.
+*/
+func (sb *SymbolSettingsBook) save() {
+    if sb.path == "" {
+        return
+    }
+    raw, err := json.MarshalIndent(sb.settings, "", "  ")
+    if err == nil {
+        raw, err = sealAtRest(raw)
+    }
+    if err == nil {
+        err = writeFileAtomic(sb.path, raw)
+    }
+    if err != nil {
+        slog.Error("saving symbol settings failed", "err", err)
+    }
+}
+
+/*
+predictionThreshold returns the predicted move in percent below which
+symbol's predictions are not acted on.
+*/
+func (fp *FinancialProcessor) predictionThreshold(symbol string) float64 {
+    if t := fp.settings.Get(symbol).PredictionThreshold; t != nil {
+        return *t
+    }
+    return fp.currentConfig().PredictionThreshold
+}
+
+/*
+symbolInterval returns symbol's configured collection interval, before
+boosts and idling are taken into account.
+*/
+func (fp *FinancialProcessor) symbolInterval(symbol string) time.Duration {
+    if s := fp.settings.Get(symbol).IntervalSeconds; s > 0 {
+        return time.Duration(s) * time.Second
+    }
+    return fp.currentConfig().Interval
+}
+
+/*
+warmCacheSince returns the oldest stored tick of symbol to load into memory
+at startup: WARM_CACHE_DAYS (default 0, no age limit) or the symbol's
+override before now.
+*/
+func (fp *FinancialProcessor) warmCacheSince(symbol string) time.Time {
+    days := fp.settings.Get(symbol).WarmCacheDays
+    if days == 0 {
+        days = envInt("WARM_CACHE_DAYS", 0)
+    }
+    if days <= 0 {
+        return time.Time{}
+    }
+    return fp.clock.Now().AddDate(0, 0, -days)
+}
+
+/*
+handleListSymbolSettings exposes GET /api/symbols/settings, the overrides in
+effect keyed by symbol.
+*/
+func (fp *FinancialProcessor) handleListSymbolSettings(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(fp.settings.All())
+}
+
+/*
+handlePatchSymbolSettings exposes PATCH /api/symbols/settings, which applies
+a JSON array of SymbolSettingsChange entries as one transaction: either all
+are applied, or the request is rejected with 422 listing every invalid entry.
+*/
+func (fp *FinancialProcessor) handlePatchSymbolSettings(w http.ResponseWriter, r *http.Request) {
+    var changes []SymbolSettingsChange
+    if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+        http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    if len(changes) == 0 {
+        http.Error(w, "no changes given", http.StatusBadRequest)
+        return
+    }
+    for i := range changes {
+        changes[i].Symbol = strings.ToUpper(strings.TrimSpace(changes[i].Symbol))
+    }
+    if errs := fp.settings.Apply(changes, fp.trackedSymbols()); errs != nil {
+        w.WriteHeader(http.StatusUnprocessableEntity)
+        json.NewEncoder(w).Encode(map[string]interface{}{"applied": false, "errors": errs})
+        return
+    }
+    for _, c := range changes {
+        s := fp.settings.Get(c.Symbol)
+        fp.publishConfig(c.Symbol, ConfigChange{Kind: "symbol_settings", Settings: &s})
+    }
+    slog.Info("symbol settings updated", "symbols", len(changes))
+    json.NewEncoder(w).Encode(map[string]interface{}{"applied": true, "settings": fp.settings.All()})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+TimeSeriesStore is a concurrency-safe, per-key bounded series of values in
+arrival order. Ticks, news items, and later derived series all share it.
+*/
+type TimeSeriesStore[T any] interface {
+    // Append adds v to the series for key and returns the new length.
+    Append(key string, v T) int
+    // Window returns a copy of the last n values for key, or all values when n <= 0.
+    Window(key string, n int) []T
+    // Latest returns the most recent value for key.
+    Latest(key string) (T, bool)
+    // Len returns the number of values held for key.
+    Len(key string) int
+    // Keys returns every key with at least one value, sorted.
+    Keys() []string
+}
+
+/*
+memorySeries is the in-memory TimeSeriesStore. Each key keeps at most capacity
+values; older values are dropped as new ones arrive.
+*/
+type memorySeries[T any] struct {
+    mu       sync.RWMutex
+    capacity int
+    series   map[string][]T
+}
+
+/*
+NewMemorySeries creates an in-memory store retaining capacity values per key.
+A capacity of 0 or less means unbounded.
+*/
+func NewMemorySeries[T any](capacity int) TimeSeriesStore[T] {
+    return &memorySeries[T]{capacity: capacity, series: make(map[string][]T)}
+}
+
+func (m *memorySeries[T]) Append(key string, v T) int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    arr := append(m.series[key], v)
+    if m.capacity > 0 && len(arr) > m.capacity {
+        // Copy instead of reslicing so the dropped prefix can be collected.
+        trimmed := make([]T, m.capacity, m.capacity+m.capacity/4+1)
+        copy(trimmed, arr[len(arr)-m.capacity:])
+        arr = trimmed
+    }
+    m.series[key] = arr
+    return len(arr)
+}
+
+func (m *memorySeries[T]) Window(key string, n int) []T {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    arr := m.series[key]
+    if n > 0 && n < len(arr) {
+        arr = arr[len(arr)-n:]
+    }
+    out := make([]T, len(arr))
+    copy(out, arr)
+    return out
+}
+
+func (m *memorySeries[T]) Latest(key string) (T, bool) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    arr := m.series[key]
+    if len(arr) == 0 {
+        var zero T
+        return zero, false
+    }
+    return arr[len(arr)-1], true
+}
+
+/*
+SetCapacity changes how many values each key retains, trimming longer series
+straight away. 0 or less means unbounded.
+*/
+func (m *memorySeries[T]) SetCapacity(capacity int) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.capacity = capacity
+    if capacity <= 0 {
+        return
+    }
+    for key, arr := range m.series {
+        if len(arr) > capacity {
+            m.series[key] = append([]T(nil), arr[len(arr)-capacity:]...)
+        }
+    }
+}
+
+func (m *memorySeries[T]) Len(key string) int {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return len(m.series[key])
+}
+
+func (m *memorySeries[T]) Keys() []string {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    keys := make([]string, 0, len(m.series))
+    for k, v := range m.series {
+        if len(v) > 0 {
+            keys = append(keys, k)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
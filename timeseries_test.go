@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMemorySeriesAppend(t *testing.T) {
+    tests := []struct {
+        name     string
+        capacity int
+        appends  []int
+        wantLens []int
+        want     []int
+    }{
+        {"unbounded", 0, []int{1, 2, 3}, []int{1, 2, 3}, []int{1, 2, 3}},
+        {"negative capacity is unbounded", -1, []int{1, 2, 3}, []int{1, 2, 3}, []int{1, 2, 3}},
+        {"under capacity", 5, []int{1, 2, 3}, []int{1, 2, 3}, []int{1, 2, 3}},
+        {"at capacity", 3, []int{1, 2, 3}, []int{1, 2, 3}, []int{1, 2, 3}},
+        {"evicts oldest past capacity", 3, []int{1, 2, 3, 4, 5}, []int{1, 2, 3, 3, 3}, []int{3, 4, 5}},
+        {"capacity one keeps latest", 1, []int{1, 2, 3}, []int{1, 1, 1}, []int{3}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := NewMemorySeries[int](tt.capacity)
+            for i, v := range tt.appends {
+                if n := s.Append("k", v); n != tt.wantLens[i] {
+                    t.Errorf("Append(%d) = %d, want %d", v, n, tt.wantLens[i])
+                }
+            }
+            if got := s.Window("k", 0); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("Window = %v, want %v", got, tt.want)
+            }
+            if got := s.Len("k"); got != len(tt.want) {
+                t.Errorf("Len = %d, want %d", got, len(tt.want))
+            }
+            if got, ok := s.Latest("k"); !ok || got != tt.want[len(tt.want)-1] {
+                t.Errorf("Latest = %d, %v; want %d", got, ok, tt.want[len(tt.want)-1])
+            }
+        })
+    }
+}
+
+func TestMemorySeriesWindow(t *testing.T) {
+    s := NewMemorySeries[int](0)
+    for v := 1; v <= 5; v++ {
+        s.Append("k", v)
+    }
+    tests := []struct {
+        name string
+        key  string
+        n    int
+        want []int
+    }{
+        {"all", "k", 0, []int{1, 2, 3, 4, 5}},
+        {"negative means all", "k", -2, []int{1, 2, 3, 4, 5}},
+        {"last two", "k", 2, []int{4, 5}},
+        {"exactly all", "k", 5, []int{1, 2, 3, 4, 5}},
+        {"more than held", "k", 10, []int{1, 2, 3, 4, 5}},
+        {"unknown key", "x", 3, []int{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := s.Window(tt.key, tt.n); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("Window(%q, %d) = %v, want %v", tt.key, tt.n, got, tt.want)
+            }
+        })
+    }
+    got := s.Window("k", 2)
+    got[0] = 99
+    if again := s.Window("k", 2); again[0] != 4 {
+        t.Errorf("Window shares its backing array: got %v after modifying a copy", again)
+    }
+}
+
+func TestMemorySeriesKeysAndLatest(t *testing.T) {
+    s := NewMemorySeries[string](2)
+    if _, ok := s.Latest("MSFT"); ok {
+        t.Error("Latest on an empty series reported a value")
+    }
+    for _, k := range []string{"MSFT", "AAPL", "MSFT"} {
+        s.Append(k, k+"-tick")
+    }
+    if got, want := s.Keys(), []string{"AAPL", "MSFT"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("Keys = %v, want %v", got, want)
+    }
+    if got := s.Len("GOOGL"); got != 0 {
+        t.Errorf("Len of unknown key = %d, want 0", got)
+    }
+}
+
+func TestMemorySeriesSetCapacity(t *testing.T) {
+    s := NewMemorySeries[int](0).(*memorySeries[int])
+    for v := 1; v <= 5; v++ {
+        s.Append("k", v)
+    }
+    s.SetCapacity(2)
+    if got, want := s.Window("k", 0), []int{4, 5}; !reflect.DeepEqual(got, want) {
+        t.Errorf("Window after SetCapacity(2) = %v, want %v", got, want)
+    }
+    s.Append("k", 6)
+    if got, want := s.Window("k", 0), []int{5, 6}; !reflect.DeepEqual(got, want) {
+        t.Errorf("Window after Append = %v, want %v", got, want)
+    }
+}
+
+func TestMemorySeriesConcurrentAccess(t *testing.T) {
+    const writers, perWriter, capacity = 8, 500, 100
+    s := NewMemorySeries[int](capacity)
+    var wg sync.WaitGroup
+    for w := 0; w < writers; w++ {
+        key := "k" + strconv.Itoa(w%2)
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < perWriter; i++ {
+                if n := s.Append(key, i); n > capacity {
+                    t.Errorf("Append returned length %d past capacity %d", n, capacity)
+                }
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            for i := 0; i < perWriter; i++ {
+                if got := s.Window(key, 10); len(got) > 10 {
+                    t.Errorf("Window(10) returned %d values", len(got))
+                }
+                s.Latest(key)
+                s.Len(key)
+                s.Keys()
+            }
+        }()
+    }
+    wg.Wait()
+    for _, key := range []string{"k0", "k1"} {
+        if got := s.Len(key); got != capacity {
+            t.Errorf("Len(%q) = %d, want %d", key, got, capacity)
+        }
+    }
+}
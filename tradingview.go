@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+TradingViewSignal is a webhook payload in the shape TradingView alerts send
+and TradingView-driven automation (order bridges, bots) expects.
+*/
+type TradingViewSignal struct {
+    Passphrase string  `json:"passphrase,omitempty"`
+    Ticker     string  `json:"ticker"`
+    Action     string  `json:"action"`
+    Sentiment  string  `json:"sentiment"`
+    Price      float64 `json:"price"`
+    Quantity   float64 `json:"quantity,omitempty"`
+    Time       string  `json:"time"`
+    Comment    string  `json:"comment,omitempty"`
+}
+
+/*
+TradingViewAdapter turns predictions into buy/sell/exit signals and POSTs them
+to TRADINGVIEW_WEBHOOK_URL. A signal is sent only when a symbol's side
+changes, so repeated predictions in the same direction do not resend orders.
+*/
+type TradingViewAdapter struct {
+    url        string
+    threshold  float64
+    passphrase string
+    quantity   float64
+    client     *http.Client
+    latency    *DependencyMetrics
+    mu         sync.Mutex
+    sides      map[string]string
+}
+
+/*
+NewTradingViewAdapterFromEnv returns nil unless TRADINGVIEW_WEBHOOK_URL is set.
+TRADINGVIEW_THRESHOLD_PERCENT (default 1) is the predicted move needed for a
+buy or sell, TRADINGVIEW_PASSPHRASE is included for receivers that check one,
+and TRADINGVIEW_QUANTITY sets an order size.
+*/
+func NewTradingViewAdapterFromEnv(latency *DependencyMetrics) *TradingViewAdapter {
+    url := os.Getenv("TRADINGVIEW_WEBHOOK_URL")
+    if url == "" {
+        return nil
+    }
+    ta := &TradingViewAdapter{
+        url:        url,
+        threshold:  1,
+        passphrase: os.Getenv("TRADINGVIEW_PASSPHRASE"),
+        client:     egressClient(egressWebhook, 10*time.Second),
+        latency:    latency,
+        sides:      make(map[string]string),
+    }
+    if v := os.Getenv("TRADINGVIEW_THRESHOLD_PERCENT"); v != "" {
+        if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+            ta.threshold = f
+        } else {
+            slog.Warn("invalid setting, using default", "key", "TRADINGVIEW_THRESHOLD_PERCENT", "value", v, "default", ta.threshold)
+        }
+    }
+    if v := os.Getenv("TRADINGVIEW_QUANTITY"); v != "" {
+        if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+            ta.quantity = f
+        } else {
+            slog.Warn("invalid setting, ignoring it", "key", "TRADINGVIEW_QUANTITY", "value", v)
+        }
+    }
+    return ta
+}
+
+/*
+signalFor maps a prediction to an action and sentiment: buy/bullish and
+sell/bearish beyond the threshold, exit/flat in between.
+*/
+func (ta *TradingViewAdapter) signalFor(p Prediction) (action, sentiment string) {
+    switch {
+    case p.PredictedChangePerc >= ta.threshold:
+        return "buy", "bullish"
+    case p.PredictedChangePerc <= -ta.threshold:
+        return "sell", "bearish"
+    }
+    return "exit", "flat"
+}
+
+/*
+Publish sends a signal for p when its side differs from the last one sent for
+the symbol, and returns the signal once it has been delivered. An initial flat
+prediction sends nothing.
+*/
+func (ta *TradingViewAdapter) Publish(p Prediction) *TradingViewSignal {
+    action, sentiment := ta.signalFor(p)
+    ta.mu.Lock()
+    prev, seen := ta.sides[p.Symbol]
+    if prev == action || !seen && action == "exit" {
+        ta.mu.Unlock()
+        return nil
+    }
+    ta.sides[p.Symbol] = action
+    ta.mu.Unlock()
+
+    sig := TradingViewSignal{
+        Passphrase: ta.passphrase,
+        Ticker:     p.Symbol,
+        Action:     action,
+        Sentiment:  sentiment,
+        Price:      p.CurrentPrice,
+        Quantity:   ta.quantity,
+        Time:       p.Timestamp.UTC().Format(time.RFC3339),
+        Comment:    fmt.Sprintf("forecast %.2f (%+.2f%%)", p.PredictedPrice, p.PredictedChangePerc),
+    }
+    if action == "exit" {
+        sig.Quantity = 0
+    }
+    start := time.Now()
+    err := ta.post(sig)
+    ta.latency.Observe("tradingview", time.Since(start), err)
+    if err != nil {
+        slog.Warn("TradingView signal failed", "symbol", p.Symbol, "err", err)
+        ta.mu.Lock()
+        if ta.sides[p.Symbol] == action {
+            if seen {
+                ta.sides[p.Symbol] = prev
+            } else {
+                delete(ta.sides, p.Symbol)
+            }
+        }
+        ta.mu.Unlock()
+        return nil
+    }
+    return &sig
+}
+
+/*
+post delivers sig to the configured webhook.
+*/
+func (ta *TradingViewAdapter) post(sig TradingViewSignal) error {
+    body, err := json.Marshal(sig)
+    if err != nil {
+        return err
+    }
+    resp, err := ta.client.Post(ta.url, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/*
+WatchdogIncident records one pipeline the watchdog found stuck or dead and
+restarted.
+*/
+type WatchdogIncident struct {
+    Symbol     string    `json:"symbol"`
+    Reason     string    `json:"reason"`
+    LastTick   time.Time `json:"last_tick,omitempty"`
+    DetectedAt time.Time `json:"detected_at"`
+}
+
+/*
+Watchdog supervises the per-symbol pipelines. A pipeline that has ended
+without being stopped, or that has not produced a tick within
+WATCHDOG_INTERVALS collection intervals (default 5, 0 disables), is restarted.
+*/
+type Watchdog struct {
+    fp        *FinancialProcessor
+    intervals int
+    mu        sync.Mutex
+    incidents []WatchdogIncident
+}
+
+/*
+NewWatchdogFromEnv creates the watchdog for fp.
+*/
+func NewWatchdogFromEnv(fp *FinancialProcessor) *Watchdog {
+    return &Watchdog{fp: fp, intervals: envInt("WATCHDOG_INTERVALS", 5)}
+}
+
+/*
+Run checks the pipelines every 30s.
+*/
+func (wd *Watchdog) Run() {
+    if wd.intervals <= 0 {
+        return
+    }
+    for {
+        wd.Check(<-wd.fp.clock.After(30 * time.Second))
+    }
+}
+
+/*
+Check restarts every pipeline that is dead or overdue at now.
+*/
+func (wd *Watchdog) Check(now time.Time) {
+    wd.fp.mutex.RLock()
+    pipelines := make([]*symbolPipeline, 0, len(wd.fp.pipelines))
+    for _, p := range wd.fp.pipelines {
+        pipelines = append(pipelines, p)
+    }
+    wd.fp.mutex.RUnlock()
+
+    for _, p := range pipelines {
+        inc := WatchdogIncident{Symbol: p.symbol, DetectedAt: now}
+        since := p.startedAt.Add(p.delay)
+        if ns := p.lastTick.Load(); ns > 0 {
+            inc.LastTick = time.Unix(0, ns)
+            since = inc.LastTick
+        }
+        // A pipeline waiting out a long closed-market interval is not overdue
+        // until its planned fetch has passed.
+        if ns := p.times.next.Load(); ns > since.UnixNano() {
+            since = time.Unix(0, ns)
+        }
+        select {
+        case <-p.done:
+            if p.stopped() {
+                continue
+            }
+            inc.Reason = "pipeline ended"
+        default:
+            limit := time.Duration(wd.intervals) * wd.fp.collectionInterval(p.symbol)
+            if now.Sub(since) <= limit {
+                continue
+            }
+            inc.Reason = "no tick for " + now.Sub(since).Round(time.Second).String()
+        }
+        slog.Warn("watchdog restarting pipeline", "symbol", p.symbol, "reason", inc.Reason)
+        wd.record(inc)
+        wd.fp.restartSymbol(p.symbol, time.Second)
+    }
+}
+
+/*
+record keeps the last 50 incidents.
+*/
+func (wd *Watchdog) record(inc WatchdogIncident) {
+    wd.mu.Lock()
+    defer wd.mu.Unlock()
+    wd.incidents = append(wd.incidents, inc)
+    if len(wd.incidents) > 50 {
+        wd.incidents = wd.incidents[len(wd.incidents)-50:]
+    }
+}
+
+/*
+Incidents returns the recorded incidents, oldest first.
+*/
+func (wd *Watchdog) Incidents() []WatchdogIncident {
+    wd.mu.Lock()
+    defer wd.mu.Unlock()
+    return append([]WatchdogIncident{}, wd.incidents...)
+}
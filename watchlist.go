@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+watchlistPrefix starts the symbol under which a watchlist's index is stored,
+so ^WL-TECH is the index of the TECH watchlist.
+*/
+const watchlistPrefix = "^WL-"
+
+/*
+WatchlistSpec configures one watchlist in the YAML config. Its index weighs
+Symbols equally, or, when Weights is given, its keys by their values, which
+need not add up to 1.
+*/
+type WatchlistSpec struct {
+    Name    string             `yaml:"name"`
+    Symbols []string           `yaml:"symbols"`
+    Weights map[string]float64 `yaml:"weights"`
+}
+
+/*
+WatchlistIndex is one watchlist's synthetic index: the weighted return of
+its constituents since base was taken, applied to level. base is taken when
+every constituent first has a price, with level continuing from the last
+stored index value or starting at 100.
+*/
+type WatchlistIndex struct {
+    Name    string             `json:"name"`
+    Symbol  string             `json:"symbol"`
+    Weights map[string]float64 `json:"weights"`
+    Value   float64            `json:"value,omitempty"`
+    level   float64
+    base    map[string]float64
+}
+
+/*
+WatchlistIndexes computes every configured watchlist index as ticks arrive.
+*/
+type WatchlistIndexes struct {
+    mu       sync.Mutex
+    indexes  []*WatchlistIndex
+    bySymbol map[string][]*WatchlistIndex
+}
+
+/*
+NewWatchlistIndexes validates specs and builds their indexes.
+*/
+func NewWatchlistIndexes(specs []WatchlistSpec) (*WatchlistIndexes, error) {
+    wi := &WatchlistIndexes{bySymbol: make(map[string][]*WatchlistIndex)}
+    seen := make(map[string]bool)
+    for _, spec := range specs {
+        name := strings.ToUpper(strings.TrimSpace(spec.Name))
+        idx := &WatchlistIndex{Name: name, Symbol: watchlistPrefix + name, Weights: make(map[string]float64), level: 100}
+        switch {
+        case name == "" || !symbolPattern.MatchString(idx.Symbol):
+            return nil, fmt.Errorf("watchlist name %q does not make a valid symbol", spec.Name)
+        case seen[name]:
+            return nil, fmt.Errorf("watchlist %s is defined twice", name)
+        case len(spec.Symbols) > 0 && len(spec.Weights) > 0:
+            return nil, fmt.Errorf("watchlist %s: give either symbols or weights", name)
+        case len(spec.Symbols) == 0 && len(spec.Weights) == 0:
+            return nil, fmt.Errorf("watchlist %s has no symbols", name)
+        }
+        seen[name] = true
+        weights := spec.Weights
+        if len(weights) == 0 {
+            weights = make(map[string]float64)
+            for _, s := range spec.Symbols {
+                weights[s] = 1
+            }
+        }
+        var total float64
+        for s, w := range weights {
+            s = strings.ToUpper(strings.TrimSpace(s))
+            switch {
+            case !symbolPattern.MatchString(s) || strings.HasPrefix(s, watchlistPrefix):
+                return nil, fmt.Errorf("watchlist %s: invalid symbol %q", name, s)
+            case w <= 0:
+                return nil, fmt.Errorf("watchlist %s: weight of %s must be positive", name, s)
+            }
+            idx.Weights[s] += w
+            total += w
+        }
+        for s := range idx.Weights {
+            idx.Weights[s] /= total
+            wi.bySymbol[s] = append(wi.bySymbol[s], idx)
+        }
+        wi.indexes = append(wi.indexes, idx)
+    }
+    return wi, nil
+}
+
+/*
+Symbols returns the symbol of every index.
+*/
+func (wi *WatchlistIndexes) Symbols() []string {
+    var out []string
+    for _, idx := range wi.indexes {
+        out = append(out, idx.Symbol)
+    }
+    return out
+}
+
+/*
+Update recomputes the indexes sd's symbol belongs to and returns a tick for
+each, using latest for the other constituents' prices. An index yields no
+tick until all its constituents have a price. The tick's volume is the sum
+of its constituents' volumes.
+*/
+func (wi *WatchlistIndexes) Update(sd StockData, latest func(string) (StockData, bool)) []StockData {
+    wi.mu.Lock()
+    defer wi.mu.Unlock()
+    var out []StockData
+    for _, idx := range wi.bySymbol[sd.Symbol] {
+        prices := make(map[string]float64, len(idx.Weights))
+        var volume int64
+        for s := range idx.Weights {
+            c := sd
+            if s != sd.Symbol {
+                var ok bool
+                if c, ok = latest(s); !ok {
+                    break
+                }
+            }
+            if c.Price <= 0 {
+                break
+            }
+            prices[s] = c.Price
+            volume += c.Volume
+        }
+        if len(prices) < len(idx.Weights) {
+            continue
+        }
+        if idx.base == nil {
+            if last, ok := latest(idx.Symbol); ok && last.Price > 0 {
+                idx.level = last.Price
+            }
+            idx.base = prices
+        }
+        var value float64
+        for s, w := range idx.Weights {
+            value += w * prices[s] / idx.base[s]
+        }
+        out = append(out, StockData{Symbol: idx.Symbol, Price: idx.level * value, Volume: volume, Timestamp: sd.Timestamp, AssetClass: assetIndex})
+    }
+    return out
+}
+
+/*
+recordIndexes records a tick for every watchlist index sd moves, so each
+index is stored, predicted and alerted on like a collected symbol.
+*/
+func (fp *FinancialProcessor) recordIndexes(sd StockData) {
+    for _, tick := range fp.watchlists.Update(sd, fp.dataStore.Latest) {
+        fp.recordTick(tick)
+    }
+}
+
+/*
+handleListWatchlists exposes GET /api/watchlists, every watchlist with its
+index symbol, normalized weights and latest index value. The index history
+is served at /api/data/{symbol} under that symbol.
+*/
+func (fp *FinancialProcessor) handleListWatchlists(w http.ResponseWriter, r *http.Request) {
+    out := make([]WatchlistIndex, 0, len(fp.watchlists.indexes))
+    for _, idx := range fp.watchlists.indexes {
+        entry := WatchlistIndex{Name: idx.Name, Symbol: idx.Symbol, Weights: idx.Weights}
+        if last, ok := fp.dataStore.Latest(idx.Symbol); ok {
+            entry.Value = last.Price
+        }
+        out = append(out, entry)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+wsPingInterval is how often idle connections are pinged; a client that has
+not answered within two intervals is dropped.
+*/
+const wsPingInterval = 30 * time.Second
+
+/*
+wsClientMessage is a message from a WebSocket client. Action is "subscribe"
+or "unsubscribe"; the symbol "*" stands for every symbol.
+*/
+type wsClientMessage struct {
+    Action  string   `json:"action"`
+    Symbols []string `json:"symbols"`
+}
+
+/*
+wsServerMessage is a control message sent to a WebSocket client. Live
+updates are sent as Events.
+*/
+type wsServerMessage struct {
+    Type    string   `json:"type"`
+    Symbols []string `json:"symbols,omitempty"`
+    Message string   `json:"message,omitempty"`
+}
+
+/*
+wsUpgrader upgrades /ws requests that pass wsCheckOrigin.
+*/
+var wsUpgrader = websocket.Upgrader{CheckOrigin: wsCheckOrigin}
+
+/*
+wsCheckOrigin accepts same-origin connections, clients that send no Origin,
+and origins listed in WS_ALLOWED_ORIGINS (comma-separated, "*" allows any).
+*/
+func wsCheckOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    for _, allowed := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+        if allowed = strings.TrimSpace(allowed); allowed == "*" || allowed == origin {
+            return true
+        }
+    }
+    return strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://") == r.Host
+}
+
+/*
+wsSubscriptions is the set of symbols a connection follows. It is only used
+from the connection's writer goroutine.
+*/
+type wsSubscriptions struct {
+    all     bool
+    symbols map[string]bool
+}
+
+/*
+update applies a subscribe or unsubscribe action and returns the resulting set.
+*/
+func (s *wsSubscriptions) update(action string, symbols []string) []string {
+    for _, sym := range symbols {
+        sym = strings.ToUpper(strings.TrimSpace(sym))
+        switch {
+        case sym == "*":
+            s.all = action == "subscribe"
+            if !s.all {
+                s.symbols = make(map[string]bool)
+            }
+        case action == "subscribe":
+            s.symbols[sym] = true
+        default:
+            delete(s.symbols, sym)
+        }
+    }
+    out := make([]string, 0, len(s.symbols)+1)
+    if s.all {
+        out = append(out, "*")
+    }
+    for sym := range s.symbols {
+        out = append(out, sym)
+    }
+    return out
+}
+
+/*
+wants reports whether events for symbol should be sent.
+*/
+func (s *wsSubscriptions) wants(symbol string) bool {
+    return s.all || s.symbols[symbol]
+}
+
+/*
+handleWebSocket exposes /ws, which pushes events as they arrive
+to clients that subscribe to their symbols, e.g. by sending
+{"action": "subscribe", "symbols": ["AAPL", "MSFT"]}. Symbols may also be
+given up front as ?symbols=AAPL,MSFT. A client that falls behind misses
+events rather than slowing collection down.
+*/
+func (fp *FinancialProcessor) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+    conn, err := wsUpgrader.Upgrade(w, r, nil)
+    if err != nil {
+        return
+    }
+    defer conn.Close()
+
+    subs := &wsSubscriptions{symbols: make(map[string]bool)}
+    if q := r.URL.Query().Get("symbols"); q != "" {
+        subs.update("subscribe", strings.Split(q, ","))
+    }
+    events, unsubscribe := fp.events.Subscribe(256)
+    defer unsubscribe()
+
+    // Reads happen on their own goroutine; all writes stay on this one.
+    requests := make(chan wsClientMessage)
+    done := make(chan struct{})
+    defer close(done)
+    go func() {
+        defer close(requests)
+        conn.SetReadLimit(64 * 1024)
+        conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+        conn.SetPongHandler(func(string) error {
+            return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+        })
+        for {
+            var msg wsClientMessage
+            if err := conn.ReadJSON(&msg); err != nil {
+                if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+                    requestLogger(r).Warn("websocket read error", "err", err)
+                }
+                return
+            }
+            select {
+            case requests <- msg:
+            case <-done:
+                return
+            }
+        }
+    }()
+
+    ping := time.NewTicker(wsPingInterval)
+    defer ping.Stop()
+    for {
+        var out interface{}
+        select {
+        case msg, ok := <-requests:
+            if !ok {
+                return
+            }
+            if msg.Action != "subscribe" && msg.Action != "unsubscribe" {
+                out = wsServerMessage{Type: "error", Message: fmt.Sprintf("unknown action %q", msg.Action)}
+                break
+            }
+            out = wsServerMessage{Type: "subscriptions", Symbols: subs.update(msg.Action, msg.Symbols)}
+        case ev, ok := <-events:
+            if !ok {
+                return
+            }
+            if !subs.wants(ev.Symbol) {
+                continue
+            }
+            out = ev
+        case <-ping.C:
+            // An open stream counts as a client being active.
+            fp.idle.Touch()
+            if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+                return
+            }
+            continue
+        }
+        conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+        if err := conn.WriteJSON(out); err != nil {
+            return
+        }
+    }
+}